@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+
+	"github.com/jdelobel/go-api/internal/image"
+	"github.com/jdelobel/go-api/internal/platform/db"
+	"github.com/jdelobel/go-api/internal/platform/storage"
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+// Blob represents the handler serving the blobs of the local storage
+// backend.
+type Blob struct {
+	MasterDB *db.DB
+	Store    storage.Store
+}
+
+// Retrieve streams the blob stored under the key, when the image owning it
+// is visible to the caller.
+// 200 Success, 400 Bad Request, 404 Not Found, 410 Gone, 500 Internal
+func (b *Blob) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	if _, err := image.RetrieveOwner(ctx, b.MasterDB, params["key"], scope(ctx)); err != nil {
+		return errors.Wrapf(err, "Key: %s", params["key"])
+	}
+
+	rc, err := b.Store.Get(ctx, params["key"])
+	if err != nil {
+		return errors.Wrapf(err, "Key: %s", params["key"])
+	}
+	defer rc.Close()
+
+	// The stored originals have no extension, net/http sniffs their type.
+	if ct := mime.TypeByExtension(path.Ext(params["key"])); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	ctx.Value(web.KeyValues).(*web.Values).StatusCode = http.StatusOK
+	if _, err := io.Copy(w, rc); err != nil {
+		return errors.Wrapf(err, "Key: %s", params["key"])
+	}
+	return nil
+}
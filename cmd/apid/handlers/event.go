@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jdelobel/go-api/internal/image"
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+// eventsRetry is the time after which the clients reconnect when an event
+// stream ends.
+const eventsRetry = 3 * time.Second
+
+// eventsPage is the number of missed events read at once.
+const eventsPage = 500
+
+// Events streams the creations, updates and deletions of the images as
+// Server-Sent Events, restricted to the publisher query parameters. A
+// client reconnecting with the Last-Event-ID header first gets the events
+// it missed. Clients falling behind are disconnected, and resume the same
+// way. Clients resuming after purged events get 410 Gone. Anonymous
+// callers only get the events of the images inside their visibility
+// window.
+// 200 Success, 400 Bad Request, 410 Gone, 503 Service Unavailable
+func (m *Image) Events(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	last, err := image.ParseEventID(r)
+	if err != nil {
+		return errors.Wrap(err, "Events")
+	}
+	var publishers []string
+	for _, p := range r.URL.Query()["publisher"] {
+		publishers = append(publishers, strings.Split(p, ",")...)
+	}
+
+	// Subscribe before catching up, so that no event is missed in between.
+	sub, err := m.Feed.Subscribe(image.ByPublisher(publishers))
+	if err != nil {
+		return errors.Wrap(err, "Events")
+	}
+	defer m.Feed.Unsubscribe(sub)
+
+	// A client which can't get the events it missed starts afresh.
+	if err := image.CheckEventsRetained(ctx, m.MasterDB, last); err != nil {
+		return errors.Wrap(err, "Events")
+	}
+
+	es := web.NewEventStream(ctx, w)
+	if err := es.Open(eventsRetry); err != nil {
+		return nil
+	}
+	sc := scope(ctx)
+	send := func(e *image.FeedEvent) error {
+		last = e.ID
+		if !e.Visible(sc, time.Now()) {
+			return nil
+		}
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return es.Send(strconv.FormatInt(e.ID, 10), e.Type, data)
+	}
+
+	for caught := last == 0; !caught; {
+		events, err := image.ListEvents(ctx, m.MasterDB, last, publishers, eventsPage)
+		if err != nil {
+			es.Abort(errors.Wrap(err, "Events"))
+		}
+		for i := range events {
+			if err := send(&events[i]); err != nil {
+				return nil
+			}
+		}
+		caught = len(events) < eventsPage
+	}
+
+	// The stream ends with the deadline of the route, the client
+	// reconnects.
+	heartbeat := time.NewTicker(m.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-r.Context().Done():
+			return nil
+		case e, ok := <-sub.Events():
+			if !ok {
+				return nil
+			}
+			if e.ID <= last {
+				continue
+			}
+			if err := send(&e); err != nil {
+				return nil
+			}
+		case <-heartbeat.C:
+			if err := es.Comment("heartbeat"); err != nil {
+				return nil
+			}
+		}
+	}
+}
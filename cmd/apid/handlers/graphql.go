@@ -0,0 +1,639 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/jdelobel/go-api/internal/image"
+	"github.com/jdelobel/go-api/internal/platform/gql"
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+// Sizes of the lists of the GraphQL schema.
+const (
+	imagesFirst       = 20
+	maxImagesFirst    = 100
+	revisionsFirst    = 10
+	maxRevisionsFirst = 50
+)
+
+// GraphQL represents the GraphQL API method handler set. The images are
+// read and changed through the same domain code as the REST routes.
+type GraphQL struct {
+	Images   *Image
+	Executor gql.Executor
+}
+
+// NewGraphQL returns the GraphQL handlers of the images, which refuse the
+// queries deeper or more complex than the limits.
+func NewGraphQL(m *Image, maxDepth, maxComplexity int) *GraphQL {
+	return &GraphQL{
+		Images: m,
+		Executor: gql.Executor{
+			Schema:        graphQLSchema,
+			MaxDepth:      maxDepth,
+			MaxComplexity: maxComplexity,
+			MaxSizes:      map[string]int{"Query.images": maxImagesFirst, "Image.revisions": maxRevisionsFirst},
+		},
+	}
+}
+
+// Serve executes a GraphQL query, sent as the query parameters of a GET or
+// as the body of a POST. The mutations must be POSTed. The errors of the
+// resolvers are returned along with the data, with their problem in their
+// extensions.
+// 200 Success, 400 Bad Request, 401 Unauthorized, 500 Internal
+func (g *GraphQL) Serve(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	req, err := gql.ParseRequest(r)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	rc := &graphQLRequest{m: g.Images, revisions: make(map[int]*gql.Loader)}
+	rc.images = gql.NewLoader(func(ctx context.Context, ids []string) ([]interface{}, []error) {
+		images, errs := image.RetrieveMany(ctx, g.Images.MasterDB, ids, scope(ctx))
+		values := make([]interface{}, len(images))
+		for i := range images {
+			if errs[i] != nil {
+				errs[i] = resolverError(ctx, errs[i])
+				continue
+			}
+			values[i] = images[i]
+		}
+		return values, errs
+	})
+
+	res := g.Executor.Execute(context.WithValue(ctx, graphQLKey, rc), req, r.Method == http.MethodPost)
+	code := http.StatusOK
+	if res.Data == nil && res.HasErrors() {
+		code = http.StatusBadRequest
+	}
+	web.Respond(ctx, w, res, code)
+	return nil
+}
+
+// graphQLKey is the context key of the state of a GraphQL request.
+type graphQLKeyType int
+
+const graphQLKey graphQLKeyType = 0
+
+// graphQLRequest is the state of a GraphQL request shared by its
+// resolvers: the handlers and the loaders batching the reads.
+type graphQLRequest struct {
+	m      *Image
+	images *gql.Loader
+
+	mu        sync.Mutex
+	revisions map[int]*gql.Loader
+}
+
+// request returns the state of the GraphQL request of the context.
+func request(ctx context.Context) *graphQLRequest {
+	return ctx.Value(graphQLKey).(*graphQLRequest)
+}
+
+// revisionsLoader returns the loader of the latest revisions of the
+// images, first per image.
+func (rc *graphQLRequest) revisionsLoader(first int) *gql.Loader {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if l, ok := rc.revisions[first]; ok {
+		return l
+	}
+
+	l := gql.NewLoader(func(ctx context.Context, ids []string) ([]interface{}, []error) {
+		values := make([]interface{}, len(ids))
+		errs := make([]error, len(ids))
+		revisions, err := image.ListRecentRevisions(ctx, rc.m.MasterDB, ids, first)
+		for i, id := range ids {
+			if err != nil {
+				errs[i] = resolverError(ctx, err)
+				continue
+			}
+			list := revisions[id]
+			if list == nil {
+				list = make([]image.Revision, 0)
+			}
+			values[i] = list
+		}
+		return values, errs
+	})
+	rc.revisions[first] = l
+	return l
+}
+
+// resolverError describes the error of a resolver by its problem. The
+// internal errors are logged and their details aren't sent.
+func resolverError(ctx context.Context, err error) error {
+	p := web.NewProblem(err)
+	if p.Status >= http.StatusInternalServerError {
+		v := ctx.Value(web.KeyValues).(*web.Values)
+		v.Log.Errorf("%s : %+v\n", v.TraceID, err)
+		p.Detail = ""
+	}
+	return &gql.Error{Problem: p}
+}
+
+// graphQLSchema is the GraphQL schema of the images.
+var graphQLSchema = newGraphQLSchema()
+
+// jsonScalar is a free-form JSON value, like the metadata of the images or
+// the filters of the lists.
+var jsonScalar = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "JSON",
+	Description: "A free-form JSON value.",
+	Serialize: func(value interface{}) interface{} {
+		return value
+	},
+	ParseValue: func(value interface{}) interface{} {
+		return value
+	},
+	ParseLiteral: literal,
+})
+
+// literal returns the value of a JSON literal of a query.
+func literal(v ast.Value) interface{} {
+	switch v := v.(type) {
+	case *ast.StringValue:
+		return v.Value
+	case *ast.BooleanValue:
+		return v.Value
+	case *ast.IntValue:
+		n, _ := strconv.ParseInt(v.Value, 10, 64)
+		return n
+	case *ast.FloatValue:
+		f, _ := strconv.ParseFloat(v.Value, 64)
+		return f
+	case *ast.ListValue:
+		list := make([]interface{}, 0, len(v.Values))
+		for _, item := range v.Values {
+			list = append(list, literal(item))
+		}
+		return list
+	case *ast.ObjectValue:
+		obj := make(map[string]interface{}, len(v.Fields))
+		for _, f := range v.Fields {
+			obj[f.Name.Value] = literal(f.Value)
+		}
+		return obj
+	}
+	return nil
+}
+
+// imageFields returns the fields of the images and of their states.
+func imageFields() graphql.Fields {
+	return graphql.Fields{
+		"id":            &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+		"title":         &graphql.Field{Type: graphql.String},
+		"url":           &graphql.Field{Type: graphql.String},
+		"slug":          &graphql.Field{Type: graphql.String},
+		"publisher":     &graphql.Field{Type: graphql.String},
+		"publishedAt":   &graphql.Field{Type: graphql.DateTime},
+		"expiredAt":     &graphql.Field{Type: graphql.DateTime},
+		"metadata":      &graphql.Field{Type: jsonScalar},
+		"contentType":   &graphql.Field{Type: graphql.String},
+		"contentSize":   &graphql.Field{Type: graphql.Int},
+		"contentSha256": &graphql.Field{Type: graphql.String},
+		"version":       &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"etag": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.String),
+			Description: "The entity tag of the version, as sent in the ETag header.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return web.ETag(p.Source.(*image.Image).Version), nil
+			},
+		},
+		"createdAt":  &graphql.Field{Type: graphql.DateTime},
+		"updatedAt":  &graphql.Field{Type: graphql.DateTime},
+		"restoredAt": &graphql.Field{Type: graphql.DateTime},
+		"deletedAt":  &graphql.Field{Type: graphql.DateTime},
+	}
+}
+
+// newGraphQLSchema builds the schema of the images. It panics when the
+// schema is invalid.
+func newGraphQLSchema() graphql.Schema {
+	imageState := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "ImageState",
+		Description: "The state of an image recorded by a revision.",
+		Fields:      imageFields(),
+	})
+
+	revisionType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Revision",
+		Description: "The state of an image before one of its changes.",
+		Fields: graphql.Fields{
+			"revision":  &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"actor":     &graphql.Field{Type: graphql.String},
+			"traceId":   &graphql.Field{Type: graphql.String},
+			"createdAt": &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+			"state": &graphql.Field{
+				Type: imageState,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					rev := p.Source.(image.Revision)
+					if rev.State == nil {
+						return nil, nil
+					}
+					return &rev.State.Image, nil
+				},
+			},
+		},
+	})
+
+	derivativeType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Derivative",
+		Description: "A rendition of an image in one of the preset sizes.",
+		Fields: graphql.Fields{
+			"width":  &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"height": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"dpr":    &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"url":    &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+
+	fields := imageFields()
+	fields["revisions"] = &graphql.Field{
+		Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(revisionType))),
+		Description: "The latest revisions of the image, latest first.",
+		Args: graphql.FieldConfigArgument{
+			"first": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: revisionsFirst},
+		},
+		Resolve: resolveRevisions,
+	}
+	fields["derivatives"] = &graphql.Field{
+		Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(derivativeType))),
+		Description: "The renditions of the image in the preset sizes, none until its content is uploaded.",
+		Args: graphql.FieldConfigArgument{
+			"dpr":    &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 1},
+			"format": &graphql.ArgumentConfig{Type: graphql.String},
+		},
+		Resolve: resolveDerivatives,
+	}
+	imageType := graphql.NewObject(graphql.ObjectConfig{
+		Name:   "Image",
+		Fields: fields,
+	})
+
+	edgeType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "ImageEdge",
+		Fields: graphql.Fields{
+			"cursor": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"node":   &graphql.Field{Type: graphql.NewNonNull(imageType)},
+		},
+	})
+	pageInfoType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "PageInfo",
+		Fields: graphql.Fields{
+			"hasNextPage": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+			"endCursor":   &graphql.Field{Type: graphql.String},
+		},
+	})
+	connectionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "ImageConnection",
+		Fields: graphql.Fields{
+			"edges":    &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(edgeType)))},
+			"nodes":    &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(imageType)))},
+			"pageInfo": &graphql.Field{Type: graphql.NewNonNull(pageInfoType)},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"image": &graphql.Field{
+				Type: imageType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return request(p.Context).images.Load(p.Context, p.Args["id"].(string)), nil
+				},
+			},
+			"images": &graphql.Field{
+				Type:        graphql.NewNonNull(connectionType),
+				Description: "The images matching the filters of GET /v1/images, oldest first.",
+				Args: graphql.FieldConfigArgument{
+					"filter": &graphql.ArgumentConfig{Type: jsonScalar},
+					"first":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: imagesFirst},
+					"after":  &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: resolveImages,
+			},
+		},
+	})
+
+	input := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "ImageInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"title":       &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"url":         &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"slug":        &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"publisher":   &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"publishedAt": &graphql.InputObjectFieldConfig{Type: graphql.DateTime},
+			"expiredAt":   &graphql.InputObjectFieldConfig{Type: graphql.DateTime},
+			"metadata":    &graphql.InputObjectFieldConfig{Type: jsonScalar},
+		},
+	})
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createImage": &graphql.Field{
+				Type: graphql.NewNonNull(imageType),
+				Args: graphql.FieldConfigArgument{
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(input)},
+				},
+				Resolve: editorsOnly(resolveCreateImage),
+			},
+			"updateImage": &graphql.Field{
+				Type:        graphql.NewNonNull(imageType),
+				Description: "Replaces an image, when its current version is the given one.",
+				Args: graphql.FieldConfigArgument{
+					"id":      &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"input":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(input)},
+					"version": &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: editorsOnly(resolveUpdateImage),
+			},
+			"deleteImage": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.Boolean),
+				Description: "Soft deletes an image, when its current version is the given one.",
+				Args: graphql.FieldConfigArgument{
+					"id":      &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"version": &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: editorsOnly(resolveDeleteImage),
+			},
+		},
+	})
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+// imageConnection is a page of images, in the shape of the Relay
+// connections.
+type imageConnection struct {
+	Edges    []imageEdge
+	Nodes    []*image.Image
+	PageInfo pageInfo
+}
+
+// imageEdge is an image of a connection along with its cursor.
+type imageEdge struct {
+	Cursor string
+	Node   *image.Image
+}
+
+// pageInfo tells whether a connection has more images after its cursor.
+type pageInfo struct {
+	HasNextPage bool
+	EndCursor   *string
+}
+
+// derivative is a rendition of an image in one of the preset sizes.
+type derivative struct {
+	Width  int
+	Height int
+	DPR    int
+	URL    string
+}
+
+// resolveImages returns a page of the images matching the filter.
+func resolveImages(p graphql.ResolveParams) (interface{}, error) {
+	rc := request(p.Context)
+
+	qp, err := filterValues(p.Args["filter"])
+	if err != nil {
+		return nil, resolverError(p.Context, err)
+	}
+	first, err := firstArg(p.Args, maxImagesFirst)
+	if err != nil {
+		return nil, resolverError(p.Context, err)
+	}
+	after, _ := p.Args["after"].(string)
+
+	images, more, err := image.ListAfter(p.Context, rc.m.MasterDB, qp, scope(p.Context), first, after)
+	if err != nil {
+		return nil, resolverError(p.Context, err)
+	}
+
+	conn := imageConnection{
+		Edges:    make([]imageEdge, len(images)),
+		Nodes:    make([]*image.Image, len(images)),
+		PageInfo: pageInfo{HasNextPage: more},
+	}
+	for i := range images {
+		img := &images[i]
+		conn.Edges[i] = imageEdge{Cursor: image.EncodeCursor(img), Node: img}
+		conn.Nodes[i] = img
+	}
+	if len(images) > 0 {
+		conn.PageInfo.EndCursor = &conn.Edges[len(images)-1].Cursor
+	}
+	return conn, nil
+}
+
+// resolveRevisions returns the latest revisions of an image, loaded along
+// with the revisions of the other images of the query.
+func resolveRevisions(p graphql.ResolveParams) (interface{}, error) {
+	first, err := firstArg(p.Args, maxRevisionsFirst)
+	if err != nil {
+		return nil, resolverError(p.Context, err)
+	}
+	img := p.Source.(*image.Image)
+	return request(p.Context).revisionsLoader(first).Load(p.Context, *img.ID), nil
+}
+
+// resolveDerivatives returns the render URLs of an image in each of the
+// preset sizes.
+func resolveDerivatives(p graphql.ResolveParams) (interface{}, error) {
+	img := p.Source.(*image.Image)
+	derivatives := make([]derivative, 0)
+	if img.ContentSHA256 == nil {
+		return derivatives, nil
+	}
+
+	opts := request(p.Context).m.Derivatives
+	presets := opts.Presets
+	if len(presets) == 0 {
+		presets = image.DefaultPresets
+	}
+	for _, preset := range presets {
+		size := strings.SplitN(preset, "x", 2)
+		if len(size) != 2 {
+			continue
+		}
+		qp := url.Values{"w": {size[0]}, "h": {size[1]}}
+		if dpr, ok := p.Args["dpr"].(int); ok {
+			qp.Set("dpr", strconv.Itoa(dpr))
+		}
+		if format, ok := p.Args["format"].(string); ok {
+			qp.Set("format", format)
+		}
+		rd, err := image.ParseRendition(qp, opts)
+		if err != nil {
+			return nil, resolverError(p.Context, err)
+		}
+		derivatives = append(derivatives, derivative{
+			Width:  rd.Width,
+			Height: rd.Height,
+			DPR:    rd.DPR,
+			URL:    fmt.Sprintf("/v1/images/%s/render?%s", *img.ID, qp.Encode()),
+		})
+	}
+	return derivatives, nil
+}
+
+// editorsOnly refuses the anonymous calls of a resolver, like the routes
+// changing the images.
+func editorsOnly(resolve graphql.FieldResolveFn) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		if p.Context.Value(web.KeyValues).(*web.Values).Actor == "" {
+			return nil, resolverError(p.Context, errors.Wrap(web.ErrNotAuthorized, "anonymous mutation"))
+		}
+		return resolve(p)
+	}
+}
+
+// resolveCreateImage inserts an image.
+func resolveCreateImage(p graphql.ResolveParams) (interface{}, error) {
+	rc := request(p.Context)
+	ci, err := imageInput(p.Args["input"])
+	if err != nil {
+		return nil, resolverError(p.Context, err)
+	}
+
+	img, err := image.Create(p.Context, rc.m.MasterDB, rc.m.rbmq, ci)
+	if err != nil {
+		return nil, resolverError(p.Context, errors.Wrapf(err, "Image: %+v", ci))
+	}
+	rc.m.Cache.Invalidate(p.Context, *img.ID)
+	return img, nil
+}
+
+// resolveUpdateImage replaces an image and returns its new state.
+func resolveUpdateImage(p graphql.ResolveParams) (interface{}, error) {
+	rc := request(p.Context)
+	id := p.Args["id"].(string)
+	pre, err := versionArg(p.Args, rc.m.RequireIfMatch)
+	if err != nil {
+		return nil, resolverError(p.Context, errors.Wrapf(err, "Id: %s", id))
+	}
+	ci, err := imageInput(p.Args["input"])
+	if err != nil {
+		return nil, resolverError(p.Context, err)
+	}
+
+	if _, err := image.Update(p.Context, rc.m.MasterDB, id, ci, author(p.Context), pre); err != nil {
+		return nil, resolverError(p.Context, errors.Wrapf(err, "Id: %s  Image: %+v", id, ci))
+	}
+	rc.m.Cache.Invalidate(p.Context, id)
+
+	img, err := image.Retrieve(p.Context, rc.m.MasterDB, id, image.Editorial)
+	if err != nil {
+		return nil, resolverError(p.Context, errors.Wrapf(err, "Id: %s", id))
+	}
+	return img, nil
+}
+
+// resolveDeleteImage soft deletes an image.
+func resolveDeleteImage(p graphql.ResolveParams) (interface{}, error) {
+	rc := request(p.Context)
+	id := p.Args["id"].(string)
+	pre, err := versionArg(p.Args, rc.m.RequireIfMatch)
+	if err != nil {
+		return nil, resolverError(p.Context, errors.Wrapf(err, "Id: %s", id))
+	}
+
+	if err := image.Delete(p.Context, rc.m.MasterDB, id, author(p.Context), pre); err != nil {
+		return nil, resolverError(p.Context, errors.Wrapf(err, "Id: %s", id))
+	}
+	rc.m.Cache.Invalidate(p.Context, id)
+	return true, nil
+}
+
+// imageInput converts an ImageInput to the image it describes, validated
+// as the bodies of the REST routes.
+func imageInput(v interface{}) (*image.CreateImage, error) {
+	in, _ := v.(map[string]interface{})
+	var ci image.CreateImage
+	ci.Title, _ = in["title"].(string)
+	ci.URL, _ = in["url"].(string)
+	ci.Slug, _ = in["slug"].(string)
+	ci.Publisher, _ = in["publisher"].(string)
+	ci.PublishedAt, _ = in["publishedAt"].(time.Time)
+	ci.ExpiredAt, _ = in["expiredAt"].(time.Time)
+	if md, ok := in["metadata"]; ok && md != nil {
+		obj, ok := md.(map[string]interface{})
+		if !ok {
+			return nil, web.InvalidError{{Fld: "metadata", Err: "object", Msg: "must be an object"}}
+		}
+		ci.Metadata = image.Metadata(obj)
+	}
+
+	if err := web.Validate(&ci); err != nil {
+		return nil, err
+	}
+	return &ci, nil
+}
+
+// firstArg reads the first argument of a list, between 1 and max.
+func firstArg(args map[string]interface{}, max int) (int, error) {
+	first, _ := args["first"].(int)
+	if first < 1 || first > max {
+		return 0, web.InvalidError{{Fld: "first", Err: "max", Param: strconv.Itoa(max), Msg: fmt.Sprintf("must be between 1 and %d", max)}}
+	}
+	return first, nil
+}
+
+// versionArg reads the version argument of a mutation as the precondition
+// of the change, like the If-Match header of the REST routes. When
+// required, the mutations without it fail with ErrPreconditionRequired.
+func versionArg(args map[string]interface{}, required bool) (web.Precondition, error) {
+	version, ok := args["version"].(int)
+	if !ok {
+		if required {
+			return web.Precondition{}, errors.Wrap(web.ErrPreconditionRequired, "version is missing")
+		}
+		return web.Precondition{}, nil
+	}
+	return web.MatchETag(web.ETag(int64(version))), nil
+}
+
+// filterValues converts the filter argument of a list to the query
+// parameters of GET /v1/images: each column is mapped to a filter like
+// "$gt.1000", or to a list of them.
+func filterValues(v interface{}) (url.Values, error) {
+	qp := url.Values{}
+	if v == nil {
+		return qp, nil
+	}
+	filter, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, web.InvalidError{{Fld: "filter", Err: "object", Msg: "must be an object"}}
+	}
+	for k, f := range filter {
+		switch f := f.(type) {
+		case []interface{}:
+			for _, item := range f {
+				qp.Add(k, fmt.Sprint(item))
+			}
+		case map[string]interface{}, nil:
+			return nil, web.InvalidError{{Fld: "filter." + k, Err: "filter", Msg: "must be a filter or a list of filters"}}
+		default:
+			qp.Add(k, fmt.Sprint(f))
+		}
+	}
+	return qp, nil
+}
@@ -2,54 +2,137 @@ package handlers
 
 import (
 	"context"
-	"net/http"
-
 	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
 
 	"github.com/jdelobel/go-api/internal/image"
 	"github.com/jdelobel/go-api/internal/platform/db"
 	"github.com/jdelobel/go-api/internal/platform/rabbitmq"
+	"github.com/jdelobel/go-api/internal/platform/storage"
 	"github.com/jdelobel/go-api/internal/platform/web"
 	"github.com/pkg/errors"
 )
 
 // Image represents the Image API method handler set.
 type Image struct {
-	MasterDB *db.DB
-	rbmq     *rabbitmq.RabbitMQ
+	MasterDB   *db.DB
+	rbmq       *rabbitmq.RabbitMQ
+	StrictJSON bool
+	Store      storage.Store
+	Content    image.ContentOptions
+
+	Derivatives image.DerivativeOptions
+
+	// SearchLanguage is the default text search configuration.
+	SearchLanguage string
+
+	// RequireIfMatch rejects the updates and deletions without an If-Match
+	// header.
+	RequireIfMatch bool
+
+	// Cache reads the images through a cache, nil to disable it.
+	Cache *image.Cache
+
+	// MaxAge is the number of seconds clients may cache the public images.
+	MaxAge int
+
+	// Batch configures POST /v1/images:batch.
+	Batch image.BatchOptions
+
+	// Feed delivers the changes streamed by GET /v1/images/events, with
+	// a comment every Heartbeat to keep the connections alive.
+	Feed      *image.Feed
+	Heartbeat time.Duration
 
 	// ADD OTHER STATE LIKE THE LOGGER AND CONFIG HERE.
 }
 
-// List returns all the existing images in the system.
+// List returns all the existing images in the system. Anonymous callers
+// only get the images inside their visibility window.
 // 200 Success, 404 Not Found, 500 Internal
 func (m *Image) List(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
 	reqDB := m.MasterDB
 	qp := r.URL.Query()
-	images, err := image.List(ctx, reqDB, qp)
+	images, err := m.Cache.List(ctx, reqDB, qp, scope(ctx))
 	if err != nil {
 		return errors.Wrap(err, "")
 	}
+
+	var lastModified time.Time
+	for i := range images {
+		if t := modified(&images[i]); t.After(lastModified) {
+			lastModified = t
+		}
+	}
+	m.cacheHeaders(ctx, w, lastModified)
 	web.Respond(ctx, w, images, http.StatusOK)
 	return nil
 }
 
-// Retrieve returns the specified image from the system.
-// 200 Success, 400 Bad Request, 404 Not Found, 500 Internal
-func (m *Image) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
-	reqDB := m.MasterDB
-	image, err := image.Retrieve(ctx, reqDB, params["id"])
+// Export streams the images matching the filters of List as NDJSON or CSV,
+// chosen by the format query parameter or the Accept header. The columns
+// query parameter selects the exported columns. An export failing midway
+// aborts the connection so that it isn't mistaken for a complete one.
+// 200 Success, 400 Bad Request, 406 Not Acceptable, 500 Internal
+func (m *Image) Export(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	e, err := image.ParseExport(r, scope(ctx))
 	if err != nil {
-		if err.Error() == "sql: no rows in result set" {
-			err = fmt.Errorf("No image found with id %s", params["id"])
-			web.RespondError(ctx, w, err, http.StatusNotFound)
-			return nil
+		return errors.Wrap(err, "Export")
+	}
+
+	sw := web.NewStream(ctx, w, func(h http.Header) {
+		h.Set("Content-Type", e.ContentType())
+		h.Set("Content-Disposition", `attachment; filename="images.`+e.Format+`"`)
+		h.Set("Cache-Control", "no-store")
+	})
+	if err := e.Run(ctx, m.MasterDB, sw); err != nil {
+		if !sw.Started() {
+			return errors.Wrap(err, "Export")
 		}
+		sw.Abort(errors.Wrap(err, "Export"))
+	}
+
+	// An empty NDJSON export writes nothing.
+	if !sw.Started() {
+		sw.WriteHeader(http.StatusOK)
+	}
+	return nil
+}
+
+// Search returns the page of images matching the words of the q query
+// parameter, best ranked first.
+// 200 Success, 400 Bad Request, 500 Internal
+func (m *Image) Search(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	s, err := image.ParseSearch(r.URL.Query(), m.SearchLanguage)
+	if err != nil {
+		return errors.Wrap(err, "Search")
+	}
+	s.Scope = scope(ctx)
 
+	page, err := image.SearchImages(ctx, m.MasterDB, s)
+	if err != nil {
+		return errors.Wrap(err, "Search")
+	}
+
+	web.Respond(ctx, w, page, http.StatusOK)
+	return nil
+}
+
+// Retrieve returns the specified image from the system along with its ETag.
+// Anonymous callers don't find the images which aren't published yet.
+// 200 Success, 304 Not Modified, 400 Bad Request, 404 Not Found, 410 Gone, 500 Internal
+func (m *Image) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	reqDB := m.MasterDB
+	image, err := m.Cache.Retrieve(ctx, reqDB, params["id"], scope(ctx))
+	if err != nil {
 		return errors.Wrapf(err, "Id: %s", params["id"])
 	}
 
-	web.Respond(ctx, w, image, http.StatusOK)
+	m.cacheHeaders(ctx, w, modified(image))
+	web.RespondETag(ctx, w, r, image, web.ETag(image.Version), http.StatusOK)
 	return nil
 }
 
@@ -59,7 +142,7 @@ func (m *Image) Create(ctx context.Context, w http.ResponseWriter, r *http.Reque
 	reqDB := m.MasterDB
 	rbmq := m.rbmq
 	var med image.CreateImage
-	if err := web.Unmarshal(r.Body, &med); err != nil {
+	if err := m.unmarshal(r, &med); err != nil {
 		return errors.Wrap(err, "")
 	}
 
@@ -67,25 +150,247 @@ func (m *Image) Create(ctx context.Context, w http.ResponseWriter, r *http.Reque
 	if err != nil {
 		return errors.Wrapf(err, "Image: %+v", &med)
 	}
+	m.Cache.Invalidate(ctx, *img.ID)
 
-	web.Respond(ctx, w, img, http.StatusCreated)
+	web.RespondETag(ctx, w, r, img, web.ETag(img.Version), http.StatusCreated)
 	return nil
 }
 
-// Update updates the specified image in the system.
-// 200 Success, 400 Bad Request, 500 Internal
+// BatchCreate inserts the images of a JSON array, or of an NDJSON stream
+// sent as application/x-ndjson. The mode query parameter overrides the
+// configured mode. Each image gets its own status in the results.
+// 201 Created, 207 Multi-Status, 400 Bad Request, 413 Request Entity Too Large, 422 Unprocessable Entity, 500 Internal
+func (m *Image) BatchCreate(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	opts := m.Batch
+	mode, err := image.ParseBatchMode(r.URL.Query().Get("mode"), opts.Mode)
+	if err != nil {
+		return errors.Wrap(err, "BatchCreate")
+	}
+	opts.Mode = mode
+
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	items, err := image.DecodeBatch(r.Body, mt == "application/x-ndjson", opts)
+	if err != nil {
+		return errors.Wrap(err, "BatchCreate")
+	}
+
+	out, err := image.CreateBatch(ctx, m.MasterDB, m.rbmq, items, opts)
+	if err != nil {
+		return errors.Wrap(err, "BatchCreate")
+	}
+	if out.Created > 0 {
+		m.Cache.Invalidate(ctx, "")
+	}
+
+	code := http.StatusMultiStatus
+	if opts.Mode == image.BatchAtomic {
+		code = http.StatusCreated
+		if !out.Committed {
+			code = http.StatusUnprocessableEntity
+		}
+	}
+	web.Respond(ctx, w, out, code)
+	return nil
+}
+
+// Update updates the specified image in the system, when its ETag matches
+// the If-Match header. The new ETag is returned.
+// 204 No Content, 400 Bad Request, 404 Not Found, 412 Precondition Failed, 428 Precondition Required, 500 Internal
 func (m *Image) Update(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
 	reqDB := m.MasterDB
+	pre, err := web.IfMatch(r, m.RequireIfMatch)
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s", params["id"])
+	}
 
 	var med image.CreateImage
-	if err := web.Unmarshal(r.Body, &med); err != nil {
+	if err := m.unmarshal(r, &med); err != nil {
 		return errors.Wrap(err, "")
 	}
 
-	if err := image.Update(ctx, reqDB, params["id"], &med); err != nil {
+	version, err := image.Update(ctx, reqDB, params["id"], &med, author(ctx), pre)
+	if err != nil {
 		return errors.Wrapf(err, "Id: %s  Image: %+v", params["id"], &med)
 	}
+	m.Cache.Invalidate(ctx, params["id"])
+
+	web.RespondETag(ctx, w, r, nil, web.ETag(version), http.StatusNoContent)
+	return nil
+}
+
+// Delete soft deletes the specified image, when its ETag matches the
+// If-Match header.
+// 204 No Content, 400 Bad Request, 404 Not Found, 412 Precondition Failed, 428 Precondition Required, 500 Internal
+func (m *Image) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	pre, err := web.IfMatch(r, m.RequireIfMatch)
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s", params["id"])
+	}
+
+	if err := image.Delete(ctx, m.MasterDB, params["id"], author(ctx), pre); err != nil {
+		return errors.Wrapf(err, "Id: %s", params["id"])
+	}
+	m.Cache.Invalidate(ctx, params["id"])
 
 	web.Respond(ctx, w, nil, http.StatusNoContent)
 	return nil
 }
+
+// StoreContent uploads the binary content of the specified image, sent
+// either as the "file" part of a multipart form or as the raw request body.
+// Near-duplicates of the same publisher are reported in a Warning header,
+// or rejected depending on the duplicate check.
+// 200 Success, 400 Bad Request, 404 Not Found, 409 Conflict, 413 Too Large, 415 Unsupported Media Type, 500 Internal
+func (m *Image) StoreContent(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	body, err := contentReader(r)
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s", params["id"])
+	}
+
+	img, similar, err := image.StoreContent(ctx, m.MasterDB, m.Store, params["id"], body, m.Content, author(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s", params["id"])
+	}
+	m.Cache.Invalidate(ctx, params["id"])
+	if len(similar) > 0 {
+		w.Header().Set("Warning", fmt.Sprintf("199 - %q", image.NearDuplicateError(similar).Error()))
+	}
+
+	web.Respond(ctx, w, img, http.StatusOK)
+	return nil
+}
+
+// ListSimilar returns the near-duplicates of the specified image, whose
+// perceptual hashes are within the threshold query parameter.
+// 200 Success, 400 Bad Request, 404 Not Found, 410 Gone, 500 Internal
+func (m *Image) ListSimilar(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	threshold, err := image.ParseThreshold(r.URL.Query())
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s", params["id"])
+	}
+
+	similar, err := image.ListSimilar(ctx, m.MasterDB, params["id"], threshold, scope(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s", params["id"])
+	}
+
+	web.Respond(ctx, w, similar, http.StatusOK)
+	return nil
+}
+
+// RetrieveExif returns the EXIF, IPTC and XMP tags extracted from the
+// content of the specified image.
+// 200 Success, 404 Not Found, 410 Gone, 500 Internal
+func (m *Image) RetrieveExif(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	tags, err := image.RetrieveExif(ctx, m.MasterDB, params["id"], scope(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s", params["id"])
+	}
+
+	web.Respond(ctx, w, tags, http.StatusOK)
+	return nil
+}
+
+// Render returns a derivative of the content of the specified image, resized
+// as described by the w, h, fit, format and dpr query parameters.
+// 200 Success, 400 Bad Request, 404 Not Found, 410 Gone, 422 Unprocessable Entity, 500 Internal
+func (m *Image) Render(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	rd, err := image.ParseRendition(r.URL.Query(), m.Derivatives)
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s", params["id"])
+	}
+
+	rc, contentType, err := image.Render(ctx, m.MasterDB, m.Store, params["id"], rd, m.Derivatives, scope(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s", params["id"])
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	if scope(ctx) == image.Editorial {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	}
+	w.Header().Add("Vary", "Authorization")
+	ctx.Value(web.KeyValues).(*web.Values).StatusCode = http.StatusOK
+	if _, err := io.Copy(w, rc); err != nil {
+		return errors.Wrapf(err, "Id: %s", params["id"])
+	}
+	return nil
+}
+
+// scope returns the images the caller sees: the authenticated editors see
+// them outside their visibility window.
+func scope(ctx context.Context) image.Scope {
+	if ctx.Value(web.KeyValues).(*web.Values).Actor != "" {
+		return image.Editorial
+	}
+	return image.Public
+}
+
+// cacheHeaders sets the Cache-Control and Last-Modified headers of a read.
+// Clients may cache the public responses, the editorial ones must be
+// revalidated. Both vary with the bearer token, which sets the scope.
+func (m *Image) cacheHeaders(ctx context.Context, w http.ResponseWriter, lastModified time.Time) {
+	if scope(ctx) == image.Editorial {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	} else {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", m.MaxAge))
+	}
+	w.Header().Add("Vary", "Authorization")
+	if !lastModified.IsZero() {
+		web.LastModified(w, lastModified)
+	}
+}
+
+// modified returns the last modification time of an image.
+func modified(img *image.Image) time.Time {
+	if img.UpdatedAt != nil {
+		return *img.UpdatedAt
+	}
+	if img.CreatedAt != nil {
+		return *img.CreatedAt
+	}
+	return time.Time{}
+}
+
+// author returns the author of the changes made by the request.
+func author(ctx context.Context) image.Author {
+	v := ctx.Value(web.KeyValues).(*web.Values)
+	return image.Author{Actor: v.Actor, TraceID: v.TraceID}
+}
+
+// contentReader returns the reader over the uploaded content of a request.
+func contentReader(r *http.Request) (io.Reader, error) {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mt != "multipart/form-data" {
+		return r.Body, nil
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, errors.Wrap(web.ErrMalformedBody, err.Error())
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, web.InvalidError{{Fld: "file", Err: "required", Msg: "is required"}}
+		}
+		if err != nil {
+			return nil, web.BodyError(r.Body, errors.Wrap(web.ErrMalformedBody, err.Error()))
+		}
+		if part.FormName() == "file" {
+			return part, nil
+		}
+	}
+}
+
+// unmarshal decodes and validates the request body, rejecting unknown
+// fields in strict mode.
+func (m *Image) unmarshal(r *http.Request, v interface{}) error {
+	if m.StrictJSON {
+		return web.UnmarshalRequestStrict(r, v)
+	}
+	return web.UnmarshalRequest(r, v)
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+// OpenAPI represents the handlers serving the OpenAPI document of the API,
+// generated from its routes once they are all registered.
+type OpenAPI struct {
+	json []byte
+	yaml []byte
+}
+
+// Load encodes the document served.
+func (o *OpenAPI) Load(doc *web.OpenAPI) error {
+	var err error
+	if o.json, err = doc.JSON(); err != nil {
+		return errors.Wrap(err, "OpenAPI JSON")
+	}
+	if o.yaml, err = doc.YAML(); err != nil {
+		return errors.Wrap(err, "OpenAPI YAML")
+	}
+	return nil
+}
+
+// JSON returns the document as JSON.
+// 200 Success
+func (o *OpenAPI) JSON(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	return o.serve(ctx, w, web.JSONContentType, o.json)
+}
+
+// YAML returns the document as YAML.
+// 200 Success
+func (o *OpenAPI) YAML(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	return o.serve(ctx, w, "application/yaml", o.yaml)
+}
+
+// serve writes the document encoded as the media type.
+func (o *OpenAPI) serve(ctx context.Context, w http.ResponseWriter, mediaType string, doc []byte) error {
+	w.Header().Set("Content-Type", mediaType)
+	ctx.Value(web.KeyValues).(*web.Values).StatusCode = http.StatusOK
+	if _, err := w.Write(doc); err != nil {
+		return errors.Wrap(err, "OpenAPI")
+	}
+	return nil
+}
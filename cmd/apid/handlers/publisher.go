@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/jdelobel/go-api/internal/image"
+	"github.com/jdelobel/go-api/internal/platform/db"
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+// Publisher represents the Publisher API method handler set.
+type Publisher struct {
+	MasterDB *db.DB
+}
+
+// RetrieveSchema returns the metadata schema of the publisher.
+// 200 Success, 404 Not Found, 500 Internal
+func (p *Publisher) RetrieveSchema(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	s, err := image.RetrieveSchema(ctx, p.MasterDB, params["publisher"])
+	if err != nil {
+		return errors.Wrapf(err, "Publisher: %s", params["publisher"])
+	}
+
+	web.Respond(ctx, w, s, http.StatusOK)
+	return nil
+}
+
+// SaveSchema creates or replaces the metadata schema of the publisher. The
+// request body is the JSON Schema document.
+// 200 Success, 400 Bad Request, 500 Internal
+func (p *Publisher) SaveSchema(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	doc, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+	if !json.Valid(doc) {
+		return errors.Wrap(web.ErrMalformedBody, "SaveSchema")
+	}
+
+	s, err := image.SaveSchema(ctx, p.MasterDB, params["publisher"], doc)
+	if err != nil {
+		return errors.Wrapf(err, "Publisher: %s", params["publisher"])
+	}
+
+	web.Respond(ctx, w, s, http.StatusOK)
+	return nil
+}
+
+// DeleteSchema removes the metadata schema of the publisher.
+// 204 No Content, 404 Not Found, 500 Internal
+func (p *Publisher) DeleteSchema(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	if err := image.DeleteSchema(ctx, p.MasterDB, params["publisher"]); err != nil {
+		return errors.Wrapf(err, "Publisher: %s", params["publisher"])
+	}
+
+	web.Respond(ctx, w, nil, http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/jdelobel/go-api/internal/image"
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+// ListRevisions returns the revisions of the specified image, latest first.
+// 200 Success, 400 Bad Request, 404 Not Found, 410 Gone, 500 Internal
+func (m *Image) ListRevisions(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	revisions, err := image.ListRevisions(ctx, m.MasterDB, params["id"], scope(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s", params["id"])
+	}
+
+	web.Respond(ctx, w, revisions, http.StatusOK)
+	return nil
+}
+
+// RetrieveRevision returns the specified revision of an image, with the
+// state the image had before the change.
+// 200 Success, 400 Bad Request, 404 Not Found, 410 Gone, 500 Internal
+func (m *Image) RetrieveRevision(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	rev, err := image.ParseRevision("rev", params["rev"])
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s", params["id"])
+	}
+
+	revision, err := image.RetrieveRevision(ctx, m.MasterDB, params["id"], rev, scope(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s Revision: %d", params["id"], rev)
+	}
+
+	web.Respond(ctx, w, revision, http.StatusOK)
+	return nil
+}
+
+// DiffRevisions returns the fields which changed from the specified
+// revision to the one of the to query parameter, or to the current state
+// of the image without it.
+// 200 Success, 400 Bad Request, 404 Not Found, 410 Gone, 500 Internal
+func (m *Image) DiffRevisions(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	from, err := image.ParseRevision("rev", params["rev"])
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s", params["id"])
+	}
+	to := image.Current
+	if s := r.URL.Query().Get("to"); s != "" {
+		if to, err = image.ParseRevision("to", s); err != nil {
+			return errors.Wrapf(err, "Id: %s", params["id"])
+		}
+	}
+
+	changes, err := image.DiffRevisions(ctx, m.MasterDB, params["id"], from, to, scope(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s Revision: %d", params["id"], from)
+	}
+
+	web.Respond(ctx, w, changes, http.StatusOK)
+	return nil
+}
+
+// RestoreRevision brings the specified image back to the state recorded by
+// a revision.
+// 200 Success, 400 Bad Request, 404 Not Found, 409 Conflict, 500 Internal
+func (m *Image) RestoreRevision(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	rev, err := image.ParseRevision("rev", params["rev"])
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s", params["id"])
+	}
+
+	img, err := image.RestoreRevision(ctx, m.MasterDB, params["id"], rev, author(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s Revision: %d", params["id"], rev)
+	}
+	m.Cache.Invalidate(ctx, params["id"])
+
+	web.Respond(ctx, w, img, http.StatusOK)
+	return nil
+}
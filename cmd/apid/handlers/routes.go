@@ -1,24 +1,41 @@
 package handlers
 
 import (
+	"encoding/json"
 	"net/http"
 	"path"
 	"runtime"
+	"time"
 
 	"github.com/apex/log"
 
 	"github.com/jdelobel/go-api/config"
+	"github.com/jdelobel/go-api/internal/image"
 	"github.com/jdelobel/go-api/internal/middleware"
 	"github.com/jdelobel/go-api/internal/platform/db"
+	"github.com/jdelobel/go-api/internal/platform/gql"
+	"github.com/jdelobel/go-api/internal/platform/imaging"
 	"github.com/jdelobel/go-api/internal/platform/rabbitmq"
+	"github.com/jdelobel/go-api/internal/platform/storage"
 	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/jdelobel/go-api/internal/webhook"
 )
 
 // API returns a handler for a set of routes.
-func API(masterDB *db.DB, log *log.Entry, c config.Config, rbmq *rabbitmq.RabbitMQ) http.Handler {
+func API(masterDB *db.DB, log *log.Entry, c config.Config, rbmq *rabbitmq.RabbitMQ, store storage.Store, cache *image.Cache, feed *image.Feed) http.Handler {
 
 	// Create the web handler for setting routes and middleware.
 	app := web.New(log, middleware.RequestLogger, middleware.ErrorHandler)
+	app.MaxBodySize = c.HTTP.MaxBodySize
+	app.BodyLimits = bodyLimits(c)
+	app.Timeout = time.Duration(c.HTTP.Timeout) * time.Second
+	app.Timeouts = timeouts(c)
+	if c.HTTP.Compression.Enabled {
+		app.Compression = &web.Compression{Encodings: c.HTTP.Compression.Encodings, MinSize: c.HTTP.Compression.MinSize}
+		if len(app.Compression.Encodings) == 0 {
+			app.Compression.Encodings = web.DefaultEncodings
+		}
+	}
 	// Create the file server to serve static content such as
 	// the index.html page.
 	statics := http.FileServer(http.Dir(staticsDir()))
@@ -26,19 +43,358 @@ func API(masterDB *db.DB, log *log.Entry, c config.Config, rbmq *rabbitmq.Rabbit
 
 	// Initialize the routes for the API binding the route to the
 	// handler code for each specified verb.
-	m := Image{masterDB, rbmq}
+	m := Image{
+		MasterDB:   masterDB,
+		rbmq:       rbmq,
+		StrictJSON: c.Validation.StrictJSON,
+		Store:      store,
+		Content: image.ContentOptions{
+			MaxSize:      c.Storage.MaxSize,
+			AllowedTypes: c.Storage.AllowedTypes,
+			StripGPS:     c.Storage.StripGPS,
+			MaxPixels:    c.Render.MaxPixels,
+			Duplicates: image.DuplicateCheck{
+				Mode:      c.Duplicates.Mode,
+				Threshold: c.Duplicates.Threshold,
+			},
+		},
+		SearchLanguage: c.Search.Language,
+		RequireIfMatch: c.Concurrency.RequireIfMatch,
+		Cache:          cache,
+		MaxAge:         c.Cache.MaxAge,
+		Batch: image.BatchOptions{
+			MaxItems:   c.Batch.MaxItems,
+			Mode:       c.Batch.Mode,
+			Events:     c.Batch.Events,
+			StrictJSON: c.Validation.StrictJSON,
+		},
+		Feed:      feed,
+		Heartbeat: time.Duration(c.Events.Heartbeat) * time.Second,
+		Derivatives: image.DerivativeOptions{
+			Presets:   c.Render.Presets,
+			MaxDPR:    c.Render.MaxDPR,
+			MaxPixels: c.Render.MaxPixels,
+		},
+	}
+	ws := Socket{
+		Feed:           feed,
+		AllowedOrigins: c.CORS.AllowedOrigins,
+		PingInterval:   time.Duration(c.WebSocket.PingInterval) * time.Second,
+	}
+	gq := NewGraphQL(&m, c.GraphQL.MaxDepth, c.GraphQL.MaxComplexity)
+	wh := Webhook{MasterDB: masterDB, StrictJSON: c.Validation.StrictJSON}
+	b := Blob{MasterDB: masterDB, Store: store}
+	p := Publisher{MasterDB: masterDB}
 	h := Healthzcheck{masterDB}
-	s := Swagger{URL: c.AppHost + ":" + c.AppPort}
-	app.Handle("GET", "/v1/healthz", h.Healthz)
-	app.Handle("GET", "/v1/readiness", h.Readiness)
-	app.Handle("GET", "/v1/swagger/swagger.yaml", s.GetAPIDocs)
-	app.Handle("GET", "/v1/images", m.List)
-	app.Handle("POST", "/v1/images", m.Create)
-	app.Handle("GET", "/v1/images/:id", m.Retrieve)
-	app.Handle("PUT", "/v1/images/:id", m.Update)
+	docs := &OpenAPI{}
+	app.Handle("GET", "/v1/healthz", h.Healthz).Describe(web.Doc{
+		Summary:   "Check the API is alive",
+		Tags:      []string{"health"},
+		Responses: map[int]interface{}{http.StatusOK: HealthCheckResp{}},
+	})
+	app.Handle("GET", "/v1/readiness", h.Readiness).Describe(web.Doc{
+		Summary:   "Check the API can serve the requests",
+		Tags:      []string{"health"},
+		Responses: map[int]interface{}{http.StatusOK: HealthCheckResp{}, http.StatusInternalServerError: HealthCheckResp{}},
+	})
+	app.Handle("GET", "/v1/openapi.json", docs.JSON).Describe(web.Doc{
+		Summary:   "Get the OpenAPI document of the API",
+		Tags:      []string{"docs"},
+		Responses: map[int]interface{}{http.StatusOK: web.Media{web.JSONContentType: nil}},
+	})
+	app.Handle("GET", "/v1/openapi.yaml", docs.YAML).Describe(web.Doc{
+		Summary:   "Get the OpenAPI document of the API as YAML",
+		Tags:      []string{"docs"},
+		Responses: map[int]interface{}{http.StatusOK: web.Media{"application/yaml": nil}},
+	})
+
+	// The routes called by the browser applications answer their
+	// preflights, and the authentication errors carry the CORS headers.
+	// The requests handled at once are limited for the whole API, and
+	// further for the queries which hit the DB hardest, GraphQL included.
+	// The event streams, which last, are limited by the feed instead. The
+	// images, their content and the metadata schemas are changed by the
+	// editors only, as are the webhooks, which hold the secrets of the
+	// partners. Each route is described for the OpenAPI document,
+	// which the requests and the responses may be checked against.
+	cors := middleware.CORS(middleware.CORSOptions{
+		AllowedOrigins:   c.CORS.AllowedOrigins,
+		AllowedMethods:   c.CORS.AllowedMethods,
+		AllowedHeaders:   c.CORS.AllowedHeaders,
+		ExposedHeaders:   c.CORS.ExposedHeaders,
+		AllowCredentials: c.CORS.AllowCredentials,
+		MaxAge:           c.CORS.MaxAge,
+	})
+	queueTarget := time.Duration(c.Limits.QueueTarget) * time.Millisecond
+	limit := middleware.Limit(middleware.LimitOptions{
+		MaxInFlight: c.Limits.API.MaxInFlight,
+		MaxQueue:    c.Limits.API.MaxQueue,
+		QueueTarget: queueTarget,
+	})
+	contract := &web.Contract{}
+	validate := middleware.Validate(contract, middleware.ValidateOptions{
+		Requests:  c.Contract.Requests,
+		Responses: c.Contract.Responses,
+	})
+	api := app.Group(cors, middleware.Authenticate(c.Auth.Tokens), validate, limit)
+	editors := app.Group(cors, middleware.Authenticate(c.Auth.Tokens), middleware.RequireActor, validate, limit)
+	streams := app.Group(cors, middleware.Authenticate(c.Auth.Tokens), validate)
+	queries := app.Group(cors, middleware.Authenticate(c.Auth.Tokens), validate, limit, middleware.Limit(middleware.LimitOptions{
+		MaxInFlight: c.Limits.Queries.MaxInFlight,
+		MaxQueue:    c.Limits.Queries.MaxQueue,
+		QueueTarget: queueTarget,
+	}))
+	queries.Handle("GET", "/v1/images", m.List).Describe(web.Doc{
+		Summary:     "List the images",
+		Description: "Anonymous callers only get the images inside their visibility window.",
+		Tags:        []string{"images"},
+		Params:      imageFilters,
+		Responses:   map[int]interface{}{http.StatusOK: []image.Image{}},
+	})
+	editors.Handle("POST", "/v1/images", m.Create).Describe(web.Doc{
+		Summary:   "Create an image",
+		Tags:      []string{"images"},
+		Body:      image.CreateImage{},
+		Responses: map[int]interface{}{http.StatusCreated: image.Image{}},
+	})
+	editors.Handle("POST", "/v1/images:batch", m.BatchCreate).Describe(web.Doc{
+		Summary:     "Create a batch of images",
+		Description: "The images are sent as a JSON array, or as NDJSON with one image per line. Each image gets its own status in the results.",
+		Tags:        []string{"images"},
+		Params:      []web.Param{{Name: "mode", Description: "Overrides the configured mode.", Enum: []string{image.BatchAtomic, image.BatchItems}}},
+		Body:        web.Media{web.JSONContentType: []image.CreateImage{}, "application/x-ndjson": nil},
+		Responses:   map[int]interface{}{http.StatusCreated: image.BatchOutcome{}, http.StatusMultiStatus: image.BatchOutcome{}, http.StatusUnprocessableEntity: image.BatchOutcome{}},
+	})
+	queries.Handle("GET", "/v1/images/search", m.Search).Describe(web.Doc{
+		Summary: "Search the images",
+		Tags:    []string{"images"},
+		Params: append([]web.Param{
+			{Name: "q", Description: "Words searched, best ranked first.", Required: true},
+			{Name: "lang", Description: "Text search configuration, like english or french."},
+			{Name: "include_expired", Type: "boolean"},
+		}, pageParams...),
+		Responses: map[int]interface{}{http.StatusOK: web.Page{Data: []image.Image{}}},
+	})
+	queries.Handle("GET", "/v1/images/export", m.Export).Describe(web.Doc{
+		Summary:     "Export the images",
+		Description: "Streams the images matching the filters, as chosen by the format query parameter or the Accept header.",
+		Tags:        []string{"images"},
+		Params: append([]web.Param{
+			{Name: "format", Enum: []string{"ndjson", "csv"}},
+			{Name: "columns", Description: "Comma separated list of the exported columns."},
+		}, imageFilters...),
+		Responses: map[int]interface{}{http.StatusOK: web.Media{"application/x-ndjson": nil, "text/csv": nil}},
+	})
+	streams.Handle("GET", "/v1/images/events", m.Events).Describe(web.Doc{
+		Summary:     "Stream the changes of the images",
+		Description: "Server-Sent Events, resumed from the Last-Event-ID header or the last_event_id query parameter. Clients resuming after purged events get 410 and start afresh.",
+		Tags:        []string{"events"},
+		Params: []web.Param{
+			{Name: "publisher", Description: "Comma separated list of the publishers of the images."},
+			{Name: "last_event_id", Type: "integer"},
+			{Name: "Last-Event-ID", In: "header", Type: "integer"},
+		},
+		Responses: map[int]interface{}{http.StatusOK: web.Media{"text/event-stream": nil}},
+	})
+	streams.Handle("GET", "/v1/ws", ws.Serve).Describe(web.Doc{
+		Summary:   "Subscribe to the changes of the images over a WebSocket",
+		Tags:      []string{"events"},
+		Params:    []web.Param{{Name: "access_token", Description: "Bearer token of the browsers, which can't send the Authorization header."}},
+		Responses: map[int]interface{}{http.StatusSwitchingProtocols: nil},
+	})
+	api.Handle("GET", "/v1/images/:id", m.Retrieve).Describe(web.Doc{
+		Summary:     "Get an image",
+		Description: "Anonymous callers don't find the images which aren't published yet.",
+		Tags:        []string{"images"},
+		Params:      []web.Param{imageID, {Name: "If-None-Match", In: "header"}, {Name: "If-Modified-Since", In: "header"}},
+		Responses:   map[int]interface{}{http.StatusOK: image.Image{}, http.StatusNotModified: nil},
+	})
+	editors.Handle("PUT", "/v1/images/:id", m.Update).Describe(web.Doc{
+		Summary:   "Update an image",
+		Tags:      []string{"images"},
+		Params:    []web.Param{imageID, ifMatch},
+		Body:      image.CreateImage{},
+		Responses: map[int]interface{}{http.StatusNoContent: nil},
+	})
+	editors.Handle("DELETE", "/v1/images/:id", m.Delete).Describe(web.Doc{
+		Summary:   "Delete an image",
+		Tags:      []string{"images"},
+		Params:    []web.Param{imageID, ifMatch},
+		Responses: map[int]interface{}{http.StatusNoContent: nil},
+	})
+	editors.Handle("POST", "/v1/images/:id/content", m.StoreContent).Describe(web.Doc{
+		Summary:     "Upload the content of an image",
+		Description: "The content is sent as the file part of a multipart form or as the raw body.",
+		Tags:        []string{"content"},
+		Params:      []web.Param{imageID},
+		Body:        web.Media{"multipart/form-data": nil, "application/octet-stream": nil, "image/*": nil},
+		Responses:   map[int]interface{}{http.StatusOK: image.Image{}},
+	})
+	api.Handle("GET", "/v1/images/:id/render", m.Render).Describe(web.Doc{
+		Summary: "Render a derivative of the content of an image",
+		Tags:    []string{"content"},
+		Params: []web.Param{
+			imageID,
+			{Name: "w", Type: "integer"},
+			{Name: "h", Type: "integer"},
+			{Name: "fit", Enum: []string{imaging.FitContain, imaging.FitCover, imaging.FitFill}},
+			{Name: "format", Enum: imaging.Formats()},
+			{Name: "dpr", Type: "integer"},
+		},
+		Responses: map[int]interface{}{http.StatusOK: web.Media{"image/*": nil}},
+	})
+	api.Handle("GET", "/v1/images/:id/exif", m.RetrieveExif).Describe(web.Doc{
+		Summary:   "Get the EXIF, IPTC and XMP tags of the content of an image",
+		Tags:      []string{"content"},
+		Params:    []web.Param{imageID},
+		Responses: map[int]interface{}{http.StatusOK: image.Metadata{}},
+	})
+	queries.Handle("GET", "/v1/images/:id/similar", m.ListSimilar).Describe(web.Doc{
+		Summary:   "List the near-duplicates of an image",
+		Tags:      []string{"content"},
+		Params:    []web.Param{imageID, {Name: "threshold", Type: "integer", Description: "Highest distance between the perceptual hashes, from 0 to 64."}},
+		Responses: map[int]interface{}{http.StatusOK: []image.Similar{}},
+	})
+	queries.Handle("GET", "/v1/graphql", gq.Serve).Describe(web.Doc{
+		Summary: "Execute a GraphQL query",
+		Tags:    []string{"graphql"},
+		Params: []web.Param{
+			{Name: "query", Required: true},
+			{Name: "operationName"},
+			{Name: "variables", Description: "JSON object of the variables."},
+		},
+		Responses: map[int]interface{}{http.StatusOK: graphQLResult},
+	})
+	queries.Handle("POST", "/v1/graphql", gq.Serve).Describe(web.Doc{
+		Summary:   "Execute a GraphQL query or mutation",
+		Tags:      []string{"graphql"},
+		Body:      gql.Request{},
+		Responses: map[int]interface{}{http.StatusOK: graphQLResult},
+	})
+	api.Handle("GET", "/v1/images/:id/revisions", m.ListRevisions).Describe(web.Doc{
+		Summary:   "List the revisions of an image",
+		Tags:      []string{"revisions"},
+		Params:    []web.Param{imageID},
+		Responses: map[int]interface{}{http.StatusOK: []image.Revision{}},
+	})
+	api.Handle("GET", "/v1/images/:id/revisions/:rev", m.RetrieveRevision).Describe(web.Doc{
+		Summary:   "Get a revision of an image",
+		Tags:      []string{"revisions"},
+		Params:    []web.Param{imageID, revision},
+		Responses: map[int]interface{}{http.StatusOK: image.Revision{}},
+	})
+	api.Handle("GET", "/v1/images/:id/revisions/:rev/diff", m.DiffRevisions).Describe(web.Doc{
+		Summary:   "List the changes of an image since a revision",
+		Tags:      []string{"revisions"},
+		Params:    []web.Param{imageID, revision, {Name: "to", Type: "integer", Description: "Revision compared, the current state when missing."}},
+		Responses: map[int]interface{}{http.StatusOK: []image.Change{}},
+	})
+	editors.Handle("POST", "/v1/images/:id/revisions/:rev/restore", m.RestoreRevision).Describe(web.Doc{
+		Summary:   "Restore an image to a revision",
+		Tags:      []string{"revisions"},
+		Params:    []web.Param{imageID, revision},
+		Responses: map[int]interface{}{http.StatusOK: image.Image{}},
+	})
+	api.Handle("GET", "/v1/blobs/*key", b.Retrieve).Describe(web.Doc{
+		Summary:     "Get a blob of the local storage",
+		Description: "Anonymous callers only get the blobs of the images inside their visibility window.",
+		Tags:        []string{"content"},
+		Responses:   map[int]interface{}{http.StatusOK: web.Media{"application/octet-stream": nil}},
+	})
+	api.Handle("GET", "/v1/publishers/:publisher/schema", p.RetrieveSchema).Describe(web.Doc{
+		Summary:   "Get the metadata schema of a publisher",
+		Tags:      []string{"publishers"},
+		Responses: map[int]interface{}{http.StatusOK: image.Schema{}},
+	})
+	editors.Handle("PUT", "/v1/publishers/:publisher/schema", p.SaveSchema).Describe(web.Doc{
+		Summary:   "Save the metadata schema of a publisher",
+		Tags:      []string{"publishers"},
+		Body:      web.Media{web.JSONContentType: json.RawMessage{}},
+		Responses: map[int]interface{}{http.StatusOK: image.Schema{}},
+	})
+	editors.Handle("DELETE", "/v1/publishers/:publisher/schema", p.DeleteSchema).Describe(web.Doc{
+		Summary:   "Delete the metadata schema of a publisher",
+		Tags:      []string{"publishers"},
+		Responses: map[int]interface{}{http.StatusNoContent: nil},
+	})
+	editors.Handle("GET", "/v1/webhooks", wh.List).Describe(web.Doc{
+		Summary:   "List the webhooks",
+		Tags:      []string{"webhooks"},
+		Responses: map[int]interface{}{http.StatusOK: []webhook.Webhook{}},
+	})
+	editors.Handle("POST", "/v1/webhooks", wh.Create).Describe(web.Doc{
+		Summary:   "Create a webhook",
+		Tags:      []string{"webhooks"},
+		Body:      webhook.CreateWebhook{},
+		Responses: map[int]interface{}{http.StatusCreated: webhook.Webhook{}},
+	})
+	editors.Handle("GET", "/v1/webhooks/:id", wh.Retrieve).Describe(web.Doc{
+		Summary:   "Get a webhook",
+		Tags:      []string{"webhooks"},
+		Params:    []web.Param{webhookID},
+		Responses: map[int]interface{}{http.StatusOK: webhook.Webhook{}},
+	})
+	editors.Handle("PUT", "/v1/webhooks/:id", wh.Update).Describe(web.Doc{
+		Summary:   "Update a webhook",
+		Tags:      []string{"webhooks"},
+		Params:    []web.Param{webhookID},
+		Body:      webhook.CreateWebhook{},
+		Responses: map[int]interface{}{http.StatusOK: webhook.Webhook{}},
+	})
+	editors.Handle("DELETE", "/v1/webhooks/:id", wh.Delete).Describe(web.Doc{
+		Summary:   "Delete a webhook",
+		Tags:      []string{"webhooks"},
+		Params:    []web.Param{webhookID},
+		Responses: map[int]interface{}{http.StatusNoContent: nil},
+	})
+	editors.Handle("GET", "/v1/webhooks/:id/deliveries", wh.ListDeliveries).Describe(web.Doc{
+		Summary:   "List the deliveries of a webhook",
+		Tags:      []string{"webhooks"},
+		Params:    append([]web.Param{webhookID}, pageParams...),
+		Responses: map[int]interface{}{http.StatusOK: web.Page{Data: []webhook.Delivery{}}},
+	})
+
+	// The document is generated once all the routes are registered.
+	doc, err := app.OpenAPI(web.Info{Title: "go-api", Description: "Images API", Version: "1.0.0"})
+	if err != nil {
+		log.Fatalf("startup : OpenAPI document : %v", err)
+	}
+	doc.Components.SecuritySchemes = map[string]*web.SecurityScheme{"bearer": {Type: "http", Scheme: "bearer"}}
+	doc.Security = []map[string][]string{{}, {"bearer": {}}}
+	if err := docs.Load(doc); err != nil {
+		log.Fatalf("startup : %v", err)
+	}
+	contract.Load(doc)
 	return app
 }
 
+// Parameters shared by the descriptions of the routes.
+var (
+	imageID   = web.Param{Name: "id", In: "path", Format: "uuid"}
+	webhookID = web.Param{Name: "id", In: "path", Format: "uuid"}
+	revision  = web.Param{Name: "rev", In: "path", Type: "integer", Description: "Revision number, from 1."}
+	ifMatch   = web.Param{Name: "If-Match", In: "header", Description: "ETag of the image, required when configured."}
+
+	pageParams = []web.Param{
+		{Name: "limit", Type: "integer"},
+		{Name: "offset", Type: "integer"},
+	}
+)
+
+// imageFilters describes the filters of the image lists, like
+// publisher=$eq.etf1. The metadata is filtered by key, like
+// metadata.width=$gt.1000.
+var imageFilters = func() []web.Param {
+	var params []web.Param
+	for _, col := range []string{"id", "title", "url", "slug", "publisher", "published_at", "expired_at", "created_at", "updated_at", "restored_at", "deleted_at"} {
+		params = append(params, web.Param{Name: col, Description: "Filter like $eq.value, $gt.value, $in.a,b or $null."})
+	}
+	return params
+}()
+
+// graphQLResult describes the results of the GraphQL queries.
+var graphQLResult = map[string]interface{}{}
+
 // staticsDir builds a full path to the 'statics' directory
 // that is relative to this file. It uses a trick of the
 // runtime package to get the path of the file that calls
@@ -47,3 +403,41 @@ func staticsDir() string {
 	_, filename, _, _ := runtime.Caller(1)
 	return path.Join(path.Dir(filename), "../statics")
 }
+
+// Sizes from which the default body limits of the routes are computed.
+const (
+	multipartOverhead = 1 << 20
+	maxBatchItemSize  = 16 << 10
+)
+
+// bodyLimits returns the body limits of the routes receiving more than the
+// default: the content uploads, which are limited by the storage, and the
+// batches. The configured limits take precedence.
+func bodyLimits(c config.Config) map[string]int64 {
+	limits := map[string]int64{
+		"POST /v1/images/:id/content": c.Storage.MaxSize + multipartOverhead,
+		"POST /v1/images:batch":       int64(c.Batch.MaxItems) * maxBatchItemSize,
+	}
+	for route, n := range c.HTTP.BodyLimits {
+		limits[route] = n
+	}
+	return limits
+}
+
+// timeouts returns the deadlines of the routes taking longer than the
+// default: the exports, which stream the whole collection, the event
+// streams, and the uploads. The WebSockets last until the clients leave.
+// The configured deadlines take precedence.
+func timeouts(c config.Config) map[string]time.Duration {
+	timeouts := map[string]time.Duration{
+		"GET /v1/images/export":       5 * time.Minute,
+		"GET /v1/images/events":       5 * time.Minute,
+		"GET /v1/ws":                  0,
+		"POST /v1/images/:id/content": time.Minute,
+		"POST /v1/images:batch":       time.Minute,
+	}
+	for route, s := range c.HTTP.Timeouts {
+		timeouts[route] = time.Duration(s) * time.Second
+	}
+	return timeouts
+}
@@ -0,0 +1,360 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"net/url"
+	"time"
+
+	"github.com/apex/log"
+	imagev1 "github.com/jdelobel/go-api/api/image/v1"
+	"github.com/jdelobel/go-api/config"
+	"github.com/jdelobel/go-api/internal/image"
+	"github.com/jdelobel/go-api/internal/middleware"
+	"github.com/jdelobel/go-api/internal/platform/db"
+	"github.com/jdelobel/go-api/internal/platform/rabbitmq"
+	"github.com/jdelobel/go-api/internal/platform/rpc"
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// listBatch is the number of images read at once by ListImages.
+const listBatch = 100
+
+// RPC returns the gRPC server of the images, along with its health service
+// which reports it serving until the shutdown starts.
+func RPC(masterDB *db.DB, log *log.Entry, c config.Config, rbmq *rabbitmq.RabbitMQ, cache *image.Cache, feed *image.Feed) (*grpc.Server, *health.Server) {
+	// The messages are limited like the request bodies, 0 disabling the
+	// limit rather than refusing every message.
+	maxRecv := math.MaxInt32
+	if c.HTTP.MaxBodySize > 0 && c.HTTP.MaxBodySize < math.MaxInt32 {
+		maxRecv = int(c.HTTP.MaxBodySize)
+	}
+
+	// Only the editors change the images, as over HTTP. The reads and the
+	// health service are anonymous.
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(rpc.UnaryValues(log), middleware.UnaryAuthenticate(c.Auth.Tokens), middleware.UnaryRequireActor(
+			imagev1.ImageService_CreateImage_FullMethodName,
+			imagev1.ImageService_UpdateImage_FullMethodName,
+			imagev1.ImageService_DeleteImage_FullMethodName,
+		)),
+		grpc.ChainStreamInterceptor(rpc.StreamValues(log), middleware.StreamAuthenticate(c.Auth.Tokens)),
+		grpc.MaxRecvMsgSize(maxRecv),
+	)
+
+	imagev1.RegisterImageServiceServer(server, &ImageService{
+		MasterDB:       masterDB,
+		rbmq:           rbmq,
+		Cache:          cache,
+		Feed:           feed,
+		RequireIfMatch: c.Concurrency.RequireIfMatch,
+	})
+
+	hs := health.NewServer()
+	hs.SetServingStatus(imagev1.ImageService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, hs)
+	return server, hs
+}
+
+// ImageService represents the gRPC image service. The images are read and
+// changed through the same domain code as the HTTP routes.
+type ImageService struct {
+	imagev1.UnimplementedImageServiceServer
+
+	MasterDB *db.DB
+	rbmq     *rabbitmq.RabbitMQ
+
+	// Cache is invalidated by the changes, nil when it is off.
+	Cache *image.Cache
+
+	// Feed delivers the changes streamed by WatchChanges.
+	Feed *image.Feed
+
+	// RequireIfMatch rejects the updates and deletions without a version.
+	RequireIfMatch bool
+}
+
+// GetImage returns the specified image. Anonymous callers only get the
+// images inside their visibility window.
+func (s *ImageService) GetImage(ctx context.Context, req *imagev1.GetImageRequest) (*imagev1.Image, error) {
+	img, err := s.Cache.Retrieve(ctx, s.MasterDB, req.GetId(), scope(ctx))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Id: %s", req.GetId())
+	}
+	return imageProto(img)
+}
+
+// ListImages streams the images matching the filters of GET /v1/images,
+// oldest first. They are read by batches, so that the stream starts at
+// once whatever the number of images.
+func (s *ImageService) ListImages(req *imagev1.ListImagesRequest, stream imagev1.ImageService_ListImagesServer) error {
+	ctx := stream.Context()
+	qp := url.Values{}
+	for _, f := range req.GetFilters() {
+		qp.Add(f.GetField(), f.GetValue())
+	}
+	if req.GetLimit() < 0 {
+		return web.InvalidError{{Fld: "limit", Err: "min", Param: "0", Msg: "must be positive"}}
+	}
+
+	var after string
+	for sent, limit := 0, int(req.GetLimit()); limit == 0 || sent < limit; {
+		n := listBatch
+		if limit > 0 && limit-sent < n {
+			n = limit - sent
+		}
+		images, more, err := image.ListAfter(ctx, s.MasterDB, qp, scope(ctx), n, after)
+		if err != nil {
+			return errors.Wrap(err, "ListImages")
+		}
+		for i := range images {
+			msg, err := imageProto(&images[i])
+			if err != nil {
+				return err
+			}
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		}
+		if !more || len(images) == 0 {
+			return nil
+		}
+		sent += len(images)
+		after = image.EncodeCursor(&images[len(images)-1])
+	}
+	return nil
+}
+
+// CreateImage inserts an image.
+func (s *ImageService) CreateImage(ctx context.Context, req *imagev1.CreateImageRequest) (*imagev1.Image, error) {
+	ci, err := imageFromProto(req.GetImage())
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := image.Create(ctx, s.MasterDB, s.rbmq, ci)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Image: %+v", ci)
+	}
+	s.Cache.Invalidate(ctx, *img.ID)
+	return imageProto(img)
+}
+
+// UpdateImage replaces the specified image, when its current version is
+// the one of the request, and returns its new state.
+func (s *ImageService) UpdateImage(ctx context.Context, req *imagev1.UpdateImageRequest) (*imagev1.Image, error) {
+	pre, err := versionPrecondition(req.Version, s.RequireIfMatch)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Id: %s", req.GetId())
+	}
+	ci, err := imageFromProto(req.GetImage())
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := image.Update(ctx, s.MasterDB, req.GetId(), ci, author(ctx), pre); err != nil {
+		return nil, errors.Wrapf(err, "Id: %s  Image: %+v", req.GetId(), ci)
+	}
+	s.Cache.Invalidate(ctx, req.GetId())
+
+	img, err := image.Retrieve(ctx, s.MasterDB, req.GetId(), image.Editorial)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Id: %s", req.GetId())
+	}
+	return imageProto(img)
+}
+
+// DeleteImage soft deletes the specified image, when its current version
+// is the one of the request.
+func (s *ImageService) DeleteImage(ctx context.Context, req *imagev1.DeleteImageRequest) (*emptypb.Empty, error) {
+	pre, err := versionPrecondition(req.Version, s.RequireIfMatch)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Id: %s", req.GetId())
+	}
+
+	if err := image.Delete(ctx, s.MasterDB, req.GetId(), author(ctx), pre); err != nil {
+		return nil, errors.Wrapf(err, "Id: %s", req.GetId())
+	}
+	s.Cache.Invalidate(ctx, req.GetId())
+	return &emptypb.Empty{}, nil
+}
+
+// WatchChanges streams the creations, updates and deletions of the images
+// of the publishers, like GET /v1/images/events. A client resuming with
+// the id of the last event it received first gets the events it missed,
+// or the NotFound code when they were purged. Clients falling behind are
+// ended with the Unavailable code, and resume the same way. Anonymous callers only get the events of the images inside
+// their visibility window.
+func (s *ImageService) WatchChanges(req *imagev1.WatchChangesRequest, stream imagev1.ImageService_WatchChangesServer) error {
+	ctx := stream.Context()
+	last, publishers := req.GetLastEventId(), req.GetPublishers()
+	if last < 0 {
+		return web.InvalidError{{Fld: "last_event_id", Err: "numeric", Msg: "must be an event id"}}
+	}
+
+	// Subscribe before catching up, so that no event is missed in between.
+	sub, err := s.Feed.Subscribe(image.ByPublisher(publishers))
+	if err != nil {
+		return errors.Wrap(err, "WatchChanges")
+	}
+	defer s.Feed.Unsubscribe(sub)
+
+	sc := scope(ctx)
+	send := func(e *image.FeedEvent) error {
+		last = e.ID
+		if !e.Visible(sc, time.Now()) {
+			return nil
+		}
+		msg, err := eventProto(e)
+		if err != nil {
+			return err
+		}
+		return stream.Send(msg)
+	}
+
+	for caught := last == 0; !caught; {
+		events, err := image.ListEvents(ctx, s.MasterDB, last, publishers, eventsPage)
+		if err != nil {
+			return errors.Wrap(err, "WatchChanges")
+		}
+		for i := range events {
+			if err := send(&events[i]); err != nil {
+				return err
+			}
+		}
+		caught = len(events) < eventsPage
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-sub.Events():
+			if !ok {
+				return status.Errorf(codes.Unavailable, "too slow, resume after the event %d", last)
+			}
+			if e.ID <= last {
+				continue
+			}
+			if err := send(&e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// versionPrecondition returns the precondition of a change from the
+// version of the request, like the If-Match header of the HTTP routes.
+// When required, the changes without it fail with ErrPreconditionRequired.
+func versionPrecondition(version *int64, required bool) (web.Precondition, error) {
+	if version == nil {
+		if required {
+			return web.Precondition{}, errors.Wrap(web.ErrPreconditionRequired, "version is missing")
+		}
+		return web.Precondition{}, nil
+	}
+	return web.MatchETag(web.ETag(*version)), nil
+}
+
+// imageFromProto converts the input of a change to the image it describes,
+// validated as the bodies of the HTTP routes.
+func imageFromProto(in *imagev1.ImageInput) (*image.CreateImage, error) {
+	if in == nil {
+		return nil, web.InvalidError{{Fld: "image", Err: "required", Msg: "is required"}}
+	}
+	ci := image.CreateImage{
+		Title:     in.GetTitle(),
+		URL:       in.GetUrl(),
+		Slug:      in.GetSlug(),
+		Publisher: in.GetPublisher(),
+	}
+	if in.PublishedAt != nil {
+		ci.PublishedAt = in.PublishedAt.AsTime()
+	}
+	if in.ExpiredAt != nil {
+		ci.ExpiredAt = in.ExpiredAt.AsTime()
+	}
+	if in.Metadata != nil {
+		ci.Metadata = image.Metadata(in.Metadata.AsMap())
+	}
+
+	if err := web.Validate(&ci); err != nil {
+		return nil, err
+	}
+	return &ci, nil
+}
+
+// imageProto converts an image to its message.
+func imageProto(img *image.Image) (*imagev1.Image, error) {
+	msg := &imagev1.Image{
+		Id:            str(img.ID),
+		Title:         str(img.Title),
+		Url:           str(img.URL),
+		Slug:          str(img.Slug),
+		Publisher:     str(img.Publisher),
+		PublishedAt:   timestamp(img.PublishedAt),
+		ExpiredAt:     timestamp(img.ExpiredAt),
+		ContentType:   str(img.ContentType),
+		ContentSha256: str(img.ContentSHA256),
+		Version:       img.Version,
+		CreatedAt:     timestamp(img.CreatedAt),
+		UpdatedAt:     timestamp(img.UpdatedAt),
+		RestoredAt:    timestamp(img.RestoredAt),
+		DeletedAt:     timestamp(img.DeletedAt),
+	}
+	if img.ContentSize != nil {
+		msg.ContentSize = *img.ContentSize
+	}
+	if img.Metadata != nil {
+		md, err := structpb.NewStruct(img.Metadata)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Id: %s metadata", msg.Id)
+		}
+		msg.Metadata = md
+	}
+	return msg, nil
+}
+
+// eventProto converts an event of the feed to its message.
+func eventProto(e *image.FeedEvent) (*imagev1.ImageEvent, error) {
+	msg := &imagev1.ImageEvent{
+		Id:        e.ID,
+		Type:      e.Type,
+		ImageId:   e.ImageID,
+		Publisher: str(e.Publisher),
+		Time:      timestamppb.New(e.CreatedAt),
+	}
+	if e.State != nil {
+		img, err := imageProto(&e.State.Image)
+		if err != nil {
+			return nil, err
+		}
+		msg.Image = img
+	}
+	return msg, nil
+}
+
+// str returns the string, empty when it is nil.
+func str(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// timestamp returns the time as a timestamp, nil when it is nil.
+func timestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
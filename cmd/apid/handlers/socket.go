@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jdelobel/go-api/internal/image"
+	"github.com/jdelobel/go-api/internal/middleware"
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+// Limits of the WebSocket connections.
+const (
+	socketWriteWait  = 10 * time.Second
+	socketMaxMessage = 64 << 10
+	socketMaxFilter  = 1000
+	socketReplies    = 16
+)
+
+// Types of the messages of the WebSocket protocol. The clients send
+// subscribe and unsubscribe messages, answered by ack or error messages,
+// and receive the event messages of their subscriptions.
+const (
+	socketSubscribe   = "subscribe"
+	socketUnsubscribe = "unsubscribe"
+	socketAck         = "ack"
+	socketError       = "error"
+	socketEvent       = "event"
+)
+
+// socketRequest is a message of a client, adding or removing publishers
+// and images to its subscriptions. The id is echoed by the answer.
+type socketRequest struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id,omitempty"`
+	Publishers []string `json:"publishers"`
+	Images     []string `json:"images"`
+}
+
+// socketMessage is a message to a client. An ack lists all the
+// subscriptions of the client.
+type socketMessage struct {
+	Type       string           `json:"type"`
+	ID         string           `json:"id,omitempty"`
+	Publishers []string         `json:"publishers,omitempty"`
+	Images     []string         `json:"images,omitempty"`
+	Event      *image.FeedEvent `json:"event,omitempty"`
+	Error      *web.Problem     `json:"error,omitempty"`
+}
+
+// Socket streams the image events over WebSockets, to the clients which
+// subscribe to them at runtime.
+type Socket struct {
+	Feed *image.Feed
+
+	// AllowedOrigins lists the origins of the browser applications
+	// allowed to connect, like the CORS ones. Same origin connections and
+	// the clients which aren't browsers are always allowed.
+	AllowedOrigins []string
+
+	// PingInterval is the time between two pings. The connections which
+	// don't answer before the next one are closed.
+	PingInterval time.Duration
+}
+
+// Serve upgrades the connection to a WebSocket streaming the events of the
+// publishers and images the client subscribes to. Clients falling behind
+// are closed with the 1013 Try Again Later status. Anonymous callers only
+// get the events of the images inside their visibility window.
+// 101 Switching Protocols, 400 Bad Request, 403 Forbidden, 503 Service Unavailable
+func (s *Socket) Serve(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	var filter image.EventFilter
+	sub, err := s.Feed.Subscribe(filter.Match)
+	if err != nil {
+		return errors.Wrap(err, "Socket")
+	}
+	defer s.Feed.Unsubscribe(sub)
+
+	v := ctx.Value(web.KeyValues).(*web.Values)
+	upgrader := websocket.Upgrader{
+		CheckOrigin: s.checkOrigin,
+		Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+			web.RespondError(ctx, w, reason, status)
+		},
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	v.StatusCode = http.StatusSwitchingProtocols
+
+	// The connection is read by its own goroutine, the answers are sent
+	// along with the events.
+	replies := make(chan socketMessage, socketReplies)
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		s.read(conn, &filter, replies)
+	}()
+
+	sc := scope(ctx)
+	ping := time.NewTicker(s.PingInterval)
+	defer ping.Stop()
+	for {
+		var msg socketMessage
+		select {
+		case <-closed:
+			return nil
+		case msg = <-replies:
+		case e, ok := <-sub.Events():
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"), time.Now().Add(socketWriteWait))
+				return nil
+			}
+			if !e.Visible(sc, time.Now()) {
+				continue
+			}
+			msg = socketMessage{Type: socketEvent, Event: &e}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(socketWriteWait)); err != nil {
+				return nil
+			}
+			continue
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+		if err := conn.WriteJSON(msg); err != nil {
+			return nil
+		}
+	}
+}
+
+// read handles the messages of a client until the connection is closed,
+// or the client misses a ping.
+func (s *Socket) read(conn *websocket.Conn, filter *image.EventFilter, replies chan<- socketMessage) {
+	conn.SetReadLimit(socketMaxMessage)
+	alive := func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * s.PingInterval))
+	}
+	alive("")
+	conn.SetPongHandler(alive)
+
+	for {
+		var req socketRequest
+		var reply socketMessage
+		switch err := conn.ReadJSON(&req); err.(type) {
+		case nil:
+			reply = s.handle(&req, filter)
+		case *json.SyntaxError, *json.UnmarshalTypeError:
+			p := web.NewProblem(errors.Wrap(web.ErrMalformedBody, err.Error()))
+			reply = socketMessage{Type: socketError, Error: &p}
+		default:
+			return
+		}
+		alive("")
+
+		// A client which doesn't read its answers is closed.
+		select {
+		case replies <- reply:
+		default:
+			return
+		}
+	}
+}
+
+// handle applies a request to the subscriptions of a client and returns
+// the answer.
+func (s *Socket) handle(req *socketRequest, filter *image.EventFilter) socketMessage {
+	fail := func(err error) socketMessage {
+		p := web.NewProblem(err)
+		return socketMessage{Type: socketError, ID: req.ID, Error: &p}
+	}
+
+	switch req.Type {
+	case socketSubscribe:
+		for _, id := range req.Images {
+			if !image.IsValidUUID(id) {
+				return fail(errors.Wrapf(web.ErrInvalidID, "IsValidUUID: %s", id))
+			}
+		}
+		for _, p := range req.Publishers {
+			if strings.TrimSpace(p) == "" {
+				return fail(web.InvalidError{{Fld: "publishers", Err: "required", Msg: "is required"}})
+			}
+		}
+		if filter.Len()+len(req.Publishers)+len(req.Images) > socketMaxFilter {
+			return fail(web.InvalidError{{Fld: "images", Err: "max", Param: "1000", Msg: "too many subscriptions"}})
+		}
+		filter.Add(req.Publishers, req.Images)
+	case socketUnsubscribe:
+		filter.Remove(req.Publishers, req.Images)
+	default:
+		return fail(web.InvalidError{{Fld: "type", Err: "oneof", Param: socketSubscribe + " " + socketUnsubscribe, Msg: "must be one of [subscribe unsubscribe]"}})
+	}
+
+	publishers, images := filter.List()
+	return socketMessage{Type: socketAck, ID: req.ID, Publishers: publishers, Images: images}
+}
+
+// checkOrigin allows the requests without origin, from the same origin or
+// from the allowed ones.
+func (s *Socket) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return middleware.MatchOrigin(s.AllowedOrigins, origin)
+}
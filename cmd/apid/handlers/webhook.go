@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/jdelobel/go-api/internal/platform/db"
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/jdelobel/go-api/internal/webhook"
+	"github.com/pkg/errors"
+)
+
+// Pagination of the deliveries.
+const (
+	deliveriesLimit    = 50
+	maxDeliveriesLimit = 500
+)
+
+// Webhook represents the Webhook API method handler set.
+type Webhook struct {
+	MasterDB   *db.DB
+	StrictJSON bool
+}
+
+// List returns the webhooks, without their secrets.
+// 200 Success, 401 Unauthorized, 500 Internal
+func (h *Webhook) List(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	webhooks, err := webhook.List(ctx, h.MasterDB)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	web.Respond(ctx, w, webhooks, http.StatusOK)
+	return nil
+}
+
+// Retrieve returns the specified webhook, without its secret.
+// 200 Success, 400 Bad Request, 401 Unauthorized, 404 Not Found, 500 Internal
+func (h *Webhook) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	wh, err := webhook.Retrieve(ctx, h.MasterDB, params["id"])
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s", params["id"])
+	}
+
+	web.Respond(ctx, w, wh, http.StatusOK)
+	return nil
+}
+
+// Create registers a webhook. It is returned along with its secret, which
+// isn't returned afterwards.
+// 201 Created, 400 Bad Request, 401 Unauthorized, 500 Internal
+func (h *Webhook) Create(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	var cw webhook.CreateWebhook
+	if err := h.unmarshal(r, &cw); err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	wh, err := webhook.Create(ctx, h.MasterDB, &cw)
+	if err != nil {
+		return errors.Wrapf(err, "Webhook: %s", cw.URL)
+	}
+
+	w.Header().Set("Location", "/v1/webhooks/"+wh.ID)
+	web.Respond(ctx, w, wh, http.StatusCreated)
+	return nil
+}
+
+// Update replaces the specified webhook. Setting active to true enables a
+// disabled webhook again.
+// 200 Success, 400 Bad Request, 401 Unauthorized, 404 Not Found, 500 Internal
+func (h *Webhook) Update(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	var uw webhook.CreateWebhook
+	if err := h.unmarshal(r, &uw); err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	wh, err := webhook.Update(ctx, h.MasterDB, params["id"], &uw)
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s", params["id"])
+	}
+
+	web.Respond(ctx, w, wh, http.StatusOK)
+	return nil
+}
+
+// Delete removes the specified webhook along with its deliveries.
+// 204 No Content, 400 Bad Request, 401 Unauthorized, 404 Not Found, 500 Internal
+func (h *Webhook) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	if err := webhook.Delete(ctx, h.MasterDB, params["id"]); err != nil {
+		return errors.Wrapf(err, "Id: %s", params["id"])
+	}
+
+	web.Respond(ctx, w, nil, http.StatusNoContent)
+	return nil
+}
+
+// ListDeliveries returns a page of the deliveries of the specified webhook,
+// latest first, along with their attempts.
+// 200 Success, 400 Bad Request, 401 Unauthorized, 404 Not Found, 500 Internal
+func (h *Webhook) ListDeliveries(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	limit, offset, err := web.ParsePage(r.URL.Query(), deliveriesLimit, maxDeliveriesLimit)
+	if err != nil {
+		return errors.Wrap(err, "ListDeliveries")
+	}
+
+	page, err := webhook.ListDeliveries(ctx, h.MasterDB, params["id"], limit, offset)
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s", params["id"])
+	}
+
+	web.Respond(ctx, w, page, http.StatusOK)
+	return nil
+}
+
+// unmarshal decodes and validates the body of a request, rejecting the
+// unknown fields in strict mode.
+func (h *Webhook) unmarshal(r *http.Request, v interface{}) error {
+	if h.StrictJSON {
+		return web.UnmarshalRequestStrict(r, v)
+	}
+	return web.UnmarshalRequest(r, v)
+}
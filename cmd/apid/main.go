@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,8 +14,12 @@ import (
 
 	"github.com/jdelobel/go-api/cmd/apid/handlers"
 	"github.com/jdelobel/go-api/config"
+	"github.com/jdelobel/go-api/internal/image"
+	"github.com/jdelobel/go-api/internal/platform/cache"
 	"github.com/jdelobel/go-api/internal/platform/db"
 	"github.com/jdelobel/go-api/internal/platform/rabbitmq"
+	"github.com/jdelobel/go-api/internal/platform/storage"
+	"github.com/jdelobel/go-api/internal/webhook"
 	"github.com/jdelobel/go-api/logger"
 )
 
@@ -69,16 +74,102 @@ func main() {
 	if err != nil {
 		log.Fatalf("startup : Register RabitMQ : %v", err)
 	}
+	store, err := newStore(c)
+	if err != nil {
+		log.Fatalf("startup : Register Storage : %v", err)
+	}
+
+	imageCache, err := newCache(c)
+	if err != nil {
+		log.Fatalf("startup : Register Cache : %v", err)
+	}
+
+	// Start the scheduler publishing the publication and expiry events.
+	schedCtx, stopScheduler := context.WithCancel(context.Background())
+	var schedWG sync.WaitGroup
+	if c.Scheduler.Enabled {
+		sched := image.Scheduler{
+			DB:       masterDB,
+			RBMQ:     rbmq,
+			Log:      logger.Log,
+			Cache:    imageCache,
+			Interval: time.Duration(c.Scheduler.Interval) * time.Second,
+			Lag:      time.Duration(c.Scheduler.Lag) * time.Second,
+		}
+		schedWG.Add(1)
+		go func() {
+			logger.Log.Infof("startup : Scheduler running every %v", sched.Interval)
+			sched.Run(schedCtx)
+			schedWG.Done()
+		}()
+	}
+
+	// Start the feed of the image events streamed to the clients.
+	feed := &image.Feed{
+		DB:             masterDB,
+		Log:            logger.Log,
+		Poll:           time.Duration(c.Events.Poll) * time.Second,
+		Buffer:         c.Events.Buffer,
+		MaxSubscribers: c.Events.MaxClients,
+		Retention:      time.Duration(c.Events.Retention) * time.Hour,
+	}
+	feedCtx, stopFeed := context.WithCancel(context.Background())
+	var feedWG sync.WaitGroup
+	feedWG.Add(1)
+	go func() {
+		feed.Run(feedCtx)
+		feedWG.Done()
+	}()
+
+	// Start the dispatcher delivering the image events to the webhooks.
+	hooksCtx, stopHooks := context.WithCancel(context.Background())
+	var hooksWG sync.WaitGroup
+	if c.Webhooks.Enabled {
+		var allowed []*net.IPNet
+		for _, cidr := range c.Webhooks.AllowedNetworks {
+			_, n, err := net.ParseCIDR(cidr)
+			if err != nil {
+				log.Fatalf("startup : Webhooks allowed network : %v", err)
+			}
+			allowed = append(allowed, n)
+		}
+		dispatcher := webhook.Dispatcher{
+			DB:              masterDB,
+			Log:             logger.Log,
+			AllowedNetworks: allowed,
+			Source:          "/v1/images",
+			Interval:        time.Duration(c.Webhooks.Interval) * time.Second,
+			Timeout:         time.Duration(c.Webhooks.Timeout) * time.Second,
+			Workers:         c.Webhooks.Workers,
+			MaxAttempts:     c.Webhooks.MaxAttempts,
+			Backoff:         time.Duration(c.Webhooks.Backoff) * time.Second,
+			MaxBackoff:      time.Duration(c.Webhooks.MaxBackoff) * time.Second,
+			DisableAfter:    time.Duration(c.Webhooks.DisableAfter) * time.Hour,
+			Retention:       time.Duration(c.Webhooks.Retention) * time.Hour,
+		}
+		hooksWG.Add(1)
+		go func() {
+			logger.Log.Infof("startup : Webhooks dispatcher running with %d workers", dispatcher.Workers)
+			dispatcher.Run(hooksCtx)
+			hooksWG.Done()
+		}()
+	}
+
 	host := fmt.Sprintf("%s:%s", c.AppHost, c.AppPort)
-	// Create a new server and set timeout values.
+	// Create a new server and set timeout values. The routes have their
+	// own deadlines, within the write timeout.
 	server := http.Server{
 		Addr:           host,
-		Handler:        handlers.API(masterDB, logger.Log, c, rbmq),
-		ReadTimeout:    5 * time.Second,
-		WriteTimeout:   10 * time.Second,
+		Handler:        handlers.API(masterDB, logger.Log, c, rbmq, store, imageCache, feed),
+		ReadTimeout:    time.Duration(c.HTTP.ReadTimeout) * time.Second,
+		WriteTimeout:   time.Duration(c.HTTP.WriteTimeout) * time.Second,
 		MaxHeaderBytes: 1 << 20,
 	}
 
+	// The event streams end along with the feed once the shutdown starts,
+	// instead of holding it.
+	server.RegisterOnShutdown(stopFeed)
+
 	// We want to report the listener is closed.
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -90,6 +181,22 @@ func main() {
 		wg.Done()
 	}()
 
+	// Start the gRPC listener, on its own port.
+	rpcServer, rpcHealth := handlers.RPC(masterDB, logger.Log, c, rbmq, imageCache, feed)
+	if c.GRPC.Enabled {
+		rpcHost := fmt.Sprintf("%s:%s", c.AppHost, c.GRPC.Port)
+		lis, err := net.Listen("tcp", rpcHost)
+		if err != nil {
+			logger.Log.Fatalf("main : gRPC listener not started : %v", err)
+		}
+		wg.Add(1)
+		go func() {
+			logger.Log.Infof("startup : gRPC listening %s", rpcHost)
+			logger.Log.Infof("shutdown : gRPC listener closed : %v", rpcServer.Serve(lis))
+			wg.Done()
+		}()
+	}
+
 	// Listen for an interrupt signal from the OS.
 	osSignals := make(chan os.Signal, 1)
 	signal.Notify(osSignals, os.Interrupt)
@@ -112,6 +219,28 @@ func main() {
 			logger.Log.Infof("shutdown : Error killing server : %v", err)
 		}
 	}
+
+	// The gRPC health checks report the service not serving, then the
+	// calls in flight complete, the streams ending along with the feed.
+	rpcHealth.Shutdown()
+	stopFeed()
+	stopped := make(chan struct{})
+	go func() {
+		rpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		logger.Log.Infof("shutdown : gRPC graceful stop did not complete in %v", timeout)
+		rpcServer.Stop()
+	}
+
+	stopScheduler()
+	stopHooks()
+	schedWG.Wait()
+	hooksWG.Wait()
+	feedWG.Wait()
 	if err := masterDB.PSQLClose(); err != nil {
 		logger.Log.Errorf("main : Database instance not closed : %v", err)
 	}
@@ -120,3 +249,41 @@ func main() {
 	wg.Wait()
 	logger.Log.Info("main : Completed")
 }
+
+// newStore creates the blob storage backend selected by the configuration.
+func newStore(c config.Config) (storage.Store, error) {
+	switch c.Storage.Backend {
+	case "local":
+		return storage.NewLocal(c.Storage.Local.Dir, c.Storage.Local.BaseURL)
+	case "s3":
+		return storage.NewS3(storage.S3Config{
+			Endpoint:  c.Storage.S3.Endpoint,
+			Region:    c.Storage.S3.Region,
+			Bucket:    c.Storage.S3.Bucket,
+			AccessKey: c.Storage.S3.AccessKey,
+			SecretKey: c.Storage.S3.SecretKey,
+			PublicURL: c.Storage.S3.PublicURL,
+		})
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", c.Storage.Backend)
+}
+
+// newCache creates the image cache selected by the configuration, nil when
+// it is off.
+func newCache(c config.Config) (*image.Cache, error) {
+	ttl := time.Duration(c.Cache.TTL) * time.Second
+	switch c.Cache.Backend {
+	case "off":
+		return nil, nil
+	case "memory":
+		return &image.Cache{Store: cache.NewLRU(c.Cache.Size), TTL: ttl}, nil
+	case "redis":
+		return &image.Cache{Store: cache.NewRedis(cache.RedisConfig{
+			Addr:     c.Cache.Redis.Addr,
+			Password: c.Cache.Redis.Password,
+			DB:       c.Cache.Redis.DB,
+			PoolSize: c.Cache.Redis.PoolSize,
+		}), TTL: ttl}, nil
+	}
+	return nil, fmt.Errorf("unknown cache backend %q", c.Cache.Backend)
+}
@@ -3,6 +3,7 @@ package tests
 import (
 	"bytes"
 	"encoding/json"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
@@ -11,14 +12,16 @@ import (
 
 	"github.com/jdelobel/go-api/cmd/apid/handlers"
 	"github.com/jdelobel/go-api/internal/image"
+	"github.com/jdelobel/go-api/internal/middleware"
 	"github.com/jdelobel/go-api/internal/platform/db"
+	"github.com/jdelobel/go-api/internal/platform/storage"
 	"github.com/jdelobel/go-api/internal/platform/web"
 	"github.com/jdelobel/go-api/logger"
 
 	"strings"
 
 	"github.com/jdelobel/go-api/config"
-	"gopkg.in/mgo.v2/bson"
+	"github.com/pborman/uuid"
 )
 
 const (
@@ -27,6 +30,9 @@ const (
 
 	// Failed is the Unicode codepoint for an X mark.
 	Failed = "\u2717"
+
+	// editorToken is the bearer token of the editor changing the images.
+	editorToken = "s3cr3t"
 )
 
 // The web application state for tests
@@ -56,12 +62,25 @@ func runTest(m *testing.M) int {
 	}
 
 	// Register the Master Session for the database.
-	masterDB, err := db.NewPSQL("postgres", dbHost)
+	masterDB, err := db.NewPSQL(dbHost)
 	c := config.Config{}
+	c.Auth.Tokens = map[string]string{editorToken: "tests"}
+
+	// Fail the responses which don't match the OpenAPI document.
+	c.Contract.Responses = middleware.ResponsesFail
+	if err != nil {
+		return 1
+	}
+	dir, err := ioutil.TempDir("", "go-api-blobs")
 	if err != nil {
 		return 1
 	}
-	a = handlers.API(masterDB, logger.Log, c, nil).(*web.App)
+	defer os.RemoveAll(dir)
+	store, err := storage.NewLocal(dir, "/v1/blobs")
+	if err != nil {
+		return 1
+	}
+	a = handlers.API(masterDB, logger.Log, c, nil, store, nil, nil).(*web.App)
 
 	return m.Run()
 }
@@ -69,10 +88,13 @@ func runTest(m *testing.M) int {
 // TestImages is the entry point for the images
 func TestImages(t *testing.T) {
 	t.Run("getImages200Empty", getImages200Empty)
+	t.Run("getImages200Pretty", getImages200Pretty)
 	t.Run("postImage400", postImage400)
+	t.Run("postImage400Legacy", postImage400Legacy)
 	t.Run("getImage404", getImage404)
 	t.Run("getImage400", getImage400)
 	t.Run("putImage404", putImage404)
+	t.Run("putImage401", putImage401)
 	t.Run("crudImages", crudImage)
 }
 
@@ -103,6 +125,25 @@ func getImages200Empty(t *testing.T) {
 	}
 }
 
+// getImages200Pretty validates the pretty query parameter isn't taken for a
+// filter of the images list.
+func getImages200Pretty(t *testing.T) {
+	r := httptest.NewRequest("GET", "/v1/images?pretty=1", nil)
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, r)
+
+	t.Log("Given the need to fetch an indented list of images with the images endpoint.")
+	{
+		t.Log("\tTest 0:\tWhen fetching the image list with pretty=1.")
+		{
+			if w.Code != http.StatusOK {
+				t.Fatalf("\t%s\tShould receive a status code of 200 for the response : %v : %s", Failed, w.Code, w.Body)
+			}
+			t.Logf("\t%s\tShould receive a status code of 200 for the response.", Succeed)
+		}
+	}
+}
+
 // postImage400 validates an image can't be created with the endpoint
 // unless a valid image document is submitted.
 func postImage400(t *testing.T) {
@@ -115,6 +156,7 @@ func postImage400(t *testing.T) {
 
 	body, _ := json.Marshal(&m)
 	r := httptest.NewRequest("POST", "/v1/images", bytes.NewBuffer(body))
+	r.Header.Set("Authorization", "Bearer "+editorToken)
 	w := httptest.NewRecorder()
 	a.ServeHTTP(w, r)
 
@@ -127,9 +169,54 @@ func postImage400(t *testing.T) {
 			}
 			t.Logf("\t%s\tShould receive a status code of 400 for the response.", Succeed)
 
+			if ct := w.Header().Get("Content-Type"); ct != web.ProblemContentType {
+				t.Fatalf("\t%s\tShould receive a problem document : %v", Failed, ct)
+			}
+			t.Logf("\t%s\tShould receive a problem document.", Succeed)
+
+			var p web.Problem
+			if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+				t.Fatalf("\t%s\tShould be able to unmarshal the response : %v", Failed, err)
+			}
+
+			if p.Status != http.StatusBadRequest || p.Instance != w.Header().Get(web.TraceIDHeader) ||
+				len(p.InvalidParams) != 1 || p.InvalidParams[0].Fld != "publisher" {
+				t.Logf("Got : %+v", p)
+				t.Fatalf("\t%s\tShould get the expected result.", Failed)
+			}
+			t.Logf("\t%s\tShould get the expected result.", Succeed)
+		}
+	}
+}
+
+// postImage400Legacy validates clients only accepting application/json still
+// receive the legacy error document.
+func postImage400Legacy(t *testing.T) {
+	m := image.CreateImage{
+		ID:    "47c658e0-68d7-4d79-9f9f-25ece8a1fb03",
+		Title: "Image Elijah Baley",
+		URL:   "/images/1280/720/test-2260-b1396d-1@1x.jpeg",
+		Slug:  "/images/1280/720/test-2260-b1396d-1@1x",
+	}
+
+	body, _ := json.Marshal(&m)
+	r := httptest.NewRequest("POST", "/v1/images", bytes.NewBuffer(body))
+	r.Header.Set("Authorization", "Bearer "+editorToken)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, r)
+
+	t.Log("Given the need to keep the legacy error document for existing clients")
+	{
+		t.Log("\tTest 0:\tWhen using an incomplete image value.")
+		{
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("\t%s\tShould receive a status code of 400 for the response : %v", Failed, w.Code)
+			}
+			t.Logf("\t%s\tShould receive a status code of 400 for the response.", Succeed)
+
 			recv := w.Body.String()
-			resps := []string{
-				`{
+			resp := `{
   "error": "field validation failure",
   "fields": [
     {
@@ -137,20 +224,10 @@ func postImage400(t *testing.T) {
       "error": "required"
     }
   ]
-}`,
-			}
-
-			var found bool
-			for _, resp := range resps {
-				if resp == recv {
-					found = true
-					break
-				}
-			}
-
-			if !found {
+}`
+			if resp != recv {
 				t.Log("Got :", recv)
-				t.Log("Want:", resps[0])
+				t.Log("Want:", resp)
 				t.Fatalf("\t%s\tShould get the expected result.", Failed)
 			}
 			t.Logf("\t%s\tShould get the expected result.", Succeed)
@@ -177,7 +254,11 @@ func getImage400(t *testing.T) {
 
 			recv := w.Body.String()
 			resp := `{
-  "error": "ID is not in it's proper form"
+  "type": "/problems/invalid-id",
+  "title": "Invalid identifier",
+  "status": 400,
+  "detail": "ID is not in it's proper form",
+  "instance": "` + w.Header().Get(web.TraceIDHeader) + `"
 }`
 			if resp != recv {
 				t.Log("Got :", recv)
@@ -191,7 +272,7 @@ func getImage400(t *testing.T) {
 
 // getImage404 validates an image request for an image that does not exist with the endpoint.
 func getImage404(t *testing.T) {
-	imageID := bson.NewObjectId().Hex()
+	imageID := uuid.New()
 
 	r := httptest.NewRequest("GET", "/v1/images/"+imageID, nil)
 	w := httptest.NewRecorder()
@@ -228,10 +309,11 @@ func putImage404(t *testing.T) {
 		Publisher: "etf1",
 	}
 
-	imageID := bson.NewObjectId().Hex()
+	imageID := uuid.New()
 
 	body, _ := json.Marshal(&m)
 	r := httptest.NewRequest("PUT", "/v1/images/"+imageID, bytes.NewBuffer(body))
+	r.Header.Set("Authorization", "Bearer "+editorToken)
 	w := httptest.NewRecorder()
 	a.ServeHTTP(w, r)
 
@@ -256,6 +338,34 @@ func putImage404(t *testing.T) {
 	}
 }
 
+// putImage401 validates anonymous callers can't update an image.
+func putImage401(t *testing.T) {
+	m := image.CreateImage{
+		Title:     "Image Elijah Baley",
+		URL:       "/images/1280/720/test-2260-b1396d-1@1x.jpeg",
+		Slug:      "/images/1280/720/test-2260-b1396d-1@1x",
+		Publisher: "etf1",
+	}
+
+	imageID := uuid.New()
+
+	body, _ := json.Marshal(&m)
+	r := httptest.NewRequest("PUT", "/v1/images/"+imageID, bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, r)
+
+	t.Log("Given the need to validate anonymous callers can't update an image.")
+	{
+		t.Logf("\tTest 0:\tWhen updating the image %s without a bearer token.", imageID)
+		{
+			if w.Code != http.StatusUnauthorized {
+				t.Fatalf("\t%s\tShould receive a status code of 401 for the response : %v", Failed, w.Code)
+			}
+			t.Logf("\t%s\tShould receive a status code of 401 for the response.", Succeed)
+		}
+	}
+}
+
 // crudImage performs a complete test of CRUD against the api.
 func crudImage(t *testing.T) {
 	nm := postImage201(t)
@@ -279,6 +389,7 @@ func postImage201(t *testing.T) image.CreateImage {
 
 	body, _ := json.Marshal(&m)
 	r := httptest.NewRequest("POST", "/v1/images", bytes.NewBuffer(body))
+	r.Header.Set("Authorization", "Bearer "+editorToken)
 	w := httptest.NewRecorder()
 	a.ServeHTTP(w, r)
 
@@ -340,6 +451,7 @@ func postImage201(t *testing.T) image.CreateImage {
 // deleteImage204 validates deleting an image that does exist.
 func deleteImage204(t *testing.T, imageID string) {
 	r := httptest.NewRequest("DELETE", "/v1/images/"+imageID, nil)
+	r.Header.Set("Authorization", "Bearer "+editorToken)
 	w := httptest.NewRecorder()
 	a.ServeHTTP(w, r)
 
@@ -407,6 +519,7 @@ func putImage204(t *testing.T, m image.CreateImage) {
 
 	body, _ := json.Marshal(&m)
 	r := httptest.NewRequest("PUT", "/v1/images/"+m.ID, bytes.NewBuffer(body))
+	r.Header.Set("Authorization", "Bearer "+editorToken)
 	w := httptest.NewRecorder()
 	a.ServeHTTP(w, r)
 
@@ -26,6 +26,286 @@ type Config struct {
 		Port     string `default:"5672"`
 	}
 
+	HTTP struct {
+		// ReadTimeout and WriteTimeout are the number of seconds the
+		// server takes to read a request and to write its response. The
+		// write timeout must exceed the longest route timeout.
+		ReadTimeout  int `default:"5"`
+		WriteTimeout int `default:"330"`
+
+		// Timeout is the number of seconds a request may take, 0 for no
+		// limit. Timeouts overrides it per route, keyed by method and path
+		// like "GET /v1/images/export".
+		Timeout  int `default:"10"`
+		Timeouts map[string]int
+
+		// MaxBodySize is the maximum size in bytes of the request bodies,
+		// once decompressed. 0 disables the limit.
+		MaxBodySize int64 `default:"1048576"`
+
+		// BodyLimits overrides MaxBodySize per route, keyed by method and
+		// path like "POST /v1/images:batch".
+		BodyLimits map[string]int64
+
+		Compression struct {
+			Enabled bool `default:"true"`
+
+			// Encodings lists the response encodings among br, zstd and
+			// gzip, in order of preference. All of them by default.
+			Encodings []string
+
+			// MinSize is the size in bytes under which responses are sent
+			// uncompressed.
+			MinSize int `default:"1024"`
+		}
+	}
+
+	CORS struct {
+		// AllowedOrigins lists the origins of the browser applications
+		// allowed to call the API, exact or with a wildcard like
+		// https://*.example.com. Empty disables CORS.
+		AllowedOrigins []string
+
+		// AllowedMethods, AllowedHeaders and ExposedHeaders default to the
+		// methods and headers the API uses.
+		AllowedMethods []string
+		AllowedHeaders []string
+		ExposedHeaders []string
+
+		AllowCredentials bool `default:"false"`
+
+		// MaxAge is the number of seconds browsers may cache a preflight.
+		MaxAge int `default:"600"`
+	}
+
+	Limits struct {
+		// QueueTarget is the number of milliseconds over which the queue
+		// latency sheds the requests finding no free slot. 0 queues them
+		// all.
+		QueueTarget int `default:"100"`
+
+		// API bounds the requests handled at once by the API, and Queries
+		// the listings, searches and exports on top of it. 0 disables a
+		// limit.
+		API struct {
+			MaxInFlight int `default:"256"`
+			MaxQueue    int `default:"1024"`
+		}
+		Queries struct {
+			MaxInFlight int `default:"16"`
+			MaxQueue    int `default:"64"`
+		}
+	}
+
+	Events struct {
+		// Heartbeat is the number of seconds between the comments keeping
+		// the event streams alive.
+		Heartbeat int `default:"15"`
+
+		// Buffer is the number of events buffered per client. The clients
+		// falling further behind are disconnected.
+		Buffer int `default:"256"`
+
+		// MaxClients is the number of event streams at once, 0 for no
+		// limit.
+		MaxClients int `default:"1000"`
+
+		// Poll is the maximum number of seconds between two reads of the
+		// events, in case a notification is lost.
+		Poll int `default:"5"`
+
+		// Retention is the number of hours the events are kept for the
+		// clients to resume. 0 keeps them forever.
+		Retention int `default:"24"`
+	}
+
+	Webhooks struct {
+		// Enabled runs the dispatcher delivering the image events to the
+		// webhooks.
+		Enabled bool `default:"true"`
+
+		// Interval is the maximum number of seconds between two checks of
+		// the deliveries due.
+		Interval int `default:"5"`
+
+		// Workers is the number of deliveries sent at once.
+		Workers int `default:"8"`
+
+		// Timeout is the number of seconds a webhook has to answer.
+		Timeout int `default:"10"`
+
+		// MaxAttempts is the number of attempts after which a delivery
+		// fails.
+		MaxAttempts int `default:"12"`
+
+		// Backoff is the number of seconds before the first retry, doubled
+		// after each attempt up to MaxBackoff.
+		Backoff    int `default:"30"`
+		MaxBackoff int `default:"21600"`
+
+		// DisableAfter is the number of hours after which a webhook failing
+		// all its attempts is disabled. 0 never disables them.
+		DisableAfter int `default:"24"`
+
+		// Retention is the number of hours the completed deliveries are
+		// kept. 0 keeps them forever.
+		Retention int `default:"168"`
+
+		// AllowedNetworks lists the CIDRs of the loopback, private or
+		// link-local networks the webhooks may be sent to anyway, like
+		// 127.0.0.1/32 for local receivers. None by default.
+		AllowedNetworks []string
+	}
+
+	WebSocket struct {
+		// PingInterval is the number of seconds between two pings of the
+		// WebSocket clients, which are disconnected when they miss one.
+		PingInterval int `default:"30"`
+	}
+
+	GraphQL struct {
+		// MaxDepth is the highest number of nested fields of a query, 0 for
+		// no limit.
+		MaxDepth int `default:"10"`
+
+		// MaxComplexity is the highest complexity of a query, 0 for no
+		// limit. Each field costs 1, times the number of items of the
+		// lists it is under.
+		MaxComplexity int `default:"5000"`
+	}
+
+	GRPC struct {
+		// Enabled serves the gRPC image service, along with the gRPC
+		// health service, on Port.
+		Enabled bool   `default:"true"`
+		Port    string `default:"3001"`
+	}
+
+	Auth struct {
+		// Tokens maps the bearer tokens of the editors to their names.
+		// Editors see the images outside their visibility window.
+		Tokens map[string]string
+	}
+
+	Scheduler struct {
+		// Enabled runs the scheduler emitting the image.published and
+		// image.expired events.
+		Enabled bool `default:"true"`
+
+		// Interval is the maximum number of seconds between two checks of
+		// the upcoming publications and expiries.
+		Interval int `default:"60"`
+
+		// Lag is the number of seconds before its previous check the
+		// scheduler looks again for the images committed meanwhile. It
+		// must exceed the transactions writing the images.
+		Lag int `default:"300"`
+	}
+
+	Concurrency struct {
+		// RequireIfMatch rejects the updates and deletions of images sent
+		// without an If-Match header.
+		RequireIfMatch bool `default:"false"`
+	}
+
+	Cache struct {
+		// Backend is memory, redis or off.
+		Backend string `default:"memory"`
+
+		// Size is the number of values held by the memory backend.
+		Size int `default:"10000"`
+
+		// TTL is the number of seconds the images are cached.
+		TTL int `default:"60"`
+
+		// MaxAge is the number of seconds clients may cache the public
+		// images.
+		MaxAge int `default:"60"`
+
+		Redis struct {
+			Addr     string `default:"127.0.0.1:6379"`
+			Password string
+			DB       int `default:"0"`
+			PoolSize int `default:"10"`
+		}
+	}
+
+	Batch struct {
+		// MaxItems is the maximum number of images of a batch.
+		MaxItems int `default:"1000"`
+
+		// Mode is atomic, to create all the images of a batch or none, or
+		// items, to create each one on its own.
+		Mode string `default:"atomic"`
+
+		// Events is aggregate, for one images_created event per batch,
+		// items, for one image_created event per image, or off.
+		Events string `default:"aggregate"`
+	}
+
+	Validation struct {
+		// StrictJSON rejects request bodies with unknown fields.
+		StrictJSON bool `default:"false"`
+	}
+
+	Contract struct {
+		// Requests rejects the requests which don't match the OpenAPI
+		// document.
+		Requests bool `default:"false"`
+
+		// Responses checks the responses against the OpenAPI document,
+		// either off, log or fail. Meant for development and tests, it
+		// buffers the JSON responses.
+		Responses string `default:"off"`
+	}
+
+	Storage struct {
+		// Backend is either local or s3.
+		Backend string `default:"local"`
+
+		// MaxSize is the maximum size in bytes of an image content.
+		MaxSize int64 `default:"20971520"`
+
+		// AllowedTypes lists the accepted image MIME types.
+		AllowedTypes []string
+
+		// StripGPS removes the location from the uploaded images.
+		StripGPS bool `default:"true"`
+
+		Local struct {
+			Dir     string `default:"data/blobs"`
+			BaseURL string `default:"/v1/blobs"`
+		}
+
+		S3 struct {
+			Endpoint  string `default:"http://127.0.0.1:9000"`
+			Region    string `default:"us-east-1"`
+			Bucket    string `default:"go-api"`
+			AccessKey string
+			SecretKey string
+			PublicURL string
+		}
+	}
+
+	Search struct {
+		// Language is the default text search configuration of the queries.
+		Language string `default:"english"`
+	}
+
+	Duplicates struct {
+		// Mode is off, warn or reject.
+		Mode      string `default:"warn"`
+		Threshold int    `default:"5"`
+	}
+
+	Render struct {
+		// Presets lists the derivative sizes as WIDTHxHEIGHT.
+		Presets []string
+
+		MaxDPR    int `default:"3"`
+		MaxPixels int `default:"50000000"`
+	}
+
 	Logger struct {
 		Host  string
 		Port  string `default:"12201"`
@@ -0,0 +1,326 @@
+package image
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/jdelobel/go-api/internal/platform/db"
+	"github.com/jdelobel/go-api/internal/platform/rabbitmq"
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// Batch modes: either all the images of a batch are created or none, or
+// each one is created on its own.
+const (
+	BatchAtomic = "atomic"
+	BatchItems  = "items"
+)
+
+// Batch events: one images_created message for the whole batch, one
+// image_created message per image like POST /v1/images, or none.
+const (
+	BatchEventsAggregate = "aggregate"
+	BatchEventsItems     = "items"
+	BatchEventsOff       = "off"
+)
+
+// batchChunk is the number of rows inserted by a statement, which keeps
+// the parameters under the limit of Postgres.
+const batchChunk = 1000
+
+// maxBatchLine is the maximum size of a line of an NDJSON batch.
+const maxBatchLine = 1 << 20
+
+// BatchOptions configures the batch creation of images.
+type BatchOptions struct {
+
+	// MaxItems is the maximum number of images of a batch.
+	MaxItems int
+
+	// Mode is either atomic or items.
+	Mode string
+
+	// Events is either aggregate, items or off.
+	Events string
+
+	// StrictJSON rejects the items with unknown fields.
+	StrictJSON bool
+}
+
+// BatchItem is an image of a batch, or the error which prevented decoding
+// it.
+type BatchItem struct {
+	Image *CreateImage
+	Err   error
+}
+
+// BatchResult is the outcome of an item of a batch. The status is the one
+// the item would get from POST /v1/images. In atomic mode, the valid items
+// of a failed batch get 424 Failed Dependency.
+type BatchResult struct {
+	Index  int          `json:"index"`
+	Status int          `json:"status"`
+	Image  *Image       `json:"image,omitempty"`
+	Error  *web.Problem `json:"error,omitempty"`
+}
+
+// BatchOutcome is the outcome of a batch.
+type BatchOutcome struct {
+	Mode      string        `json:"mode"`
+	Committed bool          `json:"committed"`
+	Created   int           `json:"created"`
+	Failed    int           `json:"failed"`
+	Results   []BatchResult `json:"results"`
+}
+
+// DecodeBatch reads the images of a batch, sent either as a JSON array or
+// as NDJSON, one image per line. Each image is validated like the body of
+// POST /v1/images, the invalid ones are returned with their error. A batch
+// which isn't well formed fails as a whole.
+func DecodeBatch(r io.Reader, ndjson bool, opts BatchOptions) ([]BatchItem, error) {
+	decode := func(b []byte) BatchItem {
+		var ci CreateImage
+		var err error
+		if opts.StrictJSON {
+			err = web.UnmarshalStrict(bytes.NewReader(b), &ci)
+		} else {
+			err = web.Unmarshal(bytes.NewReader(b), &ci)
+		}
+		if err != nil {
+			return BatchItem{Err: err}
+		}
+		return BatchItem{Image: &ci}
+	}
+
+	var items []BatchItem
+	add := func(b []byte) error {
+		if len(items) == opts.MaxItems {
+			return errors.Wrapf(ErrBatchTooLarge, "Max: %d images", opts.MaxItems)
+		}
+		items = append(items, decode(b))
+		return nil
+	}
+
+	if ndjson {
+		s := bufio.NewScanner(r)
+		s.Buffer(make([]byte, 0, 64*1024), maxBatchLine)
+		for s.Scan() {
+			line := bytes.TrimSpace(s.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			if err := add(line); err != nil {
+				return nil, err
+			}
+		}
+		if err := s.Err(); err != nil {
+			return nil, web.BodyError(r, errors.Wrap(web.ErrMalformedBody, err.Error()))
+		}
+	} else {
+		dec := json.NewDecoder(r)
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+			return nil, web.BodyError(r, errors.Wrap(web.ErrMalformedBody, "batch must be a JSON array"))
+		}
+		for dec.More() {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return nil, web.BodyError(r, errors.Wrapf(web.ErrMalformedBody, "item %d: %v", len(items), err))
+			}
+			if err := add(raw); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, web.BodyError(r, errors.Wrap(web.ErrMalformedBody, err.Error()))
+		}
+	}
+
+	if len(items) == 0 {
+		return nil, web.InvalidError{{Fld: "images", Err: "required", Msg: "is required"}}
+	}
+	return items, nil
+}
+
+// CreateBatch inserts the images of a batch with multi-row statements
+// within a transaction. In atomic mode any invalid or duplicate image
+// rolls the whole batch back, otherwise the valid images are created and
+// the other ones reported.
+func CreateBatch(ctx context.Context, dbConn *db.DB, rbmq *rabbitmq.RabbitMQ, items []BatchItem, opts BatchOptions) (*BatchOutcome, error) {
+	out := BatchOutcome{Mode: opts.Mode, Results: make([]BatchResult, len(items))}
+	fail := func(i int, err error) {
+		p := web.NewProblem(err)
+		out.Results[i] = BatchResult{Index: i, Status: p.Status, Error: &p}
+	}
+
+	// The metadata schemas are loaded once per publisher.
+	schemas := make(map[string]*gojsonschema.Schema)
+	valid := make([]int, 0, len(items))
+	for i, item := range items {
+		if item.Err != nil {
+			fail(i, item.Err)
+			continue
+		}
+
+		schema, ok := schemas[item.Image.Publisher]
+		if !ok {
+			var err error
+			if schema, err = loadSchema(ctx, dbConn, item.Image.Publisher); err != nil {
+				return nil, errors.Wrap(err, "CreateBatch")
+			}
+			schemas[item.Image.Publisher] = schema
+		}
+		if err := checkMetadata(schema, item.Image.Metadata); err != nil {
+			fail(i, err)
+			continue
+		}
+		valid = append(valid, i)
+	}
+
+	if opts.Mode == BatchAtomic && len(valid) < len(items) {
+		return out.abort(valid), nil
+	}
+
+	tx, err := dbConn.PSQLBegin(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "CreateBatch")
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(valid); start += batchChunk {
+		end := start + batchChunk
+		if end > len(valid) {
+			end = len(valid)
+		}
+		if err := insertBatch(ctx, tx, items, valid[start:end], &out); err != nil {
+			return nil, err
+		}
+	}
+
+	var created []int
+	for _, i := range valid {
+		if out.Results[i].Image != nil {
+			created = append(created, i)
+		} else {
+			fail(i, errors.Wrapf(ErrDuplicate, "Slug: %s Url: %s", items[i].Image.Slug, items[i].Image.URL))
+		}
+	}
+	if opts.Mode == BatchAtomic && len(created) < len(items) {
+		return out.abort(created), nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "CreateBatch")
+	}
+	out.Committed = true
+	out.Created, out.Failed = len(created), len(items)-len(created)
+
+	publishBatch(rbmq, &out, opts.Events)
+	return &out, nil
+}
+
+// insertBatch inserts the specified items with a single statement. The
+// images conflicting with existing ones, or with another image of the
+// batch, are skipped and get no image in their result.
+func insertBatch(ctx context.Context, tx *sqlx.Tx, items []BatchItem, chunk []int, out *BatchOutcome) error {
+	const fields = 7
+	values := make([]string, len(chunk))
+	params := make([]interface{}, 0, len(chunk)*fields)
+	for n, i := range chunk {
+		ci := items[i].Image
+		p := n * fields
+		values[n] = fmt.Sprintf("($%d,$%d,$%d,$%d,$%d,COALESCE($%d::timestamptz, now()),$%d::timestamptz)", p+1, p+2, p+3, p+4, p+5, p+6, p+7)
+		params = append(params, ci.Title, ci.URL, ci.Slug, ci.Publisher, ci.Metadata, nullTime(ci.PublishedAt), nullTime(ci.ExpiredAt))
+	}
+
+	query := `INSERT INTO images(title, url, slug, publisher, metadata, published_at, expired_at)
+		VALUES ` + strings.Join(values, ",") + `
+		ON CONFLICT DO NOTHING RETURNING ` + columns
+	rows, err := tx.QueryxContext(ctx, query, params...)
+	if err != nil {
+		return errors.Wrap(err, "db.images.insertBatch("+strconv.Itoa(len(chunk))+" images)")
+	}
+	defer rows.Close()
+
+	// The slugs are unique, the inserted rows are matched back to their
+	// item with it.
+	bySlug := make(map[string]int, len(chunk))
+	for n := len(chunk) - 1; n >= 0; n-- {
+		bySlug[items[chunk[n]].Image.Slug] = chunk[n]
+	}
+	for rows.Next() {
+		var img Image
+		if err := rows.StructScan(&img); err != nil {
+			return errors.Wrap(err, "db.images.insertBatch()StructScan")
+		}
+		i := bySlug[*img.Slug]
+		out.Results[i] = BatchResult{Index: i, Status: http.StatusCreated, Image: &img}
+	}
+	return rows.Err()
+}
+
+// abort reports a batch which is rolled back: the items which would have
+// been created fail because of the other ones.
+func (out *BatchOutcome) abort(ok []int) *BatchOutcome {
+	for _, i := range ok {
+		out.Results[i] = BatchResult{Index: i, Status: http.StatusFailedDependency}
+	}
+	out.Committed, out.Created, out.Failed = false, 0, len(out.Results)
+	return out
+}
+
+// publishBatch publishes the events of the images created by a batch.
+// Failures are logged.
+func publishBatch(rbmq *rabbitmq.RabbitMQ, out *BatchOutcome, events string) {
+	switch events {
+	case BatchEventsItems:
+		for _, r := range out.Results {
+			if r.Image != nil {
+				publishCreated(rbmq, r.Image)
+			}
+		}
+	case BatchEventsAggregate:
+		ids := make([]string, 0, out.Created)
+		for _, r := range out.Results {
+			if r.Image != nil {
+				ids = append(ids, *r.Image.ID)
+			}
+		}
+		if len(ids) == 0 {
+			return
+		}
+		msg, err := json.Marshal(map[string]interface{}{"count": len(ids), "ids": ids})
+		if err != nil {
+			log.Warnf("RabbitMQ: failed to marshal the batch creation: %v", err)
+			return
+		}
+		qn := "images_created"
+		if _, err := rbmq.DeclareQueue(qn); err != nil {
+			log.Warnf("RabbitMQ: cannot declare queue for batch creation: %v", err)
+		}
+		if err := rbmq.Publish(&qn, msg); err != nil {
+			log.Warnf("RabbitMQ: failed to publish a message for batch creation: %v", err)
+		}
+	}
+}
+
+// ParseBatchMode reads the mode query parameter, def when it is absent.
+func ParseBatchMode(s, def string) (string, error) {
+	switch s {
+	case "":
+		return def, nil
+	case BatchAtomic, BatchItems:
+		return s, nil
+	}
+	return "", web.InvalidError{{Fld: "mode", Err: "oneof", Param: BatchAtomic + " " + BatchItems, Msg: "must be one of [atomic items]"}}
+}
@@ -0,0 +1,76 @@
+package image
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+func TestDecodeBatch(t *testing.T) {
+	valid := `{"title":"Cat","url":"https://example.com/cat.jpg","slug":"cat","publisher":"acme"}`
+	invalid := `{"title":"Dog","url":"https://example.com/dog.jpg","publisher":"acme"}`
+
+	tests := []struct {
+		name    string
+		body    string
+		ndjson  bool
+		strict  bool
+		want    []bool
+		wantErr error
+	}{
+		{name: "array", body: "[" + valid + "," + invalid + "]", want: []bool{true, false}},
+		{name: "ndjson", body: valid + "\n\n" + invalid + "\n", ndjson: true, want: []bool{true, false}},
+		{name: "strict", body: `[{"title":"Cat","url":"https://example.com/cat.jpg","slug":"cat","publisher":"acme","size":1}]`, strict: true, want: []bool{false}},
+		{name: "too large", body: "[" + strings.Repeat(valid+",", 3) + valid + "]", wantErr: ErrBatchTooLarge},
+		{name: "not an array", body: valid, wantErr: web.ErrMalformedBody},
+		{name: "truncated", body: "[" + valid + ",", wantErr: web.ErrMalformedBody},
+		{name: "malformed line", body: valid + "\n{", ndjson: true, want: []bool{true, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := BatchOptions{MaxItems: 3, StrictJSON: tt.strict}
+			items, err := DecodeBatch(strings.NewReader(tt.body), tt.ndjson, opts)
+			if tt.wantErr != nil {
+				if errors.Cause(err) != tt.wantErr {
+					t.Fatalf("DecodeBatch() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecodeBatch() error = %v", err)
+			}
+			if len(items) != len(tt.want) {
+				t.Fatalf("DecodeBatch() = %d items, want %d", len(items), len(tt.want))
+			}
+			for i, ok := range tt.want {
+				if (items[i].Err == nil) != ok || (items[i].Image != nil) != ok {
+					t.Errorf("item %d = %+v, want valid %v", i, items[i], ok)
+				}
+			}
+		})
+	}
+}
+
+func TestBatchAbort(t *testing.T) {
+	p := web.NewProblem(web.InvalidError{{Fld: "slug", Err: "required"}})
+	out := BatchOutcome{Mode: BatchAtomic, Results: []BatchResult{
+		{},
+		{Index: 1, Status: p.Status, Error: &p},
+		{},
+	}}
+	out.abort([]int{0, 2})
+
+	want := []int{http.StatusFailedDependency, http.StatusBadRequest, http.StatusFailedDependency}
+	for i, status := range want {
+		if out.Results[i].Status != status || out.Results[i].Index != i {
+			t.Errorf("result %d = %+v, want status %d", i, out.Results[i], status)
+		}
+	}
+	if out.Committed || out.Created != 0 || out.Failed != 3 {
+		t.Errorf("abort() = %+v", out)
+	}
+}
@@ -0,0 +1,225 @@
+package image
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/jdelobel/go-api/internal/platform/cache"
+	"github.com/jdelobel/go-api/internal/platform/db"
+	"golang.org/x/sync/singleflight"
+)
+
+// Keys of the cached images.
+const (
+	imageKeyPrefix = "images:"
+	listKeyPrefix  = "images:list:"
+	generationKey  = "images:list:generation"
+	generationTTL  = time.Hour
+)
+
+// Cache reads the images through a cache store. The images are cached
+// whoever reads them, their visibility window is checked on each read.
+// Concurrent misses of the same key make a single query. A nil Cache reads
+// straight from the database.
+type Cache struct {
+	Store cache.Store
+	TTL   time.Duration
+
+	group singleflight.Group
+}
+
+// cachedImage is the cached form of an image, which keeps the fields
+// hidden from the responses.
+type cachedImage struct {
+	*Image
+	StorageKey *string  `json:"storage_key"`
+	Exif       Metadata `json:"exif"`
+	PHash      *int64   `json:"phash"`
+}
+
+// Retrieve gets the specified image from the cache, or from the database
+// on a miss. See Retrieve.
+func (c *Cache) Retrieve(ctx context.Context, dbConn *db.DB, imageID string, scope Scope) (*Image, error) {
+	if c == nil {
+		return Retrieve(ctx, dbConn, imageID, scope)
+	}
+
+	key := imageKeyPrefix + imageID
+	v, err, _ := c.group.Do(key, func() (interface{}, error) {
+		var img cachedImage
+		if c.get(ctx, key, &img) {
+			return img.image(), nil
+		}
+
+		// An invalidation during the query starts a new generation, the
+		// image read before it isn't cached.
+		gen := c.generation(ctx)
+		loaded, err := Retrieve(ctx, dbConn, imageID, Editorial)
+		if err != nil {
+			return nil, err
+		}
+		c.setCurrent(ctx, key, gen, newCachedImage(loaded))
+		return loaded, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The image is shared by the callers of the flight.
+	img := *v.(*Image)
+	if err := checkVisible(&img, scope, time.Now()); err != nil {
+		return nil, err
+	}
+	return &img, nil
+}
+
+// List gets the images matching the query parameters from the cache, or
+// from the database on a miss. See List.
+func (c *Cache) List(ctx context.Context, dbConn *db.DB, queryParams url.Values, scope Scope) ([]Image, error) {
+	if c == nil {
+		return List(ctx, dbConn, queryParams, scope)
+	}
+
+	sum := sha256.Sum256([]byte(queryParams.Encode()))
+	key := listKeyPrefix + c.generation(ctx) + ":" + strconv.Itoa(int(scope)) + ":" + hex.EncodeToString(sum[:16])
+	v, err, _ := c.group.Do(key, func() (interface{}, error) {
+		var cached []cachedImage
+		if c.get(ctx, key, &cached) {
+			images := make([]Image, len(cached))
+			for i, img := range cached {
+				images[i] = *img.image()
+			}
+			return images, nil
+		}
+
+		images, err := List(ctx, dbConn, queryParams, scope)
+		if err != nil {
+			return nil, err
+		}
+		cached = make([]cachedImage, len(images))
+		for i := range images {
+			cached[i] = newCachedImage(&images[i])
+		}
+		c.set(ctx, key, cached)
+		return images, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The images which expired since the list was cached are left out.
+	now := time.Now()
+	images := make([]Image, 0)
+	for _, img := range v.([]Image) {
+		if checkVisible(&img, scope, now) == nil {
+			images = append(images, img)
+		}
+	}
+	return images, nil
+}
+
+// Invalidate drops the cached copies of an image and the cached lists.
+// The lists are dropped by starting a new generation of their keys, before
+// the image is deleted so that the loads caching it meanwhile notice it.
+func (c *Cache) Invalidate(ctx context.Context, imageID string) {
+	if c == nil {
+		return
+	}
+	c.newGeneration(ctx)
+	if imageID != "" {
+		if err := c.Store.Delete(ctx, imageKeyPrefix+imageID); err != nil {
+			log.Warnf("Cache: failed to invalidate image %s: %v", imageID, err)
+		}
+	}
+}
+
+// generation returns the current generation of the list keys.
+func (c *Cache) generation(ctx context.Context) string {
+	b, ok, err := c.Store.Get(ctx, generationKey)
+	if err != nil {
+		log.Warnf("Cache: failed to read the list generation: %v", err)
+	}
+	if ok {
+		return string(b)
+	}
+
+	// A lost generation may have been bumped meanwhile, a new one is
+	// started rather than going back to an old one.
+	return c.newGeneration(ctx)
+}
+
+// newGeneration starts a new generation of the list keys. It outlives the
+// lists cached under the previous one.
+func (c *Cache) newGeneration(ctx context.Context) string {
+	gen := strconv.FormatInt(time.Now().UnixNano(), 36)
+	if err := c.Store.Set(ctx, generationKey, []byte(gen), generationTTL+c.TTL); err != nil {
+		log.Warnf("Cache: failed to start a list generation: %v", err)
+	}
+	return gen
+}
+
+// get decodes the value cached under the key. The errors of the store are
+// logged and read as misses.
+func (c *Cache) get(ctx context.Context, key string, v interface{}) bool {
+	b, ok, err := c.Store.Get(ctx, key)
+	if err != nil {
+		log.Warnf("Cache: failed to get %s: %v", key, err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		log.Warnf("Cache: failed to decode %s: %v", key, err)
+		return false
+	}
+	return true
+}
+
+// set caches the value under the key.
+func (c *Cache) set(ctx context.Context, key string, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Warnf("Cache: failed to encode %s: %v", key, err)
+		return
+	}
+	if err := c.Store.Set(ctx, key, b, c.TTL); err != nil {
+		log.Warnf("Cache: failed to set %s: %v", key, err)
+	}
+}
+
+// setCurrent caches the value under the key unless the generation changed
+// since gen, the value read before an invalidation being stale. It is
+// checked again once the value is set, for an invalidation in between.
+func (c *Cache) setCurrent(ctx context.Context, key, gen string, v interface{}) {
+	if c.generation(ctx) != gen {
+		return
+	}
+	c.set(ctx, key, v)
+	if c.generation(ctx) != gen {
+		if err := c.Store.Delete(ctx, key); err != nil {
+			log.Warnf("Cache: failed to delete %s: %v", key, err)
+		}
+	}
+}
+
+// newCachedImage returns the cached form of an image.
+func newCachedImage(img *Image) cachedImage {
+	return cachedImage{Image: img, StorageKey: img.StorageKey, Exif: img.Exif, PHash: img.PHash}
+}
+
+// image returns the image of its cached form.
+func (ci cachedImage) image() *Image {
+	img := ci.Image
+	if img == nil {
+		img = &Image{}
+	}
+	img.StorageKey, img.Exif, img.PHash = ci.StorageKey, ci.Exif, ci.PHash
+	return img
+}
@@ -0,0 +1,77 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jdelobel/go-api/internal/platform/cache"
+	"github.com/pkg/errors"
+)
+
+func TestCacheRetrieve(t *testing.T) {
+	ctx := context.Background()
+	id := "47c658e0-68d7-4d79-9f9f-25ece8a1fb03"
+	key := "images/" + id + "/original"
+	expired := time.Now().Add(-time.Minute)
+
+	store := cache.NewLRU(16)
+	b, err := json.Marshal(newCachedImage(&Image{ID: &id, StorageKey: &key, ExpiredAt: &expired}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Set(ctx, imageKeyPrefix+id, b, time.Minute)
+	c := &Cache{Store: store, TTL: time.Minute}
+
+	// The image is cached, the database isn't queried.
+	img, err := c.Retrieve(ctx, nil, id, Editorial)
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if img.StorageKey == nil || *img.StorageKey != key {
+		t.Errorf("StorageKey = %v, want %s", img.StorageKey, key)
+	}
+
+	if _, err := c.Retrieve(ctx, nil, id, Public); errors.Cause(err) != ErrExpired {
+		t.Errorf("Retrieve() public error = %v, want %v", err, ErrExpired)
+	}
+}
+
+func TestCacheInvalidate(t *testing.T) {
+	ctx := context.Background()
+	c := &Cache{Store: cache.NewLRU(16), TTL: time.Minute}
+	c.Store.Set(ctx, imageKeyPrefix+"1", []byte("{}"), time.Minute)
+
+	gen := c.generation(ctx)
+	if again := c.generation(ctx); again != gen {
+		t.Fatalf("generation() = %s then %s, want a stable generation", gen, again)
+	}
+
+	c.Invalidate(ctx, "1")
+	if _, ok, _ := c.Store.Get(ctx, imageKeyPrefix+"1"); ok {
+		t.Errorf("image still cached after Invalidate()")
+	}
+	if again := c.generation(ctx); again == gen {
+		t.Errorf("generation() = %s after Invalidate(), want a new generation", again)
+	}
+}
+
+func TestCacheSetCurrent(t *testing.T) {
+	ctx := context.Background()
+	c := &Cache{Store: cache.NewLRU(16), TTL: time.Minute}
+
+	gen := c.generation(ctx)
+	c.setCurrent(ctx, imageKeyPrefix+"1", gen, cachedImage{})
+	if _, ok, _ := c.Store.Get(ctx, imageKeyPrefix+"1"); !ok {
+		t.Errorf("image not cached by setCurrent()")
+	}
+
+	// The image loaded before an invalidation isn't cached.
+	gen = c.generation(ctx)
+	c.Invalidate(ctx, "2")
+	c.setCurrent(ctx, imageKeyPrefix+"2", gen, cachedImage{})
+	if _, ok, _ := c.Store.Get(ctx, imageKeyPrefix+"2"); ok {
+		t.Errorf("stale image cached by setCurrent()")
+	}
+}
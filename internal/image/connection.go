@@ -0,0 +1,95 @@
+package image
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jdelobel/go-api/internal/platform/db"
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+// EncodeCursor returns the opaque cursor of an image in the lists ordered by
+// creation, which ListAfter resumes after.
+func EncodeCursor(img *Image) string {
+	var createdAt time.Time
+	if img.CreatedAt != nil {
+		createdAt = *img.CreatedAt
+	}
+	var id string
+	if img.ID != nil {
+		id = *img.ID
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(createdAt.UTC().Format(time.RFC3339Nano) + "|" + id))
+}
+
+// DecodeCursor reads a cursor returned by EncodeCursor. Invalid cursors fail
+// with a web.InvalidError on the field.
+func DecodeCursor(field, cursor string) (time.Time, string, error) {
+	invalid := web.InvalidError{{Fld: field, Err: "cursor", Msg: "must be a cursor"}}
+
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", invalid
+	}
+	parts := strings.SplitN(string(b), "|", 2)
+	if len(parts) != 2 || !IsValidUUID(parts[1]) {
+		return time.Time{}, "", invalid
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, "", invalid
+	}
+	return createdAt, parts[1], nil
+}
+
+// ListAfter returns the first images matching the filters of List, oldest
+// first, after the image of the cursor when it isn't empty. It tells
+// whether more images follow them.
+func ListAfter(ctx context.Context, dbConn *db.DB, queryParams url.Values, scope Scope, first int, after string) ([]Image, bool, error) {
+	where, params, err := db.BuildWhere(queryParams, filterColumns, 0)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "ListAfter")
+	}
+	if where == "" {
+		where = " WHERE TRUE"
+	}
+	where += scopeCond(scope)
+	if after != "" {
+		createdAt, id, err := DecodeCursor("after", after)
+		if err != nil {
+			return nil, false, err
+		}
+		params = append(params, createdAt, id)
+		where += fmt.Sprintf(" AND (created_at, id) > ($%d, $%d)", len(params)-1, len(params))
+	}
+	params = append(params, first+1)
+	query := "SELECT " + columns + " FROM images" + where + fmt.Sprintf(" ORDER BY created_at, id LIMIT $%d", len(params))
+
+	rows, err := dbConn.PSQLQuerier(ctx, query, params...)
+	if err != nil {
+		return nil, false, errors.Wrap(err, fmt.Sprintf("db.images.find(%s)", db.Query(query)))
+	}
+	defer rows.Close()
+
+	images := make([]Image, 0, first)
+	for rows.Next() {
+		var img Image
+		if err := rows.StructScan(&img); err != nil {
+			return nil, false, errors.Wrap(err, fmt.Sprintf("db.images.find(%s)StructScan", db.Query(query)))
+		}
+		images = append(images, img)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, errors.Wrap(err, "ListAfter")
+	}
+
+	if len(images) > first {
+		return images[:first], true, nil
+	}
+	return images, false, nil
+}
@@ -0,0 +1,41 @@
+package image
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/jdelobel/go-api/internal/platform/web"
+)
+
+func TestCursor(t *testing.T) {
+	id := "9b7e4d7a-2a4c-4b8e-9f4e-3c1d2b6a8e01"
+	createdAt := time.Date(2019, 7, 14, 10, 30, 0, 123456789, time.UTC)
+
+	gotAt, gotID, err := DecodeCursor("after", EncodeCursor(&Image{ID: &id, CreatedAt: &createdAt}))
+	if err != nil {
+		t.Fatalf("DecodeCursor error = %v", err)
+	}
+	if !gotAt.Equal(createdAt) || gotID != id {
+		t.Errorf("DecodeCursor = %s %s, want %s %s", gotAt, gotID, createdAt, id)
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{name: "not base64", cursor: "%%%"},
+		{name: "no separator", cursor: base64.RawURLEncoding.EncodeToString([]byte("2019-07-14T10:30:00Z"))},
+		{name: "bad time", cursor: base64.RawURLEncoding.EncodeToString([]byte("yesterday|" + id))},
+		{name: "bad id", cursor: base64.RawURLEncoding.EncodeToString([]byte("2019-07-14T10:30:00Z|42"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := DecodeCursor("after", tt.cursor)
+			inv, ok := err.(web.InvalidError)
+			if !ok || len(inv) != 1 || inv[0].Fld != "after" {
+				t.Errorf("DecodeCursor(%q) error = %v, want an invalid after", tt.cursor, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package image
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/jdelobel/go-api/internal/platform/db"
+	"github.com/jdelobel/go-api/internal/platform/imaging"
+	"github.com/jdelobel/go-api/internal/platform/storage"
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/pkg/errors"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// sniffLen is the number of bytes http.DetectContentType looks at.
+const sniffLen = 512
+
+// ContentOptions configures which image contents are accepted.
+type ContentOptions struct {
+
+	// MaxSize is the maximum size of a content in bytes.
+	MaxSize int64
+
+	// AllowedTypes lists the accepted MIME types, as sniffed from the bytes.
+	AllowedTypes []string
+
+	// StripGPS removes the location from the EXIF and XMP metadata before
+	// the content is stored.
+	StripGPS bool
+
+	// MaxPixels limits the dimensions of the decoded contents.
+	MaxPixels int
+
+	// Duplicates configures the search for near-duplicates.
+	Duplicates DuplicateCheck
+}
+
+// DefaultAllowedTypes are the MIME types accepted when none is configured.
+var DefaultAllowedTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp", "image/tiff"}
+
+// StoreContent stores the binary content of the image and records its MIME
+// type, size and SHA-256. The url of the image is set to the stored blob.
+// The dimensions, camera, capture time, copyright and caption found in the
+// embedded metadata are added to the image metadata, when the schema of the
+// publisher accepts them. The near-duplicates of
+// the same publisher are returned when the duplicate check warns about them.
+// The prior state of the image is recorded as a revision.
+func StoreContent(ctx context.Context, dbConn *db.DB, store storage.Store, imageID string, r io.Reader, opts ContentOptions, by Author) (*Image, []Similar, error) {
+	img, err := Retrieve(ctx, dbConn, imageID, Editorial)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The client header can't be trusted, sniff the type from the bytes.
+	br := bufio.NewReaderSize(r, sniffLen)
+	head, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, nil, errors.Wrap(err, "StoreContent")
+	}
+	contentType := sniffType(head)
+	if !allowedType(contentType, opts.AllowedTypes) {
+		return nil, nil, errors.Wrapf(ErrContentType, "Type: %s", contentType)
+	}
+
+	// Spool the content to disk, mapped rather than read in memory, as its
+	// metadata is parsed and edited before it is stored.
+	sp, err := newSpool(br, opts.MaxSize)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "StoreContent")
+	}
+	defer sp.Close()
+	b := sp.Bytes()
+
+	if opts.StripGPS {
+		imaging.StripGPS(b)
+	}
+	info, err := imaging.ReadInfo(b)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "StoreContent: %s", imageID)
+	}
+
+	m, _, err := imaging.Decode(bytes.NewReader(b), opts.MaxPixels)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "StoreContent: %s", imageID)
+	}
+	hash := int64(imaging.DHash(m))
+
+	var similar []Similar
+	if opts.Duplicates.Mode == DuplicatesWarn || opts.Duplicates.Mode == DuplicatesReject {
+		similar, err = findSimilar(ctx, dbConn, imageID, hash, opts.Duplicates.Threshold, *img.Publisher, Editorial)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(similar) > 0 && opts.Duplicates.Mode == DuplicatesReject {
+			return nil, nil, errors.Wrapf(NearDuplicateError(similar), "Id: %s", imageID)
+		}
+	}
+
+	schema, err := loadSchema(ctx, dbConn, *img.Publisher)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "StoreContent: %s", imageID)
+	}
+	md, err := mergeInfo(schema, img.Metadata, info)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "StoreContent: %s", imageID)
+	}
+
+	// The blob of each content has its own key, so the blob the row points
+	// to is never replaced: the row is only switched to the new blob when
+	// the transaction commits, and the new blob is removed otherwise. The
+	// prior blobs are kept, the url of the revisions still refer to them.
+	sum := sha256.Sum256(b)
+	sha := hex.EncodeToString(sum[:])
+	key := contentKey(imageID, sha)
+	if err := store.Put(ctx, key, bytes.NewReader(b), int64(len(b)), contentType); err != nil {
+		return nil, nil, errors.Wrapf(err, "StoreContent: %s", imageID)
+	}
+	committed := false
+	defer func() {
+		if committed || (img.StorageKey != nil && *img.StorageKey == key) {
+			return
+		}
+		if err := store.Delete(context.Background(), key); err != nil && errors.Cause(err) != storage.ErrNotFound {
+			log.Warnf("Storage: failed to remove the blob %s: %v", key, err)
+		}
+	}()
+
+	tx, err := dbConn.PSQLBegin(ctx)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "StoreContent")
+	}
+	defer tx.Rollback()
+
+	if _, err := recordRevision(ctx, tx, imageID, by); err != nil {
+		return nil, nil, err
+	}
+
+	query := `UPDATE images SET url=$2, content_type=$3, content_size=$4, content_sha256=$5, storage_key=$6,
+		metadata=$7, exif=$8, phash=$9, updated_at=now()
+		WHERE id=$1 AND deleted_at IS NULL RETURNING ` + columns
+	row := tx.QueryRowxContext(ctx, query, imageID, store.URL(key), contentType, len(b), sha, key,
+		md, infoTags(info), hash)
+	var updated Image
+	if err := row.StructScan(&updated); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, errors.Wrapf(ErrNotFound, "Id: %s", imageID)
+		}
+		if db.IsUniqueViolation(err) {
+			return nil, nil, errors.Wrapf(ErrDuplicate, "Url: %s", store.URL(key))
+		}
+		return nil, nil, errors.Wrap(err, fmt.Sprintf("db.images.update(%s)StructScan", db.Query(imageID)))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, errors.Wrap(err, "StoreContent")
+	}
+	committed = true
+	return &updated, similar, nil
+}
+
+// RetrieveExif returns the raw EXIF, IPTC and XMP tags extracted from the
+// content of the specified image.
+func RetrieveExif(ctx context.Context, dbConn *db.DB, imageID string, scope Scope) (Metadata, error) {
+	img, err := Retrieve(ctx, dbConn, imageID, scope)
+	if err != nil {
+		return nil, err
+	}
+	if img.StorageKey == nil {
+		return nil, errors.Wrapf(ErrNoContent, "Id: %s", imageID)
+	}
+	if img.Exif == nil {
+		return Metadata{}, nil
+	}
+	return img.Exif, nil
+}
+
+// mergeInfo returns the metadata of the image along with the information
+// extracted from the content, when the schema of the publisher accepts
+// them. Otherwise the metadata is kept as is, the extracted tags are still
+// recorded in the exif column.
+func mergeInfo(schema *gojsonschema.Schema, md Metadata, info *imaging.Info) (Metadata, error) {
+	merged := infoMetadata(md, info)
+	err := checkMetadata(schema, merged)
+	if _, ok := err.(web.InvalidError); ok {
+		return md, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// infoMetadata adds the information extracted from the content to the
+// metadata of the image. The keys set by the editors are kept.
+func infoMetadata(md Metadata, info *imaging.Info) Metadata {
+	out := make(Metadata, len(md)+7)
+	for k, v := range md {
+		out[k] = v
+	}
+
+	set := func(k string, v interface{}) {
+		if _, ok := out[k]; !ok {
+			out[k] = v
+		}
+	}
+	set("width", info.Width)
+	set("height", info.Height)
+	if info.Orientation > 0 {
+		set("orientation", info.Orientation)
+	}
+	if info.Camera != "" {
+		set("camera", info.Camera)
+	}
+	if !info.CapturedAt.IsZero() {
+		set("captured_at", info.CapturedAt.Format(time.RFC3339))
+	}
+	if info.Copyright != "" {
+		set("copyright", info.Copyright)
+	}
+	if info.Caption != "" {
+		set("caption", info.Caption)
+	}
+	return out
+}
+
+// infoTags groups the raw tags extracted from the content by standard.
+func infoTags(info *imaging.Info) Metadata {
+	tags := Metadata{}
+	for k, v := range map[string]map[string]interface{}{"exif": info.EXIF, "iptc": info.IPTC, "xmp": info.XMP} {
+		if len(v) > 0 {
+			tags[k] = v
+		}
+	}
+	return tags
+}
+
+// sniffType detects the MIME type of the content. TIFF isn't known by
+// http.DetectContentType.
+func sniffType(head []byte) string {
+	if bytes.HasPrefix(head, []byte("II*\x00")) || bytes.HasPrefix(head, []byte("MM\x00*")) {
+		return "image/tiff"
+	}
+	return http.DetectContentType(head)
+}
+
+// contentKey returns the blob key of an original content of an image,
+// versioned by the SHA-256 of the content.
+func contentKey(imageID, sha string) string {
+	return "images/" + imageID + "/original/" + sha
+}
+
+// RetrieveOwner gets the image owning the blob stored under the key, see
+// Retrieve, so that the blobs are only served inside the visibility window
+// of their image. The blobs of no image aren't found.
+func RetrieveOwner(ctx context.Context, dbConn *db.DB, key string, scope Scope) (*Image, error) {
+	parts := strings.SplitN(path.Clean("/"+key), "/", 4)
+	if len(parts) != 4 || parts[1] != "images" {
+		return nil, errors.Wrapf(storage.ErrNotFound, "Key: %s", key)
+	}
+	return Retrieve(ctx, dbConn, parts[2], scope)
+}
+
+// allowedType reports whether the MIME type is in the allowed list.
+func allowedType(contentType string, allowed []string) bool {
+	if len(allowed) == 0 {
+		allowed = DefaultAllowedTypes
+	}
+	return contains(allowed, contentType)
+}
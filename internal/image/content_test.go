@@ -0,0 +1,104 @@
+package image
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jdelobel/go-api/internal/platform/imaging"
+	"github.com/jdelobel/go-api/internal/platform/storage"
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/pkg/errors"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+func TestInfoMetadata(t *testing.T) {
+	info := &imaging.Info{
+		Width:      1280,
+		Height:     720,
+		Camera:     "Canon EOS 5D",
+		CapturedAt: time.Date(2019, 7, 14, 10, 30, 0, 0, time.UTC),
+		Copyright:  "ACME Photos",
+	}
+	md := infoMetadata(Metadata{"copyright": "Agency"}, info)
+
+	tests := []struct {
+		key  string
+		want interface{}
+	}{
+		{key: "width", want: 1280},
+		{key: "height", want: 720},
+		{key: "camera", want: "Canon EOS 5D"},
+		{key: "captured_at", want: "2019-07-14T10:30:00Z"},
+		{key: "copyright", want: "Agency"},
+		{key: "caption", want: nil},
+		{key: "orientation", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := md[tt.key]; got != tt.want {
+				t.Errorf("metadata[%s] = %v, want %v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeInfo(t *testing.T) {
+	info := &imaging.Info{Width: 1280, Height: 720, Camera: "Canon EOS 5D"}
+	tests := []struct {
+		name   string
+		schema string
+		want   []string
+	}{
+		{name: "no schema", want: []string{"credit", "width", "height", "camera"}},
+		{name: "accepted", schema: `{"type": "object"}`, want: []string{"credit", "width", "height", "camera"}},
+		{name: "rejected", schema: `{"type": "object", "properties": {"credit": {"type": "string"}}, "additionalProperties": false}`, want: []string{"credit"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var schema *gojsonschema.Schema
+			if tt.schema != "" {
+				var err error
+				if schema, err = gojsonschema.NewSchema(gojsonschema.NewStringLoader(tt.schema)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			md, err := mergeInfo(schema, Metadata{"credit": "Agency"}, info)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(md) != len(tt.want) {
+				t.Errorf("metadata = %v, want the keys %v", md, tt.want)
+			}
+			for _, k := range tt.want {
+				if _, ok := md[k]; !ok {
+					t.Errorf("metadata = %v, want the key %s", md, k)
+				}
+			}
+		})
+	}
+}
+
+func TestRetrieveOwner(t *testing.T) {
+	tests := []struct {
+		key  string
+		want error
+	}{
+		{key: "other/47c658e0-68d7-4d79-9f9f-25ece8a1fb03/original", want: storage.ErrNotFound},
+		{key: "images/47c658e0-68d7-4d79-9f9f-25ece8a1fb03", want: storage.ErrNotFound},
+		{key: "images/original", want: storage.ErrNotFound},
+		{key: "images/12345/original", want: web.ErrInvalidID},
+		{key: "images/x/../12345/original", want: web.ErrInvalidID},
+		{key: contentKey("12345", "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"), want: web.ErrInvalidID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if _, err := RetrieveOwner(context.Background(), nil, tt.key, Public); errors.Cause(err) != tt.want {
+				t.Errorf("RetrieveOwner() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package image
+
+import (
+	"net/http"
+
+	"github.com/jdelobel/go-api/internal/platform/db"
+	"github.com/jdelobel/go-api/internal/platform/imaging"
+	"github.com/jdelobel/go-api/internal/platform/storage"
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNotFound occurs when no image exists with the requested id. It keeps
+	// the message the clients got before the images had their own problem
+	// type.
+	ErrNotFound = errors.New("Entity not found")
+
+	// ErrExpired occurs when a public caller retrieves an image after its
+	// expiry.
+	ErrExpired = errors.New("Image has expired")
+
+	// ErrRevisionNotFound occurs when an image has no revision with the
+	// requested number.
+	ErrRevisionNotFound = errors.New("Revision not found")
+
+	// ErrDuplicate occurs when an image already uses the slug or the url.
+	ErrDuplicate = errors.New("An image with the same slug or url already exists")
+
+	// ErrContentType occurs when the content of an image has a MIME type which
+	// is not allowed.
+	ErrContentType = errors.New("Image content type is not allowed")
+
+	// ErrContentTooLarge occurs when the content of an image exceeds the
+	// maximum size.
+	ErrContentTooLarge = errors.New("Image content is too large")
+
+	// ErrNoContent occurs when rendering an image which has no uploaded
+	// content.
+	ErrNoContent = errors.New("Image has no content")
+
+	// ErrBatchTooLarge occurs when a batch holds more images than allowed.
+	ErrBatchTooLarge = errors.New("Batch has too many images")
+
+	// ErrSchemaNotFound occurs when a publisher has no metadata schema.
+	ErrSchemaNotFound = errors.New("Metadata schema not found")
+)
+
+func init() {
+	web.RegisterError(ErrNotFound, web.ProblemType{Type: web.ProblemBaseURI + "image-not-found", Title: "Entity not found", Status: http.StatusNotFound})
+	web.RegisterError(ErrExpired, web.ProblemType{Type: web.ProblemBaseURI + "image-expired", Title: "Image has expired", Status: http.StatusGone})
+	web.RegisterError(ErrRevisionNotFound, web.ProblemType{Type: web.ProblemBaseURI + "revision-not-found", Title: "Revision not found", Status: http.StatusNotFound})
+	web.RegisterError(ErrContentType, web.ProblemType{Type: web.ProblemBaseURI + "content-type", Title: "Unsupported image type", Status: http.StatusUnsupportedMediaType})
+	web.RegisterError(ErrContentTooLarge, web.ProblemType{Type: web.ProblemBaseURI + "content-too-large", Title: "Image too large", Status: http.StatusRequestEntityTooLarge})
+	web.RegisterError(ErrNoContent, web.ProblemType{Type: web.ProblemBaseURI + "no-content", Title: "Image has no content", Status: http.StatusNotFound})
+	web.RegisterError(imaging.ErrUnknownFormat, web.ProblemType{Type: web.ProblemBaseURI + "unknown-format", Title: "Unknown image format", Status: http.StatusUnprocessableEntity})
+	web.RegisterError(imaging.ErrTooManyPixels, web.ProblemType{Type: web.ProblemBaseURI + "too-many-pixels", Title: "Image has too many pixels", Status: http.StatusUnprocessableEntity})
+	web.RegisterError(ErrBatchTooLarge, web.ProblemType{Type: web.ProblemBaseURI + "batch-too-large", Title: "Batch too large", Status: http.StatusRequestEntityTooLarge})
+	web.RegisterError(ErrSchemaNotFound, web.ProblemType{Type: web.ProblemBaseURI + "schema-not-found", Title: "Metadata schema not found", Status: http.StatusNotFound})
+	web.RegisterError(storage.ErrNotFound, web.ProblemType{Type: web.ProblemBaseURI + "blob-not-found", Title: "Blob not found", Status: http.StatusNotFound})
+	web.RegisterError(storage.ErrInvalidKey, web.ProblemType{Type: web.ProblemBaseURI + "invalid-blob-key", Title: "Invalid blob key", Status: http.StatusBadRequest})
+	web.RegisterError(db.ErrInvalidFilter, web.ProblemType{Type: web.ProblemBaseURI + "invalid-filter", Title: "Invalid filter", Status: http.StatusBadRequest})
+	web.RegisterErrorFunc(func(err error) (web.ProblemType, bool) {
+		if _, ok := err.(NearDuplicateError); ok {
+			return web.ProblemType{Type: web.ProblemBaseURI + "image-near-duplicate", Title: "Near-duplicate image", Status: http.StatusConflict}, true
+		}
+		return web.ProblemType{}, false
+	})
+	web.RegisterError(ErrDuplicate, web.ProblemType{Type: web.ProblemBaseURI + "image-duplicate", Title: "Duplicate image", Status: http.StatusConflict})
+}
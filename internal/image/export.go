@@ -0,0 +1,299 @@
+package image
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jdelobel/go-api/internal/platform/db"
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Export formats, with their media type.
+const (
+	ExportNDJSON = "ndjson"
+	ExportCSV    = "csv"
+)
+
+var exportTypes = map[string]string{
+	ExportNDJSON: "application/x-ndjson",
+	ExportCSV:    "text/csv",
+}
+
+// exportFetch is the number of rows fetched from the cursor at once. Each
+// batch is flushed to the client before the next one is fetched.
+const exportFetch = 500
+
+// exportColumns maps the columns which can be exported to the expression
+// selecting them. The uuids are read as text.
+var exportColumns = map[string]string{
+	"id":             "id::text",
+	"title":          "title",
+	"url":            "url",
+	"slug":           "slug",
+	"publisher":      "publisher",
+	"published_at":   "published_at",
+	"expired_at":     "expired_at",
+	"metadata":       "metadata",
+	"content_type":   "content_type",
+	"content_size":   "content_size",
+	"content_sha256": "content_sha256",
+	"version":        "version",
+	"created_at":     "created_at",
+	"updated_at":     "updated_at",
+	"restored_at":    "restored_at",
+	"deleted_at":     "deleted_at",
+}
+
+// defaultExportColumns are the columns exported when none are selected, in
+// the order of the image fields.
+var defaultExportColumns = []string{
+	"id", "title", "url", "slug", "publisher", "published_at", "expired_at", "metadata",
+	"content_type", "content_size", "content_sha256", "version",
+	"created_at", "updated_at", "restored_at", "deleted_at",
+}
+
+// Export describes a dump of the images: the rows matching the filters of
+// List, restricted to the scope, with the selected columns.
+type Export struct {
+	Format  string
+	Columns []string
+	Filters url.Values
+	Scope   Scope
+}
+
+// ParseExport reads an export from the query parameters: format, either
+// ndjson or csv, columns, a comma separated list of columns, and the
+// filters of List. Without format, the Accept header of the request
+// chooses it.
+func ParseExport(r *http.Request, scope Scope) (*Export, error) {
+	qp := r.URL.Query()
+	e := Export{Format: qp.Get("format"), Columns: defaultExportColumns, Filters: url.Values{}, Scope: scope}
+
+	switch e.Format {
+	case "":
+		mt := web.Negotiate(r, exportTypes[ExportNDJSON], exportTypes[ExportCSV])
+		if mt == "" {
+			return nil, errors.Wrapf(web.ErrNotAcceptable, "Accept: %s", r.Header.Get("Accept"))
+		}
+		e.Format = ExportNDJSON
+		if mt == exportTypes[ExportCSV] {
+			e.Format = ExportCSV
+		}
+	case ExportNDJSON, ExportCSV:
+	default:
+		return nil, web.InvalidError{{Fld: "format", Err: "oneof", Param: "ndjson csv", Msg: "must be one of [ndjson csv]"}}
+	}
+
+	if s := qp.Get("columns"); s != "" {
+		e.Columns = strings.Split(s, ",")
+		for _, col := range e.Columns {
+			if _, ok := exportColumns[col]; !ok {
+				return nil, web.InvalidError{{Fld: "columns", Err: "oneof", Param: col, Msg: "unknown column " + col}}
+			}
+		}
+	}
+
+	for k, v := range qp {
+		if k != "format" && k != "columns" {
+			e.Filters[k] = v
+		}
+	}
+	return &e, nil
+}
+
+// ContentType returns the media type of the export.
+func (e *Export) ContentType() string {
+	return exportTypes[e.Format]
+}
+
+// Run writes the export to w. The rows are read from a server-side cursor
+// and written by batches, each one flushed when w is an http.Flusher, so
+// that memory use doesn't grow with the number of images. The error is
+// returned as is once rows were written.
+func (e *Export) Run(ctx context.Context, dbConn *db.DB, w io.Writer) error {
+	where, params, err := db.BuildWhere(e.Filters, filterColumns, 0)
+	if err != nil {
+		return errors.Wrap(err, "Export")
+	}
+	if cond := scopeCond(e.Scope); cond != "" {
+		if where == "" {
+			where = " WHERE TRUE"
+		}
+		where += cond
+	}
+
+	exprs := make([]string, len(e.Columns))
+	for i, col := range e.Columns {
+		exprs[i] = exportColumns[col]
+	}
+
+	// Cursors live within a transaction, which also gives the whole export
+	// a single snapshot.
+	tx, err := dbConn.PSQLBegin(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Export")
+	}
+	defer tx.Rollback()
+
+	query := `DECLARE images_export NO SCROLL CURSOR FOR SELECT ` + strings.Join(exprs, ", ") +
+		` FROM images` + where + ` ORDER BY created_at, id`
+	if _, err := tx.ExecContext(ctx, query, params...); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("db.images.export(%s)", db.Query(e.Filters)))
+	}
+
+	ew := newExportWriter(e.Format, w, e.Columns)
+	if err := ew.header(); err != nil {
+		return err
+	}
+	for {
+		n, err := e.fetch(ctx, tx, ew)
+		if err != nil {
+			return err
+		}
+		if err := ew.flush(); err != nil {
+			return err
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		if n < exportFetch {
+			return nil
+		}
+	}
+}
+
+// fetch writes the next batch of rows of the cursor and returns their
+// number.
+func (e *Export) fetch(ctx context.Context, tx *sqlx.Tx, ew exportWriter) (int, error) {
+	rows, err := tx.QueryxContext(ctx, `FETCH `+strconv.Itoa(exportFetch)+` FROM images_export`)
+	if err != nil {
+		return 0, errors.Wrap(err, "db.images.export()Fetch")
+	}
+	defer rows.Close()
+
+	var n int
+	for rows.Next() {
+		values, err := rows.SliceScan()
+		if err != nil {
+			return 0, errors.Wrap(err, "db.images.export()SliceScan")
+		}
+		if err := ew.row(values); err != nil {
+			return 0, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+// exportWriter encodes the rows of an export.
+type exportWriter interface {
+	header() error
+	row(values []interface{}) error
+	flush() error
+}
+
+// newExportWriter returns the writer of the format.
+func newExportWriter(format string, w io.Writer, columns []string) exportWriter {
+	if format == ExportCSV {
+		return &csvWriter{w: csv.NewWriter(w), columns: columns}
+	}
+	return &ndjsonWriter{w: bufio.NewWriter(w), columns: columns}
+}
+
+// ndjsonWriter writes each row as a JSON object on its own line, with the
+// keys in the order of the columns.
+type ndjsonWriter struct {
+	w       *bufio.Writer
+	columns []string
+}
+
+func (nw *ndjsonWriter) header() error {
+	return nil
+}
+
+func (nw *ndjsonWriter) row(values []interface{}) error {
+	nw.w.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			nw.w.WriteByte(',')
+		}
+		key, _ := json.Marshal(nw.columns[i])
+		nw.w.Write(key)
+		nw.w.WriteByte(':')
+
+		// The metadata is read as its JSON text, the other columns may be
+		// read as bytes by the driver.
+		raw, isBytes := v.([]byte)
+		if isBytes && nw.columns[i] == "metadata" {
+			nw.w.Write(raw)
+			continue
+		}
+		if isBytes {
+			v = string(raw)
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return errors.Wrapf(err, "Export %s", nw.columns[i])
+		}
+		nw.w.Write(b)
+	}
+	_, err := nw.w.WriteString("}\n")
+	return err
+}
+
+func (nw *ndjsonWriter) flush() error {
+	return nw.w.Flush()
+}
+
+// csvWriter writes a header line with the columns, then a line per row.
+// The times are formatted as RFC 3339, the metadata as JSON and NULL as an
+// empty field.
+type csvWriter struct {
+	w       *csv.Writer
+	columns []string
+	record  []string
+}
+
+func (cw *csvWriter) header() error {
+	return cw.w.Write(cw.columns)
+}
+
+func (cw *csvWriter) row(values []interface{}) error {
+	cw.record = cw.record[:0]
+	for _, v := range values {
+		cw.record = append(cw.record, csvField(v))
+	}
+	return cw.w.Write(cw.record)
+}
+
+func (cw *csvWriter) flush() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// csvField formats a value read from the database.
+func csvField(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
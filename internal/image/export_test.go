@@ -0,0 +1,94 @@
+package image
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+func TestParseExport(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		accept      string
+		wantFormat  string
+		wantColumns []string
+		wantFilters int
+		wantErr     error
+	}{
+		{name: "default", query: "", wantFormat: ExportNDJSON, wantColumns: defaultExportColumns},
+		{name: "format", query: "format=csv", accept: "application/x-ndjson", wantFormat: ExportCSV, wantColumns: defaultExportColumns},
+		{name: "accept", accept: "text/csv;q=0.9, application/json", wantFormat: ExportCSV, wantColumns: defaultExportColumns},
+		{name: "columns and filters", query: "columns=id,metadata&publisher=$eq.acme&format=ndjson", wantFormat: ExportNDJSON, wantColumns: []string{"id", "metadata"}, wantFilters: 1},
+		{name: "not acceptable", accept: "application/xml", wantErr: web.ErrNotAcceptable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/v1/images/export?"+tt.query, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			e, err := ParseExport(r, Public)
+			if tt.wantErr != nil {
+				if errors.Cause(err) != tt.wantErr {
+					t.Fatalf("ParseExport() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseExport() error = %v", err)
+			}
+			if e.Format != tt.wantFormat || !reflect.DeepEqual(e.Columns, tt.wantColumns) || len(e.Filters) != tt.wantFilters {
+				t.Errorf("ParseExport() = %+v", e)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, q := range []string{"format=xml", "columns=id,storage_key"} {
+			r := httptest.NewRequest("GET", "/v1/images/export?"+q, nil)
+			if _, err := ParseExport(r, Public); reflect.TypeOf(err) != reflect.TypeOf(web.InvalidError{}) {
+				t.Errorf("ParseExport(%s) error = %v, want InvalidError", q, err)
+			}
+		}
+	})
+}
+
+func TestExportWriters(t *testing.T) {
+	at := time.Date(2019, 7, 14, 10, 30, 0, 0, time.UTC)
+	columns := []string{"id", "title", "metadata", "version", "published_at", "deleted_at"}
+	row := []interface{}{"47c658e0", "A \"cat\", asleep", []byte(`{"width": 800}`), int64(2), at, nil}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{format: ExportNDJSON, want: `{"id":"47c658e0","title":"A \"cat\", asleep","metadata":{"width": 800},"version":2,"published_at":"2019-07-14T10:30:00Z","deleted_at":null}` + "\n"},
+		{format: ExportCSV, want: "id,title,metadata,version,published_at,deleted_at\n" + `47c658e0,"A ""cat"", asleep","{""width"": 800}",2,2019-07-14T10:30:00Z,` + "\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			ew := newExportWriter(tt.format, &buf, columns)
+			if err := ew.header(); err != nil {
+				t.Fatal(err)
+			}
+			if err := ew.row(row); err != nil {
+				t.Fatal(err)
+			}
+			if err := ew.flush(); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("got %s\nwant %s", buf.String(), tt.want)
+			}
+		})
+	}
+}
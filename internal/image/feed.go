@@ -0,0 +1,412 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/jdelobel/go-api/internal/platform/db"
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// Events of the change feed, recorded by the database whenever an image is
+// created, updated or deleted.
+const (
+	EventCreated = "image.created"
+	EventUpdated = "image.updated"
+	EventDeleted = "image.deleted"
+)
+
+// EventsChannel is the Postgres channel notified of the new events.
+const EventsChannel = "image_events"
+
+// feedBatch is the number of events read at once.
+const feedBatch = 500
+
+var (
+	// ErrFeedFull occurs when the feed has as many subscribers as allowed.
+	ErrFeedFull = errors.New("Too many event subscribers")
+
+	// ErrEventsPurged occurs when a client resumes after an event which
+	// was purged along with the ones following it, so that it can't get
+	// the events it missed. It starts afresh instead.
+	ErrEventsPurged = errors.New("Events were purged")
+)
+
+func init() {
+	web.RegisterError(ErrFeedFull, web.ProblemType{Type: web.ProblemBaseURI + "feed-full", Title: "Too many event subscribers", Status: http.StatusServiceUnavailable})
+	web.RegisterError(ErrEventsPurged, web.ProblemType{Type: web.ProblemBaseURI + "events-purged", Title: "Events were purged", Status: http.StatusGone})
+}
+
+// FeedEvent is a change of an image along with its state after the change.
+// The ids increase in the order the events become readable, see
+// NumberEvents.
+type FeedEvent struct {
+	ID        int64     `db:"id" json:"id"`
+	Type      string    `db:"type" json:"type"`
+	ImageID   string    `db:"image_id" json:"image_id"`
+	Publisher *string   `db:"publisher" json:"publisher"`
+	State     *Snapshot `db:"state" json:"image"`
+	CreatedAt time.Time `db:"created_at" json:"time"`
+}
+
+// Visible tells whether the event is delivered to the callers of the
+// scope. Public callers get the events of the images inside their
+// visibility window, and the deletions of the images which were.
+func (e *FeedEvent) Visible(scope Scope, now time.Time) bool {
+	if scope == Editorial {
+		return true
+	}
+	img := e.State.Image
+	img.DeletedAt = nil
+	return checkVisible(&img, scope, now) == nil
+}
+
+// NumberEvents numbers the events recorded by the transactions older than
+// the oldest one in progress, which makes them readable, and returns their
+// number. The events of a transaction are thus delayed until the ones
+// started before are finished, rather than the image writes waiting for
+// each other. The readers number the events before reading them.
+func NumberEvents(ctx context.Context, dbConn *db.DB) (int64, error) {
+	var n int64
+	row, err := dbConn.PSQLQueryRawx(ctx, `SELECT image_events_number()`)
+	if err == nil {
+		err = row.Scan(&n)
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "db.image_events.number()")
+	}
+	return n, nil
+}
+
+// CheckEventsRetained fails with ErrEventsPurged when the events following
+// the specified one were purged, in part at least.
+func CheckEventsRetained(ctx context.Context, dbConn *db.DB, after int64) error {
+	if after == 0 {
+		return nil
+	}
+	var purged int64
+	row, err := dbConn.PSQLQueryRawx(ctx, `SELECT id FROM image_events_purged`)
+	if err == nil {
+		err = row.Scan(&purged)
+	}
+	if err != nil {
+		return errors.Wrap(err, "db.image_events_purged.find()")
+	}
+	if after < purged {
+		return errors.Wrapf(ErrEventsPurged, "Last-Event-ID: %d, purged up to %d", after, purged)
+	}
+	return nil
+}
+
+// ListEvents returns the events recorded after the specified one, oldest
+// first, restricted to the publishers when there are some. It fails with
+// ErrEventsPurged when some of them were purged.
+func ListEvents(ctx context.Context, dbConn *db.DB, after int64, publishers []string, limit int) ([]FeedEvent, error) {
+	if err := CheckEventsRetained(ctx, dbConn, after); err != nil {
+		return nil, err
+	}
+	return listEvents(ctx, dbConn, after, publishers, limit)
+}
+
+// listEvents returns the events recorded after the specified one, without
+// checking they were retained.
+func listEvents(ctx context.Context, dbConn *db.DB, after int64, publishers []string, limit int) ([]FeedEvent, error) {
+	query := `SELECT id, type, image_id, publisher, state, created_at FROM image_events WHERE id > $1`
+	params := []interface{}{after}
+	if len(publishers) > 0 {
+		query += ` AND publisher = ANY($2)`
+		params = append(params, pq.Array(publishers))
+	}
+	query += ` ORDER BY id LIMIT ` + strconv.Itoa(limit)
+
+	rows, err := dbConn.PSQLQuerier(ctx, query, params...)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("db.image_events.find(%d)", after))
+	}
+	defer rows.Close()
+
+	events := make([]FeedEvent, 0)
+	for rows.Next() {
+		var e FeedEvent
+		if err := rows.StructScan(&e); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("db.image_events.find(%d)StructScan", after))
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// ParseEventID reads the id of the last event received by a client, from
+// the Last-Event-ID header or the last_event_id query parameter. It is 0
+// when the client starts afresh.
+func ParseEventID(r *http.Request) (int64, error) {
+	s := r.Header.Get("Last-Event-ID")
+	if s == "" {
+		s = r.URL.Query().Get("last_event_id")
+	}
+	if s == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id < 0 {
+		return 0, web.InvalidError{{Fld: "Last-Event-ID", Err: "numeric", Msg: "must be an event id"}}
+	}
+	return id, nil
+}
+
+// ByPublisher returns the function matching the events of the publishers,
+// nil to match all the events when there are none.
+func ByPublisher(publishers []string) func(*FeedEvent) bool {
+	if len(publishers) == 0 {
+		return nil
+	}
+	var f EventFilter
+	f.Add(publishers, nil)
+	return f.Match
+}
+
+// EventFilter matches the events of a set of publishers and of a set of
+// images, which may change while it is used. An empty filter matches no
+// event.
+type EventFilter struct {
+	mu         sync.RWMutex
+	publishers map[string]bool
+	images     map[string]bool
+}
+
+// Add adds publishers and images to the filter.
+func (f *EventFilter) Add(publishers, images []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.publishers == nil {
+		f.publishers, f.images = make(map[string]bool), make(map[string]bool)
+	}
+	for _, p := range publishers {
+		f.publishers[p] = true
+	}
+	for _, id := range images {
+		f.images[id] = true
+	}
+}
+
+// Remove removes publishers and images from the filter.
+func (f *EventFilter) Remove(publishers, images []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, p := range publishers {
+		delete(f.publishers, p)
+	}
+	for _, id := range images {
+		delete(f.images, id)
+	}
+}
+
+// Len returns the number of publishers and images of the filter.
+func (f *EventFilter) Len() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.publishers) + len(f.images)
+}
+
+// List returns the publishers and the images of the filter, sorted.
+func (f *EventFilter) List() ([]string, []string) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return sortedKeys(f.publishers), sortedKeys(f.images)
+}
+
+// Match tells whether the event is about one of the images, or of the
+// publishers, of the filter.
+func (f *EventFilter) Match(e *FeedEvent) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.images[e.ImageID] || (e.Publisher != nil && f.publishers[*e.Publisher])
+}
+
+// sortedKeys returns the keys of the set, sorted.
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Feed fans the image events out to its subscribers. It reads the events
+// recorded after the last one it delivered whenever the database notifies
+// new ones, and after the poll interval in case a notification was lost.
+// A subscriber whose buffer is full is disconnected rather than slowing
+// the others down.
+type Feed struct {
+	DB  *db.DB
+	Log *log.Entry
+
+	// Poll is the maximum time between two reads of the events.
+	Poll time.Duration
+
+	// Buffer is the number of events buffered per subscriber.
+	Buffer int
+
+	// MaxSubscribers is the number of subscribers at once, 0 for no limit.
+	MaxSubscribers int
+
+	// Retention is the time the events are kept for the subscribers to
+	// resume, 0 to keep them forever.
+	Retention time.Duration
+
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+	last int64
+}
+
+// Subscription receives the events of a feed matched by its function.
+type Subscription struct {
+	match  func(*FeedEvent) bool
+	events chan FeedEvent
+}
+
+// Events returns the channel of the events. It is closed when the
+// subscriber falls behind or the feed stops.
+func (s *Subscription) Events() <-chan FeedEvent {
+	return s.events
+}
+
+// Subscribe returns a subscription to the events matched by the function,
+// or to all of them when it is nil. It receives the events read from now
+// on: the subscriber catches up with ListEvents once subscribed.
+func (f *Feed) Subscribe(match func(*FeedEvent) bool) (*Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.MaxSubscribers > 0 && len(f.subs) >= f.MaxSubscribers {
+		return nil, errors.Wrapf(ErrFeedFull, "Max: %d", f.MaxSubscribers)
+	}
+	if f.subs == nil {
+		f.subs = make(map[*Subscription]struct{})
+	}
+
+	s := Subscription{match: match, events: make(chan FeedEvent, f.Buffer)}
+	f.subs[&s] = struct{}{}
+	return &s, nil
+}
+
+// Unsubscribe ends a subscription.
+func (f *Feed) Unsubscribe(s *Subscription) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.drop(s)
+}
+
+// drop closes a subscription, once.
+func (f *Feed) drop(s *Subscription) {
+	if _, ok := f.subs[s]; ok {
+		delete(f.subs, s)
+		close(s.events)
+	}
+}
+
+// Run reads and delivers the events until the context is done, then ends
+// the subscriptions. It starts after the latest recorded event.
+func (f *Feed) Run(ctx context.Context) {
+	defer func() {
+		f.mu.Lock()
+		for s := range f.subs {
+			f.drop(s)
+		}
+		f.mu.Unlock()
+	}()
+
+	notify, err := f.DB.PSQLListen(ctx, EventsChannel)
+	if err != nil {
+		f.Log.Errorf("feed : listening to notifications, polling every %v : %v", f.Poll, err)
+	}
+	row, err := f.DB.PSQLQueryRawx(ctx, `SELECT COALESCE(max(id), 0) FROM image_events`)
+	if err == nil {
+		err = row.Scan(&f.last)
+	}
+	if err != nil {
+		f.Log.Errorf("feed : %v", errors.Wrap(err, "db.image_events.max()"))
+	}
+
+	poll := time.NewTicker(f.Poll)
+	defer poll.Stop()
+	purge := time.NewTicker(time.Hour)
+	defer purge.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-notify:
+			if !ok {
+				return
+			}
+		case <-poll.C:
+		case <-purge.C:
+			f.purge(ctx)
+			continue
+		}
+		if err := f.read(ctx); err != nil {
+			f.Log.Errorf("feed : %v", err)
+		}
+	}
+}
+
+// read numbers the events and delivers the ones recorded since the last
+// one delivered.
+func (f *Feed) read(ctx context.Context) error {
+	if _, err := NumberEvents(ctx, f.DB); err != nil {
+		return err
+	}
+	for {
+		events, err := listEvents(ctx, f.DB, f.last, nil, feedBatch)
+		if err != nil {
+			return err
+		}
+		f.publish(events)
+		if len(events) < feedBatch {
+			return nil
+		}
+	}
+}
+
+// publish delivers the events to the subscribers.
+func (f *Feed) publish(events []FeedEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, e := range events {
+		for s := range f.subs {
+			if s.match != nil && !s.match(&e) {
+				continue
+			}
+			select {
+			case s.events <- e:
+			default:
+				f.drop(s)
+			}
+		}
+		f.last = e.ID
+	}
+}
+
+// purge deletes the events up to the last one older than the retention,
+// and records it so that the clients resuming before it are told.
+func (f *Feed) purge(ctx context.Context) {
+	if f.Retention <= 0 {
+		return
+	}
+	before := time.Now().Add(-f.Retention)
+	_, err := f.DB.PSQLExecute(ctx, `WITH purged AS (
+			DELETE FROM image_events WHERE id <= (SELECT max(id) FROM image_events WHERE created_at < $1) RETURNING id)
+		UPDATE image_events_purged SET id = GREATEST(id, (SELECT max(id) FROM purged)) WHERE EXISTS (SELECT 1 FROM purged)`, before)
+	if err != nil {
+		f.Log.Errorf("feed : %v", errors.Wrap(err, "db.image_events.purge()"))
+	}
+}
@@ -0,0 +1,179 @@
+package image
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestFeed(t *testing.T) {
+	etf1, tf1 := "etf1", "tf1"
+	events := []FeedEvent{
+		{ID: 1, Type: EventCreated, Publisher: &etf1},
+		{ID: 2, Type: EventUpdated, Publisher: &tf1},
+		{ID: 3, Type: EventDeleted, Publisher: &etf1},
+	}
+
+	tests := []struct {
+		name       string
+		publishers []string
+		buffer     int
+		want       []int64
+		dropped    bool
+	}{
+		{name: "all", buffer: 3, want: []int64{1, 2, 3}},
+		{name: "publisher", publishers: []string{"etf1"}, buffer: 3, want: []int64{1, 3}},
+		{name: "publishers", publishers: []string{"etf1", "tf1"}, buffer: 3, want: []int64{1, 2, 3}},
+		{name: "slow", buffer: 2, want: []int64{1, 2}, dropped: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Feed{Buffer: tt.buffer}
+			s, err := f.Subscribe(ByPublisher(tt.publishers))
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.publish(events)
+			f.Unsubscribe(s)
+
+			var got []int64
+			for e := range s.Events() {
+				got = append(got, e.ID)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("events = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("events = %v, want %v", got, tt.want)
+				}
+			}
+			if f.last != 3 {
+				t.Errorf("last = %d, want 3", f.last)
+			}
+			if _, ok := f.subs[s]; ok {
+				t.Error("subscription is still registered")
+			}
+		})
+	}
+}
+
+func TestFeedFull(t *testing.T) {
+	f := Feed{MaxSubscribers: 1}
+	s, err := f.Subscribe(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Subscribe(nil); errors.Cause(err) != ErrFeedFull {
+		t.Errorf("Subscribe() = %v, want %v", err, ErrFeedFull)
+	}
+	f.Unsubscribe(s)
+	if _, err := f.Subscribe(nil); err != nil {
+		t.Errorf("Subscribe() = %v, want nil", err)
+	}
+}
+
+func TestFeedEventVisible(t *testing.T) {
+	now := time.Date(2019, 7, 14, 10, 30, 0, 0, time.UTC)
+	id := "47c658e0-68d7-4d79-9f9f-25ece8a1fb03"
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+
+	tests := []struct {
+		name  string
+		img   Image
+		scope Scope
+		want  bool
+	}{
+		{name: "published", img: Image{ID: &id, PublishedAt: at(-time.Hour)}, scope: Public, want: true},
+		{name: "scheduled", img: Image{ID: &id, PublishedAt: at(time.Hour)}, scope: Public},
+		{name: "deleted published", img: Image{ID: &id, PublishedAt: at(-time.Hour), DeletedAt: at(0)}, scope: Public, want: true},
+		{name: "deleted scheduled", img: Image{ID: &id, PublishedAt: at(time.Hour), DeletedAt: at(0)}, scope: Public},
+		{name: "editorial scheduled", img: Image{ID: &id, PublishedAt: at(time.Hour)}, scope: Editorial, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := FeedEvent{State: &Snapshot{tt.img}}
+			if got := e.Visible(tt.scope, now); got != tt.want {
+				t.Errorf("Visible() = %v, want %v", got, tt.want)
+			}
+			if tt.img.DeletedAt != nil && e.State.DeletedAt == nil {
+				t.Error("Visible() changed the state of the event")
+			}
+		})
+	}
+}
+
+func TestParseEventID(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		query  string
+		want   int64
+		err    bool
+	}{
+		{name: "none"},
+		{name: "header", header: "42", want: 42},
+		{name: "query", query: "42", want: 42},
+		{name: "header first", header: "42", query: "7", want: 42},
+		{name: "invalid", header: "abc", err: true},
+		{name: "negative", header: "-1", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/v1/images/events?last_event_id="+tt.query, nil)
+			if tt.header != "" {
+				r.Header.Set("Last-Event-ID", tt.header)
+			}
+			got, err := ParseEventID(r)
+			if (err != nil) != tt.err {
+				t.Fatalf("ParseEventID() error = %v, want error %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseEventID() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventFilter(t *testing.T) {
+	etf1, tf1 := "etf1", "tf1"
+	id := "47c658e0-68d7-4d79-9f9f-25ece8a1fb03"
+
+	var f EventFilter
+	tests := []struct {
+		name   string
+		change func()
+		event  FeedEvent
+		want   bool
+	}{
+		{name: "empty", change: func() {}, event: FeedEvent{ImageID: id, Publisher: &etf1}},
+		{name: "publisher", change: func() { f.Add([]string{"etf1"}, nil) }, event: FeedEvent{Publisher: &etf1}, want: true},
+		{name: "other publisher", change: func() {}, event: FeedEvent{Publisher: &tf1}},
+		{name: "image", change: func() { f.Add(nil, []string{id}) }, event: FeedEvent{ImageID: id, Publisher: &tf1}, want: true},
+		{name: "removed image", change: func() { f.Remove(nil, []string{id}) }, event: FeedEvent{ImageID: id, Publisher: &tf1}},
+		{name: "removed publisher", change: func() { f.Remove([]string{"etf1"}, nil) }, event: FeedEvent{Publisher: &etf1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.change()
+			if got := f.Match(&tt.event); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	f.Add([]string{"tf1", "etf1"}, []string{id})
+	publishers, images := f.List()
+	if len(publishers) != 2 || publishers[0] != "etf1" || len(images) != 1 || f.Len() != 3 {
+		t.Errorf("List() = %v, %v", publishers, images)
+	}
+}
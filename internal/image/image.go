@@ -2,35 +2,64 @@ package image
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/url"
 	"regexp"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/jdelobel/go-api/internal/platform/db"
 	"github.com/jdelobel/go-api/internal/platform/rabbitmq"
 	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 )
 
-// List retrieves a list of existing images from the database.
-func List(ctx context.Context, dbConn *db.DB, queryParams url.Values) ([]Image, error) {
-	sqlQuery := "SELECT * from images"
-	if len(queryParams) > 0 {
-		where, err := dbConn.BuildWhere(queryParams)
-		if err != nil {
-			return nil, errors.Wrap(err, "List")
+// columns lists the columns of the images table read into an Image. The
+// search vector is left out.
+const columns = `id, title, url, slug, publisher, published_at, expired_at, metadata,
+	content_type, content_size, content_sha256, storage_key, exif, phash,
+	version, created_at, updated_at, restored_at, deleted_at`
+
+// filterColumns lists the columns images can be filtered on with List.
+var filterColumns = map[string]db.ColumnKind{
+	"id":           db.Scalar,
+	"title":        db.Scalar,
+	"url":          db.Scalar,
+	"slug":         db.Scalar,
+	"publisher":    db.Scalar,
+	"published_at": db.Scalar,
+	"expired_at":   db.Scalar,
+	"created_at":   db.Scalar,
+	"updated_at":   db.Scalar,
+	"restored_at":  db.Scalar,
+	"deleted_at":   db.Scalar,
+	"metadata":     db.JSONB,
+}
+
+// List retrieves a list of existing images from the database. Public
+// callers only get the images inside their visibility window.
+func List(ctx context.Context, dbConn *db.DB, queryParams url.Values, scope Scope) ([]Image, error) {
+	where, params, err := db.BuildWhere(queryParams, filterColumns, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "List")
+	}
+	if cond := scopeCond(scope); cond != "" {
+		if where == "" {
+			where = " WHERE TRUE"
 		}
-		sqlQuery += where
+		where += cond
 	}
 
 	images := make([]Image, 0)
-	rows, err := dbConn.PSQLQuerier(ctx, sqlQuery)
+	rows, err := dbConn.PSQLQuerier(ctx, "SELECT "+columns+" FROM images"+where, params...)
 
 	if err != nil {
 		return nil, errors.Wrap(err, "List")
 	}
+	defer rows.Close()
 	for rows.Next() {
 		data := Image{}
 		err := rows.StructScan(&data)
@@ -40,15 +69,17 @@ func List(ctx context.Context, dbConn *db.DB, queryParams url.Values) ([]Image,
 		}
 		images = append(images, data)
 	}
-	return images, nil
+	return images, rows.Err()
 }
 
-// Retrieve gets the specified images from the database.
-func Retrieve(ctx context.Context, dbConn *db.DB, imageID string) (*Image, error) {
+// Retrieve gets the specified images from the database. Public callers
+// don't find the images which are deleted or aren't published yet, and get
+// ErrExpired for the expired ones.
+func Retrieve(ctx context.Context, dbConn *db.DB, imageID string, scope Scope) (*Image, error) {
 	if !IsValidUUID(imageID) {
 		return nil, errors.Wrapf(web.ErrInvalidID, "IsValidUUID: %s", imageID)
 	}
-	row, err := dbConn.PSQLQueryRawx(ctx, "SELECT * from images where id=$1", imageID)
+	row, err := dbConn.PSQLQueryRawx(ctx, "SELECT "+columns+" FROM images WHERE id=$1", imageID)
 
 	if err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("db.images.find(%s)", db.Query(imageID)))
@@ -56,53 +87,198 @@ func Retrieve(ctx context.Context, dbConn *db.DB, imageID string) (*Image, error
 	image := Image{}
 	err = row.StructScan(&image)
 
+	if err == sql.ErrNoRows {
+		return nil, errors.Wrapf(ErrNotFound, "Id: %s", imageID)
+	}
 	if err != nil {
 		return nil, err
 	}
+	if err := checkVisible(&image, scope, time.Now()); err != nil {
+		return nil, err
+	}
 	return &image, nil
 }
 
+// RetrieveMany gets the specified images from the database at once. It
+// returns the image or the error of each id, in the order of the ids, with
+// the errors of Retrieve.
+func RetrieveMany(ctx context.Context, dbConn *db.DB, imageIDs []string, scope Scope) ([]*Image, []error) {
+	images := make([]*Image, len(imageIDs))
+	errs := make([]error, len(imageIDs))
+
+	var valid []string
+	for i, id := range imageIDs {
+		if !IsValidUUID(id) {
+			errs[i] = errors.Wrapf(web.ErrInvalidID, "IsValidUUID: %s", id)
+			continue
+		}
+		valid = append(valid, id)
+	}
+	if len(valid) == 0 {
+		return images, errs
+	}
+
+	found := make(map[string]*Image, len(valid))
+	rows, err := dbConn.PSQLQuerier(ctx, "SELECT "+columns+" FROM images WHERE id = ANY($1)", pq.Array(valid))
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var img Image
+			if err = rows.StructScan(&img); err != nil {
+				break
+			}
+			found[*img.ID] = &img
+		}
+		if err == nil {
+			err = rows.Err()
+		}
+	}
+	if err != nil {
+		err = errors.Wrap(err, fmt.Sprintf("db.images.find(%s)", db.Query(valid)))
+	}
+
+	now := time.Now()
+	for i, id := range imageIDs {
+		switch {
+		case errs[i] != nil:
+		case err != nil:
+			errs[i] = err
+		case found[id] == nil:
+			errs[i] = errors.Wrapf(ErrNotFound, "Id: %s", id)
+		default:
+			if errs[i] = checkVisible(found[id], scope, now); errs[i] == nil {
+				images[i] = found[id]
+			}
+		}
+	}
+	return images, errs
+}
+
 // Create inserts a new image into the database.
 func Create(ctx context.Context, dbConn *db.DB, rbmq *rabbitmq.RabbitMQ, cm *CreateImage) (*Image, error) {
-	params := []interface{}{cm.Title, cm.URL, cm.Slug, cm.Publisher}
-	query := "INSERT INTO images(title, url, slug, publisher) VALUES($1,$2,$3,$4) RETURNING *"
+	if err := validateMetadata(ctx, dbConn, cm.Publisher, cm.Metadata); err != nil {
+		return nil, errors.Wrap(err, "Create")
+	}
+
+	params := []interface{}{cm.Title, cm.URL, cm.Slug, cm.Publisher, cm.Metadata, nullTime(cm.PublishedAt), nullTime(cm.ExpiredAt)}
+	query := `INSERT INTO images(title, url, slug, publisher, metadata, published_at, expired_at)
+		VALUES($1,$2,$3,$4,$5,COALESCE($6, now()),$7) RETURNING ` + columns
 	row, err := dbConn.PSQLQueryRawx(ctx, query, params...)
 	if err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("db.images.insert(%s)", db.Query(query)))
 	}
 	var img Image
 	if err = row.StructScan(&img); err != nil {
+		if db.IsUniqueViolation(err) {
+			return nil, errors.Wrapf(ErrDuplicate, "Slug: %s Url: %s", cm.Slug, cm.URL)
+		}
 		return nil, errors.Wrap(err, fmt.Sprintf("db.images.insert(%s)StructScan", db.Query(query)))
 	}
+	publishCreated(rbmq, &img)
+	return &img, nil
+}
+
+// publishCreated publishes the image on the image_created queue. Failures
+// are logged, the image is created anyway.
+func publishCreated(rbmq *rabbitmq.RabbitMQ, img *Image) {
 	qn := "image_created"
-	imgJSON, err := json.Marshal(&img)
+	imgJSON, err := json.Marshal(img)
 	if err != nil {
-		log.Warnf("RabbitMQ: failed to marshal obj %v for image creation: %v", &img, err)
+		log.Warnf("RabbitMQ: failed to marshal obj %v for image creation: %v", img, err)
 	}
-	rbmq.DeclareQueue(qn)
-	if err != nil {
+	if _, err := rbmq.DeclareQueue(qn); err != nil {
 		log.Warnf("RabbitMQ: cannot declare queue for image creation: %v", err)
 	}
 	err = rbmq.Publish(&qn, imgJSON)
 	if err != nil {
 		log.Warnf("RabbitMQ: failed to publish a message for image creation: %v", err)
 	}
-	return &img, nil
 }
 
-// Update replaces an image document in the database.
-func Update(ctx context.Context, dbConn *db.DB, imageID string, um *CreateImage) error {
+// Update replaces an image document in the database and returns its new
+// version. Its prior state is recorded as a revision. The update fails with
+// web.ErrPreconditionFailed when the current version doesn't match the
+// precondition.
+func Update(ctx context.Context, dbConn *db.DB, imageID string, um *CreateImage, by Author, pre web.Precondition) (int64, error) {
+	if !IsValidUUID(imageID) {
+		return 0, errors.Wrapf(web.ErrInvalidID, "IsValidUUID: %s", imageID)
+	}
+	if err := validateMetadata(ctx, dbConn, um.Publisher, um.Metadata); err != nil {
+		return 0, errors.Wrap(err, "Update")
+	}
+
+	tx, err := dbConn.PSQLBegin(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "Update")
+	}
+	defer tx.Rollback()
+
+	version, err := recordRevision(ctx, tx, imageID, by)
+	if err != nil {
+		return 0, err
+	}
+	if err := pre.Check(web.ETag(version)); err != nil {
+		return 0, errors.Wrapf(err, "Id: %s", imageID)
+	}
+
+	query := `UPDATE images SET title=$2, url=$3, slug=$4, publisher=$5, metadata=$6,
+		published_at=COALESCE($7, published_at), expired_at=$8, updated_at=now()
+		WHERE id=$1 AND deleted_at IS NULL RETURNING version`
+	params := []interface{}{imageID, um.Title, um.URL, um.Slug, um.Publisher, um.Metadata, nullTime(um.PublishedAt), nullTime(um.ExpiredAt)}
+	if err := tx.QueryRowxContext(ctx, query, params...).Scan(&version); err != nil {
+		if db.IsUniqueViolation(err) {
+			return 0, errors.Wrapf(ErrDuplicate, "Slug: %s Url: %s", um.Slug, um.URL)
+		}
+		return 0, errors.Wrap(err, fmt.Sprintf("db.image.update(%s, %s)", db.Query(imageID), db.Query(um)))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Wrap(err, "Update")
+	}
+	return version, nil
+}
+
+// Delete soft deletes an image: it is kept in the database with its
+// deletion time. Its prior state is recorded as a revision. The deletion
+// fails with web.ErrPreconditionFailed when the current version doesn't
+// match the precondition.
+func Delete(ctx context.Context, dbConn *db.DB, imageID string, by Author, pre web.Precondition) error {
 	if !IsValidUUID(imageID) {
 		return errors.Wrapf(web.ErrInvalidID, "IsValidUUID: %s", imageID)
 	}
 
-	if _, err := dbConn.PSQLExecute(ctx, "UPDATE images"); err != nil {
-		return errors.Wrap(err, fmt.Sprintf("db.image.update(%s, %s)", db.Query(imageID), db.Query(um)))
+	tx, err := dbConn.PSQLBegin(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Delete")
 	}
+	defer tx.Rollback()
 
+	version, err := recordRevision(ctx, tx, imageID, by)
+	if err != nil {
+		return err
+	}
+	if err := pre.Check(web.ETag(version)); err != nil {
+		return errors.Wrapf(err, "Id: %s", imageID)
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE images SET deleted_at=now() WHERE id=$1`, imageID); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("db.image.delete(%s)", db.Query(imageID)))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "Delete")
+	}
 	return nil
 }
 
+// nullTime returns nil for the zero time so that it's stored as NULL.
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
 // IsValidUUID check if uuid is in valid format
 func IsValidUUID(uuid string) bool {
 	r := regexp.MustCompile("^[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-4[a-fA-F0-9]{3}-[8|9|aA|bB][a-fA-F0-9]{3}-[a-fA-F0-9]{12}$")
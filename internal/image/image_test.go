@@ -3,11 +3,14 @@ package image_test
 import (
 	"context"
 	"log"
+	"net/http/httptest"
 	"os"
 	"testing"
 
 	"github.com/jdelobel/go-api/internal/image"
 	"github.com/jdelobel/go-api/internal/platform/db"
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/pkg/errors"
 )
 
 const (
@@ -56,7 +59,7 @@ func TestImages(t *testing.T) {
 			}
 			t.Logf("\t%s\tShould be able to create an image in the system.", Succeed)
 
-			rm, err := image.Retrieve(ctx, masterDB, *imageId.ID)
+			rm, err := image.Retrieve(ctx, masterDB, *imageId.ID, image.Editorial)
 			if err != nil {
 				t.Fatalf("\t%s\tShould be able to retrieve the image back from the system : %v", Failed, err)
 			}
@@ -67,10 +70,76 @@ func TestImages(t *testing.T) {
 			}
 			t.Logf("\t%s\tShould have a match between the created image and the one retrieved.", Succeed)
 
-			if _, err := image.Retrieve(ctx, masterDB, *rm.ID); err == nil {
+			if _, err := image.Retrieve(ctx, masterDB, *rm.ID, image.Editorial); err == nil {
 				t.Fatalf("\t%s\tShould NOT be able to retrieve the image back from the system : %v", Failed, err)
 			}
 			t.Logf("\t%s\tShould NOT be able to retrieve the image back from the system.", Succeed)
 		}
 	}
+
+	t.Run("versions", func(t *testing.T) {
+		m := image.CreateImage{
+			ID:        "8d3c5c1a-2f0b-4c51-9a47-3e2a1b0c9d7e",
+			Title:     "Image R. Daneel Olivaw",
+			URL:       "/images/1280/720/test-2261-c2407e-1@1x.jpeg",
+			Slug:      "/images/1280/720/test-2261-c2407e-1@1x",
+			Publisher: "etf1",
+		}
+
+		t.Log("Given the need to change an image only at the version the client has seen.")
+		{
+			t.Log("\tTest 0:\tWhen using the ETag of the image as If-Match")
+			{
+				if _, err := image.Create(ctx, masterDB, nil, &m); err != nil {
+					t.Fatalf("\t%s\tShould be able to create an image in the system : %v", Failed, err)
+				}
+				t.Logf("\t%s\tShould be able to create an image in the system.", Succeed)
+
+				rm, err := image.Retrieve(ctx, masterDB, m.ID, image.Editorial)
+				if err != nil {
+					t.Fatalf("\t%s\tShould be able to retrieve the image back from the system : %v", Failed, err)
+				}
+				t.Logf("\t%s\tShould be able to retrieve the image back from the system.", Succeed)
+
+				stale := ifMatch(t, web.ETag(rm.Version+1))
+				if _, err := image.Update(ctx, masterDB, m.ID, &m, image.Author{}, stale); errors.Cause(err) != web.ErrPreconditionFailed {
+					t.Fatalf("\t%s\tShould NOT be able to update the image with a stale ETag : %v", Failed, err)
+				}
+				t.Logf("\t%s\tShould NOT be able to update the image with a stale ETag.", Succeed)
+
+				version, err := image.Update(ctx, masterDB, m.ID, &m, image.Author{}, ifMatch(t, web.ETag(rm.Version)))
+				if err != nil || version != rm.Version+1 {
+					t.Fatalf("\t%s\tShould be able to update the image to the next version : %d %v", Failed, version, err)
+				}
+				t.Logf("\t%s\tShould be able to update the image to the next version.", Succeed)
+
+				if err := image.Delete(ctx, masterDB, m.ID, image.Author{}, ifMatch(t, web.ETag(rm.Version))); errors.Cause(err) != web.ErrPreconditionFailed {
+					t.Fatalf("\t%s\tShould NOT be able to remove the image with a stale ETag : %v", Failed, err)
+				}
+				t.Logf("\t%s\tShould NOT be able to remove the image with a stale ETag.", Succeed)
+
+				if err := image.Delete(ctx, masterDB, m.ID, image.Author{}, ifMatch(t, web.ETag(version))); err != nil {
+					t.Fatalf("\t%s\tShould be able to remove the image from the system : %v", Failed, err)
+				}
+				t.Logf("\t%s\tShould be able to remove the image from the system.", Succeed)
+
+				if _, err := image.Retrieve(ctx, masterDB, m.ID, image.Public); err == nil {
+					t.Fatalf("\t%s\tShould NOT be able to retrieve the removed image : %v", Failed, err)
+				}
+				t.Logf("\t%s\tShould NOT be able to retrieve the removed image.", Succeed)
+			}
+		}
+	})
+}
+
+// ifMatch returns the precondition of a request sending the entity tag in
+// its If-Match header.
+func ifMatch(t *testing.T, etag string) web.Precondition {
+	r := httptest.NewRequest("PUT", "/", nil)
+	r.Header.Set("If-Match", etag)
+	pre, err := web.IfMatch(r, true)
+	if err != nil {
+		t.Fatalf("\t%s\tShould be able to read the If-Match header : %v", Failed, err)
+	}
+	return pre
 }
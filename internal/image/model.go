@@ -1,17 +1,23 @@
 package image
 
-import "time"
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
 
 // CreateImage contains information about a image.
 type CreateImage struct {
-	ID          string    `json:"image_id"`
+	ID          string    `json:"image_id" validate:"omitempty,uuid4"`
 	Title       string    `json:"title" validate:"required,min=3"`
-	URL         string    `json:"url" validate:"required,min=3"`
-	Slug        string    `json:"slug" validate:"required,min=3"`
+	URL         string    `json:"url" validate:"required,min=3,imageurl"`
+	Slug        string    `json:"slug" validate:"required,min=3,urlslug"`
 	Publisher   string    `json:"publisher" validate:"required,min=3"`
 	PublishedAt time.Time `json:"published_at"`
 	ExpiredAt   time.Time `json:"expired_at"`
-	Metadata    struct{}  `json:"metadata"`
+	Metadata    Metadata  `json:"metadata"`
 }
 
 // Image contains information about a image.
@@ -23,9 +29,53 @@ type Image struct {
 	Publisher   *string    `db:"publisher" json:"publisher" validate:"required,min=3"`
 	PublishedAt *time.Time `db:"published_at" json:"published_at"`
 	ExpiredAt   *time.Time `db:"expired_at" json:"expired_at"`
-	Metadata    *struct{}  `db:"metadata" json:"metadata" `
-	CreatedAt   *time.Time `db:"created_at" json:"created_at"`
-	UpdatedAt   *time.Time `db:"updated_at" json:"updated_at"`
-	RestoredAt  *time.Time `db:"restored_at" json:"restored_at"`
-	DeletedAt   *time.Time `db:"deleted_at" json:"deleted_at"`
+	Metadata    Metadata   `db:"metadata" json:"metadata"`
+
+	ContentType   *string  `db:"content_type" json:"content_type"`
+	ContentSize   *int64   `db:"content_size" json:"content_size"`
+	ContentSHA256 *string  `db:"content_sha256" json:"content_sha256"`
+	StorageKey    *string  `db:"storage_key" json:"-"`
+	Exif          Metadata `db:"exif" json:"-"`
+	PHash         *int64   `db:"phash" json:"-"`
+
+	// Version is incremented on each update of the image.
+	Version int64 `db:"version" json:"version"`
+
+	CreatedAt  *time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt  *time.Time `db:"updated_at" json:"updated_at"`
+	RestoredAt *time.Time `db:"restored_at" json:"restored_at"`
+	DeletedAt  *time.Time `db:"deleted_at" json:"deleted_at"`
+}
+
+// Metadata holds free-form information about an image, like its
+// dimensions, credits or focal point. It is stored as jsonb.
+type Metadata map[string]interface{}
+
+// Value implements the driver.Valuer interface.
+func (m Metadata) Value() (driver.Value, error) {
+	if m == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, errors.Wrap(err, "Metadata.Value")
+	}
+	return string(b), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (m *Metadata) Scan(src interface{}) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return errors.Errorf("Metadata.Scan: unsupported type %T", src)
+	}
+	return json.Unmarshal(b, m)
 }
@@ -0,0 +1,214 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jdelobel/go-api/internal/platform/db"
+	"github.com/jdelobel/go-api/internal/platform/imaging"
+	"github.com/jdelobel/go-api/internal/platform/storage"
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+// DerivativeOptions limits the derivatives which can be rendered, so that
+// clients can't fill the blob store with arbitrary sizes.
+type DerivativeOptions struct {
+
+	// Presets lists the allowed sizes as WIDTHxHEIGHT, a zero dimension
+	// keeps the ratio of the original. E.g. 1280x720 or 320x0.
+	Presets []string
+
+	// MaxDPR is the highest device pixel ratio.
+	MaxDPR int
+
+	// MaxPixels limits the dimensions of the decoded originals.
+	MaxPixels int
+}
+
+// DefaultPresets are the sizes allowed when none is configured.
+var DefaultPresets = []string{"1280x720", "640x360", "320x180", "150x150", "1280x0", "640x0", "320x0"}
+
+// Rendition describes a derivative of an image.
+type Rendition struct {
+	Width  int
+	Height int
+	Fit    string
+	Format string
+	DPR    int
+}
+
+// ParseRendition reads the rendition from the w, h, fit, format and dpr
+// query parameters and checks it against the options. An empty format
+// keeps the format of the original when it can be encoded.
+func ParseRendition(qp url.Values, opts DerivativeOptions) (Rendition, error) {
+	rd := Rendition{Fit: imaging.FitContain, Format: qp.Get("format"), DPR: 1}
+	var inv web.InvalidError
+
+	for _, p := range []struct {
+		fld string
+		dst *int
+	}{{"w", &rd.Width}, {"h", &rd.Height}, {"dpr", &rd.DPR}} {
+		s := qp.Get(p.fld)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			inv = append(inv, web.Invalid{Fld: p.fld, Err: "numeric", Msg: "must be a positive integer"})
+			continue
+		}
+		*p.dst = n
+	}
+
+	if fit := qp.Get("fit"); fit != "" {
+		rd.Fit = fit
+	}
+	fits := []string{imaging.FitContain, imaging.FitCover, imaging.FitFill}
+	if !contains(fits, rd.Fit) {
+		inv = append(inv, oneOf("fit", fits))
+	}
+	if formats := imaging.Formats(); rd.Format != "" && !contains(formats, rd.Format) {
+		inv = append(inv, oneOf("format", formats))
+	}
+
+	maxDPR := opts.MaxDPR
+	if maxDPR < 1 {
+		maxDPR = 1
+	}
+	if rd.DPR < 1 || rd.DPR > maxDPR {
+		inv = append(inv, web.Invalid{Fld: "dpr", Err: "max", Param: strconv.Itoa(maxDPR), Msg: fmt.Sprintf("must be between 1 and %d", maxDPR)})
+	}
+
+	presets := opts.Presets
+	if len(presets) == 0 {
+		presets = DefaultPresets
+	}
+	if rd.Width == 0 && rd.Height == 0 {
+		inv = append(inv, web.Invalid{Fld: "w", Err: "required", Msg: "is required"})
+	} else if !contains(presets, fmt.Sprintf("%dx%d", rd.Width, rd.Height)) {
+		inv = append(inv, web.Invalid{Fld: "w", Err: "preset", Param: strings.Join(presets, " "), Msg: fmt.Sprintf("w and h must be one of the presets [%s]", strings.Join(presets, " "))})
+	}
+	if rd.Fit != imaging.FitContain && (rd.Width == 0 || rd.Height == 0) {
+		inv = append(inv, web.Invalid{Fld: "fit", Err: "required_with", Msg: "requires both w and h"})
+	}
+
+	if len(inv) > 0 {
+		return rd, inv
+	}
+	return rd, nil
+}
+
+// Render returns the derivative of the image content along with its MIME
+// type. Derivatives are cached in the store, under a key including the
+// hash of the original so that uploading a new content invalidates them.
+func Render(ctx context.Context, dbConn *db.DB, store storage.Store, imageID string, rd Rendition, opts DerivativeOptions, scope Scope) (io.ReadCloser, string, error) {
+	img, err := Retrieve(ctx, dbConn, imageID, scope)
+	if err != nil {
+		return nil, "", err
+	}
+	if img.StorageKey == nil || img.ContentSHA256 == nil || img.ContentType == nil {
+		return nil, "", errors.Wrapf(ErrNoContent, "Id: %s", imageID)
+	}
+
+	// Without an explicit format the derivative is cached under the format
+	// of the original, which is known from its MIME type.
+	if rd.Format == "" {
+		rd.Format = outputFormat(*img.ContentType)
+	}
+	contentType, _ := imaging.ContentType(rd.Format)
+
+	key := renditionKey(imageID, *img.ContentSHA256, rd)
+	if rc, err := store.Get(ctx, key); err == nil {
+		return rc, contentType, nil
+	} else if errors.Cause(err) != storage.ErrNotFound {
+		return nil, "", errors.Wrapf(err, "Render: %s", imageID)
+	}
+
+	orig, err := store.Get(ctx, *img.StorageKey)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "Render: %s", imageID)
+	}
+	defer orig.Close()
+
+	src, _, err := imaging.Decode(orig, opts.MaxPixels)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "Render: %s", imageID)
+	}
+
+	fx, fy := focalPoint(img.Metadata)
+	dst := imaging.Transform(src, imaging.Options{
+		Width:  rd.Width * rd.DPR,
+		Height: rd.Height * rd.DPR,
+		Fit:    rd.Fit,
+		FocusX: fx,
+		FocusY: fy,
+	})
+
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, dst, rd.Format); err != nil {
+		return nil, "", errors.Wrapf(err, "Render: %s", imageID)
+	}
+	if err := store.Put(ctx, key, bytes.NewReader(buf.Bytes()), int64(buf.Len()), contentType); err != nil {
+		return nil, "", errors.Wrapf(err, "Render: %s", imageID)
+	}
+
+	return ioutil.NopCloser(&buf), contentType, nil
+}
+
+// renditionKey returns the blob key of a derivative.
+func renditionKey(imageID, sha string, rd Rendition) string {
+	if len(sha) > 16 {
+		sha = sha[:16]
+	}
+	return fmt.Sprintf("images/%s/render/%s/%dx%d-%s@%dx.%s", imageID, sha, rd.Width, rd.Height, rd.Fit, rd.DPR, rd.Format)
+}
+
+// outputFormat returns the format a derivative of the content type is
+// encoded to by default.
+func outputFormat(contentType string) string {
+	name := strings.TrimPrefix(contentType, "image/")
+	if _, ok := imaging.ContentType(name); ok {
+		return name
+	}
+	return "jpeg"
+}
+
+// focalPoint reads the focal_point metadata, like {"x": 0.3, "y": 0.6},
+// and defaults to the center of the image.
+func focalPoint(md Metadata) (float64, float64) {
+	fx, fy := 0.5, 0.5
+	fp, ok := md["focal_point"].(map[string]interface{})
+	if !ok {
+		return fx, fy
+	}
+	if x, ok := fp["x"].(float64); ok && x >= 0 && x <= 1 {
+		fx = x
+	}
+	if y, ok := fp["y"].(float64); ok && y >= 0 && y <= 1 {
+		fy = y
+	}
+	return fx, fy
+}
+
+// oneOf builds the error of a parameter which isn't one of the values.
+func oneOf(fld string, values []string) web.Invalid {
+	param := strings.Join(values, " ")
+	return web.Invalid{Fld: fld, Err: "oneof", Param: param, Msg: fmt.Sprintf("must be one of [%s]", param)}
+}
+
+// contains reports whether the list holds the value.
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package image
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/jdelobel/go-api/internal/platform/web"
+)
+
+func TestParseRendition(t *testing.T) {
+	opts := DerivativeOptions{Presets: []string{"1280x720", "320x0"}, MaxDPR: 2}
+
+	tests := []struct {
+		name  string
+		query string
+		want  Rendition
+		field string
+	}{
+		{name: "preset", query: "w=1280&h=720", want: Rendition{Width: 1280, Height: 720, Fit: "contain", DPR: 1}},
+		{name: "width only", query: "w=320&format=png&dpr=2", want: Rendition{Width: 320, Fit: "contain", Format: "png", DPR: 2}},
+		{name: "cover", query: "w=1280&h=720&fit=cover", want: Rendition{Width: 1280, Height: 720, Fit: "cover", DPR: 1}},
+		{name: "no size", query: "", field: "w"},
+		{name: "not a preset", query: "w=1281&h=720", field: "w"},
+		{name: "negative width", query: "w=-1", field: "w"},
+		{name: "unknown fit", query: "w=1280&h=720&fit=zoom", field: "fit"},
+		{name: "cover without height", query: "w=320&fit=cover", field: "fit"},
+		{name: "unknown format", query: "w=1280&h=720&format=bmp", field: "format"},
+		{name: "dpr too high", query: "w=1280&h=720&dpr=3", field: "dpr"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qp, _ := url.ParseQuery(tt.query)
+			got, err := ParseRendition(qp, opts)
+
+			if tt.field == "" {
+				if err != nil {
+					t.Fatalf("ParseRendition(%q) = %v", tt.query, err)
+				}
+				if got != tt.want {
+					t.Errorf("ParseRendition(%q) = %+v, want %+v", tt.query, got, tt.want)
+				}
+				return
+			}
+
+			inv, ok := err.(web.InvalidError)
+			if !ok || len(inv) == 0 || inv[0].Fld != tt.field {
+				t.Errorf("ParseRendition(%q) = %v, want an error on %s", tt.query, err, tt.field)
+			}
+		})
+	}
+}
+
+func TestFocalPoint(t *testing.T) {
+	tests := []struct {
+		name   string
+		md     Metadata
+		fx, fy float64
+	}{
+		{name: "no metadata", md: nil, fx: 0.5, fy: 0.5},
+		{name: "focal point", md: Metadata{"focal_point": map[string]interface{}{"x": 0.2, "y": 0.8}}, fx: 0.2, fy: 0.8},
+		{name: "out of range", md: Metadata{"focal_point": map[string]interface{}{"x": 2.0, "y": -1.0}}, fx: 0.5, fy: 0.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if fx, fy := focalPoint(tt.md); fx != tt.fx || fy != tt.fy {
+				t.Errorf("focalPoint = %v, %v, want %v, %v", fx, fy, tt.fx, tt.fy)
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package image
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jdelobel/go-api/internal/platform/db"
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// Current designates the current state of an image when diffing revisions.
+const Current = 0
+
+// Author identifies who makes a change to an image. It is recorded along
+// with the prior state of the image.
+type Author struct {
+	Actor   string
+	TraceID string
+}
+
+// Revision is the state of an image before one of its changes, along with
+// the author of the change. Revisions are numbered from 1 for each image.
+type Revision struct {
+	ImageID   string    `db:"image_id" json:"image_id"`
+	Revision  int       `db:"revision" json:"revision"`
+	Actor     *string   `db:"actor" json:"actor"`
+	TraceID   *string   `db:"trace_id" json:"trace_id"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	State     *Snapshot `db:"state" json:"state,omitempty"`
+}
+
+// Snapshot is the state of an image recorded by a revision.
+type Snapshot struct {
+	Image
+}
+
+// Scan implements the sql.Scanner interface.
+func (s *Snapshot) Scan(src interface{}) error {
+	var b []byte
+	switch v := src.(type) {
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return errors.Errorf("Snapshot.Scan: unsupported type %T", src)
+	}
+	return json.Unmarshal(b, &s.Image)
+}
+
+// Change is a field which differs between two states of an image. The
+// metadata fields are named after their key, like metadata.caption.
+type Change struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// ListRevisions returns the revisions of the specified image, latest first,
+// without their state.
+func ListRevisions(ctx context.Context, dbConn *db.DB, imageID string, scope Scope) ([]Revision, error) {
+	if _, err := Retrieve(ctx, dbConn, imageID, scope); err != nil {
+		return nil, err
+	}
+
+	rows, err := dbConn.PSQLQuerier(ctx, `SELECT image_id, revision, actor, trace_id, created_at
+		FROM image_revisions WHERE image_id = $1 ORDER BY revision DESC`, imageID)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("db.image_revisions.find(%s)", db.Query(imageID)))
+	}
+	defer rows.Close()
+
+	revisions := make([]Revision, 0)
+	for rows.Next() {
+		var rev Revision
+		if err := rows.StructScan(&rev); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("db.image_revisions.find(%s)StructScan", db.Query(imageID)))
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+// ListRecentRevisions returns the latest revisions of each of the images at
+// once, at most limit per image, latest first and along with their state.
+// The visibility of the images isn't checked.
+func ListRecentRevisions(ctx context.Context, dbConn *db.DB, imageIDs []string, limit int) (map[string][]Revision, error) {
+	rows, err := dbConn.PSQLQuerier(ctx, `SELECT image_id, revision, actor, trace_id, created_at, state
+		FROM (SELECT *, row_number() OVER (PARTITION BY image_id ORDER BY revision DESC) AS n
+			FROM image_revisions WHERE image_id = ANY($1)) r
+		WHERE n <= $2 ORDER BY image_id, revision DESC`, pq.Array(imageIDs), limit)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("db.image_revisions.find(%s)", db.Query(imageIDs)))
+	}
+	defer rows.Close()
+
+	revisions := make(map[string][]Revision, len(imageIDs))
+	for rows.Next() {
+		var rev Revision
+		if err := rows.StructScan(&rev); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("db.image_revisions.find(%s)StructScan", db.Query(imageIDs)))
+		}
+		revisions[rev.ImageID] = append(revisions[rev.ImageID], rev)
+	}
+	return revisions, rows.Err()
+}
+
+// RetrieveRevision returns the specified revision of an image along with
+// its state.
+func RetrieveRevision(ctx context.Context, dbConn *db.DB, imageID string, revision int, scope Scope) (*Revision, error) {
+	if _, err := Retrieve(ctx, dbConn, imageID, scope); err != nil {
+		return nil, err
+	}
+	return findRevision(ctx, dbConn, imageID, revision)
+}
+
+// DiffRevisions returns the fields which changed from a revision of an
+// image to another one, or to its current state.
+func DiffRevisions(ctx context.Context, dbConn *db.DB, imageID string, from, to int, scope Scope) ([]Change, error) {
+	current, err := Retrieve(ctx, dbConn, imageID, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := findRevision(ctx, dbConn, imageID, from)
+	if err != nil {
+		return nil, err
+	}
+	if to == Current {
+		return Diff(&a.State.Image, current), nil
+	}
+	b, err := findRevision(ctx, dbConn, imageID, to)
+	if err != nil {
+		return nil, err
+	}
+	return Diff(&a.State.Image, &b.State.Image), nil
+}
+
+// RestoreRevision brings an image back to the state of the specified
+// revision, which is recorded as a new change. The content isn't
+// versioned: the title, url, slug, publisher, metadata and visibility
+// window are restored.
+func RestoreRevision(ctx context.Context, dbConn *db.DB, imageID string, revision int, by Author) (*Image, error) {
+	if !IsValidUUID(imageID) {
+		return nil, errors.Wrapf(web.ErrInvalidID, "IsValidUUID: %s", imageID)
+	}
+
+	tx, err := dbConn.PSQLBegin(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "RestoreRevision")
+	}
+	defer tx.Rollback()
+
+	var n int
+	err = tx.QueryRowxContext(ctx, `SELECT count(*) FROM image_revisions WHERE image_id = $1 AND revision = $2`, imageID, revision).Scan(&n)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("db.image_revisions.find(%s, %d)", db.Query(imageID), revision))
+	}
+	if n == 0 {
+		return nil, errors.Wrapf(ErrRevisionNotFound, "Id: %s Revision: %d", imageID, revision)
+	}
+
+	if _, err := recordRevision(ctx, tx, imageID, by); err != nil {
+		return nil, err
+	}
+
+	query := `UPDATE images SET (title, url, slug, publisher, metadata, published_at, expired_at) = (
+			SELECT s.title, s.url, s.slug, s.publisher, s.metadata, s.published_at, s.expired_at
+			FROM image_revisions r, jsonb_populate_record(NULL::images, r.state) s
+			WHERE r.image_id = $1 AND r.revision = $2),
+		updated_at = now()
+		WHERE id = $1 AND deleted_at IS NULL RETURNING ` + columns
+	var img Image
+	if err := tx.QueryRowxContext(ctx, query, imageID, revision).StructScan(&img); err != nil {
+		if db.IsUniqueViolation(err) {
+			return nil, errors.Wrapf(ErrDuplicate, "Id: %s Revision: %d", imageID, revision)
+		}
+		return nil, errors.Wrap(err, fmt.Sprintf("db.images.restore(%s, %d)", db.Query(imageID), revision))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "RestoreRevision")
+	}
+	return &img, nil
+}
+
+// ParseRevision reads a revision number from a path or query parameter.
+func ParseRevision(field, s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, web.InvalidError{{Fld: field, Err: "min", Param: "1", Msg: "must be a revision number"}}
+	}
+	return n, nil
+}
+
+// Diff returns the fields which differ between two states of an image,
+// sorted by name.
+func Diff(from, to *Image) []Change {
+	a, b := diffFields(from), diffFields(to)
+
+	names := make([]string, 0, len(a)+len(b))
+	for name := range a {
+		names = append(names, name)
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	changes := make([]Change, 0)
+	for _, name := range names {
+		if !reflect.DeepEqual(a[name], b[name]) {
+			changes = append(changes, Change{Field: name, From: a[name], To: b[name]})
+		}
+	}
+	return changes
+}
+
+// diffFields flattens the fields of an image which are compared by Diff.
+// The times are normalized to UTC as they're decoded from different
+// sources.
+func diffFields(img *Image) map[string]interface{} {
+	f := map[string]interface{}{
+		"title":          str(img.Title),
+		"url":            str(img.URL),
+		"slug":           str(img.Slug),
+		"publisher":      str(img.Publisher),
+		"published_at":   timestamp(img.PublishedAt),
+		"expired_at":     timestamp(img.ExpiredAt),
+		"content_type":   str(img.ContentType),
+		"content_sha256": str(img.ContentSHA256),
+		"deleted_at":     timestamp(img.DeletedAt),
+	}
+	for k, v := range img.Metadata {
+		f["metadata."+k] = v
+	}
+	return f
+}
+
+// str dereferences an optional string.
+func str(s *string) interface{} {
+	if s == nil {
+		return nil
+	}
+	return *s
+}
+
+// timestamp formats an optional time.
+func timestamp(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// findRevision reads a revision along with its state.
+func findRevision(ctx context.Context, dbConn *db.DB, imageID string, revision int) (*Revision, error) {
+	row, err := dbConn.PSQLQueryRawx(ctx, `SELECT image_id, revision, actor, trace_id, created_at, state
+		FROM image_revisions WHERE image_id = $1 AND revision = $2`, imageID, revision)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("db.image_revisions.find(%s, %d)", db.Query(imageID), revision))
+	}
+
+	var rev Revision
+	if err := row.StructScan(&rev); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.Wrapf(ErrRevisionNotFound, "Id: %s Revision: %d", imageID, revision)
+		}
+		return nil, errors.Wrap(err, fmt.Sprintf("db.image_revisions.find(%s, %d)StructScan", db.Query(imageID), revision))
+	}
+	return &rev, nil
+}
+
+// recordRevision locks the image and records its current state as a new
+// revision, before it is changed within the same transaction. The current
+// version of the image is returned.
+func recordRevision(ctx context.Context, tx *sqlx.Tx, imageID string, by Author) (int64, error) {
+	var version int64
+	err := tx.QueryRowxContext(ctx, `SELECT version FROM images WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, imageID).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, errors.Wrapf(ErrNotFound, "Id: %s", imageID)
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("db.images.lock(%s)", db.Query(imageID)))
+	}
+
+	// The lock is held until the end of the transaction, so the revision
+	// numbers of concurrent changes can't collide.
+	query := `INSERT INTO image_revisions(image_id, revision, state, actor, trace_id)
+		SELECT id, COALESCE((SELECT max(revision) FROM image_revisions WHERE image_id = $1), 0) + 1,
+			to_jsonb(images) - 'search', NULLIF($2, ''), NULLIF($3, '')
+		FROM images WHERE id = $1`
+	if _, err := tx.ExecContext(ctx, query, imageID, by.Actor, by.TraceID); err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("db.image_revisions.insert(%s)", db.Query(imageID)))
+	}
+	return version, nil
+}
@@ -0,0 +1,84 @@
+package image
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	title, retitled := "Image Elijah Baley", "Image R. Daneel Olivaw"
+	slug := "/images/1280/720/test-2260-b1396d-1@1x"
+	published := time.Date(2019, 7, 14, 10, 30, 0, 0, time.UTC)
+	samePublished := published.In(time.FixedZone("CEST", 2*3600))
+
+	from := &Image{
+		Title:       &title,
+		Slug:        &slug,
+		PublishedAt: &published,
+		Metadata:    Metadata{"caption": "Baley", "credit": "ACME"},
+	}
+	to := &Image{
+		Title:       &retitled,
+		Slug:        &slug,
+		PublishedAt: &samePublished,
+		Metadata:    Metadata{"caption": "Olivaw", "width": 1280.0},
+	}
+
+	want := []Change{
+		{Field: "metadata.caption", From: "Baley", To: "Olivaw"},
+		{Field: "metadata.credit", From: "ACME", To: nil},
+		{Field: "metadata.width", From: nil, To: 1280.0},
+		{Field: "title", From: title, To: retitled},
+	}
+	if got := Diff(from, to); !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %v, want %v", got, want)
+	}
+	if got := Diff(from, from); len(got) != 0 {
+		t.Errorf("Diff() of the same image = %v, want none", got)
+	}
+}
+
+func TestSnapshotScan(t *testing.T) {
+	var s Snapshot
+	src := []byte(`{"id": "47c658e0-68d7-4d79-9f9f-25ece8a1fb03", "title": "Image Elijah Baley",
+		"published_at": "2019-07-14T10:30:00.123456+00:00", "storage_key": "images/47c658e0", "metadata": {"caption": "Baley"}}`)
+	if err := s.Scan(src); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if s.Title == nil || *s.Title != "Image Elijah Baley" {
+		t.Errorf("Title = %v, want Image Elijah Baley", s.Title)
+	}
+	if s.PublishedAt == nil || s.PublishedAt.Nanosecond() != 123456000 {
+		t.Errorf("PublishedAt = %v, want 10:30:00.123456", s.PublishedAt)
+	}
+	if s.Metadata["caption"] != "Baley" {
+		t.Errorf("Metadata = %v, want the caption", s.Metadata)
+	}
+}
+
+func TestParseRevision(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    int
+		invalid bool
+	}{
+		{s: "1", want: 1},
+		{s: "42", want: 42},
+		{s: "0", invalid: true},
+		{s: "-3", invalid: true},
+		{s: "latest", invalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseRevision("rev", tt.s)
+			if (err != nil) != tt.invalid {
+				t.Fatalf("ParseRevision(%q) error = %v, want invalid %v", tt.s, err, tt.invalid)
+			}
+			if got != tt.want {
+				t.Errorf("ParseRevision(%q) = %d, want %d", tt.s, got, tt.want)
+			}
+		})
+	}
+}
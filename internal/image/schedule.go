@@ -0,0 +1,227 @@
+package image
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/jdelobel/go-api/internal/platform/db"
+	"github.com/jdelobel/go-api/internal/platform/rabbitmq"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Events published when an image enters or leaves its visibility window.
+// Each event is published on the queue of the same name.
+const (
+	EventPublished = "image.published"
+	EventExpired   = "image.expired"
+)
+
+// scheduleName is the row of images_schedule holding the time up to which
+// the visibility boundaries were processed.
+const scheduleName = "visibility"
+
+// boundaries maps the events to the column holding their time.
+var boundaries = []struct {
+	event  string
+	column string
+}{
+	{event: EventPublished, column: "published_at"},
+	{event: EventExpired, column: "expired_at"},
+}
+
+// Event is the message published when a visibility boundary of an image
+// passes. ID is the same each time the event is published, see Tick.
+type Event struct {
+	ID    string    `json:"id"`
+	Type  string    `json:"type"`
+	At    time.Time `json:"at"`
+	Image *Image    `json:"image"`
+}
+
+// eventID returns the idempotency key of the event of an image boundary,
+// also sent as the message id.
+func eventID(imageID, event string, at time.Time) string {
+	return imageID + "/" + event + "/" + at.UTC().Format(time.RFC3339Nano)
+}
+
+// Scheduler publishes the image.published and image.expired events when
+// the publication and expiry times of the images pass. The time up to
+// which the events were published is stored in the database, the events
+// missed while no scheduler was running are published on restart.
+// Several schedulers can run at once, each boundary is handled by one.
+// The boundaries announced are recorded, so that each tick looks back over
+// the lag for the images committed after the previous one read them.
+type Scheduler struct {
+	DB   *db.DB
+	RBMQ *rabbitmq.RabbitMQ
+	Log  *log.Entry
+
+	// Cache drops the cached lists when images are published or expire.
+	Cache *Cache
+
+	// Interval is the maximum time between two checks of the upcoming
+	// boundaries, so that images added meanwhile are noticed.
+	Interval time.Duration
+
+	// Lag is how long before the previous tick the boundaries are looked
+	// for again, longer than the transactions writing the images.
+	Lag time.Duration
+}
+
+// Run publishes the events until the context is done. It wakes up when the
+// next boundary passes, or after the interval.
+func (s *Scheduler) Run(ctx context.Context) {
+	for _, b := range boundaries {
+		if _, err := s.RBMQ.DeclareQueue(b.event); err != nil {
+			s.Log.Warnf("RabbitMQ: cannot declare queue %s: %v", b.event, err)
+		}
+	}
+
+	for {
+		next, err := s.Tick(ctx)
+		if err != nil {
+			s.Log.Errorf("scheduler : %v", err)
+			next = s.Interval
+		}
+
+		t := time.NewTimer(wait(next, s.Interval))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Tick publishes the events of the boundaries which passed since the last
+// tick and returns the time left until the next one, zero when none is
+// known. The events are published before the progress is committed so
+// that none is lost, a failed tick publishes them again: the delivery is
+// at least once, and the consumers drop the events whose id they already
+// handled.
+func (s *Scheduler) Tick(ctx context.Context) (time.Duration, error) {
+	tx, err := s.DB.PSQLBegin(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "Tick")
+	}
+	defer tx.Rollback()
+
+	// The lock makes concurrent schedulers wait for the progress of the
+	// current one. The database clock is used throughout so that the
+	// schedulers agree on the time.
+	var last, now time.Time
+	row := tx.QueryRowxContext(ctx, `SELECT last_run, now() FROM images_schedule WHERE name = $1 FOR UPDATE`, scheduleName)
+	if err := row.Scan(&last, &now); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, errors.Errorf("Tick: schedule %s not found", scheduleName)
+		}
+		return 0, errors.Wrap(err, "Tick")
+	}
+
+	from := last.Add(-s.Lag)
+	var published int
+	for _, b := range boundaries {
+		n, err := s.publish(ctx, tx, b.event, b.column, from, now)
+		if err != nil {
+			return 0, err
+		}
+		published += n
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE images_schedule SET last_run = $2 WHERE name = $1`, scheduleName, now); err != nil {
+		return 0, errors.Wrap(err, "Tick")
+	}
+
+	// The boundaries before the window aren't looked for anymore.
+	if _, err := tx.ExecContext(ctx, `DELETE FROM images_announced WHERE at <= $1`, from); err != nil {
+		return 0, errors.Wrap(err, "db.images_announced.delete()")
+	}
+
+	var next *time.Time
+	row = tx.QueryRowxContext(ctx, `SELECT LEAST(
+		(SELECT min(published_at) FROM images WHERE published_at > $1 AND deleted_at IS NULL),
+		(SELECT min(expired_at) FROM images WHERE expired_at > $1 AND deleted_at IS NULL))`, now)
+	if err := row.Scan(&next); err != nil {
+		return 0, errors.Wrap(err, "Tick")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Wrap(err, "Tick")
+	}
+	if published > 0 {
+		s.Cache.Invalidate(ctx, "")
+	}
+	if next == nil {
+		return 0, nil
+	}
+	return next.Sub(now), nil
+}
+
+// publish publishes the event of the images whose boundary column is in the
+// (from, to] interval and wasn't announced yet, in the order of their
+// boundaries, and records them announced. The number of events is
+// returned.
+func (s *Scheduler) publish(ctx context.Context, tx *sqlx.Tx, event, column string, from, to time.Time) (int, error) {
+	rows, err := tx.QueryxContext(ctx, `SELECT `+columns+` FROM images
+		WHERE deleted_at IS NULL AND `+column+` > $1 AND `+column+` <= $2
+			AND NOT EXISTS (SELECT 1 FROM images_announced a
+				WHERE a.image_id = images.id AND a.event = $3 AND a.at = images.`+column+`)
+		ORDER BY `+column, from, to, event)
+	if err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("db.images.%s(%s, %s)", event, from, to))
+	}
+	defer rows.Close()
+
+	var images []Image
+	for rows.Next() {
+		var img Image
+		if err := rows.StructScan(&img); err != nil {
+			return 0, errors.Wrap(err, fmt.Sprintf("db.images.%s(%s, %s)StructScan", event, from, to))
+		}
+		images = append(images, img)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("db.images.%s(%s, %s)", event, from, to))
+	}
+	rows.Close()
+
+	for i := range images {
+		img := &images[i]
+
+		at := *img.PublishedAt
+		if event == EventExpired {
+			at = *img.ExpiredAt
+		}
+		id := eventID(*img.ID, event, at)
+		msg, err := json.Marshal(Event{ID: id, Type: event, At: at, Image: img})
+		if err != nil {
+			return 0, errors.Wrapf(err, "Marshal %s of %s", event, *img.ID)
+		}
+		qn := event
+		if err := s.RBMQ.PublishID(&qn, id, msg); err != nil {
+			return 0, errors.Wrapf(err, "Publish %s of %s", event, *img.ID)
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO images_announced(image_id, event, at) VALUES ($1, $2, $3)
+			ON CONFLICT (image_id, event) DO UPDATE SET at = EXCLUDED.at`, *img.ID, event, at)
+		if err != nil {
+			return 0, errors.Wrap(err, fmt.Sprintf("db.images_announced.insert(%s, %s)", *img.ID, event))
+		}
+	}
+	return len(images), nil
+}
+
+// wait returns how long to sleep before the next tick: until the next
+// boundary, but no longer than the interval.
+func wait(next, interval time.Duration) time.Duration {
+	if next <= 0 || next > interval {
+		return interval
+	}
+	return next
+}
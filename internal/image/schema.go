@@ -0,0 +1,149 @@
+package image
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/jdelobel/go-api/internal/platform/db"
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/pkg/errors"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// Schema is the JSON Schema the metadata of the images of a publisher must
+// match.
+type Schema struct {
+	Publisher string          `json:"publisher"`
+	Schema    json.RawMessage `json:"schema"`
+	CreatedAt *time.Time      `json:"created_at"`
+	UpdatedAt *time.Time      `json:"updated_at"`
+}
+
+// RetrieveSchema gets the metadata schema of the publisher.
+func RetrieveSchema(ctx context.Context, dbConn *db.DB, publisher string) (*Schema, error) {
+	row, err := dbConn.PSQLQueryRawx(ctx, "SELECT publisher, schema, created_at, updated_at FROM publisher_schemas WHERE publisher=$1", publisher)
+	if err != nil {
+		return nil, errors.Wrapf(err, "db.publisher_schemas.find(%s)", db.Query(publisher))
+	}
+
+	var s Schema
+	var doc []byte
+	err = row.Scan(&s.Publisher, &doc, &s.CreatedAt, &s.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, errors.Wrapf(ErrSchemaNotFound, "Publisher: %s", publisher)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "db.publisher_schemas.find(%s)", db.Query(publisher))
+	}
+	s.Schema = doc
+	return &s, nil
+}
+
+// SaveSchema creates or replaces the metadata schema of the publisher.
+func SaveSchema(ctx context.Context, dbConn *db.DB, publisher string, schema json.RawMessage) (*Schema, error) {
+	if _, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(schema)); err != nil {
+		return nil, web.InvalidError{{Fld: "schema", Err: "jsonschema", Msg: err.Error()}}
+	}
+
+	query := `INSERT INTO publisher_schemas(publisher, schema) VALUES($1,$2)
+		ON CONFLICT (publisher) DO UPDATE SET schema=EXCLUDED.schema, updated_at=now()
+		RETURNING publisher, schema, created_at, updated_at`
+	row, err := dbConn.PSQLQueryRawx(ctx, query, publisher, string(schema))
+	if err != nil {
+		return nil, errors.Wrapf(err, "db.publisher_schemas.upsert(%s)", db.Query(publisher))
+	}
+
+	var s Schema
+	var doc []byte
+	if err := row.Scan(&s.Publisher, &doc, &s.CreatedAt, &s.UpdatedAt); err != nil {
+		return nil, errors.Wrapf(err, "db.publisher_schemas.upsert(%s)", db.Query(publisher))
+	}
+	s.Schema = doc
+	return &s, nil
+}
+
+// DeleteSchema removes the metadata schema of the publisher, its images
+// metadata are no longer validated.
+func DeleteSchema(ctx context.Context, dbConn *db.DB, publisher string) error {
+	res, err := dbConn.PSQLExecute(ctx, "DELETE FROM publisher_schemas WHERE publisher=$1", publisher)
+	if err != nil {
+		return errors.Wrapf(err, "db.publisher_schemas.delete(%s)", db.Query(publisher))
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "DeleteSchema")
+	}
+	if n == 0 {
+		return errors.Wrapf(ErrSchemaNotFound, "Publisher: %s", publisher)
+	}
+	return nil
+}
+
+// validateMetadata checks the metadata against the schema of the publisher,
+// if it has one.
+func validateMetadata(ctx context.Context, dbConn *db.DB, publisher string, md Metadata) error {
+	schema, err := loadSchema(ctx, dbConn, publisher)
+	if err != nil {
+		return err
+	}
+	return checkMetadata(schema, md)
+}
+
+// loadSchema compiles the metadata schema of the publisher, nil when it has
+// none.
+func loadSchema(ctx context.Context, dbConn *db.DB, publisher string) (*gojsonschema.Schema, error) {
+	s, err := RetrieveSchema(ctx, dbConn, publisher)
+	if errors.Cause(err) == ErrSchemaNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(s.Schema))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Publisher %s schema", publisher)
+	}
+	return schema, nil
+}
+
+// checkMetadata validates the metadata against a compiled schema. Any
+// metadata is valid without a schema.
+func checkMetadata(schema *gojsonschema.Schema, md Metadata) error {
+	if schema == nil {
+		return nil
+	}
+	res, err := schema.Validate(gojsonschema.NewGoLoader(md))
+	if err != nil {
+		return errors.Wrap(err, "checkMetadata")
+	}
+	if res.Valid() {
+		return nil
+	}
+
+	inv := make(web.InvalidError, 0, len(res.Errors()))
+	for _, re := range res.Errors() {
+		inv = append(inv, web.Invalid{
+			Fld: metadataField(re),
+			Err: re.Type(),
+			Msg: re.Description(),
+		})
+	}
+	return inv
+}
+
+// metadataField returns the path of the metadata field a schema error is
+// about, like metadata.credits.0.
+func metadataField(re gojsonschema.ResultError) string {
+	fld := "metadata"
+	if f := re.Field(); f != gojsonschema.STRING_CONTEXT_ROOT {
+		fld += "." + strings.TrimPrefix(f, gojsonschema.STRING_CONTEXT_ROOT+".")
+	}
+	if p, ok := re.Details()["property"].(string); ok && re.Type() == "required" {
+		fld += "." + p
+	}
+	return fld
+}
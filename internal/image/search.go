@@ -0,0 +1,156 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"github.com/jdelobel/go-api/internal/platform/db"
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+// Pagination of the search results.
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+// maxSearchTerms limits the number of words of a search.
+const maxSearchTerms = 16
+
+// Search describes a full-text search over the images.
+type Search struct {
+	Query string
+
+	// Language is the text search configuration the query is parsed with,
+	// like english or french. Unknown configurations fall back to english.
+	Language string
+
+	// IncludeExpired returns the expired images too.
+	IncludeExpired bool
+
+	// Scope restricts the public searches to the published images. The
+	// expired ones are only included for editorial searches.
+	Scope Scope
+
+	Limit  int
+	Offset int
+}
+
+// SearchResult is an image matching a search, along with its rank and the
+// matching terms highlighted in its title and caption.
+type SearchResult struct {
+	Image
+	Rank           float64 `db:"rank" json:"rank"`
+	TitleHighlight string  `db:"title_highlight" json:"title_highlight"`
+	Snippet        string  `db:"snippet" json:"snippet"`
+	Total          int     `db:"total" json:"-"`
+}
+
+// ParseSearch reads the search from the q, lang, include_expired, limit and
+// offset query parameters.
+func ParseSearch(qp url.Values, defaultLanguage string) (Search, error) {
+	s := Search{
+		Query:          qp.Get("q"),
+		Language:       qp.Get("lang"),
+		IncludeExpired: qp.Get("include_expired") == "true",
+	}
+	if s.Language == "" {
+		s.Language = defaultLanguage
+	}
+
+	limit, offset, err := web.ParsePage(qp, DefaultSearchLimit, MaxSearchLimit)
+	inv, _ := err.(web.InvalidError)
+	s.Limit, s.Offset = limit, offset
+
+	if tsQuery(s.Query) == "" {
+		inv = append(inv, web.Invalid{Fld: "q", Err: "required", Msg: "is required"})
+	}
+
+	if len(inv) > 0 {
+		return s, inv
+	}
+	return s, nil
+}
+
+// SearchImages returns the page of images matching the search, best ranked
+// first. The title, slug, publisher and a few metadata fields like the
+// caption are searched. The words match as prefixes of the indexed words
+// when they end with a star, and the last word always does so that the
+// search can be used while typing. Soft deleted images are excluded, and so
+// are the images outside their visibility window for public searches.
+func SearchImages(ctx context.Context, dbConn *db.DB, s Search) (*web.Page, error) {
+	includeExpired := s.IncludeExpired && s.Scope == Editorial
+	published := `TRUE`
+	if s.Scope != Editorial {
+		published = `(published_at IS NULL OR published_at <= now())`
+	}
+	query := `WITH c AS (SELECT images_search_config($1) AS cfg),
+		q AS (SELECT c.cfg, to_tsquery(c.cfg, $2) || to_tsquery('simple', $2) AS query FROM c)
+		SELECT ` + columns + `,
+			ts_rank_cd(search, q.query) AS rank,
+			ts_headline(q.cfg, title, q.query, 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true') AS title_highlight,
+			ts_headline(q.cfg, concat_ws(' ', metadata->>'caption', metadata->>'description'), q.query,
+				'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=20, MinWords=5') AS snippet,
+			count(*) OVER () AS total
+		FROM images, q
+		WHERE search @@ q.query AND deleted_at IS NULL AND ` + published + `
+			AND ($3 OR expired_at IS NULL OR expired_at > now())
+		ORDER BY rank DESC, created_at DESC
+		LIMIT $4 OFFSET $5`
+	rows, err := dbConn.PSQLQuerier(ctx, query, s.Language, tsQuery(s.Query), includeExpired, s.Limit, s.Offset)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("db.images.search(%s)", db.Query(s)))
+	}
+	defer rows.Close()
+
+	results := make([]SearchResult, 0)
+	for rows.Next() {
+		var r SearchResult
+		if err := rows.StructScan(&r); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("db.images.search(%s)StructScan", db.Query(s)))
+		}
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "SearchImages")
+	}
+
+	// The total is only known from the rows, an offset past the end
+	// reports none.
+	page := web.Page{Data: results, Limit: s.Limit, Offset: s.Offset}
+	if len(results) > 0 {
+		page.Total = results[0].Total
+	}
+	return &page, nil
+}
+
+// tsQuery turns the words of a search into a to_tsquery expression matching
+// all of them, like 'elijah' & 'bal':*. Everything but letters and digits is
+// dropped so that the input can't break the query syntax.
+func tsQuery(q string) string {
+	words := strings.FieldsFunc(q, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '*'
+	})
+
+	terms := make([]string, 0, len(words))
+	for i, w := range words {
+		prefix := strings.HasSuffix(w, "*") || i == len(words)-1
+		w = strings.Replace(w, "*", "", -1)
+		if w == "" {
+			continue
+		}
+		term := "'" + strings.ToLower(w) + "'"
+		if prefix {
+			term += ":*"
+		}
+		terms = append(terms, term)
+		if len(terms) == maxSearchTerms {
+			break
+		}
+	}
+	return strings.Join(terms, " & ")
+}
@@ -0,0 +1,68 @@
+package image
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/jdelobel/go-api/internal/platform/web"
+)
+
+func TestTSQuery(t *testing.T) {
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{q: "elijah baley", want: "'elijah' & 'baley':*"},
+		{q: "Elijah  BALEY", want: "'elijah' & 'baley':*"},
+		{q: "eli* baley", want: "'eli':* & 'baley':*"},
+		{q: "robots' & (dawn | !steel)", want: "'robots' & 'dawn' & 'steel':*"},
+		{q: "l'aube des robots", want: "'l' & 'aube' & 'des' & 'robots':*"},
+		{q: "éléphant", want: "'éléphant':*"},
+		{q: " * ' ", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			if got := tsQuery(tt.q); got != tt.want {
+				t.Errorf("tsQuery(%q) = %q, want %q", tt.q, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSearch(t *testing.T) {
+	tests := []struct {
+		query string
+		want  Search
+		field string
+	}{
+		{query: "q=baley", want: Search{Query: "baley", Language: "english", Limit: DefaultSearchLimit}},
+		{
+			query: "q=baley&lang=french&include_expired=true&limit=5&offset=10",
+			want:  Search{Query: "baley", Language: "french", IncludeExpired: true, Limit: 5, Offset: 10},
+		},
+		{query: "", field: "q"},
+		{query: "q=baley&limit=1000", field: "limit"},
+		{query: "q=baley&offset=-1", field: "offset"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			qp, _ := url.ParseQuery(tt.query)
+			got, err := ParseSearch(qp, "english")
+			if tt.field != "" {
+				inv, ok := err.(web.InvalidError)
+				if !ok || len(inv) != 1 || inv[0].Fld != tt.field {
+					t.Errorf("ParseSearch(%q) = %v, want an error on %s", tt.query, err, tt.field)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSearch(%q) = %v", tt.query, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSearch(%q) = %+v, want %+v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
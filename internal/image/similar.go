@@ -0,0 +1,114 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jdelobel/go-api/internal/platform/db"
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+// Duplicate check modes, applied when the content of an image is stored.
+const (
+	DuplicatesOff    = "off"
+	DuplicatesWarn   = "warn"
+	DuplicatesReject = "reject"
+)
+
+// DefaultThreshold is the Hamming distance under which two perceptual
+// hashes are considered near-duplicates.
+const DefaultThreshold = 5
+
+// maxSimilar limits the number of near-duplicates returned.
+const maxSimilar = 50
+
+// distanceExpr counts the bits which differ between the hash of an image
+// and the $2 parameter.
+const distanceExpr = `length(replace(((phash # $2)::bit(64))::text, '0', ''))`
+
+// DuplicateCheck configures the search for near-duplicates of the same
+// publisher when the content of an image is stored.
+type DuplicateCheck struct {
+
+	// Mode is either off, warn or reject.
+	Mode string
+
+	// Threshold is the maximum Hamming distance of near-duplicates.
+	Threshold int
+}
+
+// Similar is an image along with the Hamming distance of its perceptual
+// hash to the hash of another image.
+type Similar struct {
+	Image
+	Distance int `db:"distance" json:"distance"`
+}
+
+// NearDuplicateError occurs when storing the content of an image for which
+// near-duplicates exist and the duplicate check rejects them.
+type NearDuplicateError []Similar
+
+// Error implements the error interface.
+func (nde NearDuplicateError) Error() string {
+	ids := make([]string, len(nde))
+	for i, s := range nde {
+		ids[i] = *s.ID
+	}
+	return "Image is a near-duplicate of " + strings.Join(ids, ", ")
+}
+
+// ListSimilar returns the images whose perceptual hash is within the
+// threshold of the hash of the specified image, closest first. Public
+// callers only get the images inside their visibility window.
+func ListSimilar(ctx context.Context, dbConn *db.DB, imageID string, threshold int, scope Scope) ([]Similar, error) {
+	img, err := Retrieve(ctx, dbConn, imageID, scope)
+	if err != nil {
+		return nil, err
+	}
+	if img.PHash == nil {
+		return nil, errors.Wrapf(ErrNoContent, "Id: %s", imageID)
+	}
+
+	return findSimilar(ctx, dbConn, imageID, *img.PHash, threshold, "", scope)
+}
+
+// ParseThreshold reads the threshold query parameter.
+func ParseThreshold(qp url.Values) (int, error) {
+	s := qp.Get("threshold")
+	if s == "" {
+		return DefaultThreshold, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > 64 {
+		return 0, web.InvalidError{{Fld: "threshold", Err: "max", Param: "64", Msg: "must be an integer between 0 and 64"}}
+	}
+	return n, nil
+}
+
+// findSimilar searches the images near the hash, other than the specified
+// one and optionally limited to a publisher.
+func findSimilar(ctx context.Context, dbConn *db.DB, imageID string, hash int64, threshold int, publisher string, scope Scope) ([]Similar, error) {
+	query := `SELECT ` + columns + `, ` + distanceExpr + ` AS distance FROM images
+		WHERE id <> $1 AND deleted_at IS NULL AND phash IS NOT NULL AND ` + distanceExpr + ` <= $3
+		AND ($4 = '' OR publisher = $4)` + scopeCond(scope) + `
+		ORDER BY distance, created_at LIMIT ` + strconv.Itoa(maxSimilar)
+	rows, err := dbConn.PSQLQuerier(ctx, query, imageID, hash, threshold, publisher)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("db.images.similar(%s)", db.Query(imageID)))
+	}
+	defer rows.Close()
+
+	similar := make([]Similar, 0)
+	for rows.Next() {
+		var s Similar
+		if err := rows.StructScan(&s); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("db.images.similar(%s)StructScan", db.Query(imageID)))
+		}
+		similar = append(similar, s)
+	}
+	return similar, rows.Err()
+}
@@ -0,0 +1,44 @@
+package image
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseThreshold(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    int
+		invalid bool
+	}{
+		{query: "", want: DefaultThreshold},
+		{query: "threshold=0", want: 0},
+		{query: "threshold=12", want: 12},
+		{query: "threshold=65", invalid: true},
+		{query: "threshold=-1", invalid: true},
+		{query: "threshold=near", invalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			qp, _ := url.ParseQuery(tt.query)
+			got, err := ParseThreshold(qp)
+			if (err != nil) != tt.invalid {
+				t.Fatalf("ParseThreshold(%q) error = %v, want invalid %v", tt.query, err, tt.invalid)
+			}
+			if !tt.invalid && got != tt.want {
+				t.Errorf("ParseThreshold(%q) = %d, want %d", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNearDuplicateError(t *testing.T) {
+	id1, id2 := "47c658e0-68d7-4d79-9f9f-25ece8a1fb03", "5d0e1a8e-3b1f-4c5e-9d8b-0c6f2e7a9b11"
+	err := NearDuplicateError{{Image: Image{ID: &id1}}, {Image: Image{ID: &id2}}}
+
+	want := "Image is a near-duplicate of " + id1 + ", " + id2
+	if got := err.Error(); got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,62 @@
+package image
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// spool holds a content spooled to a temporary file, mapped in memory so
+// that its metadata can be parsed and edited in place without holding it in
+// the heap.
+type spool struct {
+	f     *os.File
+	b     []byte
+	unmap func() error
+}
+
+// newSpool copies the content to a temporary file, failing with
+// ErrContentTooLarge past the maximum size.
+func newSpool(r io.Reader, maxSize int64) (*spool, error) {
+	f, err := ioutil.TempFile("", "image-content-")
+	if err != nil {
+		return nil, errors.Wrap(err, "spool")
+	}
+	s := spool{f: f}
+
+	size, err := io.Copy(f, io.LimitReader(r, maxSize+1))
+	if err != nil {
+		s.Close()
+		return nil, errors.Wrap(err, "spool")
+	}
+	if size > maxSize {
+		s.Close()
+		return nil, errors.Wrapf(ErrContentTooLarge, "Max: %d bytes", maxSize)
+	}
+	if size == 0 {
+		return &s, nil
+	}
+
+	s.b, s.unmap, err = mapFile(f, size)
+	if err != nil {
+		s.Close()
+		return nil, errors.Wrap(err, "spool")
+	}
+	return &s, nil
+}
+
+// Bytes returns the content. Its edits are written to the file.
+func (s *spool) Bytes() []byte {
+	return s.b
+}
+
+// Close unmaps and removes the file.
+func (s *spool) Close() error {
+	if s.unmap != nil {
+		s.unmap()
+	}
+	s.f.Close()
+	return os.Remove(s.f.Name())
+}
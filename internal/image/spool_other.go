@@ -0,0 +1,19 @@
+//go:build !unix
+
+package image
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// mapFile reads the file in memory where it can't be mapped. The edits
+// aren't written back, the content is stored from the bytes.
+func mapFile(f *os.File, size int64) ([]byte, func() error, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	b, err := ioutil.ReadAll(f)
+	return b, nil, err
+}
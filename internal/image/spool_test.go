@@ -0,0 +1,52 @@
+package image
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestSpool(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		max     int64
+		err     error
+	}{
+		{name: "content", content: "abcdef", max: 6},
+		{name: "empty", max: 6},
+		{name: "too large", content: "abcdefg", max: 6, err: ErrContentTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sp, err := newSpool(strings.NewReader(tt.content), tt.max)
+			if errors.Cause(err) != tt.err {
+				t.Fatalf("newSpool() = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if string(sp.Bytes()) != tt.content {
+				t.Errorf("Bytes() = %q, want %q", sp.Bytes(), tt.content)
+			}
+
+			name := sp.f.Name()
+			if sp.unmap != nil {
+				sp.Bytes()[0] = 'z'
+				if b, _ := ioutil.ReadFile(name); b[0] != 'z' {
+					t.Errorf("file = %q, want the edit", b)
+				}
+			}
+			if err := sp.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(name); !os.IsNotExist(err) {
+				t.Errorf("file %s not removed: %v", name, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package image
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps the file in memory, shared so that the edits go to the file.
+func mapFile(f *os.File, size int64) ([]byte, func() error, error) {
+	b, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, func() error { return syscall.Munmap(b) }, nil
+}
@@ -0,0 +1,47 @@
+package image
+
+import (
+	"net/url"
+	"regexp"
+
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// slugRegex matches the characters which can be used in a URL path without
+// being escaped.
+var slugRegex = regexp.MustCompile(`^[A-Za-z0-9\-._~/@]+$`)
+
+func init() {
+	web.RegisterValidation("imageurl", isImageURL, "must be an absolute URL or a path starting with /")
+	web.RegisterValidation("urlslug", isURLSlug, "must only contain URL-safe characters")
+	web.RegisterMessage("after", "must be after %s")
+	web.RegisterStructValidation(createImageStructLevel, CreateImage{})
+}
+
+// isImageURL checks the field is an absolute http(s) URL or a rooted path.
+func isImageURL(fl validator.FieldLevel) bool {
+	u, err := url.Parse(fl.Field().String())
+	if err != nil {
+		return false
+	}
+	if u.IsAbs() {
+		return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+	}
+	return u.Host == "" && len(u.Path) > 0 && u.Path[0] == '/'
+}
+
+// isURLSlug checks the field can be used in a URL as is.
+func isURLSlug(fl validator.FieldLevel) bool {
+	return slugRegex.MatchString(fl.Field().String())
+}
+
+// createImageStructLevel checks the rules involving several fields of a
+// CreateImage.
+func createImageStructLevel(sl validator.StructLevel) {
+	ci := sl.Current().Interface().(CreateImage)
+
+	if !ci.PublishedAt.IsZero() && !ci.ExpiredAt.IsZero() && !ci.ExpiredAt.After(ci.PublishedAt) {
+		sl.ReportError(ci.ExpiredAt, "expired_at", "ExpiredAt", "after", "published_at")
+	}
+}
@@ -0,0 +1,59 @@
+package image
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jdelobel/go-api/internal/platform/web"
+)
+
+func TestCreateImageValidation(t *testing.T) {
+	now := time.Now()
+	valid := CreateImage{
+		ID:        "47c658e0-68d7-4d79-9f9f-25ece8a1fb03",
+		Title:     "Image Elijah Baley",
+		URL:       "/images/1280/720/test-2260-b1396d-1@1x.jpeg",
+		Slug:      "/images/1280/720/test-2260-b1396d-1@1x",
+		Publisher: "etf1",
+	}
+
+	tests := []struct {
+		name   string
+		modify func(ci *CreateImage)
+		field  string
+	}{
+		{name: "valid image", modify: func(ci *CreateImage) {}},
+		{name: "absolute url", modify: func(ci *CreateImage) { ci.URL = "https://cdn.example.com/a.jpeg" }},
+		{name: "relative url", modify: func(ci *CreateImage) { ci.URL = "images/a.jpeg" }, field: "url"},
+		{name: "ftp url", modify: func(ci *CreateImage) { ci.URL = "ftp://cdn.example.com/a.jpeg" }, field: "url"},
+		{name: "unsafe slug", modify: func(ci *CreateImage) { ci.Slug = "my image?" }, field: "slug"},
+		{name: "invalid image_id", modify: func(ci *CreateImage) { ci.ID = "12345" }, field: "image_id"},
+		{name: "no image_id", modify: func(ci *CreateImage) { ci.ID = "" }},
+		{
+			name:   "expired before published",
+			modify: func(ci *CreateImage) { ci.PublishedAt, ci.ExpiredAt = now, now.Add(-time.Hour) },
+			field:  "expired_at",
+		},
+		{name: "expired after published", modify: func(ci *CreateImage) { ci.PublishedAt, ci.ExpiredAt = now, now.Add(time.Hour) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ci := valid
+			tt.modify(&ci)
+
+			err := web.Validate(&ci)
+			if tt.field == "" {
+				if err != nil {
+					t.Fatalf("got error %v, want none", err)
+				}
+				return
+			}
+
+			inv, ok := err.(web.InvalidError)
+			if !ok || len(inv) != 1 || inv[0].Fld != tt.field {
+				t.Fatalf("got error %v, want an invalid %s", err, tt.field)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package image
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Scope selects the images a caller can see.
+type Scope int
+
+const (
+	// Public callers only see the images inside their visibility window:
+	// published, not yet expired and not deleted.
+	Public Scope = iota
+
+	// Editorial callers see the images before they are published, after
+	// they expired and after they were deleted.
+	Editorial
+)
+
+// visibleCond restricts a query to the images inside their visibility
+// window which aren't deleted.
+const visibleCond = `deleted_at IS NULL AND (published_at IS NULL OR published_at <= now()) AND (expired_at IS NULL OR expired_at > now())`
+
+// scopeCond returns the condition restricting a query to the images the
+// scope sees, to be appended to a WHERE clause.
+func scopeCond(scope Scope) string {
+	if scope == Editorial {
+		return ""
+	}
+	return " AND " + visibleCond
+}
+
+// checkVisible tells whether the image is visible in the scope at the
+// specified time. Images which are deleted or aren't published yet aren't
+// found, expired ones are gone.
+func checkVisible(img *Image, scope Scope, now time.Time) error {
+	if scope == Editorial {
+		return nil
+	}
+	if img.DeletedAt != nil {
+		return errors.Wrapf(ErrNotFound, "Id: %s is deleted", *img.ID)
+	}
+	if img.PublishedAt != nil && img.PublishedAt.After(now) {
+		return errors.Wrapf(ErrNotFound, "Id: %s is published at %s", *img.ID, img.PublishedAt.Format(time.RFC3339))
+	}
+	if img.ExpiredAt != nil && !img.ExpiredAt.After(now) {
+		return errors.Wrapf(ErrExpired, "Id: %s expired at %s", *img.ID, img.ExpiredAt.Format(time.RFC3339))
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package image
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestCheckVisible(t *testing.T) {
+	now := time.Date(2019, 7, 14, 10, 30, 0, 0, time.UTC)
+	id := "47c658e0-68d7-4d79-9f9f-25ece8a1fb03"
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+
+	tests := []struct {
+		name  string
+		img   Image
+		scope Scope
+		want  error
+	}{
+		{name: "published", img: Image{ID: &id, PublishedAt: at(-time.Hour)}, scope: Public},
+		{name: "published now", img: Image{ID: &id, PublishedAt: at(0)}, scope: Public},
+		{name: "scheduled", img: Image{ID: &id, PublishedAt: at(time.Hour)}, scope: Public, want: ErrNotFound},
+		{name: "expiring", img: Image{ID: &id, PublishedAt: at(-time.Hour), ExpiredAt: at(time.Minute)}, scope: Public},
+		{name: "expired now", img: Image{ID: &id, PublishedAt: at(-time.Hour), ExpiredAt: at(0)}, scope: Public, want: ErrExpired},
+		{name: "expired", img: Image{ID: &id, ExpiredAt: at(-time.Minute)}, scope: Public, want: ErrExpired},
+		{name: "editorial scheduled", img: Image{ID: &id, PublishedAt: at(time.Hour)}, scope: Editorial},
+		{name: "editorial expired", img: Image{ID: &id, ExpiredAt: at(-time.Minute)}, scope: Editorial},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkVisible(&tt.img, tt.scope, now); errors.Cause(err) != tt.want {
+				t.Errorf("checkVisible() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWait(t *testing.T) {
+	tests := []struct {
+		name string
+		next time.Duration
+		want time.Duration
+	}{
+		{name: "unknown", next: 0, want: time.Minute},
+		{name: "soon", next: 3 * time.Second, want: 3 * time.Second},
+		{name: "later", next: time.Hour, want: time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := wait(tt.next, time.Minute); got != tt.want {
+				t.Errorf("wait(%v) = %v, want %v", tt.next, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventID(t *testing.T) {
+	id := "47c658e0-68d7-4d79-9f9f-25ece8a1fb03"
+	at := time.Date(2019, 7, 14, 10, 30, 0, 0, time.UTC)
+	paris := time.FixedZone("CEST", 2*60*60)
+
+	tests := []struct {
+		name  string
+		event string
+		at    time.Time
+		want  string
+	}{
+		{name: "published", event: EventPublished, at: at, want: id + "/image.published/2019-07-14T10:30:00Z"},
+		{name: "same instant", event: EventPublished, at: at.In(paris), want: id + "/image.published/2019-07-14T10:30:00Z"},
+		{name: "expired", event: EventExpired, at: at.Add(time.Millisecond), want: id + "/image.expired/2019-07-14T10:30:00.001Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := eventID(id, tt.event, tt.at); got != tt.want {
+				t.Errorf("eventID() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
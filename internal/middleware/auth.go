@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+// Authenticate identifies the caller from the bearer token of the
+// Authorization header. The tokens map each accepted token to the name of
+// its actor. Requests without the header stay anonymous, the ones with an
+// unknown token are rejected. Browsers can't set the header of WebSocket
+// handshakes, which may send the token in the access_token query
+// parameter instead.
+func Authenticate(tokens map[string]string) web.Middleware {
+
+	// This is the actual middleware function to be executed.
+	return func(next web.Handler) web.Handler {
+
+		// Create the handler that will be attached in the middleware chain.
+		return func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+			auth := r.Header.Get("Authorization")
+			if token := r.URL.Query().Get("access_token"); auth == "" && token != "" && strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+				auth = "Bearer " + token
+			}
+			if err := authenticate(ctx, tokens, auth); err != nil {
+				return err
+			}
+			return next(ctx, w, r, params)
+		}
+	}
+}
+
+// RequireActor rejects the anonymous requests of the routes it wraps, which
+// must come after Authenticate. The OPTIONS requests, which browsers send
+// without credentials, are let through.
+func RequireActor(next web.Handler) web.Handler {
+
+	// Wrap this handler around the next one provided.
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+		if r.Method != http.MethodOptions && ctx.Value(web.KeyValues).(*web.Values).Actor == "" {
+			return errors.Wrap(web.ErrNotAuthorized, "anonymous request")
+		}
+		return next(ctx, w, r, params)
+	}
+}
+
+// authenticate sets the actor of the bearer token of the Authorization
+// header value on the request values. An empty value leaves the request
+// anonymous.
+func authenticate(ctx context.Context, tokens map[string]string, auth string) error {
+	if auth == "" {
+		return nil
+	}
+
+	const prefix = "Bearer "
+	if !strings.HasPrefix(auth, prefix) {
+		return errors.Wrap(web.ErrNotAuthorized, "Authorization is not a bearer token")
+	}
+	actor, ok := lookupToken(tokens, strings.TrimSpace(auth[len(prefix):]))
+	if !ok {
+		return errors.Wrap(web.ErrNotAuthorized, "unknown bearer token")
+	}
+
+	ctx.Value(web.KeyValues).(*web.Values).Actor = actor
+	return nil
+}
+
+// lookupToken returns the actor of the token. All the tokens are compared
+// in constant time so that the response time doesn't leak them.
+func lookupToken(tokens map[string]string, token string) (string, bool) {
+	var actor string
+	var found bool
+	for t, a := range tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			actor, found = a, true
+		}
+	}
+	return actor, found && token != ""
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/jdelobel/go-api/internal/platform/web"
+)
+
+func TestAuthenticate(t *testing.T) {
+	tokens := map[string]string{"s3cr3t": "alice"}
+	tests := []struct {
+		name    string
+		headers map[string]string
+		query   string
+		status  int
+		actor   string
+	}{
+		{name: "anonymous", status: http.StatusOK},
+		{name: "bearer", headers: map[string]string{"Authorization": "Bearer s3cr3t"}, status: http.StatusOK, actor: "alice"},
+		{name: "unknown token", headers: map[string]string{"Authorization": "Bearer nope"}, status: http.StatusUnauthorized},
+		{name: "basic", headers: map[string]string{"Authorization": "Basic s3cr3t"}, status: http.StatusUnauthorized},
+		{name: "websocket query", headers: map[string]string{"Upgrade": "websocket"}, query: "?access_token=s3cr3t", status: http.StatusOK, actor: "alice"},
+		{name: "websocket unknown query", headers: map[string]string{"Upgrade": "websocket"}, query: "?access_token=nope", status: http.StatusUnauthorized},
+		{name: "query without upgrade", query: "?access_token=s3cr3t", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var actor string
+			app := web.New(log.WithField("test", t.Name()), ErrorHandler, Authenticate(tokens))
+			app.Handle("GET", "/v1/ws", func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+				actor = ctx.Value(web.KeyValues).(*web.Values).Actor
+				w.WriteHeader(http.StatusOK)
+				return nil
+			})
+
+			r := httptest.NewRequest("GET", "/v1/ws"+tt.query, nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if actor != tt.actor {
+				t.Errorf("actor = %q, want %q", actor, tt.actor)
+			}
+		})
+	}
+}
+
+func TestRequireActor(t *testing.T) {
+	tokens := map[string]string{"s3cr3t": "alice"}
+	tests := []struct {
+		name   string
+		method string
+		auth   string
+		status int
+	}{
+		{name: "anonymous", method: "GET", status: http.StatusUnauthorized},
+		{name: "editor", method: "GET", auth: "Bearer s3cr3t", status: http.StatusOK},
+		{name: "anonymous options", method: "OPTIONS", status: http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := web.New(log.WithField("test", t.Name()), ErrorHandler, Authenticate(tokens), RequireActor)
+			app.Handle("GET", "/v1/webhooks", func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+				w.WriteHeader(http.StatusOK)
+				return nil
+			})
+
+			r := httptest.NewRequest(tt.method, "/v1/webhooks", nil)
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+// Modes of the response validation.
+const (
+	ResponsesOff  = "off"
+	ResponsesLog  = "log"
+	ResponsesFail = "fail"
+)
+
+// ValidateOptions configures the checks of the requests and the responses
+// against the OpenAPI document.
+type ValidateOptions struct {
+
+	// Requests rejects the requests whose parameters or body don't match
+	// their operation, with the same problems as the handlers.
+	Requests bool
+
+	// Responses checks the responses, either logging the violations or
+	// replacing the responses with a 500 problem. It buffers the JSON
+	// responses, and is meant for development and tests.
+	Responses string
+}
+
+// Validate checks the requests and the responses of the routes it wraps
+// against the operations of the contract, loaded once the routes are
+// registered. The routes the document doesn't describe aren't checked.
+// The errors returned by the handlers are written by the error handler
+// around it, so the problems aren't checked. The streamed responses, which
+// aren't JSON, are only checked for their status code and media type, and
+// the violations are logged since they were sent already.
+func Validate(c *web.Contract, opts ValidateOptions) web.Middleware {
+	if !opts.Requests && (opts.Responses == "" || opts.Responses == ResponsesOff) {
+		return nil
+	}
+
+	// This is the actual middleware function to be executed.
+	return func(next web.Handler) web.Handler {
+
+		// Create the handler that will be attached in the middleware chain.
+		return func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+			op := c.Operation(r.Method, r.URL.Path)
+			if op == nil {
+				return next(ctx, w, r, params)
+			}
+			if opts.Requests {
+				if err := c.CheckRequest(op, r, params); err != nil {
+					return err
+				}
+			}
+			if opts.Responses != ResponsesLog && opts.Responses != ResponsesFail {
+				return next(ctx, w, r, params)
+			}
+
+			v := ctx.Value(web.KeyValues).(*web.Values)
+			header := w.Header().Clone()
+			cw := &contractWriter{ResponseWriter: w}
+			if err := next(ctx, cw, r, params); err != nil {
+				return err
+			}
+			if cw.code == 0 {
+				return nil
+			}
+
+			err := c.CheckResponse(op, cw.code, w.Header(), cw.buf.Bytes())
+			if err != nil && (!cw.buffered || opts.Responses == ResponsesLog) {
+				v.Log.Errorf("%s : Validate: %s %s -> %d: %v", v.TraceID, r.Method, r.URL.Path, cw.code, err)
+				err = nil
+			}
+			if err != nil {
+
+				// Send the problem in place of the response, without the
+				// headers the handler set.
+				for k := range w.Header() {
+					delete(w.Header(), k)
+				}
+				for k, vv := range header {
+					w.Header()[k] = vv
+				}
+				return errors.Wrapf(web.ErrContractViolation, "%s %s -> %d: %v", r.Method, r.URL.Path, cw.code, err)
+			}
+			if cw.buffered {
+				cw.ResponseWriter.WriteHeader(cw.code)
+				_, err := cw.ResponseWriter.Write(cw.buf.Bytes())
+				return err
+			}
+			return nil
+		}
+	}
+}
+
+// contractWriter keeps the status code of a response, and buffers it to be
+// checked when it is JSON. The other responses are written as is, so that
+// the event streams and the WebSockets work.
+type contractWriter struct {
+	http.ResponseWriter
+	code     int
+	buffered bool
+	buf      bytes.Buffer
+}
+
+// WriteHeader records the status code, and sends it unless the response is
+// buffered.
+func (cw *contractWriter) WriteHeader(code int) {
+	if cw.code != 0 {
+		return
+	}
+	cw.code = code
+	cw.buffered = isJSON(cw.Header().Get("Content-Type"))
+	if !cw.buffered {
+		cw.ResponseWriter.WriteHeader(code)
+	}
+}
+
+// Write buffers the JSON responses, and writes the others.
+func (cw *contractWriter) Write(b []byte) (int, error) {
+	if cw.code == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.buffered {
+		return cw.buf.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush sends the written data of the responses which aren't buffered.
+func (cw *contractWriter) Flush() {
+	if cw.buffered {
+		return
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hands the connection over, for the WebSockets.
+func (cw *contractWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	return h.Hijack()
+}
+
+// isJSON tells whether a Content-Type header value is JSON, like
+// application/json or application/problem+json.
+func isJSON(contentType string) bool {
+	t, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (t == web.JSONContentType || strings.HasSuffix(t, "+json"))
+}
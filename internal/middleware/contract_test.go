@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/jdelobel/go-api/internal/platform/web"
+)
+
+type testImage struct {
+	Title string `json:"title" validate:"required"`
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      ValidateOptions
+		path      string
+		body      string
+		response  string
+		code      int
+		wantCode  int
+		wantBody  string
+		wantETag  bool
+		wantCalls int
+	}{
+		{name: "valid", opts: ValidateOptions{Requests: true, Responses: ResponsesFail}, path: "/v1/images", body: `{"title":"a"}`, response: `{"title":"a"}`, code: http.StatusCreated, wantCode: http.StatusCreated, wantBody: `{"title":"a"}`, wantETag: true, wantCalls: 1},
+		{name: "invalid request", opts: ValidateOptions{Requests: true}, path: "/v1/images", body: `{}`, wantCode: http.StatusBadRequest},
+		{name: "requests off", opts: ValidateOptions{Responses: ResponsesLog}, path: "/v1/images", body: `{}`, response: `{"title":"a"}`, code: http.StatusCreated, wantCode: http.StatusCreated, wantETag: true, wantCalls: 1},
+		{name: "invalid response", opts: ValidateOptions{Responses: ResponsesFail}, path: "/v1/images", body: `{"title":"a"}`, response: `{"title":1}`, code: http.StatusCreated, wantCode: http.StatusInternalServerError, wantCalls: 1},
+		{name: "undocumented status", opts: ValidateOptions{Responses: ResponsesFail}, path: "/v1/images", body: `{"title":"a"}`, response: `{"title":"a"}`, code: http.StatusOK, wantCode: http.StatusInternalServerError, wantCalls: 1},
+		{name: "logged response", opts: ValidateOptions{Responses: ResponsesLog}, path: "/v1/images", body: `{"title":"a"}`, response: `{"title":1}`, code: http.StatusCreated, wantCode: http.StatusCreated, wantBody: `{"title":1}`, wantETag: true, wantCalls: 1},
+		{name: "undescribed route", opts: ValidateOptions{Requests: true, Responses: ResponsesFail}, path: "/v1/other", body: `{}`, response: `{"title":1}`, code: http.StatusOK, wantCode: http.StatusOK, wantETag: true, wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+				calls++
+				w.Header().Set("Content-Type", web.JSONContentType)
+				w.Header().Set("ETag", `"1"`)
+				w.WriteHeader(tt.code)
+				_, err := w.Write([]byte(tt.response))
+				return err
+			}
+
+			contract := &web.Contract{}
+			app := web.New(log.WithField("test", t.Name()), ErrorHandler)
+			g := app.Group(Validate(contract, tt.opts))
+			g.Handle("POST", "/v1/images", handler).Describe(web.Doc{
+				Body:      testImage{},
+				Responses: map[int]interface{}{http.StatusCreated: testImage{}},
+			})
+			g.Handle("POST", "/v1/other", handler)
+			doc, err := app.OpenAPI(web.Info{Title: "test", Version: "1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			delete(doc.Paths, "/v1/other")
+			contract.Load(doc)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", web.JSONContentType)
+			app.ServeHTTP(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("body = %s, want %s", w.Body, tt.wantBody)
+			}
+			if got := w.Header().Get("ETag") != ""; got != tt.wantETag {
+				t.Errorf("ETag sent = %v, want %v", got, tt.wantETag)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("handler called %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestValidateOff(t *testing.T) {
+	if Validate(&web.Contract{}, ValidateOptions{Responses: ResponsesOff}) != nil {
+		t.Error("Validate() isn't nil with the checks off")
+	}
+}
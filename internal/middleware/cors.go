@@ -0,0 +1,170 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jdelobel/go-api/internal/platform/web"
+)
+
+// Defaults of the CORS options, matching the methods and headers the API
+// uses.
+var (
+	DefaultCORSMethods        = []string{"GET", "HEAD", "POST", "PUT", "DELETE"}
+	DefaultCORSHeaders        = []string{"Authorization", "Content-Type", "Content-Encoding", "If-Match", "If-None-Match", "If-Modified-Since"}
+	DefaultCORSExposedHeaders = []string{"ETag", "Last-Modified", "Location", "Retry-After", "Warning", web.TraceIDHeader}
+)
+
+// CORSOptions configures the cross-origin requests allowed to the routes.
+type CORSOptions struct {
+
+	// AllowedOrigins lists the origins allowed to call the routes, either
+	// exact like https://editor.example.com or with a wildcard like
+	// https://*.example.com. A single * allows any origin. CORS is disabled
+	// when it is empty.
+	AllowedOrigins []string
+
+	// AllowedMethods and AllowedHeaders are the methods and request headers
+	// allowed by the preflights. A * header allows any.
+	AllowedMethods []string
+	AllowedHeaders []string
+
+	// ExposedHeaders are the response headers readable by the browsers.
+	ExposedHeaders []string
+
+	// AllowCredentials lets the browsers send their cookies.
+	AllowCredentials bool
+
+	// MaxAge is the number of seconds the browsers may cache a preflight.
+	MaxAge int
+}
+
+// CORS answers the preflights of the allowed origins and adds the CORS
+// headers to their requests. The requests of the other origins proceed
+// without them, so the browsers block the responses. The allowed origin is
+// always echoed, never *, so that credentials may be allowed.
+func CORS(opts CORSOptions) web.Middleware {
+	if len(opts.AllowedMethods) == 0 {
+		opts.AllowedMethods = DefaultCORSMethods
+	}
+	if len(opts.AllowedHeaders) == 0 {
+		opts.AllowedHeaders = DefaultCORSHeaders
+	}
+	if len(opts.ExposedHeaders) == 0 {
+		opts.ExposedHeaders = DefaultCORSExposedHeaders
+	}
+	methods := strings.Join(opts.AllowedMethods, ", ")
+	exposed := strings.Join(opts.ExposedHeaders, ", ")
+
+	// This is the actual middleware function to be executed.
+	return func(next web.Handler) web.Handler {
+
+		// Create the handler that will be attached in the middleware chain.
+		return func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+			if len(opts.AllowedOrigins) == 0 {
+				return next(ctx, w, r, params)
+			}
+
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if preflight {
+				h.Add("Vary", "Access-Control-Request-Method")
+				h.Add("Vary", "Access-Control-Request-Headers")
+			}
+			if origin == "" || !MatchOrigin(opts.AllowedOrigins, origin) {
+				return next(ctx, w, r, params)
+			}
+
+			if !preflight {
+				h.Set("Access-Control-Allow-Origin", origin)
+				if opts.AllowCredentials {
+					h.Set("Access-Control-Allow-Credentials", "true")
+				}
+				h.Set("Access-Control-Expose-Headers", exposed)
+				return next(ctx, w, r, params)
+			}
+
+			// A denied preflight is answered like an OPTIONS request, the
+			// browser fails it for the missing headers.
+			requested := r.Header.Get("Access-Control-Request-Headers")
+			if !contains(opts.AllowedMethods, r.Header.Get("Access-Control-Request-Method")) || !allowHeaders(opts.AllowedHeaders, requested) {
+				return next(ctx, w, r, params)
+			}
+
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Set("Access-Control-Allow-Methods", methods)
+			if requested != "" {
+				h.Set("Access-Control-Allow-Headers", requested)
+			}
+			if opts.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if opts.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(opts.MaxAge))
+			}
+			ctx.Value(web.KeyValues).(*web.Values).StatusCode = http.StatusNoContent
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		}
+	}
+}
+
+// MatchOrigin tells whether the origin matches one of the patterns. The
+// wildcard of a pattern stands for one or more characters without slashes,
+// so https://*.example.com matches the subdomains of example.com but not
+// example.com itself.
+func MatchOrigin(patterns []string, origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if p == "*" || p == origin {
+			return true
+		}
+		i := strings.Index(p, "*")
+		if i < 0 {
+			continue
+		}
+		prefix, suffix := p[:i], p[i+1:]
+		if len(origin) > len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) &&
+			!strings.Contains(origin[len(prefix):len(origin)-len(suffix)], "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// allowHeaders tells whether all the headers of the comma separated list
+// are allowed, case insensitively.
+func allowHeaders(allowed []string, requested string) bool {
+	if contains(allowed, "*") {
+		return true
+	}
+	for _, name := range strings.Split(requested, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, a := range allowed {
+			found = found || strings.EqualFold(a, name)
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// contains tells whether the list holds the value.
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
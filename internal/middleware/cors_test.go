@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/jdelobel/go-api/internal/platform/web"
+)
+
+func TestMatchOrigin(t *testing.T) {
+	patterns := []string{"https://editor.example.com", "https://*.preview.example.com"}
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{origin: "https://editor.example.com", want: true},
+		{origin: "https://Editor.Example.com", want: true},
+		{origin: "http://editor.example.com", want: false},
+		{origin: "https://pr-12.preview.example.com", want: true},
+		{origin: "https://a.b.preview.example.com", want: true},
+		{origin: "https://preview.example.com", want: false},
+		{origin: "https://.preview.example.com", want: false},
+		{origin: "https://evil.com/.preview.example.com", want: false},
+		{origin: "https://evilpreview.example.com", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			if got := MatchOrigin(patterns, tt.origin); got != tt.want {
+				t.Errorf("MatchOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCORS(t *testing.T) {
+	opts := CORSOptions{
+		AllowedOrigins:   []string{"https://*.example.com"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		status  int
+		want    map[string]string
+	}{
+		{
+			name:    "preflight",
+			method:  "OPTIONS",
+			headers: map[string]string{"Origin": "https://editor.example.com", "Access-Control-Request-Method": "PUT", "Access-Control-Request-Headers": "authorization, if-match"},
+			status:  http.StatusNoContent,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":      "https://editor.example.com",
+				"Access-Control-Allow-Methods":     "GET, HEAD, POST, PUT, DELETE",
+				"Access-Control-Allow-Headers":     "authorization, if-match",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Max-Age":           "600",
+			},
+		},
+		{
+			name:    "preflight denied method",
+			method:  "OPTIONS",
+			headers: map[string]string{"Origin": "https://editor.example.com", "Access-Control-Request-Method": "PATCH"},
+			status:  http.StatusNoContent,
+			want:    map[string]string{"Access-Control-Allow-Origin": "", "Allow": "PUT, OPTIONS"},
+		},
+		{
+			name:    "preflight denied header",
+			method:  "OPTIONS",
+			headers: map[string]string{"Origin": "https://editor.example.com", "Access-Control-Request-Method": "PUT", "Access-Control-Request-Headers": "X-Debug"},
+			status:  http.StatusNoContent,
+			want:    map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:    "preflight denied origin",
+			method:  "OPTIONS",
+			headers: map[string]string{"Origin": "https://example.org", "Access-Control-Request-Method": "PUT"},
+			status:  http.StatusNoContent,
+			want:    map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:    "request",
+			method:  "PUT",
+			headers: map[string]string{"Origin": "https://editor.example.com"},
+			status:  http.StatusOK,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":      "https://editor.example.com",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Expose-Headers":    "ETag, Last-Modified, Location, Retry-After, Warning, X-Trace-ID",
+				"Vary":                             "Origin",
+			},
+		},
+		{
+			name:    "request denied origin",
+			method:  "PUT",
+			headers: map[string]string{"Origin": "https://example.org"},
+			status:  http.StatusOK,
+			want:    map[string]string{"Access-Control-Allow-Origin": "", "Vary": "Origin"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := web.New(log.WithField("test", t.Name()))
+			app.Group(CORS(opts)).Handle("PUT", "/v1/images/:id", func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+				w.WriteHeader(http.StatusOK)
+				return nil
+			})
+
+			r := httptest.NewRequest(tt.method, "/v1/images/1", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			for k, v := range tt.want {
+				if got := w.Header().Get(k); got != v {
+					t.Errorf("%s = %q, want %q", k, got, v)
+				}
+			}
+		})
+	}
+}
@@ -20,6 +20,12 @@ func ErrorHandler(next web.Handler) web.Handler {
 		defer func() {
 			if r := recover(); r != nil {
 
+				// Let the server abort the connection of a response which
+				// already started.
+				if r == http.ErrAbortHandler {
+					panic(r)
+				}
+
 				// Log the panic.
 				v.Log.Errorf("%s : Panic Caught : %s\n", v.TraceID, r)
 
@@ -33,7 +39,13 @@ func ErrorHandler(next web.Handler) web.Handler {
 
 		if err := next(ctx, w, r, params); err != nil {
 
-			if errors.Cause(err) != web.ErrNotFound {
+			// The internal errors caused by the deadline of the route, like
+			// the canceled queries, are timeouts.
+			if ctx.Err() == context.DeadlineExceeded && web.LookupProblem(errors.Cause(err)).Status == http.StatusInternalServerError {
+				err = errors.Wrap(web.ErrTimeout, err.Error())
+			}
+
+			if web.LookupProblem(errors.Cause(err)).Status != http.StatusNotFound {
 
 				// Log the error.
 				v.Log.Errorf("%s : %+v\n", v.TraceID, err)
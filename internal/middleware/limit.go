@@ -0,0 +1,147 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+// ErrOverloaded occurs when a request is shed because too many requests are
+// in flight.
+var ErrOverloaded = errors.New("Server is overloaded")
+
+func init() {
+	web.RegisterError(ErrOverloaded, web.ProblemType{Type: web.ProblemBaseURI + "overloaded", Title: "Server is overloaded", Status: http.StatusServiceUnavailable})
+}
+
+// latencyWeight is the weight of the last queue latency in its moving
+// average.
+const latencyWeight = 0.1
+
+// LimitOptions configures the requests handled at once by a group of
+// routes.
+type LimitOptions struct {
+
+	// MaxInFlight is the number of requests handled at once, 0 for no limit.
+	// The other requests wait in a queue.
+	MaxInFlight int
+
+	// MaxQueue is the number of requests waiting at once, 0 for no limit.
+	MaxQueue int
+
+	// QueueTarget is the queue latency over which the requests which find
+	// no free slot are shed, 0 to queue them all.
+	QueueTarget time.Duration
+}
+
+// limiter holds the slots of the requests in flight and the moving average
+// of the time the requests wait for them.
+type limiter struct {
+	opts  LimitOptions
+	slots chan struct{}
+
+	mu      sync.Mutex
+	queued  int
+	latency float64
+}
+
+// Limit bounds the number of requests of the routes it wraps handled at
+// once, so that a flood of requests can't exhaust the DB pool. Once the
+// queue latency exceeds the target, or the queue is full, the requests are
+// shed with 503 and a Retry-After header instead of queuing. The latency
+// falls back as soon as requests find free slots. Queued requests give up
+// at the deadline of their route.
+// The middleware must be created once per group, its slots are shared by
+// all the routes it wraps.
+func Limit(opts LimitOptions) web.Middleware {
+	if opts.MaxInFlight <= 0 {
+		return func(next web.Handler) web.Handler { return next }
+	}
+	l := limiter{opts: opts, slots: make(chan struct{}, opts.MaxInFlight)}
+
+	// This is the actual middleware function to be executed.
+	return func(next web.Handler) web.Handler {
+
+		// Create the handler that will be attached in the middleware chain.
+		return func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+			if err := l.acquire(ctx); err != nil {
+				w.Header().Set("Retry-After", strconv.Itoa(l.retryAfter()))
+				return err
+			}
+			defer l.release()
+
+			return next(ctx, w, r, params)
+		}
+	}
+}
+
+// acquire takes a slot, waiting for one unless the request is shed.
+func (l *limiter) acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		l.observe(0)
+		return nil
+	default:
+	}
+
+	l.mu.Lock()
+	latency := time.Duration(l.latency)
+	if l.opts.MaxQueue > 0 && l.queued >= l.opts.MaxQueue {
+		l.mu.Unlock()
+		return errors.Wrapf(ErrOverloaded, "%d requests queued", l.opts.MaxQueue)
+	}
+	if l.opts.QueueTarget > 0 && latency > l.opts.QueueTarget {
+		l.mu.Unlock()
+		return errors.Wrapf(ErrOverloaded, "queue latency %v over %v", latency, l.opts.QueueTarget)
+	}
+	l.queued++
+	l.mu.Unlock()
+
+	start := time.Now()
+	defer func() {
+		l.mu.Lock()
+		l.queued--
+		l.mu.Unlock()
+	}()
+
+	select {
+	case l.slots <- struct{}{}:
+		l.observe(time.Since(start))
+		return nil
+	case <-ctx.Done():
+		wait := time.Since(start)
+		l.observe(wait)
+		return errors.Wrapf(ErrOverloaded, "no slot after %v", wait)
+	}
+}
+
+// release frees the slot of a request.
+func (l *limiter) release() {
+	<-l.slots
+}
+
+// observe adds the time a request waited for its slot to the moving
+// average.
+func (l *limiter) observe(wait time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.latency += latencyWeight * (float64(wait) - l.latency)
+}
+
+// retryAfter returns the number of seconds after which a shed request may
+// be retried: the queue latency, at least one second.
+func (l *limiter) retryAfter() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := int(math.Ceil(time.Duration(l.latency).Seconds()))
+	if s < 1 {
+		s = 1
+	}
+	return s
+}
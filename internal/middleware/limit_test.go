@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+func TestLimiter(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    LimitOptions
+		queued  int
+		latency time.Duration
+		timeout time.Duration
+		free    bool
+		want    error
+	}{
+		{name: "free slot", opts: LimitOptions{MaxInFlight: 1, QueueTarget: time.Millisecond}, latency: time.Second, free: true},
+		{name: "queue full", opts: LimitOptions{MaxInFlight: 1, MaxQueue: 2}, queued: 2, want: ErrOverloaded},
+		{name: "latency over target", opts: LimitOptions{MaxInFlight: 1, QueueTarget: 100 * time.Millisecond}, latency: time.Second, want: ErrOverloaded},
+		{name: "deadline while queued", opts: LimitOptions{MaxInFlight: 1, QueueTarget: time.Second}, timeout: 10 * time.Millisecond, want: ErrOverloaded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := limiter{opts: tt.opts, slots: make(chan struct{}, tt.opts.MaxInFlight), queued: tt.queued, latency: float64(tt.latency)}
+			if !tt.free {
+				l.slots <- struct{}{}
+			}
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			err := l.acquire(ctx)
+			if errors.Cause(err) != tt.want {
+				t.Fatalf("acquire() = %v, want %v", err, tt.want)
+			}
+			if tt.free && time.Duration(l.latency) >= tt.latency {
+				t.Errorf("latency = %v, want it under %v once a slot is free", time.Duration(l.latency), tt.latency)
+			}
+		})
+	}
+}
+
+func TestLimit(t *testing.T) {
+	app := web.New(log.WithField("test", t.Name()), ErrorHandler)
+	app.Timeout = 20 * time.Millisecond
+	block, started := make(chan struct{}), make(chan struct{})
+	app.Group(Limit(LimitOptions{MaxInFlight: 1})).Handle("GET", "/v1/images", func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+		close(started)
+		<-block
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+
+	// The first request holds the slot, the second one waits for it until
+	// its deadline.
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest("GET", "/v1/images", nil))
+		done <- w.Code
+	}()
+	<-started
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest("GET", "/v1/images", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want %q", got, "1")
+	}
+
+	close(block)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("status = %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	app := web.New(log.WithField("test", t.Name()), ErrorHandler)
+	app.Timeout = time.Hour
+	app.Timeouts = map[string]time.Duration{"GET /v1/images": 10 * time.Millisecond}
+	app.Handle("GET", "/v1/images", func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+		<-ctx.Done()
+		return errors.Wrap(errors.New("pq: canceling statement due to user request"), "db.images.List")
+	})
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest("GET", "/v1/images", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
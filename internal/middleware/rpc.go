@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// UnaryAuthenticate identifies the callers of the gRPC calls from the
+// bearer token of their authorization metadata, like Authenticate does for
+// the HTTP requests. It must come after rpc.UnaryValues.
+func UnaryAuthenticate(tokens map[string]string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := authenticate(ctx, tokens, authorization(ctx)); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamAuthenticate is UnaryAuthenticate for the streaming calls. It must
+// come after rpc.StreamValues.
+func StreamAuthenticate(tokens map[string]string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+		if err := authenticate(ctx, tokens, authorization(ctx)); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// UnaryRequireActor rejects the anonymous calls of the methods, given by
+// their full name, like RequireActor does for the HTTP routes. It must
+// come after UnaryAuthenticate.
+func UnaryRequireActor(methods ...string) grpc.UnaryServerInterceptor {
+	required := make(map[string]bool, len(methods))
+	for _, m := range methods {
+		required[m] = true
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if required[info.FullMethod] && ctx.Value(web.KeyValues).(*web.Values).Actor == "" {
+			return nil, errors.Wrap(web.ErrNotAuthorized, "anonymous call")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// authorization returns the authorization metadata of a call.
+func authorization(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if auth := md.Get("authorization"); len(auth) > 0 {
+		return auth[0]
+	}
+	return ""
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestUnaryAuthenticate(t *testing.T) {
+	tokens := map[string]string{"s3cr3t": "alice"}
+	tests := []struct {
+		name  string
+		auth  string
+		err   bool
+		actor string
+	}{
+		{name: "anonymous"},
+		{name: "bearer", auth: "Bearer s3cr3t", actor: "alice"},
+		{name: "unknown token", auth: "Bearer nope", err: true},
+		{name: "basic", auth: "Basic s3cr3t", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), web.KeyValues, &web.Values{})
+			if tt.auth != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", tt.auth))
+			}
+
+			var actor string
+			_, err := UnaryAuthenticate(tokens)(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				actor = ctx.Value(web.KeyValues).(*web.Values).Actor
+				return nil, nil
+			})
+
+			if tt.err != (err != nil) || (err != nil && errors.Cause(err) != web.ErrNotAuthorized) {
+				t.Errorf("err = %v, want not authorized: %v", err, tt.err)
+			}
+			if actor != tt.actor {
+				t.Errorf("actor = %q, want %q", actor, tt.actor)
+			}
+		})
+	}
+}
+
+func TestUnaryRequireActor(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		actor  string
+		err    bool
+	}{
+		{name: "anonymous write", method: "/image.v1.ImageService/DeleteImage", err: true},
+		{name: "editor write", method: "/image.v1.ImageService/DeleteImage", actor: "alice"},
+		{name: "anonymous read", method: "/image.v1.ImageService/GetImage"},
+	}
+
+	interceptor := UnaryRequireActor("/image.v1.ImageService/DeleteImage")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), web.KeyValues, &web.Values{Actor: tt.actor})
+
+			var called bool
+			_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				called = true
+				return nil, nil
+			})
+
+			if tt.err != (err != nil) || (err != nil && errors.Cause(err) != web.ErrNotAuthorized) {
+				t.Errorf("err = %v, want not authorized: %v", err, tt.err)
+			}
+			if called == tt.err {
+				t.Errorf("handler called = %v, want %v", called, !tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Store is a cache backend holding values for a limited time.
+type Store interface {
+
+	// Get returns the value stored under the key, and false when there is
+	// none or it expired.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+
+	// Set stores the value under the key for the ttl.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+
+	// Delete removes the values stored under the keys.
+	Delete(ctx context.Context, keys ...string) error
+}
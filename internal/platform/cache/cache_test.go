@@ -0,0 +1,170 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryRedis is a minimal server speaking the Redis protocol, keeping the
+// values in memory.
+type memoryRedis struct {
+	mu      sync.Mutex
+	values  map[string][]byte
+	expires map[string]time.Time
+}
+
+func (m *memoryRedis) serve(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go m.handle(conn)
+	}
+}
+
+func (m *memoryRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		cmd, err := readReply(r)
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, a := range cmd.([]interface{}) {
+			args = append(args, string(a.([]byte)))
+		}
+		fmt.Fprint(conn, m.exec(args))
+	}
+}
+
+func (m *memoryRedis) exec(args []string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "AUTH":
+		if args[1] != "secret" {
+			return "-WRONGPASS invalid password\r\n"
+		}
+		return "+OK\r\n"
+	case "GET":
+		v, ok := m.values[args[1]]
+		if !ok || !time.Now().Before(m.expires[args[1]]) {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+	case "SET":
+		ms, _ := strconv.Atoi(args[4])
+		m.values[args[1]] = []byte(args[2])
+		m.expires[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		return "+OK\r\n"
+	case "DEL":
+		n := 0
+		for _, k := range args[1:] {
+			if _, ok := m.values[k]; ok {
+				delete(m.values, k)
+				n++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func TestStores(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go (&memoryRedis{values: map[string][]byte{}, expires: map[string]time.Time{}}).serve(l)
+
+	redis := NewRedis(RedisConfig{Addr: l.Addr().String(), Password: "secret", PoolSize: 2})
+	defer redis.Close()
+
+	tests := []struct {
+		name  string
+		store Store
+	}{
+		{name: "lru", store: NewLRU(16)},
+		{name: "redis", store: redis},
+	}
+
+	ctx := context.Background()
+	value := []byte("{\"title\": \"Image\r\nElijah Baley\"}")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok, err := tt.store.Get(ctx, "images:1"); ok || err != nil {
+				t.Fatalf("Get() of a missing key = %v, %v, want a miss", ok, err)
+			}
+			if err := tt.store.Set(ctx, "images:1", value, time.Minute); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			if err := tt.store.Set(ctx, "images:2", value, time.Millisecond); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+
+			got, ok, err := tt.store.Get(ctx, "images:1")
+			if err != nil || !ok || !bytes.Equal(got, value) {
+				t.Fatalf("Get() = %q, %v, %v, want %q", got, ok, err, value)
+			}
+
+			time.Sleep(5 * time.Millisecond)
+			if _, ok, _ := tt.store.Get(ctx, "images:2"); ok {
+				t.Errorf("Get() of an expired key hits")
+			}
+
+			if err := tt.store.Delete(ctx, "images:1", "images:3"); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			if _, ok, _ := tt.store.Get(ctx, "images:1"); ok {
+				t.Errorf("Get() of a deleted key hits")
+			}
+		})
+	}
+}
+
+func TestRedisAuth(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go (&memoryRedis{values: map[string][]byte{}, expires: map[string]time.Time{}}).serve(l)
+
+	redis := NewRedis(RedisConfig{Addr: l.Addr().String(), Password: "wrong"})
+	if _, _, err := redis.Get(context.Background(), "images:1"); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("Get() error = %v, want WRONGPASS", err)
+	}
+}
+
+func TestLRUEviction(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRU(2)
+	c.Set(ctx, "a", []byte("a"), time.Minute)
+	c.Set(ctx, "b", []byte("b"), time.Minute)
+
+	// Reading a makes b the least recently used value.
+	c.Get(ctx, "a")
+	c.Set(ctx, "c", []byte("c"), time.Minute)
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok, _ := c.Get(ctx, key); ok != want {
+			t.Errorf("Get(%s) hit = %v, want %v", key, ok, want)
+		}
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", c.Len())
+	}
+}
@@ -0,0 +1,224 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RedisConfig configures the connection to a Redis server.
+type RedisConfig struct {
+	Addr     string
+	Password string
+	DB       int
+
+	// PoolSize is the number of idle connections kept open.
+	PoolSize int
+
+	// Timeout bounds each command when the context has no deadline.
+	Timeout time.Duration
+}
+
+// Redis is a Store backed by a server speaking the Redis protocol (RESP),
+// like Redis, Valkey or KeyDB.
+type Redis struct {
+	cfg  RedisConfig
+	pool chan *redisConn
+}
+
+// redisConn is a connection along with its buffered reader.
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// redisError is an error reply of the server. The connection is still
+// usable after it.
+type redisError string
+
+// Error implements the error interface.
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// NewRedis creates a Store sending its commands to a Redis server. The
+// connections are opened on demand.
+func NewRedis(cfg RedisConfig) *Redis {
+	if cfg.PoolSize < 1 {
+		cfg.PoolSize = 1
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = time.Second
+	}
+	return &Redis{cfg: cfg, pool: make(chan *redisConn, cfg.PoolSize)}
+}
+
+// Get implements the Store interface.
+func (c *Redis) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	reply, err := c.do(ctx, "GET", key)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "GET %s", key)
+	}
+	b, ok := reply.([]byte)
+	return b, ok, nil
+}
+
+// Set implements the Store interface.
+func (c *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	ms := int64(ttl / time.Millisecond)
+	if ms < 1 {
+		ms = 1
+	}
+	if _, err := c.do(ctx, "SET", key, string(value), "PX", strconv.FormatInt(ms, 10)); err != nil {
+		return errors.Wrapf(err, "SET %s", key)
+	}
+	return nil
+}
+
+// Delete implements the Store interface.
+func (c *Redis) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if _, err := c.do(ctx, append([]string{"DEL"}, keys...)...); err != nil {
+		return errors.Wrapf(err, "DEL %v", keys)
+	}
+	return nil
+}
+
+// Close closes the idle connections.
+func (c *Redis) Close() error {
+	for {
+		select {
+		case conn := <-c.pool:
+			conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// do sends a command and reads its reply. The connections which fail are
+// closed, the other ones go back to the pool.
+func (c *Redis) do(ctx context.Context, args ...string) (interface{}, error) {
+	conn, err := c.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.roundTrip(ctx, c.cfg.Timeout, args...)
+	if _, ok := err.(redisError); err != nil && !ok {
+		conn.Close()
+		return nil, err
+	}
+
+	select {
+	case c.pool <- conn:
+	default:
+		conn.Close()
+	}
+	return reply, err
+}
+
+// conn returns an idle connection, or opens a new one.
+func (c *Redis) conn(ctx context.Context) (*redisConn, error) {
+	select {
+	case conn := <-c.pool:
+		return conn, nil
+	default:
+	}
+
+	d := net.Dialer{Timeout: c.cfg.Timeout}
+	nc, err := d.DialContext(ctx, "tcp", c.cfg.Addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "dial %s", c.cfg.Addr)
+	}
+	conn := &redisConn{Conn: nc, r: bufio.NewReader(nc)}
+
+	if c.cfg.Password != "" {
+		if _, err := conn.roundTrip(ctx, c.cfg.Timeout, "AUTH", c.cfg.Password); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "AUTH")
+		}
+	}
+	if c.cfg.DB != 0 {
+		if _, err := conn.roundTrip(ctx, c.cfg.Timeout, "SELECT", strconv.Itoa(c.cfg.DB)); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "SELECT")
+		}
+	}
+	return conn, nil
+}
+
+// roundTrip writes a command as an array of bulk strings and reads the
+// reply.
+func (conn *redisConn) roundTrip(ctx context.Context, timeout time.Duration, args ...string) (interface{}, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(timeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return readReply(conn.r)
+}
+
+// readReply reads a RESP reply: simple strings, errors, integers, bulk
+// strings and arrays. Nil bulk strings and arrays are returned as nil.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.Errorf("redis: malformed reply %q", line)
+	}
+	kind, line := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, redisError(line)
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			if values[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	return nil, errors.Errorf("redis: unknown reply type %q", kind)
+}
@@ -6,15 +6,20 @@ import (
 	"encoding/json"
 	"net/url"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
-
-	// postgres driver
-	_ "github.com/lib/pq"
 )
 
+// uniqueViolation is the postgres error code raised when a unique constraint
+// is violated.
+const uniqueViolation = "23505"
+
 // ErrInvalidDBProvided is returned in the event that an uninitialized db is
 // used to perform actions against.
 var ErrInvalidDBProvided = errors.New("invalid DB provided")
@@ -26,6 +31,9 @@ var ErrInvalidDBProvided = errors.New("invalid DB provided")
 type DB struct {
 	// Postgres Support.
 	database *sqlx.DB
+
+	// url connects the listeners of notifications.
+	url string
 }
 
 // NewPSQL returns a new DB value for use with Postgresql
@@ -35,7 +43,7 @@ func NewPSQL(url string) (*DB, error) {
 		return nil, errors.Wrap(err, "NewPSQL")
 	}
 
-	return &DB{database: db}, nil
+	return &DB{database: db, url: url}, nil
 }
 
 // PSQLClose closes a DB value being used with Postgresql.
@@ -70,6 +78,55 @@ func (db *DB) PSQLQueryRawx(ctx context.Context, query string, params ...interfa
 	return db.database.QueryRowx(query, params...), nil
 }
 
+// PSQLBegin starts a transaction, rolled back when the context is done
+// before it is committed.
+func (db *DB) PSQLBegin(ctx context.Context) (*sqlx.Tx, error) {
+	if db == nil {
+		return nil, errors.Wrap(ErrInvalidDBProvided, "db == nil")
+	}
+	return db.database.BeginTxx(ctx, nil)
+}
+
+// PSQLListen listens to the notifications of a Postgres channel on a
+// dedicated connection, until the context is done. The returned channel
+// receives a value when notifications arrive, coalescing them, and after
+// each reconnection since notifications may have been lost meanwhile.
+func (db *DB) PSQLListen(ctx context.Context, channel string) (<-chan struct{}, error) {
+	if db == nil {
+		return nil, errors.Wrap(ErrInvalidDBProvided, "db == nil")
+	}
+	l := pq.NewListener(db.url, 10*time.Second, time.Minute, nil)
+	if err := l.Listen(channel); err != nil {
+		l.Close()
+		return nil, errors.Wrapf(err, "PSQLListen(%s)", channel)
+	}
+
+	notify := make(chan struct{}, 1)
+	go func() {
+		defer close(notify)
+		defer l.Close()
+
+		// The connection is pinged while idle to detect it is broken.
+		ping := time.NewTicker(90 * time.Second)
+		defer ping.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-l.Notify:
+			case <-ping.C:
+				go l.Ping()
+				continue
+			}
+			select {
+			case notify <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return notify, nil
+}
+
 // newPSQL creates a new postgres connection.
 func newPSQL(url string) (*sqlx.DB, error) {
 
@@ -96,6 +153,12 @@ func Query(value interface{}) string {
 	return string(json)
 }
 
+// IsUniqueViolation reports whether err was raised by a unique constraint.
+func IsUniqueViolation(err error) bool {
+	pqErr, ok := errors.Cause(err).(*pq.Error)
+	return ok && pqErr.Code == uniqueViolation
+}
+
 // Ping checks the connection availability
 func (db *DB) Ping() error {
 	if db == nil {
@@ -107,6 +170,24 @@ func (db *DB) Ping() error {
 	return nil
 }
 
+// ColumnKind tells BuildWhere how a column can be filtered.
+type ColumnKind int
+
+const (
+	// Scalar columns are compared to the filter value.
+	Scalar ColumnKind = iota
+
+	// JSONB columns are filtered on a path inside the document, like
+	// metadata.width for the width key of the metadata column.
+	JSONB
+)
+
+// ErrInvalidFilter occurs when a filter uses an unknown column or operator.
+var ErrInvalidFilter = errors.New("Invalid filter")
+
+// identRegex matches the column names and json keys accepted in filters.
+var identRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
 // GetQueryOperator identifies operator on a join
 func getQueryOperator(op string) (string, error) {
 	op = strings.Replace(op, "$", "", -1)
@@ -134,29 +215,148 @@ func getQueryOperator(op string) (string, error) {
 	case "null":
 		return "IS NULL", nil
 	}
-	return "", errors.New("Invalid operator")
+	return "", errors.Wrapf(ErrInvalidFilter, "Invalid operator %s", op)
+}
+
+// splitFilter separates the operator from the value of a filter like
+// $gt.1000. A value without operator is an equality.
+func splitFilter(filter string) (string, string, error) {
+	if !strings.HasPrefix(filter, "$") {
+		return "=", filter, nil
+	}
+	parts := strings.SplitN(filter, ".", 2)
+	op, err := getQueryOperator(parts[0])
+	if err != nil {
+		return "", "", err
+	}
+	if len(parts) == 1 {
+		return op, "", nil
+	}
+	return op, parts[1], nil
 }
 
-// BuildWhere builds WHERE statement
-func (db *DB) BuildWhere(queryParams url.Values) (string, error) {
-	removeOperatorRegex := regexp.MustCompile(`\$[a-z]+.`)
-	sqlWhere := " WHERE "
-	result := ""
-	i := 0
-	for k, v := range queryParams {
-		value := removeOperatorRegex.ReplaceAllString(v[0], "")
-		op, err := getQueryOperator(strings.Split(v[0], ".")[0])
-		if err != nil {
-			return "", errors.Wrap(err, "BuildWhere")
+// BuildWhere builds WHERE statement from query parameters like
+// publisher=$eq.etf1 or metadata.width=$gt.1000. Only the given columns can
+// be filtered on. The values are returned as parameters of the statement,
+// numbered after the offset ones already used by the query.
+func BuildWhere(queryParams url.Values, columns map[string]ColumnKind, offset int) (string, []interface{}, error) {
+
+	// Sort the keys so the same filters always build the same statement.
+	keys := make([]string, 0, len(queryParams))
+	for k := range queryParams {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var conds []string
+	var params []interface{}
+	for _, k := range keys {
+		path := strings.Split(k, ".")
+		for _, p := range path {
+			if !identRegex.MatchString(p) {
+				return "", nil, errors.Wrapf(ErrInvalidFilter, "Invalid filter name %s", k)
+			}
+		}
+		kind, ok := columns[path[0]]
+		if !ok || (kind == Scalar && len(path) > 1) {
+			return "", nil, errors.Wrapf(ErrInvalidFilter, "Unknown filter %s", k)
+		}
+
+		for _, v := range queryParams[k] {
+			op, value, err := splitFilter(v)
+			if err != nil {
+				return "", nil, errors.Wrap(err, "BuildWhere")
+			}
+
+			var cond string
+			if kind == JSONB && len(path) > 1 {
+				cond, params = jsonbCondition(path[0], path[1:], op, value, params, offset)
+			} else {
+				cond, params = scalarCondition(path[0], op, value, params, offset)
+			}
+			conds = append(conds, cond)
+		}
+	}
+
+	if len(conds) == 0 {
+		return "", nil, nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), params, nil
+}
+
+// placeholder appends the value to the parameters and returns its
+// placeholder.
+func placeholder(value interface{}, params []interface{}, offset int) (string, []interface{}) {
+	params = append(params, value)
+	return "$" + strconv.Itoa(len(params)+offset), params
+}
+
+// scalarCondition builds the condition for a plain column.
+func scalarCondition(col, op, value string, params []interface{}, offset int) (string, []interface{}) {
+	var ph string
+	switch op {
+	case "IS NULL", "IS NOT NULL":
+		return col + " " + op, params
+	case "IN", "NOT IN":
+		ph, params = placeholder(pq.Array(strings.Split(value, ",")), params, offset)
+		if op == "IN" {
+			return col + "::text = ANY(" + ph + ")", params
+		}
+		return "NOT (" + col + "::text = ANY(" + ph + "))", params
+	}
+	ph, params = placeholder(value, params, offset)
+	return col + " " + op + " " + ph, params
+}
+
+// jsonbCondition builds the condition for a path inside a jsonb column.
+// Equality uses containment so it is served by a GIN index on the column.
+func jsonbCondition(col string, path []string, op, value string, params []interface{}, offset int) (string, []interface{}) {
+	var ph, pathPH string
+	switch op {
+	case "=", "!=":
+		doc := jsonValue(value)
+		for i := len(path) - 1; i >= 0; i-- {
+			doc = map[string]interface{}{path[i]: doc}
 		}
-		if i == 0 {
-			result = k + " " + op + "'" + value + "'"
-		} else {
-			result += " AND " + k + " " + op + "'" + value + "'"
+		ph, params = placeholder(Query(doc), params, offset)
+		if op == "=" {
+			return col + " @> " + ph + "::jsonb", params
 		}
-		i++
+		return "NOT (" + col + " @> " + ph + "::jsonb)", params
 	}
-	sqlWhere += result
-	return sqlWhere, nil
 
+	pathPH, params = placeholder(pq.Array(path), params, offset)
+	switch op {
+	case "IS NULL", "IS NOT NULL":
+		return col + " #> " + pathPH + "::text[] " + op, params
+	case "IN", "NOT IN":
+		ph, params = placeholder(pq.Array(strings.Split(value, ",")), params, offset)
+		if op == "IN" {
+			return col + " #>> " + pathPH + "::text[] = ANY(" + ph + ")", params
+		}
+		return "NOT (" + col + " #>> " + pathPH + "::text[] = ANY(" + ph + "))", params
+	}
+
+	// Compare numbers as numbers, anything else as text.
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		ph, params = placeholder(f, params, offset)
+		return "(CASE WHEN jsonb_typeof(" + col + " #> " + pathPH + "::text[]) = 'number' THEN (" +
+			col + " #>> " + pathPH + "::text[])::numeric END) " + op + " " + ph, params
+	}
+	ph, params = placeholder(value, params, offset)
+	return col + " #>> " + pathPH + "::text[] " + op + " " + ph, params
+}
+
+// jsonValue converts a filter value to the JSON value it represents, so that
+// 1000 matches a number and true a boolean. Other values are strings.
+func jsonValue(value string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(value), &v); err != nil {
+		return value
+	}
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		return value
+	}
+	return v
 }
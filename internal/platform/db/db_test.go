@@ -0,0 +1,90 @@
+package db
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+func TestBuildWhere(t *testing.T) {
+	columns := map[string]ColumnKind{
+		"publisher": Scalar,
+		"slug":      Scalar,
+		"metadata":  JSONB,
+	}
+
+	tests := []struct {
+		name   string
+		query  string
+		offset int
+		where  string
+		params []interface{}
+		err    error
+	}{
+		{name: "no filter", query: ""},
+		{
+			name:   "operator",
+			query:  "publisher=$eq.etf1",
+			where:  " WHERE publisher = $1",
+			params: []interface{}{"etf1"},
+		},
+		{
+			name:   "implicit equality and offset",
+			query:  "publisher=etf1&slug=$ne.a.b",
+			offset: 1,
+			where:  " WHERE publisher = $2 AND slug != $3",
+			params: []interface{}{"etf1", "a.b"},
+		},
+		{
+			name:   "scalar in",
+			query:  "publisher=$in.etf1,tf1",
+			where:  " WHERE publisher::text = ANY($1)",
+			params: []interface{}{pq.Array([]string{"etf1", "tf1"})},
+		},
+		{
+			name:   "metadata containment",
+			query:  "metadata.size.width=$eq.1000",
+			where:  " WHERE metadata @> $1::jsonb",
+			params: []interface{}{`{"size":{"width":1000}}`},
+		},
+		{
+			name:   "metadata numeric comparison",
+			query:  "metadata.width=$gt.1000",
+			where:  " WHERE (CASE WHEN jsonb_typeof(metadata #> $1::text[]) = 'number' THEN (metadata #>> $1::text[])::numeric END) > $2",
+			params: []interface{}{pq.Array([]string{"width"}), 1000.0},
+		},
+		{
+			name:   "metadata not null",
+			query:  "metadata.credits=$notnull",
+			where:  " WHERE metadata #> $1::text[] IS NOT NULL",
+			params: []interface{}{pq.Array([]string{"credits"})},
+		},
+		{name: "unknown column", query: "password=$eq.x", err: ErrInvalidFilter},
+		{name: "path on scalar column", query: "publisher.name=$eq.x", err: ErrInvalidFilter},
+		{name: "injected column", query: "publisher%20or%201=$eq.x", err: ErrInvalidFilter},
+		{name: "unknown operator", query: "publisher=$like.x", err: ErrInvalidFilter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qp, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			where, params, err := BuildWhere(qp, columns, tt.offset)
+			if errors.Cause(err) != tt.err {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if where != tt.where {
+				t.Errorf("got where %q, want %q", where, tt.where)
+			}
+			if !reflect.DeepEqual(params, tt.params) {
+				t.Errorf("got params %#v, want %#v", params, tt.params)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package gql
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// sizeArg is the argument of the list fields holding the number of items
+// they return.
+const sizeArg = "first"
+
+// maxCost bounds the complexities, which saturate rather than overflow.
+const maxCost = math.MaxInt32
+
+// coster computes the depth and the complexity of an operation, following
+// the types of the schema to find the default sizes of the lists. The
+// sizes over their maximum, keyed like Query.items, count as the maximum.
+type coster struct {
+	schema    *graphql.Schema
+	doc       *ast.Document
+	variables map[string]interface{}
+	maxSizes  map[string]int
+}
+
+// operation returns the depth and the complexity of the operation.
+func (c *coster) operation(op *ast.OperationDefinition) (int, int) {
+	var root graphql.Type = c.schema.QueryType()
+	if op.Operation == ast.OperationTypeMutation {
+		root = c.schema.MutationType()
+	}
+	return c.selections(op.SelectionSet, root, 1, make(map[string]bool))
+}
+
+// selections returns the depth and the complexity of a selection set of
+// the type, whose fields are at the depth. The fragments being expanded
+// are skipped, the validation rejects their cycles anyway.
+func (c *coster) selections(set *ast.SelectionSet, parent graphql.Type, depth int, expanding map[string]bool) (int, int) {
+	if set == nil {
+		return 0, 0
+	}
+
+	var maxDepth, complexity int
+	for _, sel := range set.Selections {
+		var d, cx int
+		switch s := sel.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(s.Name.Value, "__") {
+				continue
+			}
+			d, cx = c.field(s, parent, depth, expanding)
+		case *ast.InlineFragment:
+			t := parent
+			if s.TypeCondition != nil {
+				t = c.schema.Type(s.TypeCondition.Name.Value)
+			}
+			d, cx = c.selections(s.SelectionSet, t, depth, expanding)
+		case *ast.FragmentSpread:
+			name := s.Name.Value
+			frag := c.fragment(name)
+			if frag == nil || expanding[name] {
+				continue
+			}
+			expanding[name] = true
+			d, cx = c.selections(frag.SelectionSet, c.schema.Type(frag.TypeCondition.Name.Value), depth, expanding)
+			delete(expanding, name)
+		}
+		if d > maxDepth {
+			maxDepth = d
+		}
+		complexity = add(complexity, cx)
+	}
+	return maxDepth, complexity
+}
+
+// field returns the depth and the complexity of a field: 1, plus the
+// complexity of its selections times the size of its list.
+func (c *coster) field(f *ast.Field, parent graphql.Type, depth int, expanding map[string]bool) (int, int) {
+	var def *graphql.FieldDefinition
+	size := 1
+	if obj, ok := parent.(*graphql.Object); ok {
+		def = obj.Fields()[f.Name.Value]
+		size = c.size(f, def)
+		if max, ok := c.maxSizes[obj.Name()+"."+f.Name.Value]; ok && size > max {
+			size = max
+		}
+	}
+	var t graphql.Type
+	if def != nil {
+		t, _ = graphql.GetNamed(def.Type).(graphql.Type)
+	}
+
+	d, cx := c.selections(f.SelectionSet, t, depth+1, expanding)
+	if d < depth {
+		d = depth
+	}
+	return d, add(1, mul(size, cx))
+}
+
+// size returns the number of items of a list field, from its first
+// argument or its default. It is 1 for the other fields.
+func (c *coster) size(f *ast.Field, def *graphql.FieldDefinition) int {
+	for _, arg := range f.Arguments {
+		if arg.Name.Value != sizeArg {
+			continue
+		}
+		switch v := arg.Value.(type) {
+		case *ast.IntValue:
+			if n, err := strconv.ParseFloat(v.Value, 64); err == nil && n >= 1 {
+				return number(n)
+			}
+		case *ast.Variable:
+			if n := number(c.variables[v.Name.Value]); n > 0 {
+				return n
+			}
+		}
+	}
+	if def != nil {
+		for _, arg := range def.Args {
+			if arg.Name() == sizeArg {
+				if n := number(arg.DefaultValue); n > 0 {
+					return n
+				}
+			}
+		}
+	}
+	return 1
+}
+
+// add returns the sum of the complexities, saturated at maxCost.
+func add(a, b int) int {
+	if a > maxCost-b {
+		return maxCost
+	}
+	return a + b
+}
+
+// mul returns the product of a size and a complexity, saturated at
+// maxCost.
+func mul(a, b int) int {
+	if b != 0 && a > maxCost/b {
+		return maxCost
+	}
+	return a * b
+}
+
+// fragment returns the definition of the named fragment.
+func (c *coster) fragment(name string) *ast.FragmentDefinition {
+	for _, def := range c.doc.Definitions {
+		if frag, ok := def.(*ast.FragmentDefinition); ok && frag.Name.Value == name {
+			return frag
+		}
+	}
+	return nil
+}
+
+// number returns the integer value of a variable or a default, 0 when it
+// isn't a number.
+func number(v interface{}) int {
+	var f float64
+	switch n := v.(type) {
+	case int:
+		f = float64(n)
+	case float64:
+		f = n
+	case json.Number:
+		f, _ = n.Float64()
+	default:
+		return 0
+	}
+	if f > maxCost {
+		return maxCost
+	}
+	return int(f)
+}
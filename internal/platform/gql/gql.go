@@ -0,0 +1,181 @@
+package gql
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+// Request is a GraphQL request, sent as the body of a POST or as the
+// query, operationName and variables query parameters of a GET.
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// ParseRequest reads the GraphQL request of an HTTP request. The body of
+// the POSTs is decoded according to its content type.
+func ParseRequest(r *http.Request) (Request, error) {
+	var req Request
+	if r.Method != http.MethodGet {
+		if err := web.UnmarshalRequest(r, &req); err != nil {
+			return req, err
+		}
+	} else {
+		qp := r.URL.Query()
+		req.Query, req.OperationName = qp.Get("query"), qp.Get("operationName")
+		if s := qp.Get("variables"); s != "" {
+			if err := json.Unmarshal([]byte(s), &req.Variables); err != nil {
+				return req, errors.Wrap(web.ErrMalformedBody, "variables: "+err.Error())
+			}
+		}
+	}
+
+	if strings.TrimSpace(req.Query) == "" {
+		return req, web.InvalidError{{Fld: "query", Err: "required", Msg: "is required"}}
+	}
+	return req, nil
+}
+
+// Executor runs the requests against a schema, once they are checked
+// against its limits. Requests over the limits aren't executed.
+type Executor struct {
+	Schema graphql.Schema
+
+	// MaxDepth is the highest number of nested fields of a query, 0 for no
+	// limit. The introspection fields aren't counted.
+	MaxDepth int
+
+	// MaxComplexity is the highest complexity of a query, 0 for no limit.
+	// Each field costs 1, and the fields under a list field with a first
+	// argument cost once per item.
+	MaxComplexity int
+
+	// MaxSizes holds the highest first argument of the list fields, keyed
+	// like Query.items, which their resolvers refuse beyond. A larger one
+	// costs as much as the highest.
+	MaxSizes map[string]int
+}
+
+// Execute runs the request. The errors of the document, like the syntax
+// errors or the queries over the limits, are returned without data. The
+// mutations are refused unless allowed, so that the GET requests are safe.
+func (e *Executor) Execute(ctx context.Context, req Request, mutations bool) *graphql.Result {
+	src := source.NewSource(&source.Source{Body: []byte(req.Query), Name: "GraphQL request"})
+	doc, err := parser.Parse(parser.ParseParams{Source: src})
+	if err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+	if vr := graphql.ValidateDocument(&e.Schema, doc, nil); !vr.IsValid {
+		return &graphql.Result{Errors: vr.Errors}
+	}
+
+	op := operation(doc, req.OperationName)
+	if op == nil && req.OperationName == "" {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(errors.New("Must provide an operation name when the query has several operations"))}
+	}
+	if op == nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(errors.Errorf("Unknown operation %q", req.OperationName))}
+	}
+	if op.Operation == ast.OperationTypeMutation && !mutations {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(errors.New("Mutations must be sent with POST"))}
+	}
+
+	c := coster{schema: &e.Schema, doc: doc, variables: req.Variables, maxSizes: e.MaxSizes}
+	depth, complexity := c.operation(op)
+	if e.MaxDepth > 0 && depth > e.MaxDepth {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(errors.Errorf("Query depth %d exceeds the limit of %d", depth, e.MaxDepth))}
+	}
+	if e.MaxComplexity > 0 && complexity > e.MaxComplexity {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(errors.Errorf("Query complexity %d exceeds the limit of %d", complexity, e.MaxComplexity))}
+	}
+
+	res := graphql.Execute(graphql.ExecuteParams{
+		Schema:        e.Schema,
+		AST:           doc,
+		OperationName: req.OperationName,
+		Args:          req.Variables,
+		Context:       ctx,
+	})
+	for i := range res.Errors {
+		if res.Errors[i].Extensions == nil {
+			res.Errors[i].Extensions = extensions(res.Errors[i])
+		}
+	}
+	return res
+}
+
+// Error is an error of a resolver, described by a problem which is sent
+// in the extensions of the GraphQL error.
+type Error struct {
+	Problem web.Problem
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	if e.Problem.Detail != "" {
+		return e.Problem.Detail
+	}
+	return e.Problem.Title
+}
+
+// Extensions implements the gqlerrors.ExtendedError interface.
+func (e *Error) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{"type": e.Problem.Type, "status": e.Problem.Status}
+	if len(e.Problem.InvalidParams) > 0 {
+		ext["invalid_params"] = e.Problem.InvalidParams
+	}
+	return ext
+}
+
+// extensions returns the extensions of the original error of a formatted
+// one. The executor formats the errors of the thunks before locating them,
+// which hides their extensions.
+func extensions(err error) map[string]interface{} {
+	for err != nil {
+		switch e := err.(type) {
+		case gqlerrors.ExtendedError:
+			return e.Extensions()
+		case gqlerrors.FormattedError:
+			err = e.OriginalError()
+		case *gqlerrors.Error:
+			err = e.OriginalError
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// operation returns the operation of the document selected by its name,
+// the only one when it has no name.
+func operation(doc *ast.Document, name string) *ast.OperationDefinition {
+	var found *ast.OperationDefinition
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if name == "" {
+			if found != nil {
+				return nil
+			}
+			found = op
+			continue
+		}
+		if op.Name != nil && op.Name.Value == name {
+			return op
+		}
+	}
+	return found
+}
@@ -0,0 +1,164 @@
+package gql
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	"github.com/jdelobel/go-api/internal/platform/web"
+)
+
+// testSchema lists items, each with a list of children.
+func testSchema(t *testing.T) graphql.Schema {
+	item := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Item",
+		Fields: graphql.Fields{
+			"name": &graphql.Field{Type: graphql.String},
+		},
+	})
+	item.AddFieldConfig("children", &graphql.Field{
+		Type: graphql.NewList(item),
+		Args: graphql.FieldConfigArgument{
+			"first": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 10},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return []map[string]interface{}{{"name": "child"}}, nil
+		},
+	})
+
+	problem := web.Problem{Type: "/problems/item-not-found", Title: "Item not found", Status: http.StatusNotFound}
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"items": &graphql.Field{
+					Type: graphql.NewList(item),
+					Args: graphql.FieldConfigArgument{
+						"first": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 20},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return []map[string]interface{}{{"name": "item"}}, nil
+					},
+				},
+				"missing": &graphql.Field{
+					Type: item,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return func() (interface{}, error) { return nil, &Error{Problem: problem} }, nil
+					},
+				},
+			},
+		}),
+		Mutation: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Mutation",
+			Fields: graphql.Fields{
+				"touch": &graphql.Field{
+					Type: graphql.Boolean,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return true, nil
+					},
+				},
+			},
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+func TestCost(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		variables  map[string]interface{}
+		depth      int
+		complexity int
+	}{
+		{name: "field", query: `{ items(first: 2) { name } }`, depth: 2, complexity: 1 + 2*1},
+		{name: "default size", query: `{ items { name } }`, depth: 2, complexity: 1 + 20*1},
+		{name: "nested", query: `{ items(first: 2) { name children(first: 3) { name } } }`, depth: 3, complexity: 1 + 2*(1+1+3*1)},
+		{name: "variable", query: `query($n: Int) { items(first: $n) { name } }`, variables: map[string]interface{}{"n": float64(5)}, depth: 2, complexity: 1 + 5*1},
+		{name: "fragment", query: `{ items(first: 2) { ...f } } fragment f on Item { name children(first: 1) { name } }`, depth: 3, complexity: 1 + 2*(1+1+1)},
+		{name: "introspection", query: `{ __typename items(first: 1) { name } }`, depth: 2, complexity: 2},
+	}
+
+	schema := testSchema(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := (&Executor{Schema: schema, MaxDepth: tt.depth - 1}).Execute(context.Background(), Request{Query: tt.query, Variables: tt.variables}, false)
+			if !res.HasErrors() || !strings.Contains(res.Errors[0].Message, "depth") {
+				t.Errorf("depth over %d: errors = %v, want a depth error", tt.depth-1, res.Errors)
+			}
+			res = (&Executor{Schema: schema, MaxComplexity: tt.complexity - 1}).Execute(context.Background(), Request{Query: tt.query, Variables: tt.variables}, false)
+			if !res.HasErrors() || !strings.Contains(res.Errors[0].Message, "complexity") {
+				t.Errorf("complexity over %d: errors = %v, want a complexity error", tt.complexity-1, res.Errors)
+			}
+			res = (&Executor{Schema: schema, MaxDepth: tt.depth, MaxComplexity: tt.complexity}).Execute(context.Background(), Request{Query: tt.query, Variables: tt.variables}, false)
+			if res.HasErrors() {
+				t.Errorf("at the limits: errors = %v, want none", res.Errors)
+			}
+		})
+	}
+}
+
+func TestCostOverflow(t *testing.T) {
+	// The huge sizes of a would wrap its complexity negative, letting b in
+	// although it is over the limit on its own.
+	query := `{
+		a: items(first: 2147483647) { children(first: 2147483647) { r0: name r1: name r2: name } }
+		b: items(first: 100) { children(first: 50) { name } }
+	}`
+	tests := []struct {
+		name     string
+		maxSizes map[string]int
+	}{
+		{name: "saturated"},
+		{name: "clamped", maxSizes: map[string]int{"Query.items": 100, "Item.children": 50}},
+	}
+
+	schema := testSchema(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &Executor{Schema: schema, MaxComplexity: 5000, MaxSizes: tt.maxSizes}
+			res := e.Execute(context.Background(), Request{Query: query}, false)
+			if res.Data != nil || !res.HasErrors() || !strings.Contains(res.Errors[0].Message, "complexity") {
+				t.Errorf("errors = %v, want a complexity error", res.Errors)
+			}
+		})
+	}
+
+	// The resolvers refuse the sizes over the maximum, which is what they
+	// cost.
+	e := &Executor{Schema: schema, MaxComplexity: 1 + 100, MaxSizes: map[string]int{"Query.items": 100}}
+	if res := e.Execute(context.Background(), Request{Query: `{ items(first: 2147483647) { name } }`}, false); res.HasErrors() {
+		t.Errorf("clamped size: errors = %v, want none", res.Errors)
+	}
+}
+
+func TestExecute(t *testing.T) {
+	e := &Executor{Schema: testSchema(t)}
+	ctx := context.Background()
+
+	if res := e.Execute(ctx, Request{Query: `mutation { touch }`}, false); !res.HasErrors() || res.Data != nil {
+		t.Errorf("mutation over GET = %v, want refused", res)
+	}
+	if res := e.Execute(ctx, Request{Query: `mutation { touch }`}, true); res.HasErrors() {
+		t.Errorf("mutation = %v, want executed", res.Errors)
+	}
+	if res := e.Execute(ctx, Request{Query: `{ items { `}, false); !res.HasErrors() {
+		t.Errorf("syntax error = %v, want refused", res)
+	}
+	if res := e.Execute(ctx, Request{Query: `query a { items { name } } query b { items { name } }`}, false); !res.HasErrors() {
+		t.Errorf("several operations without a name = %v, want refused", res)
+	}
+
+	res := e.Execute(ctx, Request{Query: `{ missing { name } }`}, false)
+	if len(res.Errors) != 1 {
+		t.Fatalf("errors = %v, want 1", res.Errors)
+	}
+	if ext := res.Errors[0].Extensions; ext["status"] != http.StatusNotFound || ext["type"] != "/problems/item-not-found" {
+		t.Errorf("extensions = %v, want the problem", ext)
+	}
+}
@@ -0,0 +1,75 @@
+package gql
+
+import (
+	"context"
+	"sync"
+)
+
+// BatchFunc fetches the values of a batch of keys. It returns the value and
+// the error of each key, in the order of the keys.
+type BatchFunc func(ctx context.Context, keys []string) ([]interface{}, []error)
+
+// Loader batches and caches the loads of the values of a request, like a
+// DataLoader. The resolvers load their values as thunks, which the
+// executor calls once the fields of a level are resolved: the first call
+// fetches all the keys loaded meanwhile at once. A Loader must not outlive
+// its request.
+type Loader struct {
+	fetch BatchFunc
+
+	mu      sync.Mutex
+	pending []string
+	results map[string]*result
+}
+
+// result is the value of a key, known once its batch is fetched.
+type result struct {
+	done  bool
+	value interface{}
+	err   error
+}
+
+// NewLoader returns a loader fetching its batches with the function.
+func NewLoader(fetch BatchFunc) *Loader {
+	return &Loader{fetch: fetch, results: make(map[string]*result)}
+}
+
+// Load returns the thunk of the value of the key. The keys already loaded
+// aren't fetched again.
+func (l *Loader) Load(ctx context.Context, key string) func() (interface{}, error) {
+	l.mu.Lock()
+	res, ok := l.results[key]
+	if !ok {
+		res = &result{}
+		l.results[key] = res
+		l.pending = append(l.pending, key)
+	}
+	l.mu.Unlock()
+
+	return func() (interface{}, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if !res.done {
+			l.dispatch(ctx)
+		}
+		return res.value, res.err
+	}
+}
+
+// dispatch fetches the pending keys. It is called with the lock held.
+func (l *Loader) dispatch(ctx context.Context) {
+	keys := l.pending
+	l.pending = nil
+
+	values, errs := l.fetch(ctx, keys)
+	for i, key := range keys {
+		res := l.results[key]
+		res.done = true
+		if i < len(values) {
+			res.value = values[i]
+		}
+		if i < len(errs) {
+			res.err = errs[i]
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package gql
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestLoader(t *testing.T) {
+	var batches [][]string
+	l := NewLoader(func(ctx context.Context, keys []string) ([]interface{}, []error) {
+		batches = append(batches, keys)
+		values := make([]interface{}, len(keys))
+		errs := make([]error, len(keys))
+		for i, key := range keys {
+			if key == "missing" {
+				errs[i] = errors.New("not found")
+				continue
+			}
+			values[i] = strings.ToUpper(key)
+		}
+		return values, errs
+	})
+
+	ctx := context.Background()
+	a, b, again, missing := l.Load(ctx, "a"), l.Load(ctx, "b"), l.Load(ctx, "a"), l.Load(ctx, "missing")
+
+	for _, tt := range []struct {
+		thunk func() (interface{}, error)
+		want  interface{}
+		err   bool
+	}{
+		{thunk: a, want: "A"},
+		{thunk: b, want: "B"},
+		{thunk: again, want: "A"},
+		{thunk: missing, err: true},
+	} {
+		got, err := tt.thunk()
+		if (err != nil) != tt.err || (!tt.err && got != tt.want) {
+			t.Errorf("thunk() = %v, %v, want %v", got, err, tt.want)
+		}
+	}
+
+	if got, err := l.Load(ctx, "b")(); got != "B" || err != nil {
+		t.Errorf("Load(b) after its batch = %v, %v, want B", got, err)
+	}
+	if got, _ := l.Load(ctx, "c")(); got != "C" {
+		t.Errorf("Load(c) = %v, want C", got)
+	}
+
+	want := [][]string{{"a", "b", "missing"}, {"c"}}
+	if !reflect.DeepEqual(batches, want) {
+		t.Errorf("batches = %v, want %v", batches, want)
+	}
+}
@@ -0,0 +1,54 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// gradient returns an image getting brighter from left to right.
+func gradient(w, h int) image.Image {
+	m := image.NewGray(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			m.SetGray(x, y, color.Gray{Y: uint8(x * 255 / w)})
+		}
+	}
+	return m
+}
+
+func TestDHash(t *testing.T) {
+	src := halves(400, 200)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, Transform(src, Options{Width: 120}), &jpeg.Options{Quality: 50}); err != nil {
+		t.Fatal(err)
+	}
+	recompressed, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		m    image.Image
+		max  int
+		min  int
+	}{
+		{name: "same image", m: src, max: 0},
+		{name: "resized and recompressed", m: recompressed, max: 5},
+		{name: "different image", m: gradient(400, 200), min: 20},
+	}
+
+	h := DHash(src)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := Distance(h, DHash(tt.m))
+			if d < tt.min || (tt.min == 0 && d > tt.max) {
+				t.Errorf("Distance = %d, want between %d and %d", d, tt.min, tt.max)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+// Package imaging decodes, resizes and encodes images with pure Go codecs.
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+
+	// Register the WebP decoder, Go has no pure WebP encoder.
+	_ "golang.org/x/image/webp"
+)
+
+// Fit modes of a resize.
+const (
+	// FitContain scales the image to fit inside the box, keeping its ratio.
+	FitContain = "contain"
+
+	// FitCover scales the image to cover the box and crops the overflow
+	// around the focal point.
+	FitCover = "cover"
+
+	// FitFill stretches the image to the box.
+	FitFill = "fill"
+)
+
+var (
+	// ErrUnknownFormat occurs when an image can't be decoded or no encoder
+	// exists for the requested format.
+	ErrUnknownFormat = errors.New("Unknown image format")
+
+	// ErrTooManyPixels occurs when the dimensions of an image exceed the
+	// decoding limit.
+	ErrTooManyPixels = errors.New("Image has too many pixels")
+)
+
+// Options describes a resize. A zero width or height is computed from the
+// other one to keep the ratio of the source.
+type Options struct {
+	Width  int
+	Height int
+	Fit    string
+
+	// FocusX and FocusY locate the focal point kept by FitCover, as
+	// fractions of the source width and height.
+	FocusX float64
+	FocusY float64
+}
+
+// Encoder writes an image in a given format.
+type Encoder func(w io.Writer, m image.Image) error
+
+// format is a registered output format.
+type format struct {
+	contentType string
+	encode      Encoder
+}
+
+// formats holds the output formats by name.
+var formats = struct {
+	sync.RWMutex
+	m map[string]format
+}{
+	m: make(map[string]format),
+}
+
+func init() {
+	RegisterFormat("jpeg", "image/jpeg", encodeJPEG)
+	RegisterFormat("png", "image/png", png.Encode)
+	RegisterFormat("gif", "image/gif", func(w io.Writer, m image.Image) error {
+		return gif.Encode(w, m, nil)
+	})
+}
+
+// RegisterFormat adds an output format, like a WebP encoder backed by a C
+// library in builds which can afford one.
+func RegisterFormat(name, contentType string, enc Encoder) {
+	formats.Lock()
+	defer formats.Unlock()
+	formats.m[name] = format{contentType: contentType, encode: enc}
+}
+
+// Formats returns the names of the registered output formats.
+func Formats() []string {
+	formats.RLock()
+	defer formats.RUnlock()
+	names := make([]string, 0, len(formats.m))
+	for name := range formats.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ContentType returns the MIME type of an output format.
+func ContentType(name string) (string, bool) {
+	formats.RLock()
+	defer formats.RUnlock()
+	f, ok := formats.m[name]
+	return f.contentType, ok
+}
+
+// Decode reads an image and returns it with the name of its format. Images
+// with more than maxPixels pixels are rejected from their header, before
+// being decoded. Only the header is buffered, the image is decoded from r.
+func Decode(r io.Reader, maxPixels int) (image.Image, string, error) {
+	var head bytes.Buffer
+	cfg, _, err := image.DecodeConfig(io.TeeReader(r, &head))
+	if err != nil {
+		return nil, "", errors.Wrap(ErrUnknownFormat, err.Error())
+	}
+	if maxPixels > 0 && cfg.Width*cfg.Height > maxPixels {
+		return nil, "", errors.Wrapf(ErrTooManyPixels, "%dx%d", cfg.Width, cfg.Height)
+	}
+
+	m, name, err := image.Decode(io.MultiReader(&head, r))
+	if err != nil {
+		return nil, "", errors.Wrap(err, "Decode")
+	}
+	return m, name, nil
+}
+
+// Encode writes the image in the named format.
+func Encode(w io.Writer, m image.Image, name string) error {
+	formats.RLock()
+	f, ok := formats.m[name]
+	formats.RUnlock()
+	if !ok {
+		return errors.Wrapf(ErrUnknownFormat, "Format: %s", name)
+	}
+	return errors.Wrapf(f.encode(w, m), "Encode: %s", name)
+}
+
+// Transform resizes the image as described by the options.
+func Transform(src image.Image, o Options) image.Image {
+	sb := src.Bounds()
+	sw, sh := float64(sb.Dx()), float64(sb.Dy())
+	if sw == 0 || sh == 0 {
+		return src
+	}
+
+	w, h := float64(o.Width), float64(o.Height)
+	switch {
+	case w == 0 && h == 0:
+		return src
+	case w == 0:
+		w = sw * h / sh
+	case h == 0:
+		h = sh * w / sw
+	}
+
+	srcRect := sb
+	switch o.Fit {
+	case FitFill:
+	case FitCover:
+		s := math.Max(w/sw, h/sh)
+		cw, ch := w/s, h/s
+		x0 := clamp(o.FocusX*sw-cw/2, 0, sw-cw)
+		y0 := clamp(o.FocusY*sh-ch/2, 0, sh-ch)
+		srcRect = image.Rect(
+			sb.Min.X+round(x0), sb.Min.Y+round(y0),
+			sb.Min.X+round(x0+cw), sb.Min.Y+round(y0+ch),
+		)
+	default:
+		s := math.Min(w/sw, h/sh)
+		w, h = sw*s, sh*s
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, atLeastOne(round(w)), atLeastOne(round(h))))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, srcRect, draw.Src, nil)
+	return dst
+}
+
+// encodeJPEG flattens the transparent areas on white, as JPEG has no alpha
+// channel, and encodes the image.
+func encodeJPEG(w io.Writer, m image.Image) error {
+	dst := image.NewRGBA(m.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), m, m.Bounds().Min, draw.Over)
+	return jpeg.Encode(w, dst, &jpeg.Options{Quality: 85})
+}
+
+// clamp restricts v to the [min, max] range.
+func clamp(v, min, max float64) float64 {
+	return math.Max(min, math.Min(v, max))
+}
+
+// round rounds to the nearest integer.
+func round(v float64) int {
+	return int(math.Floor(v + 0.5))
+}
+
+// atLeastOne keeps a dimension from rounding down to zero.
+func atLeastOne(v int) int {
+	if v < 1 {
+		return 1
+	}
+	return v
+}
@@ -0,0 +1,116 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// halves returns an image with a red left half and a blue right half.
+func halves(w, h int) image.Image {
+	m := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			m.Set(x, y, c)
+		}
+	}
+	return m
+}
+
+func TestTransform(t *testing.T) {
+	src := halves(400, 200)
+
+	tests := []struct {
+		name   string
+		opts   Options
+		width  int
+		height int
+	}{
+		{name: "width only", opts: Options{Width: 100}, width: 100, height: 50},
+		{name: "height only", opts: Options{Height: 100}, width: 200, height: 100},
+		{name: "contain", opts: Options{Width: 100, Height: 100, Fit: FitContain}, width: 100, height: 50},
+		{name: "cover", opts: Options{Width: 100, Height: 100, Fit: FitCover, FocusX: 0.5, FocusY: 0.5}, width: 100, height: 100},
+		{name: "fill", opts: Options{Width: 100, Height: 100, Fit: FitFill}, width: 100, height: 100},
+		{name: "no size", opts: Options{}, width: 400, height: 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := Transform(src, tt.opts).Bounds()
+			if b.Dx() != tt.width || b.Dy() != tt.height {
+				t.Errorf("Transform = %dx%d, want %dx%d", b.Dx(), b.Dy(), tt.width, tt.height)
+			}
+		})
+	}
+
+	t.Run("cover keeps the focal point", func(t *testing.T) {
+		for _, f := range []struct {
+			x    float64
+			want color.RGBA
+		}{{0, color.RGBA{R: 255, A: 255}}, {1, color.RGBA{B: 255, A: 255}}} {
+			m := Transform(src, Options{Width: 10, Height: 10, Fit: FitCover, FocusX: f.x, FocusY: 0.5})
+			if got := m.At(5, 5).(color.RGBA); got != f.want {
+				t.Errorf("focus %v: center = %v, want %v", f.x, got, f.want)
+			}
+		}
+	})
+}
+
+func TestEncodeDecode(t *testing.T) {
+	src := halves(40, 20)
+
+	for _, name := range []string{"jpeg", "png", "gif"} {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Encode(&buf, src, name); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			m, format, err := Decode(&buf, 0)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if format != name || m.Bounds() != src.Bounds() {
+				t.Errorf("Decode = %s %v, want %s %v", format, m.Bounds(), name, src.Bounds())
+			}
+		})
+	}
+
+	t.Run("unknown format", func(t *testing.T) {
+		if err := Encode(&bytes.Buffer{}, src, "webp"); errors.Cause(err) != ErrUnknownFormat {
+			t.Errorf("Encode(webp) = %v, want %v", err, ErrUnknownFormat)
+		}
+	})
+
+	t.Run("too many pixels", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := Encode(&buf, src, "png"); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := Decode(&buf, 100); errors.Cause(err) != ErrTooManyPixels {
+			t.Errorf("Decode = %v, want %v", err, ErrTooManyPixels)
+		}
+	})
+
+	t.Run("header only", func(t *testing.T) {
+		var buf bytes.Buffer
+		enc := png.Encoder{CompressionLevel: png.NoCompression}
+		if err := enc.Encode(&buf, halves(200, 200)); err != nil {
+			t.Fatal(err)
+		}
+		size := buf.Len()
+		if _, _, err := Decode(&buf, 100); errors.Cause(err) != ErrTooManyPixels {
+			t.Fatalf("Decode = %v, want %v", err, ErrTooManyPixels)
+		}
+		if read := size - buf.Len(); read >= size/2 {
+			t.Errorf("Decode read %d of %d bytes, want the header only", read, size)
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package imaging
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// iptcNames names the datasets of the IPTC-IIM application record.
+var iptcNames = map[byte]string{
+	5:   "ObjectName",
+	25:  "Keywords",
+	55:  "DateCreated",
+	60:  "TimeCreated",
+	80:  "By-line",
+	85:  "By-lineTitle",
+	90:  "City",
+	95:  "Province-State",
+	101: "Country-PrimaryLocationName",
+	105: "Headline",
+	110: "Credit",
+	115: "Source",
+	116: "CopyrightNotice",
+	120: "Caption-Abstract",
+	122: "Writer-Editor",
+}
+
+// iptcRepeatable lists the datasets which may appear several times.
+var iptcRepeatable = map[string]bool{"Keywords": true, "By-line": true}
+
+// photoshopResources returns the IPTC-IIM block of the image resources
+// stored in a JPEG APP13 segment.
+func photoshopResources(b []byte) []byte {
+	for len(b) >= 12 && string(b[:4]) == "8BIM" {
+		id := binary.BigEndian.Uint16(b[4:6])
+
+		// The resource name is a padded Pascal string.
+		nameLen := 1 + int(b[6])
+		nameLen += nameLen % 2
+		if 6+nameLen+4 > len(b) {
+			return nil
+		}
+		b = b[6+nameLen:]
+
+		size := int(binary.BigEndian.Uint32(b[:4]))
+		if 4+size > len(b) {
+			return nil
+		}
+		if id == 0x0404 {
+			return b[4 : 4+size]
+		}
+
+		// The pad byte of an odd sized last resource may be missing.
+		next := 4 + size + size%2
+		if next > len(b) {
+			return nil
+		}
+		b = b[next:]
+	}
+	return nil
+}
+
+// iptcTags decodes the datasets of the application record.
+func iptcTags(b []byte) map[string]interface{} {
+	tags := make(map[string]interface{})
+	for len(b) >= 5 && b[0] == 0x1c {
+		record, dataset := b[1], b[2]
+		size := int(binary.BigEndian.Uint16(b[3:5]))
+
+		// Extended datasets are only used for binary records.
+		if size&0x8000 != 0 || 5+size > len(b) {
+			break
+		}
+		data := b[5 : 5+size]
+		b = b[5+size:]
+
+		if record != 2 || !utf8.Valid(data) {
+			continue
+		}
+		name, ok := iptcNames[dataset]
+		if !ok {
+			name = "2:" + strconv.Itoa(int(dataset))
+		}
+		v := strings.TrimSpace(string(data))
+		if !iptcRepeatable[name] {
+			tags[name] = v
+			continue
+		}
+		list, _ := tags[name].([]interface{})
+		tags[name] = append(list, v)
+	}
+	return tags
+}
+
+// iptcTime combines the DateCreated and TimeCreated datasets.
+func iptcTime(tags map[string]interface{}) (time.Time, bool) {
+	d, _ := tags["DateCreated"].(string)
+	if d == "" {
+		return time.Time{}, false
+	}
+	if tc, _ := tags["TimeCreated"].(string); tc != "" {
+		if t, err := time.Parse("20060102150405-0700", d+tc); err == nil {
+			return t, true
+		}
+		if t, err := time.Parse("20060102150405", d+tc); err == nil {
+			return t, true
+		}
+	}
+	t, err := time.Parse("20060102", d)
+	return t, err == nil
+}
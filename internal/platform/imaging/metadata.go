@@ -0,0 +1,254 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	// Register the TIFF decoder, TIFF originals carry their metadata too.
+	_ "golang.org/x/image/tiff"
+)
+
+// Headers of the metadata segments of JPEG files.
+const (
+	jpegEXIF = "Exif\x00\x00"
+	jpegXMP  = "http://ns.adobe.com/xap/1.0/\x00"
+	jpegPS   = "Photoshop 3.0\x00"
+	pngSig   = "\x89PNG\r\n\x1a\n"
+)
+
+// Info holds the dimensions and the metadata embedded in an image. The
+// summary fields are read from EXIF first, then IPTC and XMP.
+type Info struct {
+	Format      string
+	Width       int
+	Height      int
+	Orientation int
+	Camera      string
+	CapturedAt  time.Time
+	Copyright   string
+	Caption     string
+
+	// EXIF, IPTC and XMP hold the raw tags by name.
+	EXIF map[string]interface{}
+	IPTC map[string]interface{}
+	XMP  map[string]interface{}
+}
+
+// segments locates the metadata blocks of a file. The slices share the
+// memory of the file so that they can be edited in place.
+type segments struct {
+	exif []byte
+	iptc []byte
+	xmp  [][]byte
+
+	// chunks holds the offsets of the PNG chunks holding metadata, which
+	// need a new CRC when edited.
+	chunks []int
+}
+
+// ReadInfo decodes the dimensions and the EXIF, IPTC and XMP metadata of a
+// JPEG, PNG or TIFF image. The other formats only report their dimensions.
+func ReadInfo(b []byte) (*Info, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, errors.Wrap(ErrUnknownFormat, err.Error())
+	}
+	info := Info{Format: format, Width: cfg.Width, Height: cfg.Height}
+
+	seg := locate(b)
+	if seg.exif != nil {
+		info.EXIF = exifTags(seg.exif)
+	}
+	if seg.iptc != nil {
+		info.IPTC = iptcTags(seg.iptc)
+	}
+	for _, packet := range seg.xmp {
+		if info.XMP == nil {
+			info.XMP = make(map[string]interface{})
+		}
+		xmpTags(packet, info.XMP)
+	}
+
+	info.summarize()
+	return &info, nil
+}
+
+// StripGPS removes the GPS location from the EXIF and XMP metadata of the
+// image, in place. The size of the file is unchanged. It reports whether a
+// location was found.
+func StripGPS(b []byte) bool {
+	seg := locate(b)
+
+	var stripped bool
+	if t, ok := newTIFF(seg.exif); ok && t.stripGPS() {
+		stripped = true
+	}
+	for _, packet := range seg.xmp {
+		if stripXMPGPS(packet) {
+			stripped = true
+		}
+	}
+
+	if stripped {
+		for _, off := range seg.chunks {
+			n := int(binary.BigEndian.Uint32(b[off:]))
+			binary.BigEndian.PutUint32(b[off+8+n:], crc32.ChecksumIEEE(b[off+4:off+8+n]))
+		}
+	}
+	return stripped
+}
+
+// summarize fills the summary fields from the raw tags.
+func (i *Info) summarize() {
+	i.Orientation = int(firstInt(i.EXIF["Orientation"], i.XMP["tiff:Orientation"]))
+
+	// Models often repeat the make, like Canon and Canon EOS 5D.
+	mk := firstString(i.EXIF["Make"], i.XMP["tiff:Make"])
+	model := firstString(i.EXIF["Model"], i.XMP["tiff:Model"])
+	if strings.HasPrefix(model, mk) {
+		mk = ""
+	}
+	i.Camera = strings.TrimSpace(mk + " " + model)
+
+	i.Copyright = firstString(i.EXIF["Copyright"], i.IPTC["CopyrightNotice"], i.XMP["dc:rights"])
+	i.Caption = firstString(i.EXIF["ImageDescription"], i.IPTC["Caption-Abstract"], i.XMP["dc:description"])
+
+	// EXIF dates have no time zone, they are read as UTC.
+	if s := firstString(i.EXIF["DateTimeOriginal"], i.EXIF["DateTime"]); s != "" {
+		if t, err := time.Parse("2006:01:02 15:04:05", s); err == nil {
+			i.CapturedAt = t
+			return
+		}
+	}
+	if t, ok := iptcTime(i.IPTC); ok {
+		i.CapturedAt = t
+		return
+	}
+	s := firstString(i.XMP["photoshop:DateCreated"], i.XMP["exif:DateTimeOriginal"], i.XMP["xmp:CreateDate"])
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			i.CapturedAt = t
+			return
+		}
+	}
+}
+
+// locate finds the metadata blocks of a JPEG, PNG or TIFF file.
+func locate(b []byte) segments {
+	var seg segments
+	switch {
+	case bytes.HasPrefix(b, []byte("\xff\xd8")):
+		locateJPEG(b, &seg)
+	case bytes.HasPrefix(b, []byte(pngSig)):
+		locatePNG(b, &seg)
+	default:
+		t, ok := newTIFF(b)
+		if !ok {
+			break
+		}
+		seg.exif = b
+		if d, ok := t.lookup(t.ifd0(), tagXMP); ok {
+			seg.xmp = append(seg.xmp, d)
+		}
+		if d, ok := t.lookup(t.ifd0(), tagIPTC); ok {
+			seg.iptc = d
+		}
+	}
+	return seg
+}
+
+// locateJPEG walks the segments preceding the image data.
+func locateJPEG(b []byte, seg *segments) {
+	i := 2
+	for i+4 <= len(b) && b[i] == 0xff {
+		marker := b[i+1]
+		switch {
+		case marker == 0xff:
+			i++
+			continue
+		case marker == 0xd8 || marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7):
+			i += 2
+			continue
+		case marker == 0xda || marker == 0xd9:
+			return
+		}
+
+		size := int(binary.BigEndian.Uint16(b[i+2:]))
+		if size < 2 || i+2+size > len(b) {
+			return
+		}
+		data := b[i+4 : i+2+size]
+		switch {
+		case marker == 0xe1 && bytes.HasPrefix(data, []byte(jpegEXIF)) && seg.exif == nil:
+			seg.exif = data[len(jpegEXIF):]
+		case marker == 0xe1 && bytes.HasPrefix(data, []byte(jpegXMP)):
+			seg.xmp = append(seg.xmp, data[len(jpegXMP):])
+		case marker == 0xed && bytes.HasPrefix(data, []byte(jpegPS)):
+			seg.iptc = photoshopResources(data[len(jpegPS):])
+		}
+		i += 2 + size
+	}
+}
+
+// locatePNG walks the chunks of the file, looking for the eXIf chunk and
+// the XMP packet of the iTXt chunks.
+func locatePNG(b []byte, seg *segments) {
+	for off := len(pngSig); off+12 <= len(b); {
+		n := int(binary.BigEndian.Uint32(b[off:]))
+		if n < 0 || off+12+n > len(b) {
+			return
+		}
+		typ, data := string(b[off+4:off+8]), b[off+8:off+8+n]
+
+		switch typ {
+		case "eXIf":
+			seg.exif = bytes.TrimPrefix(data, []byte(jpegEXIF))
+			seg.chunks = append(seg.chunks, off)
+		case "iTXt":
+			// keyword\0 flag method language\0 translated\0 text
+			parts := bytes.SplitN(data, []byte{0}, 2)
+			if len(parts) == 2 && string(parts[0]) == "XML:com.adobe.xmp" && len(parts[1]) > 2 && parts[1][0] == 0 {
+				rest := bytes.SplitN(parts[1][2:], []byte{0}, 3)
+				if len(rest) == 3 {
+					seg.xmp = append(seg.xmp, rest[2])
+					seg.chunks = append(seg.chunks, off)
+				}
+			}
+		case "IDAT", "IEND":
+			return
+		}
+		off += 12 + n
+	}
+}
+
+// firstString returns the first non empty string value.
+func firstString(vals ...interface{}) string {
+	for _, v := range vals {
+		if s, ok := v.(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// firstInt returns the first integer value.
+func firstInt(vals ...interface{}) int64 {
+	for _, v := range vals {
+		switch n := v.(type) {
+		case int64:
+			return n
+		case string:
+			if i, err := strconv.ParseInt(n, 10, 64); err == nil {
+				return i
+			}
+		}
+	}
+	return 0
+}
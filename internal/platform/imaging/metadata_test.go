@@ -0,0 +1,337 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image/jpeg"
+	"image/png"
+	"testing"
+	"time"
+)
+
+// entry is a TIFF directory entry used to build test files.
+type entry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// ascii returns an ASCII entry.
+func ascii(tag uint16, s string) entry {
+	return entry{tag: tag, typ: 2, count: uint32(len(s) + 1), value: append([]byte(s), 0)}
+}
+
+// buildTIFF writes a little endian TIFF structure with the IFD0 entries and
+// a GPS directory holding a latitude.
+func buildTIFF(ifd0 ...entry) []byte {
+	le := binary.LittleEndian
+	gps := []entry{
+		ascii(0x0001, "N"),
+		{tag: 0x0002, typ: 5, count: 3, value: rationals(48, 1, 51, 1, 24, 1)},
+	}
+
+	// Directories first, then the values which don't fit in the entries.
+	ifd0 = append(ifd0, entry{tag: tagGPSPointer, typ: 4, count: 1})
+	ifd0Off := 8
+	gpsOff := ifd0Off + 2 + 12*len(ifd0) + 4
+	dataOff := gpsOff + 2 + 12*len(gps) + 4
+
+	b := make([]byte, dataOff)
+	copy(b, "II*\x00")
+	le.PutUint32(b[4:], uint32(ifd0Off))
+	ifd0[len(ifd0)-1].value = make([]byte, 4)
+	le.PutUint32(ifd0[len(ifd0)-1].value, uint32(gpsOff))
+
+	write := func(off int, entries []entry) {
+		le.PutUint16(b[off:], uint16(len(entries)))
+		for i, e := range entries {
+			p := off + 2 + 12*i
+			le.PutUint16(b[p:], e.tag)
+			le.PutUint16(b[p+2:], e.typ)
+			le.PutUint32(b[p+4:], e.count)
+			if len(e.value) <= 4 {
+				copy(b[p+8:], e.value)
+				continue
+			}
+			le.PutUint32(b[p+8:], uint32(len(b)))
+			b = append(b, e.value...)
+		}
+	}
+	write(ifd0Off, ifd0)
+	write(gpsOff, gps)
+	return b
+}
+
+// rationals encodes little endian rationals.
+func rationals(v ...uint32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, n := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], n)
+	}
+	return b
+}
+
+// short encodes a little endian SHORT entry.
+func short(tag, v uint16) entry {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, v)
+	return entry{tag: tag, typ: 3, count: 1, value: b}
+}
+
+// segment writes a JPEG marker segment.
+func segment(marker byte, data []byte) []byte {
+	b := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(b[2:], uint16(len(data)+2))
+	return append(b, data...)
+}
+
+// iptcRecord writes an IPTC-IIM dataset of the application record.
+func iptcRecord(dataset byte, v string) []byte {
+	b := []byte{0x1c, 2, dataset, 0, 0}
+	binary.BigEndian.PutUint16(b[3:], uint16(len(v)))
+	return append(b, v...)
+}
+
+const testXMP = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:exif="http://ns.adobe.com/exif/1.0/"
+ exif:GPSLatitude="48,51.4N" exif:GPSLongitude="2,21.1E">
+<dc:description><rdf:Alt><rdf:li xml:lang="x-default">Eiffel tower</rdf:li></rdf:Alt></dc:description>
+<dc:subject><rdf:Bag><rdf:li>paris</rdf:li><rdf:li>tower</rdf:li></rdf:Bag></dc:subject>
+</rdf:Description></rdf:RDF></x:xmpmeta>`
+
+// testJPEG returns a JPEG with EXIF, IPTC and XMP metadata.
+func testJPEG(t testing.TB) []byte {
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, halves(40, 20), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tiff := buildTIFF(
+		ascii(0x010f, "Canon"),
+		ascii(0x0110, "Canon EOS 5D"),
+		short(0x0112, 6),
+		ascii(0x0132, "2019:07:14 10:30:00"),
+		ascii(0x8298, "ACME Photos"),
+	)
+
+	iptc := append(iptcRecord(120, "The tower at dusk"), iptcRecord(25, "paris")...)
+	iptc = append(iptc, iptcRecord(25, "tower")...)
+	ps := []byte(jpegPS + "8BIM\x04\x04\x00\x00\x00\x00\x00\x00")
+	binary.BigEndian.PutUint32(ps[len(ps)-4:], uint32(len(iptc)))
+	ps = append(ps, iptc...)
+
+	var b bytes.Buffer
+	b.Write(img.Bytes()[:2])
+	b.Write(segment(0xe1, append([]byte(jpegEXIF), tiff...)))
+	b.Write(segment(0xed, ps))
+	b.Write(segment(0xe1, append([]byte(jpegXMP), testXMP...)))
+	b.Write(img.Bytes()[2:])
+	return b.Bytes()
+}
+
+// testPNG returns a PNG with an eXIf chunk.
+func testPNG(t testing.TB) []byte {
+	return exifPNG(t, buildTIFF(ascii(0x8298, "ACME Photos")))
+}
+
+// exifPNG returns a PNG whose eXIf chunk holds the data.
+func exifPNG(t testing.TB, data []byte) []byte {
+	var img bytes.Buffer
+	if err := png.Encode(&img, halves(40, 20)); err != nil {
+		t.Fatal(err)
+	}
+
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	copy(chunk[4:], "eXIf")
+	chunk = append(chunk, data...)
+	chunk = append(chunk, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(chunk[8+len(data):], crc32.ChecksumIEEE(chunk[4:8+len(data)]))
+
+	// The chunk goes after the 33 bytes of the signature and IHDR chunk.
+	b := append([]byte{}, img.Bytes()[:33]...)
+	b = append(b, chunk...)
+	return append(b, img.Bytes()[33:]...)
+}
+
+func TestReadInfo(t *testing.T) {
+	info, err := ReadInfo(testJPEG(t))
+	if err != nil {
+		t.Fatalf("ReadInfo: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{name: "format", got: info.Format, want: "jpeg"},
+		{name: "width", got: info.Width, want: 40},
+		{name: "height", got: info.Height, want: 20},
+		{name: "orientation", got: info.Orientation, want: 6},
+		{name: "camera", got: info.Camera, want: "Canon EOS 5D"},
+		{name: "captured at", got: info.CapturedAt, want: time.Date(2019, 7, 14, 10, 30, 0, 0, time.UTC)},
+		{name: "copyright", got: info.Copyright, want: "ACME Photos"},
+		{name: "caption", got: info.Caption, want: "The tower at dusk"},
+		{name: "exif gps", got: info.EXIF["GPSLatitudeRef"], want: "N"},
+		{name: "iptc keywords", got: len(info.IPTC["Keywords"].([]interface{})), want: 2},
+		{name: "xmp description", got: info.XMP["dc:description"], want: "Eiffel tower"},
+		{name: "xmp subject", got: len(info.XMP["dc:subject"].([]interface{})), want: 2},
+		{name: "xmp gps", got: info.XMP["exif:GPSLatitude"], want: "48,51.4N"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+// gpsBomb returns a PNG whose GPS pointer has a count of 0x40000001, which
+// wraps the size goexif computes for it.
+func gpsBomb(t testing.TB) []byte {
+	le := binary.LittleEndian
+	b := make([]byte, 26)
+	copy(b, "II*\x00")
+	le.PutUint32(b[4:], 8)
+	le.PutUint16(b[8:], 1)
+	le.PutUint16(b[10:], tagGPSPointer)
+	le.PutUint16(b[12:], 4)
+	le.PutUint32(b[14:], 0x40000001)
+	return exifPNG(t, b)
+}
+
+func FuzzReadInfo(f *testing.F) {
+	f.Add(testJPEG(f))
+	f.Add(testPNG(f))
+	f.Add(buildTIFF(ascii(0x8298, "ACME Photos")))
+	f.Add(gpsBomb(f))
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		ReadInfo(b)
+	})
+}
+
+func TestValidTIFF(t *testing.T) {
+	le := binary.LittleEndian
+	tests := []struct {
+		name   string
+		modify func(b []byte)
+		want   bool
+	}{
+		{name: "valid", modify: func(b []byte) {}, want: true},
+		{name: "huge count", modify: func(b []byte) { le.PutUint32(b[8+2+4:], 0x40000001) }},
+		{name: "value out of range", modify: func(b []byte) { le.PutUint32(b[8+2+8:], 0xfffffff0) }},
+		{name: "directory out of range", modify: func(b []byte) { le.PutUint32(b[4:], 0xfff0) }},
+		{name: "directory loop", modify: func(b []byte) { le.PutUint32(b[8+2+12*2:], 8) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := buildTIFF(ascii(0x8298, "ACME Photos"))
+			tt.modify(b)
+			tf, _ := newTIFF(b)
+			if got := tf.valid(); got != tt.want {
+				t.Errorf("valid() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if info, err := ReadInfo(gpsBomb(t)); err != nil || info.EXIF != nil {
+		t.Errorf("ReadInfo() = %+v, %v, want no EXIF", info, err)
+	}
+}
+
+func TestStripGPS(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{name: "jpeg", file: testJPEG(t)},
+		{name: "png", file: testPNG(t)},
+		{name: "tiff", file: buildTIFF(ascii(0x8298, "ACME Photos"))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size := len(tt.file)
+			if !StripGPS(tt.file) {
+				t.Fatal("StripGPS found no location")
+			}
+			if len(tt.file) != size {
+				t.Errorf("size = %d, want %d", len(tt.file), size)
+			}
+			if StripGPS(tt.file) {
+				t.Error("StripGPS found a location twice")
+			}
+
+			seg := locate(tt.file)
+			tags := exifTags(seg.exif)
+			if tags["Copyright"] != "ACME Photos" {
+				t.Errorf("Copyright = %v, want ACME Photos", tags["Copyright"])
+			}
+			for name := range tags {
+				if len(name) > 3 && name[:3] == "GPS" {
+					t.Errorf("EXIF tag %s not stripped", name)
+				}
+			}
+			for _, packet := range seg.xmp {
+				xmp := map[string]interface{}{}
+				xmpTags(packet, xmp)
+				if _, ok := xmp["exif:GPSLatitude"]; ok || xmp["dc:description"] != "Eiffel tower" {
+					t.Errorf("XMP = %v, want no location", xmp)
+				}
+			}
+
+			switch tt.name {
+			case "jpeg":
+				_, err := jpeg.Decode(bytes.NewReader(tt.file))
+				if err != nil {
+					t.Errorf("jpeg.Decode: %v", err)
+				}
+			case "png":
+				_, err := png.Decode(bytes.NewReader(tt.file))
+				if err != nil {
+					t.Errorf("png.Decode: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestPhotoshopResources(t *testing.T) {
+	iptc := iptcRecord(120, "The tower at dusk")
+	resource := func(id uint16, data []byte, pad bool) []byte {
+		b := []byte("8BIM\x00\x00\x00\x00\x00\x00\x00\x00")
+		binary.BigEndian.PutUint16(b[4:], id)
+		binary.BigEndian.PutUint32(b[8:], uint32(len(data)))
+		b = append(b, data...)
+		if pad && len(data)%2 == 1 {
+			b = append(b, 0)
+		}
+		return b
+	}
+
+	tests := []struct {
+		name string
+		b    []byte
+		want []byte
+	}{
+		{name: "iptc", b: resource(0x0404, iptc, true), want: iptc},
+		{name: "after odd resource", b: append(resource(0x03ed, []byte("abc"), true), resource(0x0404, iptc, true)...), want: iptc},
+		{name: "odd last resource without pad", b: resource(0x03ed, []byte("abc"), false)},
+		{name: "truncated", b: resource(0x0404, iptc, true)[:20]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := photoshopResources(tt.b); !bytes.Equal(got, tt.want) {
+				t.Errorf("photoshopResources() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
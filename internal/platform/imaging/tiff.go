@@ -0,0 +1,262 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// TIFF tags locating the metadata blocks.
+const (
+	tagEXIFPointer    = 0x8769
+	tagGPSPointer     = 0x8825
+	tagInteropPointer = 0xa005
+	tagXMP            = 0x02bc
+	tagIPTC           = 0x83bb
+)
+
+// maxDirs bounds the number of directories of a TIFF structure.
+const maxDirs = 64
+
+// typeSizes holds the size in bytes of the TIFF field types.
+var typeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 13: 4}
+
+// ifdEntry is an entry of a TIFF image file directory. Data is the slice of
+// the TIFF bytes holding its value, whether inline or at an offset.
+type ifdEntry struct {
+	tag  uint16
+	data []byte
+}
+
+// tiffFile walks the directories of a TIFF structure, like the EXIF block of
+// a JPEG. Every offset is checked so that corrupt files are ignored.
+type tiffFile struct {
+	b     []byte
+	order binary.ByteOrder
+}
+
+// newTIFF returns the walker of b, which must start with a TIFF header.
+func newTIFF(b []byte) (*tiffFile, bool) {
+	if len(b) < 8 {
+		return nil, false
+	}
+	switch string(b[:4]) {
+	case "II*\x00":
+		return &tiffFile{b: b, order: binary.LittleEndian}, true
+	case "MM\x00*":
+		return &tiffFile{b: b, order: binary.BigEndian}, true
+	}
+	return nil, false
+}
+
+// ifd0 returns the offset of the first directory.
+func (t *tiffFile) ifd0() int {
+	return int(t.order.Uint32(t.b[4:8]))
+}
+
+// entries returns the entries of the directory at the offset.
+func (t *tiffFile) entries(off int) []ifdEntry {
+	if off < 8 || off+2 > len(t.b) {
+		return nil
+	}
+	n := int(t.order.Uint16(t.b[off:]))
+	if off+2+12*n > len(t.b) {
+		return nil
+	}
+
+	entries := make([]ifdEntry, 0, n)
+	for i := 0; i < n; i++ {
+		e := t.b[off+2+12*i:]
+		typ := t.order.Uint16(e[2:4])
+		size := typeSizes[typ] * int(t.order.Uint32(e[4:8]))
+		if size <= 0 {
+			continue
+		}
+
+		var data []byte
+		if size <= 4 {
+			data = e[8 : 8+size]
+		} else {
+			vo := int(t.order.Uint32(e[8:12]))
+			if vo < 0 || vo+size > len(t.b) {
+				continue
+			}
+			data = t.b[vo : vo+size]
+		}
+		entries = append(entries, ifdEntry{tag: t.order.Uint16(e[0:2]), data: data})
+	}
+	return entries
+}
+
+// lookup returns the data of the tag in the directory at the offset.
+func (t *tiffFile) lookup(off int, tag uint16) ([]byte, bool) {
+	for _, e := range t.entries(off) {
+		if e.tag == tag {
+			return e.data, true
+		}
+	}
+	return nil, false
+}
+
+// valid reports whether every directory goexif reads, the chain from IFD0
+// and the EXIF, GPS and interoperability ones, and every value of their
+// entries lie within the structure. goexif trusts the counts of the
+// entries: a corrupt one makes it allocate gigabytes.
+func (t *tiffFile) valid() bool {
+	seen := make(map[int]bool)
+	var dir func(off int) (int, bool)
+	dir = func(off int) (int, bool) {
+		if seen[off] || len(seen) == maxDirs || off < 8 || off+2 > len(t.b) {
+			return 0, false
+		}
+		seen[off] = true
+		n := int(t.order.Uint16(t.b[off:]))
+		end := off + 2 + 12*n
+		if end+4 > len(t.b) {
+			return 0, false
+		}
+
+		for i := 0; i < n; i++ {
+			e := t.b[off+2+12*i:]
+			tag, typ := t.order.Uint16(e[0:2]), t.order.Uint16(e[2:4])
+			count := int64(t.order.Uint32(e[4:8]))
+			size := int64(typeSizes[typ]) * count
+			if size > 4 && int64(t.order.Uint32(e[8:12]))+size > int64(len(t.b)) {
+				return 0, false
+			}
+
+			switch tag {
+			case tagEXIFPointer, tagGPSPointer, tagInteropPointer:
+				if typ != 4 && typ != 13 || count != 1 {
+					return 0, false
+				}
+				if _, ok := dir(int(t.order.Uint32(e[8:12]))); !ok {
+					return 0, false
+				}
+			}
+		}
+		return int(t.order.Uint32(t.b[end:])), true
+	}
+
+	for off := t.ifd0(); off != 0; {
+		next, ok := dir(off)
+		if !ok {
+			return false
+		}
+		off = next
+	}
+	return true
+}
+
+// stripGPS empties the GPS directory: its values are zeroed and its entry
+// count set to zero, leaving the size of the structure unchanged.
+func (t *tiffFile) stripGPS() bool {
+	ptr, ok := t.lookup(t.ifd0(), tagGPSPointer)
+	if !ok || len(ptr) != 4 {
+		return false
+	}
+	off := int(t.order.Uint32(ptr))
+	entries := t.entries(off)
+	if len(entries) == 0 {
+		return false
+	}
+
+	for _, e := range entries {
+		zero(e.data)
+	}
+	n := int(t.order.Uint16(t.b[off:]))
+	zero(t.b[off : off+2+12*n])
+	return true
+}
+
+// exifTags decodes the EXIF block and returns its tags by name. The
+// blocks goexif could choke on are ignored.
+func exifTags(b []byte) map[string]interface{} {
+	if t, ok := newTIFF(b); !ok || !t.valid() {
+		return nil
+	}
+	x, err := exif.Decode(bytes.NewReader(b))
+	if x == nil || (err != nil && exif.IsCriticalError(err)) {
+		return nil
+	}
+
+	tags := make(map[string]interface{})
+	x.Walk(walkFunc(func(name exif.FieldName, tag *tiff.Tag) error {
+		if strings.HasSuffix(string(name), "IFDPointer") {
+			return nil
+		}
+		if v := tagValue(tag); v != nil {
+			tags[string(name)] = v
+		}
+		return nil
+	}))
+	return tags
+}
+
+// walkFunc adapts a function to the exif.Walker interface.
+type walkFunc func(name exif.FieldName, tag *tiff.Tag) error
+
+// Walk implements the exif.Walker interface.
+func (f walkFunc) Walk(name exif.FieldName, tag *tiff.Tag) error {
+	return f(name, tag)
+}
+
+// tagValue converts a tag to a JSON friendly value. Rationals are kept
+// exact as "num/den" strings and binary values like maker notes are
+// skipped.
+func tagValue(tag *tiff.Tag) interface{} {
+	var vals []interface{}
+	switch tag.Format() {
+	case tiff.StringVal:
+		s, _ := tag.StringVal()
+		return strings.TrimSpace(strings.TrimRight(s, "\x00"))
+	case tiff.UndefVal:
+		s := strings.TrimRight(string(tag.Val), "\x00")
+		if s == "" || len(s) > 256 || strings.IndexFunc(s, func(r rune) bool { return r > unicode.MaxASCII || !unicode.IsPrint(r) }) >= 0 {
+			return nil
+		}
+		return s
+	case tiff.IntVal:
+		for i := 0; i < int(tag.Count); i++ {
+			v, _ := tag.Int64(i)
+			vals = append(vals, v)
+		}
+	case tiff.RatVal:
+		for i := 0; i < int(tag.Count); i++ {
+			n, d, _ := tag.Rat2(i)
+			vals = append(vals, ratString(n, d))
+		}
+	case tiff.FloatVal:
+		for i := 0; i < int(tag.Count); i++ {
+			v, _ := tag.Float(i)
+			vals = append(vals, v)
+		}
+	default:
+		return nil
+	}
+
+	switch len(vals) {
+	case 0:
+		return nil
+	case 1:
+		return vals[0]
+	}
+	return vals
+}
+
+// ratString formats a rational.
+func ratString(n, d int64) string {
+	return strconv.FormatInt(n, 10) + "/" + strconv.FormatInt(d, 10)
+}
+
+// zero clears the bytes.
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,125 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/xml"
+	"regexp"
+	"strings"
+)
+
+// rdfNS is the namespace of the RDF syntax XMP packets are written in.
+const rdfNS = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+
+// xmpPrefixes holds the prefixes of the XMP namespaces which are extracted.
+var xmpPrefixes = map[string]string{
+	"http://purl.org/dc/elements/1.1/":            "dc",
+	"http://ns.adobe.com/xap/1.0/":                "xmp",
+	"http://ns.adobe.com/xap/1.0/rights/":         "xmpRights",
+	"http://ns.adobe.com/photoshop/1.0/":          "photoshop",
+	"http://ns.adobe.com/exif/1.0/":               "exif",
+	"http://ns.adobe.com/tiff/1.0/":               "tiff",
+	"http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/": "Iptc4xmpCore",
+	"http://ns.useplus.org/ldf/xmp/1.0/":          "plus",
+}
+
+// xmpGPS matches the GPS properties of the exif namespace, written either
+// as attributes or as simple elements.
+var xmpGPS = regexp.MustCompile(`\sexif:GPS\w+="[^"]*"|<exif:GPS\w+>[^<]*</exif:GPS\w+>|<exif:GPS\w+\s*/>`)
+
+// xmpTags decodes the simple properties of an XMP packet by prefixed name.
+// Language alternatives keep their first value and arrays become lists.
+func xmpTags(packet []byte, tags map[string]interface{}) {
+	dec := xml.NewDecoder(bytes.NewReader(packet))
+	dec.Strict = false
+
+	var (
+		depth     int
+		descDepth = -1
+		prop      string
+		items     []interface{}
+		text      strings.Builder
+	)
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			switch {
+			case t.Name.Space == rdfNS && t.Name.Local == "Description":
+				descDepth = depth
+				for _, a := range t.Attr {
+					if name := xmpName(a.Name); name != "" {
+						tags[name] = a.Value
+					}
+				}
+			case descDepth > 0 && depth == descDepth+1:
+				prop, items = xmpName(t.Name), nil
+				text.Reset()
+			case t.Name.Space == rdfNS && t.Name.Local == "li":
+				text.Reset()
+			}
+
+		case xml.CharData:
+			text.Write(t)
+
+		case xml.EndElement:
+			switch {
+			case t.Name.Space == rdfNS && t.Name.Local == "li" && prop != "":
+				if v := strings.TrimSpace(text.String()); v != "" {
+					items = append(items, v)
+				}
+			case depth == descDepth+1 && prop != "":
+				switch {
+				case len(items) == 1 || (len(items) > 1 && xmpAlt(prop)):
+					tags[prop] = items[0]
+				case len(items) > 1:
+					tags[prop] = items
+				default:
+					if v := strings.TrimSpace(text.String()); v != "" {
+						tags[prop] = v
+					}
+				}
+				prop = ""
+			case depth == descDepth:
+				descDepth = -1
+			}
+			depth--
+		}
+	}
+}
+
+// xmpName returns the prefixed name of a property, or an empty string for
+// the namespaces which are not extracted.
+func xmpName(n xml.Name) string {
+	prefix, ok := xmpPrefixes[n.Space]
+	if !ok {
+		return ""
+	}
+	return prefix + ":" + n.Local
+}
+
+// xmpAlt reports whether the property is a language alternative, of which
+// only the default value is kept.
+func xmpAlt(prop string) bool {
+	switch prop {
+	case "dc:title", "dc:description", "dc:rights", "xmpRights:UsageTerms":
+		return true
+	}
+	return false
+}
+
+// stripXMPGPS blanks the GPS properties of the packet with spaces, which
+// keeps the size of the packet and its validity.
+func stripXMPGPS(packet []byte) bool {
+	locs := xmpGPS.FindAllIndex(packet, -1)
+	for _, loc := range locs {
+		for i := loc[0]; i < loc[1]; i++ {
+			packet[i] = ' '
+		}
+	}
+	return len(locs) > 0
+}
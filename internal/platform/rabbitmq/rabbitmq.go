@@ -66,6 +66,12 @@ func (rbmq *RabbitMQ) DeclareQueue(queueName string) (*amqp.Queue, error) {
 
 // Publish send a jsonMessage to the queue
 func (rbmq *RabbitMQ) Publish(queueName *string, jsonMessage []byte) error {
+	return rbmq.PublishID(queueName, "", jsonMessage)
+}
+
+// PublishID send a jsonMessage to the queue with the message id, which lets
+// the consumers drop the messages published more than once.
+func (rbmq *RabbitMQ) PublishID(queueName *string, messageID string, jsonMessage []byte) error {
 	if rbmq == nil {
 		return errors.Wrap(ErrInvalidRabbitMQProvided, "rbmq == nil")
 	}
@@ -81,6 +87,7 @@ func (rbmq *RabbitMQ) Publish(queueName *string, jsonMessage []byte) error {
 		false,
 		amqp.Publishing{
 			ContentType: "application/json",
+			MessageId:   messageID,
 			Body:        jsonMessage,
 		})
 	if err != nil {
@@ -0,0 +1,174 @@
+// Package rpc runs the gRPC services with the same request values, trace
+// IDs, logs and errors as the web package.
+package rpc
+
+import (
+	"context"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// TraceIDKey is the metadata key of the trace id, sent back in the header
+// of each call. The trace id of the caller is kept when it sends one, so
+// that a trace spans the services.
+var TraceIDKey = strings.ToLower(web.TraceIDHeader)
+
+// maxTraceID is the longest trace id kept from the callers.
+const maxTraceID = 128
+
+// UnaryValues sets the values of the unary calls on their context, like
+// web.App does for the requests, logs them, and converts their errors to
+// statuses. The interceptors of the services must come after it.
+func UnaryValues(l *log.Entry) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		ctx, v := newValues(ctx, l)
+		defer func() {
+			err = finish(ctx, v, info.FullMethod, recover(), err)
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// StreamValues is UnaryValues for the streaming calls.
+func StreamValues(l *log.Entry) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		ctx, v := newValues(ss.Context(), l)
+		defer func() {
+			err = finish(ctx, v, info.FullMethod, recover(), err)
+		}()
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// serverStream is a server stream with the context of the call values.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the context of the stream.
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// newValues sets the values of a call on its context and sends its trace
+// id back.
+func newValues(ctx context.Context, l *log.Entry) (context.Context, *web.Values) {
+	v := web.Values{
+		TraceID: uuid.New(),
+		Now:     time.Now(),
+		Log:     l,
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(TraceIDKey); len(ids) > 0 && ids[0] != "" && len(ids[0]) <= maxTraceID {
+			v.TraceID = ids[0]
+		}
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(TraceIDKey, v.TraceID))
+	return context.WithValue(ctx, web.KeyValues, &v), &v
+}
+
+// finish logs a call and returns its error as a status. A panic is logged
+// with its stack and returned as an internal error.
+func finish(ctx context.Context, v *web.Values, method string, panicked interface{}, err error) error {
+	if panicked != nil {
+		v.Log.Errorf("%s : Panic Caught : %s\n", v.TraceID, panicked)
+		v.Log.Errorf("%s : Stacktrace\n%s\n", v.TraceID, debug.Stack())
+		err = status.Error(codes.Internal, "unhandled")
+	}
+
+	// The internal errors caused by the deadline of the call, like the
+	// canceled queries, are timeouts.
+	if err != nil && ctx.Err() == context.DeadlineExceeded && web.LookupProblem(errors.Cause(err)).Status == http.StatusInternalServerError {
+		err = errors.Wrap(web.ErrTimeout, err.Error())
+	}
+
+	st := Status(err)
+	if err != nil && panicked == nil && st.Code() != codes.NotFound {
+		v.Log.Errorf("%s : %+v\n", v.TraceID, err)
+	}
+
+	var addr string
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	v.Log.Infof("%s : (%s) : %s -> %s (%s)", v.TraceID, st.Code(), method, addr, time.Since(v.Now))
+
+	return st.Err()
+}
+
+// statusCodes maps the status codes of the problems to the gRPC codes.
+var statusCodes = map[int]codes.Code{
+	http.StatusBadRequest:            codes.InvalidArgument,
+	http.StatusUnauthorized:          codes.Unauthenticated,
+	http.StatusForbidden:             codes.PermissionDenied,
+	http.StatusNotFound:              codes.NotFound,
+	http.StatusNotAcceptable:         codes.InvalidArgument,
+	http.StatusConflict:              codes.AlreadyExists,
+	http.StatusGone:                  codes.NotFound,
+	http.StatusPreconditionFailed:    codes.FailedPrecondition,
+	http.StatusRequestEntityTooLarge: codes.ResourceExhausted,
+	http.StatusUnsupportedMediaType:  codes.InvalidArgument,
+	http.StatusUnprocessableEntity:   codes.InvalidArgument,
+	http.StatusPreconditionRequired:  codes.FailedPrecondition,
+	http.StatusTooManyRequests:       codes.ResourceExhausted,
+	http.StatusNotImplemented:        codes.Unimplemented,
+	http.StatusServiceUnavailable:    codes.Unavailable,
+	http.StatusGatewayTimeout:        codes.DeadlineExceeded,
+}
+
+// Status returns the status of an error, looked up from the registered
+// problems like the HTTP responses. The invalid parameters are sent as a
+// BadRequest detail, and the details of the internal errors aren't sent.
+// The timeouts exceed the deadline of the call rather than make the service
+// unavailable. The errors which already are statuses are returned as is.
+func Status(err error) *status.Status {
+	if err == nil {
+		return status.New(codes.OK, "")
+	}
+	cause := errors.Cause(err)
+	if st, ok := status.FromError(cause); ok {
+		return st
+	}
+	switch cause {
+	case context.Canceled:
+		return status.New(codes.Canceled, cause.Error())
+	case web.ErrTimeout:
+		return status.New(codes.DeadlineExceeded, cause.Error())
+	}
+
+	p := web.NewProblem(err)
+	code, ok := statusCodes[p.Status]
+	if !ok {
+		code = codes.Internal
+	}
+	if code == codes.Internal {
+		return status.New(code, p.Title)
+	}
+
+	st := status.New(code, p.Detail)
+	if len(p.InvalidParams) == 0 {
+		return st
+	}
+	br := &errdetails.BadRequest{}
+	for _, inv := range p.InvalidParams {
+		br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{Field: inv.Fld, Description: inv.Msg})
+	}
+	if detailed, err := st.WithDetails(br); err == nil {
+		return detailed
+	}
+	return st
+}
@@ -0,0 +1,55 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/pkg/errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		code    codes.Code
+		message string
+		fields  []string
+	}{
+		{name: "nil", code: codes.OK},
+		{name: "not authorized", err: errors.Wrap(web.ErrNotAuthorized, "unknown bearer token"), code: codes.Unauthenticated, message: web.ErrNotAuthorized.Error()},
+		{name: "precondition required", err: web.ErrPreconditionRequired, code: codes.FailedPrecondition},
+		{name: "timeout", err: web.ErrTimeout, code: codes.DeadlineExceeded},
+		{name: "invalid", err: errors.Wrap(web.InvalidError{{Fld: "title", Err: "required", Msg: "is required"}}, ""), code: codes.InvalidArgument, fields: []string{"title"}},
+		{name: "internal", err: errors.New("pq: connection refused"), code: codes.Internal, message: web.NewProblem(errors.New("")).Title},
+		{name: "canceled", err: errors.Wrap(context.Canceled, "query"), code: codes.Canceled},
+		{name: "status", err: status.Error(codes.Unavailable, "too slow"), code: codes.Unavailable, message: "too slow"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := Status(tt.err)
+			if st.Code() != tt.code {
+				t.Errorf("code = %s, want %s", st.Code(), tt.code)
+			}
+			if tt.message != "" && st.Message() != tt.message {
+				t.Errorf("message = %q, want %q", st.Message(), tt.message)
+			}
+
+			var fields []string
+			for _, d := range st.Details() {
+				if br, ok := d.(*errdetails.BadRequest); ok {
+					for _, v := range br.FieldViolations {
+						fields = append(fields, v.Field)
+					}
+				}
+			}
+			if len(fields) != len(tt.fields) || (len(fields) > 0 && fields[0] != tt.fields[0]) {
+				t.Errorf("field violations = %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
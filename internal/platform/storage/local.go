@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Local stores blobs as files under a directory.
+type Local struct {
+	dir     string
+	baseURL string
+}
+
+// NewLocal returns a store writing to dir. The blob URLs are the keys
+// appended to baseURL.
+func NewLocal(dir, baseURL string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "NewLocal: %s", dir)
+	}
+	return &Local{dir: dir, baseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+// Put implements the Store interface. The file is written aside and renamed
+// so that readers never see a partial blob.
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return errors.Wrapf(err, "Put: %s", key)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".upload-")
+	if err != nil {
+		return errors.Wrapf(err, "Put: %s", key)
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Put: %s", key)
+	}
+	if size >= 0 && n != size {
+		return errors.Errorf("Put: %s: wrote %d bytes, expected %d", key, n, size)
+	}
+
+	return errors.Wrapf(os.Rename(tmp.Name(), name), "Put: %s", key)
+}
+
+// Get implements the Store interface.
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	name, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, errors.Wrapf(ErrNotFound, "Key: %s", key)
+	}
+	return f, errors.Wrapf(err, "Get: %s", key)
+}
+
+// Delete implements the Store interface.
+func (l *Local) Delete(ctx context.Context, key string) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(name)
+	if os.IsNotExist(err) {
+		return errors.Wrapf(ErrNotFound, "Key: %s", key)
+	}
+	return errors.Wrapf(err, "Delete: %s", key)
+}
+
+// URL implements the Store interface.
+func (l *Local) URL(key string) string {
+	return l.baseURL + "/" + strings.TrimPrefix(key, "/")
+}
+
+// path returns the file name of the blob.
+func (l *Local) path(key string) (string, error) {
+	k, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(k)), nil
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNotFound occurs when no blob exists with the requested key.
+	ErrNotFound = errors.New("Blob not found")
+
+	// ErrInvalidKey occurs when a key would escape the store.
+	ErrInvalidKey = errors.New("Invalid blob key")
+)
+
+// Store is a blob storage backend. Keys are slash separated paths like
+// images/<id>/original/<sha256>.
+type Store interface {
+
+	// Put stores size bytes read from r under the key, replacing any
+	// existing blob.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+
+	// Get returns a reader over the blob stored under the key. The caller
+	// must close it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Delete removes the blob stored under the key.
+	Delete(ctx context.Context, key string) error
+
+	// URL returns the address clients can fetch the blob from.
+	URL(key string) string
+}
+
+// cleanKey normalizes the key and rejects the ones leaving the store root.
+func cleanKey(key string) (string, error) {
+	k := path.Clean("/" + key)
+	if k == "/" || strings.Contains(key, "..") {
+		return "", errors.Wrapf(ErrInvalidKey, "Key: %s", key)
+	}
+	return strings.TrimPrefix(k, "/"), nil
+}
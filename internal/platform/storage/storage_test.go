@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// memoryS3 is a minimal S3 service keeping the objects in memory.
+type memoryS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+}
+
+func (m *memoryS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch r.Method {
+	case "PUT":
+		b, _ := ioutil.ReadAll(r.Body)
+		m.objects[r.URL.Path] = b
+		m.types[r.URL.Path] = r.Header.Get("Content-Type")
+	case "GET":
+		b, ok := m.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", m.types[r.URL.Path])
+		w.Write(b)
+	case "DELETE":
+		if _, ok := m.objects[r.URL.Path]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(m.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestStores(t *testing.T) {
+	srv := httptest.NewServer(&memoryS3{objects: map[string][]byte{}, types: map[string]string{}})
+	defer srv.Close()
+
+	s3, err := NewS3(S3Config{Endpoint: srv.URL, Bucket: "images", AccessKey: "key", SecretKey: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := NewLocal(t.TempDir(), "/v1/blobs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		store Store
+		url   string
+	}{
+		{name: "local", store: local, url: "/v1/blobs/images/1/original"},
+		{name: "s3", store: s3, url: srv.URL + "/images/images/1/original"},
+	}
+
+	ctx := context.Background()
+	content := []byte("image content")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.store.Put(ctx, "images/1/original", bytes.NewReader(content), int64(len(content)), "image/png"); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+
+			rc, err := tt.store.Get(ctx, "images/1/original")
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			got, _ := ioutil.ReadAll(rc)
+			rc.Close()
+			if !bytes.Equal(got, content) {
+				t.Errorf("Get = %q, want %q", got, content)
+			}
+
+			if got := tt.store.URL("images/1/original"); got != tt.url {
+				t.Errorf("URL = %q, want %q", got, tt.url)
+			}
+
+			if err := tt.store.Delete(ctx, "images/1/original"); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if _, err := tt.store.Get(ctx, "images/1/original"); errors.Cause(err) != ErrNotFound {
+				t.Errorf("Get after Delete = %v, want %v", err, ErrNotFound)
+			}
+
+			if err := tt.store.Put(ctx, "../escape", bytes.NewReader(content), int64(len(content)), ""); errors.Cause(err) != ErrInvalidKey {
+				t.Errorf("Put(../escape) = %v, want %v", err, ErrInvalidKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package web
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrBodyTooLarge occurs when the request body, once decompressed, exceeds
+// the maximum size of the route.
+var ErrBodyTooLarge = errors.New("Request body is too large")
+
+func init() {
+	RegisterError(ErrBodyTooLarge, ProblemType{Type: ProblemBaseURI + "body-too-large", Title: "Request body is too large", Status: http.StatusRequestEntityTooLarge})
+}
+
+// body reads the request body, decompressing it when it is gzip encoded,
+// and fails with ErrBodyTooLarge past the limit. The errors are returned
+// on read so that the handlers report them like decoding errors.
+type body struct {
+	raw      io.ReadCloser
+	r        io.Reader
+	encoding string
+	limit    int64
+	n        int64
+	err      error
+}
+
+// newBody wraps the request body. A limit of 0 reads it whole.
+func newBody(r *http.Request, limit int64) *body {
+	return &body{raw: r.Body, encoding: strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))), limit: limit}
+}
+
+// Read implements the io.Reader interface.
+func (b *body) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.r == nil {
+		switch b.encoding {
+		case "", "identity":
+			b.r = b.raw
+		case "gzip", "x-gzip":
+			zr, err := gzip.NewReader(b.raw)
+			if err != nil {
+				b.err = errors.Wrap(ErrMalformedBody, "gzip: "+err.Error())
+				return 0, b.err
+			}
+			b.r = zr
+		default:
+			b.err = errors.Wrapf(ErrUnsupportedMediaType, "Content-Encoding: %s", b.encoding)
+			return 0, b.err
+		}
+	}
+
+	// One byte more than the limit is read to tell a body of exactly the
+	// limit from a larger one.
+	if b.limit > 0 && int64(len(p)) > b.limit-b.n+1 {
+		p = p[:b.limit-b.n+1]
+	}
+	n, err := b.r.Read(p)
+	b.n += int64(n)
+	if b.limit > 0 && b.n > b.limit {
+		b.err = errors.Wrapf(ErrBodyTooLarge, "Max: %d bytes", b.limit)
+		return n - int(b.n-b.limit), b.err
+	}
+	return n, err
+}
+
+// Close implements the io.Closer interface.
+func (b *body) Close() error {
+	return b.raw.Close()
+}
+
+// BodyError returns the error which stopped reading the request body, like
+// ErrBodyTooLarge, in place of err, the error of the decoder which may hide
+// it. Otherwise err is returned.
+func BodyError(r io.Reader, err error) error {
+	if b, ok := r.(*body); ok && b.err != nil {
+		return b.err
+	}
+	return err
+}
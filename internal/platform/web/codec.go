@@ -0,0 +1,351 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Media types of the codecs registered by the package.
+const (
+	JSONContentType    = "application/json"
+	MsgPackContentType = "application/msgpack"
+	CBORContentType    = "application/cbor"
+	XMLContentType     = "application/xml"
+)
+
+// A Codec encodes the responses and decodes the request bodies of a media
+// type. Marshal indents its output when pretty is set, if the format allows
+// it. Unmarshal reports the fields which don't exist in v when strict is
+// set; the errors it returns are reported to the client as is.
+type Codec struct {
+	Marshal   func(v interface{}, pretty bool) ([]byte, error)
+	Unmarshal func(r io.Reader, v interface{}, strict bool) error
+}
+
+// codecs holds the registered codecs. The media types are offered in
+// registration order, so the first one is the default.
+var codecs = struct {
+	sync.RWMutex
+	types []string
+	m     map[string]Codec
+}{m: make(map[string]Codec)}
+
+func init() {
+	RegisterCodec(JSONContentType, Codec{Marshal: marshalJSON, Unmarshal: unmarshalJSON})
+	RegisterCodec(MsgPackContentType, Codec{Marshal: marshalMsgPack, Unmarshal: unmarshalMsgPack})
+	RegisterCodec(CBORContentType, Codec{Marshal: marshalCBOR, Unmarshal: unmarshalCBOR})
+	RegisterCodec(XMLContentType, Codec{Marshal: marshalXML, Unmarshal: unmarshalXML})
+}
+
+// RegisterCodec adds the codec of a media type, or replaces it.
+func RegisterCodec(mediaType string, c Codec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	if _, ok := codecs.m[mediaType]; !ok {
+		codecs.types = append(codecs.types, mediaType)
+	}
+	codecs.m[mediaType] = c
+}
+
+// negotiateCodec returns the codec preferred by the Accept header value,
+// false when none is acceptable.
+func negotiateCodec(accept string) (string, Codec, bool) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	mt := negotiate(accept, codecs.types...)
+	c, ok := codecs.m[mt]
+	return mt, c, ok
+}
+
+// lookupCodec returns the codec of a Content-Type header value. A request
+// without Content-Type is read as JSON.
+func lookupCodec(contentType string) (Codec, error) {
+	mt := JSONContentType
+	if contentType != "" {
+		var err error
+		if mt, _, err = mime.ParseMediaType(contentType); err != nil {
+			return Codec{}, errors.Wrapf(ErrUnsupportedMediaType, "Content-Type: %s", contentType)
+		}
+	}
+
+	codecs.RLock()
+	defer codecs.RUnlock()
+	c, ok := codecs.m[mt]
+	if !ok {
+		return Codec{}, errors.Wrapf(ErrUnsupportedMediaType, "Content-Type: %s", contentType)
+	}
+	return c, nil
+}
+
+// marshalJSON encodes compact JSON, indented when pretty.
+func marshalJSON(v interface{}, pretty bool) ([]byte, error) {
+	if pretty {
+		return json.MarshalIndent(v, "", "  ")
+	}
+	return json.Marshal(v)
+}
+
+// unmarshalJSON decodes JSON.
+func unmarshalJSON(r io.Reader, v interface{}, strict bool) error {
+	dec := json.NewDecoder(r)
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		return decodeError(err)
+	}
+	return nil
+}
+
+// marshalMsgPack encodes MessagePack, naming the fields after their json
+// tag like the JSON responses.
+func marshalMsgPack(v interface{}, pretty bool) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// unmarshalMsgPack decodes MessagePack.
+func unmarshalMsgPack(r io.Reader, v interface{}, strict bool) error {
+	dec := msgpack.NewDecoder(r)
+	dec.SetCustomStructTag("json")
+	dec.DisallowUnknownFields(strict)
+	if err := dec.Decode(v); err != nil {
+		const unknown = "msgpack: unknown field "
+		if msg := err.Error(); strings.HasPrefix(msg, unknown) {
+			fld := strings.Trim(strings.TrimPrefix(msg, unknown), `"`)
+			return InvalidError{{Fld: fld, Err: "unknown", Msg: messages["unknown"]}}
+		}
+		return errors.Wrap(ErrMalformedBody, err.Error())
+	}
+	return nil
+}
+
+// cborEnc encodes the times as RFC 3339 strings, like JSON.
+var cborEnc, _ = cbor.EncOptions{Time: cbor.TimeRFC3339Nano}.EncMode()
+
+// cborDec decodes the maps of interface values with string keys, like JSON,
+// and cborStrictDec also rejects the unknown fields.
+var (
+	cborDec, _       = cbor.DecOptions{DefaultMapType: reflect.TypeOf(map[string]interface{}(nil))}.DecMode()
+	cborStrictDec, _ = cbor.DecOptions{
+		DefaultMapType:    reflect.TypeOf(map[string]interface{}(nil)),
+		ExtraReturnErrors: cbor.ExtraDecErrorUnknownField,
+	}.DecMode()
+)
+
+// marshalCBOR encodes CBOR. The fields are named after their json tag.
+func marshalCBOR(v interface{}, pretty bool) ([]byte, error) {
+	return cborEnc.Marshal(v)
+}
+
+// unmarshalCBOR decodes CBOR.
+func unmarshalCBOR(r io.Reader, v interface{}, strict bool) error {
+	dm := cborDec
+	if strict {
+		dm = cborStrictDec
+	}
+	if err := dm.NewDecoder(r).Decode(v); err != nil {
+		if e, ok := err.(*cbor.UnknownFieldError); ok {
+			return InvalidError{{Fld: fmt.Sprintf("field %d", e.Index), Err: "unknown", Msg: messages["unknown"]}}
+		}
+		return errors.Wrap(ErrMalformedBody, err.Error())
+	}
+	return nil
+}
+
+// xmlRoot names the root element of the XML documents.
+const xmlRoot = "response"
+
+// marshalXML encodes XML with the structure of the JSON representation:
+// objects are elements named after their keys, sorted, and arrays repeat an
+// item element. Null values are empty elements with a nil attribute, empty
+// objects and arrays are read back as null.
+func marshalXML(v interface{}, pretty bool) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var tree interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	if pretty {
+		enc.Indent("", "  ")
+	}
+	if err := encodeXML(enc, xmlRoot, tree); err != nil {
+		return nil, err
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// xmlName matches the keys which can be used as element names.
+var xmlName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9._-]*$`)
+
+// encodeXMLField writes a key of an object as an element named after it,
+// or as an entry element with a key attribute when the key isn't a valid
+// name.
+func encodeXMLField(enc *xml.Encoder, key string, v interface{}) error {
+	if xmlName.MatchString(key) && !strings.HasPrefix(strings.ToLower(key), "xml") {
+		return encodeXML(enc, key, v)
+	}
+	return encodeXML(enc, "entry", v, xml.Attr{Name: xml.Name{Local: "key"}, Value: key})
+}
+
+// encodeXML writes a JSON value as an element.
+func encodeXML(enc *xml.Encoder, name string, v interface{}, attrs ...xml.Attr) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}, Attr: attrs}
+	if v == nil {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "nil"}, Value: "true"})
+	}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := encodeXMLField(enc, k, v[k]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err := encodeXML(enc, "item", item); err != nil {
+				return err
+			}
+		}
+	case nil:
+	default:
+		if err := enc.EncodeToken(xml.CharData(fmt.Sprint(v))); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// unmarshalXML decodes XML with the structure written by marshalXML. The
+// document is read as JSON whose values are all strings, so it only fits
+// the fields which are strings or times.
+func unmarshalXML(r io.Reader, v interface{}, strict bool) error {
+	dec := xml.NewDecoder(r)
+	var tree interface{}
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return errors.Wrap(ErrMalformedBody, err.Error())
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			if tree, err = decodeXML(dec, start); err != nil {
+				return errors.Wrap(ErrMalformedBody, err.Error())
+			}
+			break
+		}
+	}
+
+	b, err := json.Marshal(tree)
+	if err != nil {
+		return errors.Wrap(ErrMalformedBody, err.Error())
+	}
+	return unmarshalJSON(bytes.NewReader(b), v, strict)
+}
+
+// decodeXML reads the content of an element as a JSON value: an object when
+// it has child elements, or an array when they're all named item. Empty
+// elements, or with only spaces, are null.
+func decodeXML(dec *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	for _, a := range start.Attr {
+		if a.Name.Local == "nil" && a.Value == "true" {
+			return nil, dec.Skip()
+		}
+	}
+
+	var text strings.Builder
+	var names []string
+	var values []interface{}
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			text.Write(t)
+		case xml.StartElement:
+			child, err := decodeXML(dec, t)
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, xmlKey(t))
+			values = append(values, child)
+		case xml.EndElement:
+			if len(names) == 0 && strings.TrimSpace(text.String()) == "" {
+				return nil, nil
+			}
+			if len(names) == 0 {
+				return text.String(), nil
+			}
+			return xmlValue(names, values), nil
+		}
+	}
+}
+
+// xmlKey returns the key of the object field of an element.
+func xmlKey(start xml.StartElement) string {
+	if start.Name.Local == "entry" {
+		for _, a := range start.Attr {
+			if a.Name.Local == "key" {
+				return a.Value
+			}
+		}
+	}
+	return start.Name.Local
+}
+
+// xmlValue builds the object, or the array, of the child elements.
+func xmlValue(names []string, values []interface{}) interface{} {
+	items := true
+	for _, name := range names {
+		items = items && name == "item"
+	}
+	if items {
+		return values
+	}
+
+	obj := make(map[string]interface{}, len(names))
+	for i, name := range names {
+		obj[name] = values[i]
+	}
+	return obj
+}
@@ -0,0 +1,135 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type codecImage struct {
+	ID        string                 `json:"id" validate:"required"`
+	Title     *string                `json:"title"`
+	Metadata  map[string]interface{} `json:"metadata"`
+	CreatedAt time.Time              `json:"created_at"`
+	Secret    string                 `json:"-"`
+}
+
+func TestCodecs(t *testing.T) {
+	title := "Cat"
+	in := codecImage{
+		ID:        "47c658e0",
+		Title:     &title,
+		Metadata:  map[string]interface{}{"credit": "AFP", "focal point": "center", "tags": []interface{}{"cat", "pet"}},
+		CreatedAt: time.Date(2019, 7, 14, 10, 30, 0, 0, time.UTC),
+		Secret:    "hidden",
+	}
+
+	for _, mt := range []string{JSONContentType, MsgPackContentType, CBORContentType, XMLContentType} {
+		t.Run(mt, func(t *testing.T) {
+			c := codecs.m[mt]
+			b, err := c.Marshal(in, false)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if bytes.Contains(b, []byte("hidden")) {
+				t.Errorf("Marshal() = %q, leaks an ignored field", b)
+			}
+
+			var out codecImage
+			if err := c.Unmarshal(bytes.NewReader(b), &out, true); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			out.CreatedAt = out.CreatedAt.UTC()
+			want := in
+			want.Secret = ""
+			if !reflect.DeepEqual(out, want) {
+				t.Errorf("Unmarshal() = %+v, want %+v", out, want)
+			}
+		})
+	}
+}
+
+func TestRespondNegotiation(t *testing.T) {
+	tests := []struct {
+		name       string
+		accept     string
+		pretty     bool
+		want       int
+		wantType   string
+		wantIndent bool
+	}{
+		{name: "default", want: http.StatusOK, wantType: JSONContentType},
+		{name: "pretty", pretty: true, want: http.StatusOK, wantType: JSONContentType, wantIndent: true},
+		{name: "msgpack", accept: "application/msgpack", want: http.StatusOK, wantType: MsgPackContentType},
+		{name: "preferred", accept: "application/json;q=0.5, application/cbor", want: http.StatusOK, wantType: CBORContentType},
+		{name: "wildcard", accept: "application/*", want: http.StatusOK, wantType: JSONContentType},
+		{name: "not acceptable", accept: "text/html", want: http.StatusNotAcceptable, wantType: ProblemContentType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			ctx := context.WithValue(context.Background(), KeyValues, &Values{Accept: tt.accept, Pretty: tt.pretty})
+
+			Respond(ctx, w, map[string]int{"version": 3}, http.StatusOK)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Content-Type = %s, want %s", got, tt.wantType)
+			}
+			if got := strings.Contains(w.Body.String(), "\n"); got != tt.wantIndent {
+				t.Errorf("body = %q, want indented %v", w.Body.String(), tt.wantIndent)
+			}
+		})
+	}
+}
+
+func TestUnmarshalRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantID      string
+		wantErr     error
+	}{
+		{name: "no content type", body: `{"id":"1"}`, wantID: "1"},
+		{name: "json", contentType: "application/json; charset=utf-8", body: `{"id":"1"}`, wantID: "1"},
+		{name: "xml", contentType: "application/xml", body: `<image><id>1</id><metadata><entry key="focal point">center</entry></metadata></image>`, wantID: "1"},
+		{name: "unsupported", contentType: "text/plain", body: `id=1`, wantErr: ErrUnsupportedMediaType},
+		{name: "malformed msgpack", contentType: "application/msgpack", body: "\xc1", wantErr: ErrMalformedBody},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/v1/images", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			var img codecImage
+			err := UnmarshalRequest(r, &img)
+			if errors.Cause(err) != tt.wantErr {
+				t.Fatalf("UnmarshalRequest() error = %v, want %v", err, tt.wantErr)
+			}
+			if img.ID != tt.wantID {
+				t.Errorf("UnmarshalRequest() = %+v, want id %s", img, tt.wantID)
+			}
+		})
+	}
+
+	t.Run("validated", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/v1/images", strings.NewReader(`<image><title>Cat</title></image>`))
+		r.Header.Set("Content-Type", "application/xml")
+		var img codecImage
+		if _, ok := UnmarshalRequest(r, &img).(InvalidError); !ok {
+			t.Errorf("UnmarshalRequest() accepted an image without id")
+		}
+	})
+}
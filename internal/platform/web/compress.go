@@ -0,0 +1,237 @@
+package web
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Response encodings.
+const (
+	EncodingBrotli = "br"
+	EncodingZstd   = "zstd"
+	EncodingGzip   = "gzip"
+)
+
+// DefaultEncodings are the response encodings offered when none are
+// configured, in order of preference.
+var DefaultEncodings = []string{EncodingBrotli, EncodingZstd, EncodingGzip}
+
+// Compression configures the compression of the responses.
+type Compression struct {
+
+	// Encodings lists the offered encodings in order of preference, used
+	// to break the ties of the Accept-Encoding header.
+	Encodings []string
+
+	// MinSize is the size under which responses are sent uncompressed.
+	MinSize int
+}
+
+// encoder is implemented by the compressors of all the encodings.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// encoders pools the compressors of each encoding.
+var encoders = map[string]*sync.Pool{
+	EncodingBrotli: {New: func() interface{} { return brotli.NewWriterLevel(nil, brotli.DefaultCompression) }},
+	EncodingZstd: {New: func() interface{} {
+		enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return enc
+	}},
+	EncodingGzip: {New: func() interface{} { return gzip.NewWriter(nil) }},
+}
+
+// negotiateEncoding returns the encoding preferred by the Accept-Encoding
+// header value, an empty string for identity.
+func (c *Compression) negotiateEncoding(accept string) string {
+	best, bestQ := "", 0.0
+	for _, enc := range c.Encodings {
+		if _, ok := encoders[enc]; !ok {
+			continue
+		}
+		if q := encodingQuality(accept, enc); q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// encodingQuality returns the quality the Accept-Encoding header gives to
+// the encoding, the wildcard applying to those it doesn't list.
+func encodingQuality(accept, encoding string) float64 {
+	q, wildcard := -1.0, 0.0
+	for _, coding := range strings.Split(accept, ",") {
+		parts := strings.Split(coding, ";")
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		if name != encoding && name != "*" {
+			continue
+		}
+
+		cq := 1.0
+		for _, p := range parts[1:] {
+			if kv := strings.SplitN(strings.TrimSpace(p), "=", 2); len(kv) == 2 && kv[0] == "q" {
+				if f, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					cq = f
+				}
+			}
+		}
+		if name == "*" {
+			wildcard = cq
+		} else {
+			q = cq
+		}
+	}
+	if q < 0 {
+		return wildcard
+	}
+	return q
+}
+
+// compressible tells whether responses with the headers are worth
+// compressing: media are already compressed and encoded responses are left
+// alone.
+func compressible(h http.Header) bool {
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	ct := h.Get("Content-Type")
+	for _, prefix := range []string{"image/", "video/", "audio/", "application/zip", "application/gzip"} {
+		if strings.HasPrefix(ct, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// compressWriter compresses the response once its body reaches the
+// minimum size. The first bytes are buffered until then, along with the
+// status code. Close must be called when the handler returns.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	code    int
+	buf     []byte
+	decided bool
+	enc     encoder
+}
+
+// newCompressWriter returns the writer compressing the response with the
+// encoding preferred by the request, or w when it accepts none.
+func (c *Compression) newCompressWriter(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	encoding := c.negotiateEncoding(r.Header.Get("Accept-Encoding"))
+
+	// The upgraded connections, like WebSockets, hijack the writer.
+	if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+		return w, func() {}
+	}
+	cw := compressWriter{ResponseWriter: w, encoding: encoding, minSize: c.MinSize}
+	return &cw, cw.close
+}
+
+// WriteHeader implements the http.ResponseWriter interface. The status is
+// sent along with the first bytes of the body.
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.decided {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if cw.code == 0 {
+		cw.code = code
+	}
+}
+
+// Write implements the io.Writer interface.
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+
+		// net/http would sniff the compressed bytes.
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(append(cw.buf, b...)))
+		}
+		if !compressible(cw.Header()) {
+			cw.decide(false)
+		} else if len(cw.buf)+len(b) < cw.minSize {
+			cw.buf = append(cw.buf, b...)
+			return len(b), nil
+		} else {
+			cw.decide(true)
+		}
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush implements the http.Flusher interface. A response flushed before
+// reaching the minimum size is streamed, hence compressed.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(compressible(cw.Header()))
+	}
+	if cw.enc != nil {
+		cw.enc.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// decide sends the status and the buffered bytes, compressed or not.
+func (cw *compressWriter) decide(compress bool) {
+	cw.decided = true
+	code := cw.code
+	if code == 0 {
+		code = http.StatusOK
+	}
+	bodyless := code == http.StatusNoContent || code == http.StatusNotModified
+
+	if compress && !bodyless {
+		h := cw.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding)
+		cw.enc = encoders[cw.encoding].Get().(encoder)
+		cw.enc.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(code)
+
+	if len(cw.buf) > 0 {
+		if cw.enc != nil {
+			cw.enc.Write(cw.buf)
+		} else {
+			cw.ResponseWriter.Write(cw.buf)
+		}
+	}
+	cw.buf = nil
+}
+
+// close sends what is left of the response and ends the compressed stream.
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		if cw.code == 0 && len(cw.buf) == 0 {
+			return
+		}
+
+		// The body is under the minimum size, or Write would have decided.
+		cw.decide(false)
+	}
+	if cw.enc != nil {
+		cw.enc.Close()
+		cw.enc.Reset(nil)
+		encoders[cw.encoding].Put(cw.enc)
+		cw.enc = nil
+	}
+}
@@ -0,0 +1,173 @@
+package web
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/apex/log"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	c := Compression{Encodings: DefaultEncodings}
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{accept: "", want: ""},
+		{accept: "gzip, deflate", want: EncodingGzip},
+		{accept: "gzip, deflate, br, zstd", want: EncodingBrotli},
+		{accept: "gzip;q=1, br;q=0.5", want: EncodingGzip},
+		{accept: "*", want: EncodingBrotli},
+		{accept: "*, br;q=0", want: EncodingZstd},
+		{accept: "identity", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			if got := c.negotiateEncoding(tt.accept); got != tt.want {
+				t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.accept, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompression(t *testing.T) {
+	large := strings.Repeat("image ", 1000)
+	readers := map[string]func(io.Reader) io.Reader{
+		"": func(r io.Reader) io.Reader { return r },
+		EncodingGzip: func(r io.Reader) io.Reader {
+			zr, err := gzip.NewReader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return zr
+		},
+		EncodingBrotli: func(r io.Reader) io.Reader { return brotli.NewReader(r) },
+		EncodingZstd: func(r io.Reader) io.Reader {
+			zr, err := zstd.NewReader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return zr
+		},
+	}
+
+	tests := []struct {
+		name        string
+		accept      string
+		contentType string
+		body        string
+		flush       bool
+		upgrade     string
+		want        string
+	}{
+		{name: "gzip", accept: "gzip", body: large, want: EncodingGzip},
+		{name: "brotli", accept: "br", body: large, want: EncodingBrotli},
+		{name: "zstd", accept: "zstd", body: large, want: EncodingZstd},
+		{name: "small", accept: "gzip", body: "{}", want: ""},
+		{name: "streamed", accept: "gzip", body: "{}", flush: true, want: EncodingGzip},
+		{name: "image", accept: "gzip", contentType: "image/jpeg", body: large, want: ""},
+		{name: "identity", accept: "", body: large, want: ""},
+		{name: "upgrade", accept: "gzip", upgrade: "websocket", body: large, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := New(log.WithField("test", t.Name()))
+			app.Compression = &Compression{Encodings: DefaultEncodings, MinSize: 1024}
+			app.Handle("GET", "/v1/images", func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				w.WriteHeader(http.StatusCreated)
+				io.WriteString(w, tt.body[:len(tt.body)/2])
+				if tt.flush {
+					w.(http.Flusher).Flush()
+				}
+				io.WriteString(w, tt.body[len(tt.body)/2:])
+				return nil
+			})
+
+			r := httptest.NewRequest("GET", "/v1/images", nil)
+			r.Header.Set("Accept-Encoding", tt.accept)
+			r.Header.Set("Upgrade", tt.upgrade)
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+
+			if w.Code != http.StatusCreated {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusCreated)
+			}
+			if got := w.Header().Get("Content-Encoding"); got != tt.want {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.want)
+			}
+			b, err := ioutil.ReadAll(readers[tt.want](w.Body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.body {
+				t.Errorf("body = %d bytes, want %d", len(b), len(tt.body))
+			}
+		})
+	}
+}
+
+func TestRequestBody(t *testing.T) {
+	gzipped := func(s string) string {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		io.WriteString(zw, s)
+		zw.Close()
+		return buf.String()
+	}
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     string
+		limit    int64
+		want     int
+	}{
+		{name: "plain", body: `{"id":"1"}`, limit: 64, want: http.StatusOK},
+		{name: "gzip", encoding: "gzip", body: gzipped(`{"id":"1"}`), limit: 64, want: http.StatusOK},
+		{name: "too large", body: `{"id":"` + strings.Repeat("1", 64) + `"}`, limit: 64, want: http.StatusRequestEntityTooLarge},
+		{name: "gzip bomb", encoding: "gzip", body: gzipped(`{"id":"` + strings.Repeat("1", 1<<20) + `"}`), limit: 64, want: http.StatusRequestEntityTooLarge},
+		{name: "unlimited", body: `{"id":"` + strings.Repeat("1", 64) + `"}`, want: http.StatusOK},
+		{name: "corrupt gzip", encoding: "gzip", body: `{"id":"1"}`, want: http.StatusBadRequest},
+		{name: "unknown encoding", encoding: "compress", body: `{"id":"1"}`, want: http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := New(log.WithField("test", t.Name()))
+			app.BodyLimits = map[string]int64{"POST /v1/images": tt.limit}
+			app.MaxBodySize = 1
+			app.Handle("POST", "/v1/images", func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+				var v codecImage
+				if err := UnmarshalRequest(r, &v); err != nil {
+					Error(ctx, w, err)
+					return nil
+				}
+				Respond(ctx, w, v, http.StatusOK)
+				return nil
+			})
+
+			r := httptest.NewRequest("POST", "/v1/images", strings.NewReader(tt.body))
+			r.Header.Set("Content-Encoding", tt.encoding)
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
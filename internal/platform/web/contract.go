@@ -0,0 +1,455 @@
+package web
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// ErrContractViolation occurs when a response doesn't match the OpenAPI
+// document of the API.
+var ErrContractViolation = errors.New("Response violates the API contract")
+
+func init() {
+	RegisterError(ErrContractViolation, ProblemType{Type: ProblemBaseURI + "contract-violation", Title: "Response violates the API contract", Status: http.StatusInternalServerError})
+	RegisterMessage("datetime", "must be an RFC 3339 date-time")
+	RegisterMessage("base64", "must be base64 encoded")
+}
+
+// uuidRegex matches the UUIDs, whatever their version.
+var uuidRegex = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Contract checks the requests and the responses against the operations of
+// an OpenAPI document. The unknown properties and query parameters are
+// accepted, and only the JSON bodies are checked.
+type Contract struct {
+	doc    *OpenAPI
+	routes []contractRoute
+}
+
+// contractRoute is a path of the document, split in segments, and its
+// operations.
+type contractRoute struct {
+	segments []string
+	static   int
+	item     PathItem
+}
+
+// Load sets the document the requests and responses are checked against.
+// It must be called before serving them.
+func (c *Contract) Load(doc *OpenAPI) {
+	c.doc = doc
+	c.routes = nil
+	for p, item := range doc.Paths {
+		rt := contractRoute{segments: strings.Split(p, "/"), item: item}
+		for _, s := range rt.segments {
+			if !strings.HasPrefix(s, "{") {
+				rt.static++
+			}
+		}
+		c.routes = append(c.routes, rt)
+	}
+}
+
+// Operation returns the operation of a request, nil when the document has
+// none, or isn't loaded. Like the router, the static segments win over the
+// parameters, and a parameter ending the path matches the rest of it.
+func (c *Contract) Operation(method, path string) *Operation {
+	segments := strings.Split(path, "/")
+	var op *Operation
+	best := -1
+	for _, rt := range c.routes {
+		o := rt.item[strings.ToLower(method)]
+		if o == nil || rt.static <= best || !rt.match(segments) {
+			continue
+		}
+		op, best = o, rt.static
+	}
+	return op
+}
+
+// match tells whether the segments of a path match the route.
+func (rt contractRoute) match(segments []string) bool {
+	for i, s := range rt.segments {
+		if i >= len(segments) {
+			return false
+		}
+		if !strings.HasPrefix(s, "{") {
+			if s != segments[i] {
+				return false
+			}
+			continue
+		}
+		if i == len(rt.segments)-1 {
+			return true
+		}
+	}
+	return len(segments) == len(rt.segments)
+}
+
+// CheckRequest checks the parameters and the body of a request. Invalid
+// values are reported as an InvalidError, and the media types which aren't
+// documented with ErrUnsupportedMediaType. The body is read, and replaced
+// to be read again by the handler.
+func (c *Contract) CheckRequest(op *Operation, r *http.Request, params map[string]string) error {
+	var inv InvalidError
+	for _, p := range op.Parameters {
+		var values []string
+		switch p.In {
+		case "path":
+			values = []string{params[p.Name]}
+		case "query":
+			values = r.URL.Query()[p.Name]
+		case "header":
+			values = r.Header.Values(p.Name)
+		}
+		if len(values) == 0 {
+			if p.Required {
+				inv = append(inv, Invalid{Fld: p.Name, Err: "required", Msg: messages["required"]})
+			}
+			continue
+		}
+		for _, s := range values {
+			c.checkParam(p, s, &inv)
+		}
+	}
+	if len(inv) > 0 {
+		return inv
+	}
+
+	if op.RequestBody == nil || r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	ct := r.Header.Get("Content-Type")
+	if ct == "" {
+		ct = JSONContentType
+	}
+	mt, ok := lookupContent(op.RequestBody.Content, ct)
+	if !ok {
+		return errors.Wrapf(ErrUnsupportedMediaType, "Content-Type: %s", ct)
+	}
+	if mt.Schema == nil || !isJSON(ct) {
+		return nil
+	}
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return BodyError(r.Body, errors.Wrap(ErrMalformedBody, err.Error()))
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+	// The bodies which can't be decoded are reported by the handlers.
+	v, err := decodeValue(b)
+	if err != nil {
+		return nil
+	}
+	c.check(mt.Schema, v, "", &inv)
+	if len(inv) > 0 {
+		return inv
+	}
+	return nil
+}
+
+// CheckResponse checks the status code, the media type and the body of a
+// response, which must be documented. The errors are documented by the
+// default response, and the answers to conditional requests need no
+// documentation. The violations are returned as an InvalidError.
+func (c *Contract) CheckResponse(op *Operation, code int, header http.Header, body []byte) error {
+	if code == http.StatusNotModified {
+		return nil
+	}
+	resp := op.Responses[strconv.Itoa(code)]
+	if resp == nil && code >= http.StatusBadRequest {
+		resp = op.Responses["default"]
+	}
+	if resp == nil {
+		return InvalidError{{Fld: "status", Err: "oneof", Param: strconv.Itoa(code), Msg: "is not documented"}}
+	}
+	if len(resp.Content) == 0 {
+		if len(body) > 0 {
+			return InvalidError{{Fld: "body", Err: "len", Param: "0", Msg: "must be empty"}}
+		}
+		return nil
+	}
+
+	ct := header.Get("Content-Type")
+	mt, ok := lookupContent(resp.Content, ct)
+	if !ok {
+		return InvalidError{{Fld: "Content-Type", Err: "oneof", Param: ct, Msg: "is not documented"}}
+	}
+	if mt.Schema == nil || !isJSON(ct) {
+		return nil
+	}
+	v, err := decodeValue(body)
+	if err != nil {
+		return InvalidError{{Fld: "body", Err: "type", Param: ct, Msg: err.Error()}}
+	}
+	var inv InvalidError
+	c.check(mt.Schema, v, "", &inv)
+	if len(inv) > 0 {
+		return inv
+	}
+	return nil
+}
+
+// lookupContent returns the media type of a Content-Type header value in
+// the content of a body, matching the wildcards like image/*.
+func lookupContent(content map[string]*MediaType, contentType string) (*MediaType, bool) {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+	if mt, ok := content[t]; ok {
+		return mt, true
+	}
+	if i := strings.Index(t, "/"); i >= 0 {
+		if mt, ok := content[t[:i]+"/*"]; ok {
+			return mt, true
+		}
+	}
+	mt, ok := content["*/*"]
+	return mt, ok
+}
+
+// isJSON tells whether a Content-Type header value is JSON, like
+// application/json or application/problem+json.
+func isJSON(contentType string) bool {
+	t, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (t == JSONContentType || strings.HasSuffix(t, "+json"))
+}
+
+// decodeValue decodes a JSON value, keeping the numbers as is.
+func decodeValue(b []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// checkParam checks the value of a parameter, converted to its type.
+func (c *Contract) checkParam(p *Parameter, s string, inv *InvalidError) {
+	var v interface{} = s
+	if p.Schema != nil && len(p.Schema.Type) > 0 {
+		switch p.Schema.Type[0] {
+		case "integer", "number":
+			if _, err := strconv.ParseFloat(s, 64); err != nil {
+				*inv = append(*inv, typeInvalid(p.Name, p.Schema.Type))
+				return
+			}
+			v = json.Number(s)
+		case "boolean":
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				*inv = append(*inv, typeInvalid(p.Name, p.Schema.Type))
+				return
+			}
+			v = b
+		}
+	}
+	c.check(p.Schema, v, p.Name, inv)
+}
+
+// check checks a decoded JSON value against a schema. The invalid values
+// are named by their path, with dots between the keys and the indexes.
+func (c *Contract) check(s *Schema, v interface{}, path string, inv *InvalidError) {
+	if s == nil {
+		return
+	}
+	if s.Ref != "" {
+		c.check(c.doc.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")], v, path, inv)
+		return
+	}
+	if len(s.AnyOf) > 0 {
+		var first InvalidError
+		for i, alt := range s.AnyOf {
+			var altInv InvalidError
+			c.check(alt, v, path, &altInv)
+			if len(altInv) == 0 {
+				return
+			}
+			if i == 0 {
+				first = altInv
+			}
+		}
+		*inv = append(*inv, first...)
+		return
+	}
+
+	fld := path
+	if fld == "" {
+		fld = "body"
+	}
+	if len(s.Type) > 0 && !hasType(s.Type, v) {
+		*inv = append(*inv, typeInvalid(fld, s.Type))
+		return
+	}
+	if len(s.Enum) > 0 && !inEnum(s.Enum, v) {
+		param := make([]string, len(s.Enum))
+		for i, e := range s.Enum {
+			param[i] = fmt.Sprint(e)
+		}
+		*inv = append(*inv, invalid(fld, "oneof", strings.Join(param, " ")))
+		return
+	}
+
+	switch v := v.(type) {
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.MinLength != nil && n < *s.MinLength {
+			*inv = append(*inv, invalid(fld, "min", strconv.Itoa(*s.MinLength)))
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			*inv = append(*inv, invalid(fld, "max", strconv.Itoa(*s.MaxLength)))
+		}
+		if tag, ok := checkFormat(s.Format, v); !ok {
+			*inv = append(*inv, invalid(fld, tag, ""))
+		}
+	case json.Number:
+		f, _ := v.Float64()
+		if s.Minimum != nil && f < *s.Minimum {
+			*inv = append(*inv, numericInvalid(fld, "min", *s.Minimum))
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			*inv = append(*inv, numericInvalid(fld, "max", *s.Maximum))
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			*inv = append(*inv, numericInvalid(fld, "min", float64(*s.MinItems)))
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			*inv = append(*inv, numericInvalid(fld, "max", float64(*s.MaxItems)))
+		}
+		for i, item := range v {
+			c.check(s.Items, item, join(path, strconv.Itoa(i)), inv)
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				*inv = append(*inv, invalid(join(path, name), "required", ""))
+			}
+		}
+		for name, pv := range v {
+			ps, ok := s.Properties[name]
+			if !ok {
+				ps = s.AdditionalProperties
+			}
+			c.check(ps, pv, join(path, name), inv)
+		}
+	}
+}
+
+// join appends a key or an index to the path of a value.
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// hasType tells whether a decoded JSON value has one of the types.
+func hasType(types SchemaType, v interface{}) bool {
+	for _, t := range types {
+		switch v := v.(type) {
+		case nil:
+			if t == "null" {
+				return true
+			}
+		case bool:
+			if t == "boolean" {
+				return true
+			}
+		case string:
+			if t == "string" {
+				return true
+			}
+		case json.Number:
+			if t == "number" {
+				return true
+			}
+			if f, err := v.Float64(); t == "integer" && err == nil && f == math.Trunc(f) {
+				return true
+			}
+		case []interface{}:
+			if t == "array" {
+				return true
+			}
+		case map[string]interface{}:
+			if t == "object" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// inEnum tells whether a decoded JSON value is one of the values.
+func inEnum(enum []interface{}, v interface{}) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFormat checks a string against a format, returning the tag of the
+// error when it doesn't match. The unknown formats aren't checked.
+func checkFormat(format, s string) (string, bool) {
+	switch format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339Nano, s)
+		return "datetime", err == nil
+	case "uuid":
+		return "uuid", uuidRegex.MatchString(s)
+	case "uri":
+		u, err := url.Parse(s)
+		return "uri", err == nil && u.IsAbs()
+	case "email":
+		return "email", strings.Contains(s, "@")
+	case "byte":
+		_, err := base64.StdEncoding.DecodeString(s)
+		return "base64", err == nil
+	}
+	return "", true
+}
+
+// invalid returns the invalid value of a tag, with its message.
+func invalid(fld, tag, param string) Invalid {
+	msg, ok := messages[tag]
+	if !ok {
+		msg = "failed on the " + tag + " rule"
+	}
+	if strings.Contains(msg, "%s") {
+		msg = fmt.Sprintf(msg, param)
+	}
+	return Invalid{Fld: fld, Err: tag, Param: param, Msg: msg}
+}
+
+// numericInvalid returns the invalid value of a limit of a number or of a
+// number of items.
+func numericInvalid(fld, tag string, limit float64) Invalid {
+	param := strconv.FormatFloat(limit, 'f', -1, 64)
+	return Invalid{Fld: fld, Err: tag, Param: param, Msg: fmt.Sprintf(numericMessages[tag], param)}
+}
+
+// typeInvalid returns the invalid value of a type mismatch.
+func typeInvalid(fld string, types SchemaType) Invalid {
+	param := strings.Join(types, " or ")
+	return Invalid{Fld: fld, Err: "type", Param: param, Msg: fmt.Sprintf(messages["type"], param)}
+}
@@ -0,0 +1,197 @@
+package web
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/pkg/errors"
+)
+
+// testContract returns a contract loaded with the document of a few item
+// routes.
+func testContract(t *testing.T) *Contract {
+	noop := func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+		return nil
+	}
+	app := New(log.WithField("test", t.Name()))
+	app.Handle("GET", "/v1/items", noop).Describe(Doc{
+		Params:    []Param{{Name: "limit", Type: "integer"}, {Name: "draft", Type: "boolean"}},
+		Responses: map[int]interface{}{http.StatusOK: []testItem{}},
+	})
+	app.Handle("GET", "/v1/items/search", noop).Describe(Doc{
+		Params:    []Param{{Name: "q", Required: true}},
+		Responses: map[int]interface{}{http.StatusOK: []testItem{}},
+	})
+	app.Handle("PUT", "/v1/items/:id", noop).Describe(Doc{
+		Params:    []Param{{Name: "id", In: "path", Format: "uuid"}},
+		Body:      testDetailed{},
+		Responses: map[int]interface{}{http.StatusOK: testDetailed{}, http.StatusNoContent: nil},
+	})
+	app.Handle("GET", "/v1/blobs/*key", noop).Describe(Doc{
+		Responses: map[int]interface{}{http.StatusOK: Media{"image/*": nil}},
+	})
+
+	doc, err := app.OpenAPI(Info{Title: "test", Version: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c Contract
+	c.Load(doc)
+	return &c
+}
+
+func TestContractOperation(t *testing.T) {
+	c := testContract(t)
+	tests := []struct {
+		method string
+		path   string
+		want   *Operation
+	}{
+		{method: "GET", path: "/v1/items", want: c.doc.Paths["/v1/items"]["get"]},
+		{method: "GET", path: "/v1/items/search", want: c.doc.Paths["/v1/items/search"]["get"]},
+		{method: "PUT", path: "/v1/items/search", want: c.doc.Paths["/v1/items/{id}"]["put"]},
+		{method: "GET", path: "/v1/blobs/a/b.png", want: c.doc.Paths["/v1/blobs/{key}"]["get"]},
+		{method: "DELETE", path: "/v1/items/1"},
+		{method: "GET", path: "/v1/items/1/file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			if got := c.Operation(tt.method, tt.path); got != tt.want {
+				t.Errorf("Operation() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if (&Contract{}).Operation("GET", "/v1/items") != nil {
+		t.Error("Operation() of a contract not loaded isn't nil")
+	}
+}
+
+func TestContractCheckRequest(t *testing.T) {
+	c := testContract(t)
+	id := "6e3b2a5c-1d4f-4b8e-9a7c-2f5d8e1b3c4a"
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		params      map[string]string
+		contentType string
+		body        string
+		fields      []string
+		err         error
+	}{
+		{name: "valid query", method: "GET", path: "/v1/items?limit=10&draft=true&unknown=1"},
+		{name: "integer", method: "GET", path: "/v1/items?limit=ten", fields: []string{"limit"}},
+		{name: "boolean", method: "GET", path: "/v1/items?draft=maybe", fields: []string{"draft"}},
+		{name: "required query", method: "GET", path: "/v1/items/search", fields: []string{"q"}},
+		{name: "valid body", method: "PUT", path: "/v1/items/" + id, params: map[string]string{"id": id}, body: `{"title":"abc","tags":["a"],"score":10,"extra":true}`},
+		{name: "path format", method: "PUT", path: "/v1/items/1", params: map[string]string{"id": "1"}, body: `{"title":"abc"}`, fields: []string{"id"}},
+		{name: "body rules", method: "PUT", path: "/v1/items/" + id, params: map[string]string{"id": id}, body: `{"title":"ab","tags":["c"],"score":11}`, fields: []string{"title", "tags.0", "score"}},
+		{name: "required property", method: "PUT", path: "/v1/items/" + id, params: map[string]string{"id": id}, body: `{"score":1}`, fields: []string{"title"}},
+		{name: "root type", method: "PUT", path: "/v1/items/" + id, params: map[string]string{"id": id}, body: `[]`, fields: []string{"body"}},
+		{name: "malformed body", method: "PUT", path: "/v1/items/" + id, params: map[string]string{"id": id}, body: `{`},
+		{name: "other codec", method: "PUT", path: "/v1/items/" + id, params: map[string]string{"id": id}, contentType: "application/msgpack", body: "\x80"},
+		{name: "media type", method: "PUT", path: "/v1/items/" + id, params: map[string]string{"id": id}, contentType: "text/plain", body: "abc", err: ErrUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			err := c.CheckRequest(c.Operation(tt.method, r.URL.Path), r, tt.params)
+
+			if tt.err != nil {
+				if errors.Cause(err) != tt.err {
+					t.Fatalf("CheckRequest() = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			var fields []string
+			if err != nil {
+				inv, ok := err.(InvalidError)
+				if !ok {
+					t.Fatalf("CheckRequest() = %v, want an InvalidError", err)
+				}
+				for _, i := range inv {
+					fields = append(fields, i.Fld)
+				}
+			}
+			if !sameFields(fields, tt.fields) {
+				t.Errorf("invalid fields = %v, want %v", fields, tt.fields)
+			}
+			if b, _ := ioutil.ReadAll(r.Body); string(b) != tt.body {
+				t.Errorf("body left = %q, want %q", b, tt.body)
+			}
+		})
+	}
+}
+
+func TestContractCheckResponse(t *testing.T) {
+	c := testContract(t)
+	put := c.Operation("PUT", "/v1/items/1")
+	blob := c.Operation("GET", "/v1/blobs/a.png")
+	tests := []struct {
+		name        string
+		op          *Operation
+		code        int
+		contentType string
+		body        string
+		fields      []string
+	}{
+		{name: "valid", op: put, code: http.StatusOK, contentType: JSONContentType, body: `{"id":null,"title":"abc","tags":null,"score":1}`},
+		{name: "no content", op: put, code: http.StatusNoContent},
+		{name: "not modified", op: put, code: http.StatusNotModified},
+		{name: "problem", op: put, code: http.StatusNotFound, contentType: ProblemContentType, body: `{"type":"/problems/not-found","title":"Entity not found","status":404}`},
+		{name: "undocumented status", op: put, code: http.StatusCreated, contentType: JSONContentType, body: `{}`, fields: []string{"status"}},
+		{name: "undocumented media type", op: put, code: http.StatusOK, contentType: "text/plain", body: "abc", fields: []string{"Content-Type"}},
+		{name: "schema", op: put, code: http.StatusOK, contentType: JSONContentType, body: `{"id":1,"title":"abc","tags":null,"score":"1"}`, fields: []string{"id", "score"}},
+		{name: "missing property", op: put, code: http.StatusOK, contentType: JSONContentType, body: `{"tags":null,"score":1}`, fields: []string{"title"}},
+		{name: "not JSON", op: put, code: http.StatusOK, contentType: JSONContentType, body: `{`, fields: []string{"body"}},
+		{name: "wildcard", op: blob, code: http.StatusOK, contentType: "image/png", body: "\x89PNG"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			if tt.contentType != "" {
+				h.Set("Content-Type", tt.contentType)
+			}
+			var fields []string
+			if err := c.CheckResponse(tt.op, tt.code, h, []byte(tt.body)); err != nil {
+				for _, i := range err.(InvalidError) {
+					fields = append(fields, i.Fld)
+				}
+			}
+			if !sameFields(fields, tt.fields) {
+				t.Errorf("invalid fields = %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
+
+// sameFields tells whether two lists hold the same field names, whatever
+// their order.
+func sameFields(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	seen := make(map[string]int)
+	for _, f := range got {
+		seen[f]++
+	}
+	for _, f := range want {
+		if seen[f] == 0 {
+			return false
+		}
+		seen[f]--
+	}
+	return true
+}
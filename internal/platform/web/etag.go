@@ -0,0 +1,143 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ETag formats the version of a resource as a strong entity tag.
+func ETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// Precondition is the If-Match header of a request, which the current
+// entity tag of a resource must match before it is changed.
+type Precondition struct {
+	present bool
+	any     bool
+	tags    []string
+}
+
+// IfMatch reads the If-Match header of the request. When required, the
+// requests without it fail with ErrPreconditionRequired.
+func IfMatch(r *http.Request, required bool) (Precondition, error) {
+	h := r.Header.Get("If-Match")
+	if h == "" {
+		if required {
+			return Precondition{}, errors.Wrap(ErrPreconditionRequired, "If-Match is missing")
+		}
+		return Precondition{}, nil
+	}
+
+	p := Precondition{present: true}
+	for _, tag := range splitTags(h) {
+		if tag == "*" {
+			p.any = true
+		}
+		p.tags = append(p.tags, tag)
+	}
+	return p, nil
+}
+
+// MatchETag returns the precondition matching only the entity tag, for the
+// requests carrying the expected version of a resource outside of the
+// If-Match header.
+func MatchETag(etag string) Precondition {
+	return Precondition{present: true, tags: []string{etag}}
+}
+
+// Present tells whether the request had an If-Match header.
+func (p Precondition) Present() bool {
+	return p.present
+}
+
+// Match tells whether the entity tag satisfies the precondition. Weak tags
+// never match as If-Match uses the strong comparison.
+func (p Precondition) Match(etag string) bool {
+	if !p.present || p.any {
+		return true
+	}
+	for _, tag := range p.tags {
+		if tag == etag && !strings.HasPrefix(tag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns ErrPreconditionFailed when the entity tag doesn't satisfy
+// the precondition.
+func (p Precondition) Check(etag string) error {
+	if !p.Match(etag) {
+		return errors.Wrapf(ErrPreconditionFailed, "ETag is %s", etag)
+	}
+	return nil
+}
+
+// LastModified sets the Last-Modified header of the response, which
+// RespondETag compares to the If-Modified-Since header of the requests.
+func LastModified(w http.ResponseWriter, t time.Time) {
+	w.Header().Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+}
+
+// RespondETag sends JSON to the client along with the entity tag of the
+// data. GET and HEAD requests whose If-None-Match header matches the tag,
+// or without If-None-Match and not modified since If-Modified-Since, get a
+// 304 Not Modified response without a body.
+func RespondETag(ctx context.Context, w http.ResponseWriter, r *http.Request, data interface{}, etag string, code int) {
+	w.Header().Set("ETag", etag)
+
+	if (r.Method == http.MethodGet || r.Method == http.MethodHead) && notModified(r, w.Header(), etag) {
+		ctx.Value(KeyValues).(*Values).StatusCode = http.StatusNotModified
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	Respond(ctx, w, data, code)
+}
+
+// notModified tells whether the conditional headers of the request match
+// the entity tag and the Last-Modified header of the response.
+func notModified(r *http.Request, h http.Header, etag string) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return noneMatch(inm, etag)
+	}
+
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lm, err := http.ParseTime(h.Get("Last-Modified"))
+	return err == nil && !lm.After(ims)
+}
+
+// noneMatch tells whether the If-None-Match header lists the entity tag,
+// using the weak comparison.
+func noneMatch(h, etag string) bool {
+	if h == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range splitTags(h) {
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// splitTags splits a list of entity tags.
+func splitTags(h string) []string {
+	var tags []string
+	for _, tag := range strings.Split(h, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
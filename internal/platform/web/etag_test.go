@@ -0,0 +1,116 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		required bool
+		etag     string
+		err      error
+	}{
+		{name: "absent", etag: `"3"`},
+		{name: "absent required", required: true, etag: `"3"`, err: ErrPreconditionRequired},
+		{name: "match", header: `"3"`, etag: `"3"`},
+		{name: "list", header: `"2", "3"`, etag: `"3"`},
+		{name: "any", header: `*`, required: true, etag: `"3"`},
+		{name: "stale", header: `"2"`, etag: `"3"`, err: ErrPreconditionFailed},
+		{name: "weak", header: `W/"3"`, etag: `"3"`, err: ErrPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("PUT", "/v1/images/1", nil)
+			if tt.header != "" {
+				r.Header.Set("If-Match", tt.header)
+			}
+			pre, err := IfMatch(r, tt.required)
+			if err == nil {
+				err = pre.Check(tt.etag)
+			}
+			if errors.Cause(err) != tt.err {
+				t.Errorf("IfMatch(%q).Check(%s) = %v, want %v", tt.header, tt.etag, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRespondETag(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		header string
+		want   int
+	}{
+		{name: "no header", method: "GET", want: http.StatusOK},
+		{name: "match", method: "GET", header: `"3"`, want: http.StatusNotModified},
+		{name: "weak match", method: "GET", header: `W/"3"`, want: http.StatusNotModified},
+		{name: "any", method: "GET", header: `*`, want: http.StatusNotModified},
+		{name: "stale", method: "GET", header: `"2"`, want: http.StatusOK},
+		{name: "not a read", method: "PUT", header: `"3"`, want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/v1/images/1", nil)
+			if tt.header != "" {
+				r.Header.Set("If-None-Match", tt.header)
+			}
+			w := httptest.NewRecorder()
+			ctx := context.WithValue(context.Background(), KeyValues, &Values{})
+
+			RespondETag(ctx, w, r, map[string]int{"version": 3}, ETag(3), http.StatusOK)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if got := w.Header().Get("ETag"); got != `"3"` {
+				t.Errorf("ETag = %s, want \"3\"", got)
+			}
+			if tt.want == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Errorf("body = %q, want none", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestIfModifiedSince(t *testing.T) {
+	lastModified := time.Date(2019, 7, 14, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   int
+	}{
+		{name: "no header", want: http.StatusOK},
+		{name: "not modified", header: map[string]string{"If-Modified-Since": "Sun, 14 Jul 2019 10:30:00 GMT"}, want: http.StatusNotModified},
+		{name: "modified", header: map[string]string{"If-Modified-Since": "Sun, 14 Jul 2019 10:29:59 GMT"}, want: http.StatusOK},
+		{name: "etag wins", header: map[string]string{"If-Modified-Since": "Sun, 14 Jul 2019 10:30:00 GMT", "If-None-Match": `"2"`}, want: http.StatusOK},
+		{name: "malformed", header: map[string]string{"If-Modified-Since": "yesterday"}, want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/v1/images/1", nil)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			ctx := context.WithValue(context.Background(), KeyValues, &Values{})
+
+			LastModified(w, lastModified)
+			RespondETag(ctx, w, r, map[string]int{"version": 3}, ETag(3), http.StatusOK)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,637 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// OpenAPIVersion is the version of the specification the OpenAPI documents
+// generated by the App follow.
+const OpenAPIVersion = "3.1.0"
+
+// Route is a route registered on the App, along with its description in the
+// OpenAPI document.
+type Route struct {
+	Method string
+	Path   string
+	Doc    Doc
+}
+
+// Describe sets the description of the route.
+func (r *Route) Describe(d Doc) *Route {
+	r.Doc = d
+	return r
+}
+
+// Doc describes a route in the OpenAPI document. The bodies are described
+// by a value of their type: the structs are documented from their json and
+// validate tags, and the interfaces from the value they hold. A value which
+// isn't a Media is encoded by the codecs, and gets the media types of all of
+// them.
+type Doc struct {
+	Summary     string
+	Description string
+	Tags        []string
+
+	// Params are the query and header parameters of the route. The path
+	// parameters are documented as strings, unless listed here.
+	Params []Param
+
+	// Body is the request body, nil for none.
+	Body interface{}
+
+	// Responses maps the status codes of the successes to their body, nil
+	// for none. The errors are documented as problems.
+	Responses map[int]interface{}
+}
+
+// Param is a parameter of a route.
+type Param struct {
+	Name string
+
+	// In is query, header or path, query when empty.
+	In string
+
+	Description string
+	Required    bool
+
+	// Type is the JSON Schema type of the parameter, string when empty.
+	Type   string
+	Format string
+	Enum   []string
+}
+
+// Media describes a body by media type, for the bodies which aren't
+// encoded by the codecs, like images or event streams. The values describe
+// the body as in Doc, nil for a body without schema.
+type Media map[string]interface{}
+
+// OpenAPI is an OpenAPI document. Only the parts generated by App.OpenAPI
+// are modeled.
+type OpenAPI struct {
+	OpenAPI    string                `json:"openapi"`
+	Info       Info                  `json:"info"`
+	Paths      map[string]PathItem   `json:"paths"`
+	Components Components            `json:"components"`
+	Security   []map[string][]string `json:"security,omitempty"`
+}
+
+// Info is the metadata of the API.
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// PathItem maps the lowercase methods of a path to their operations.
+type PathItem map[string]*Operation
+
+// Operation is a route of the document.
+type Operation struct {
+	Summary     string               `json:"summary,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []*Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// Parameter is a parameter of an operation.
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody is the request body of an operation.
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+// Response is a response of an operation.
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+// MediaType is the schema of a body of a media type.
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+// Components holds the schemas referenced by the operations.
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme is a way the callers authenticate.
+type SecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme,omitempty"`
+}
+
+// Schema is a JSON Schema, restricted to the keywords generated from the Go
+// types. The empty schema accepts any value.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 SchemaType         `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+}
+
+// SchemaType lists the JSON types a schema accepts. It is encoded as a
+// string when there is only one.
+type SchemaType []string
+
+// MarshalJSON implements the json.Marshaler interface.
+func (st SchemaType) MarshalJSON() ([]byte, error) {
+	if len(st) == 1 {
+		return json.Marshal(st[0])
+	}
+	return json.Marshal([]string(st))
+}
+
+// JSON encodes the document as JSON.
+func (d *OpenAPI) JSON() ([]byte, error) {
+	return json.MarshalIndent(d, "", "  ")
+}
+
+// YAML encodes the document as YAML, with the keys in the order of the JSON
+// document.
+func (d *OpenAPI) YAML() ([]byte, error) {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	var ms yaml.MapSlice
+	if err := yaml.Unmarshal(b, &ms); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(ms)
+}
+
+// OpenAPI generates the OpenAPI document of the routes registered on the
+// App, from their description. The routes without a description are
+// documented from their path only.
+func (a *App) OpenAPI(info Info) (*OpenAPI, error) {
+	g := schemaGenerator{schemas: make(map[string]*Schema), names: make(map[reflect.Type]string)}
+	problem, err := g.schema(reflect.ValueOf(Problem{}))
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &OpenAPI{
+		OpenAPI:    OpenAPIVersion,
+		Info:       info,
+		Paths:      make(map[string]PathItem),
+		Components: Components{Schemas: g.schemas},
+	}
+	for _, rt := range a.routes {
+		op, err := g.operation(rt)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s %s", rt.Method, rt.Path)
+		}
+		op.Responses["default"] = &Response{
+			Description: "Error",
+			Content:     map[string]*MediaType{ProblemContentType: {Schema: problem}},
+		}
+
+		p := OpenAPIPath(rt.Path)
+		if doc.Paths[p] == nil {
+			doc.Paths[p] = make(PathItem)
+		}
+		doc.Paths[p][strings.ToLower(rt.Method)] = op
+	}
+	return doc, nil
+}
+
+// OpenAPIPath returns the path of a route in the OpenAPI document, with its
+// parameters in braces.
+func OpenAPIPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if name, ok := pathParam(s); ok {
+			segments[i] = "{" + name + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// pathParam returns the name of the parameter of a path segment, false
+// when the segment is static.
+func pathParam(segment string) (string, bool) {
+	if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+		return segment[1:], true
+	}
+	return "", false
+}
+
+// schemaGenerator generates the schemas of the Go types. The named structs
+// are added to the schemas and referenced.
+type schemaGenerator struct {
+	schemas map[string]*Schema
+	names   map[reflect.Type]string
+}
+
+// operation generates the operation of a route.
+func (g *schemaGenerator) operation(rt *Route) (*Operation, error) {
+	d := rt.Doc
+	op := &Operation{
+		Summary:     d.Summary,
+		Description: d.Description,
+		Tags:        d.Tags,
+		Responses:   make(map[string]*Response),
+	}
+
+	// The path parameters come first, in the order of the path.
+	inPath := make(map[string]Param)
+	for _, p := range d.Params {
+		if p.In == "path" {
+			inPath[p.Name] = p
+		}
+	}
+	for _, s := range strings.Split(rt.Path, "/") {
+		name, ok := pathParam(s)
+		if !ok {
+			continue
+		}
+		p, ok := inPath[name]
+		if !ok {
+			p = Param{Name: name, In: "path"}
+		}
+		delete(inPath, name)
+		p.Required = true
+		op.Parameters = append(op.Parameters, parameter(p))
+	}
+	for name := range inPath {
+		return nil, errors.Errorf("path parameter %s is not in the path", name)
+	}
+	for _, p := range d.Params {
+		if p.In != "path" {
+			op.Parameters = append(op.Parameters, parameter(p))
+		}
+	}
+
+	if d.Body != nil {
+		content, err := g.content(d.Body)
+		if err != nil {
+			return nil, errors.Wrap(err, "body")
+		}
+		op.RequestBody = &RequestBody{Required: true, Content: content}
+	}
+
+	codes := make([]int, 0, len(d.Responses))
+	for code := range d.Responses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		resp := &Response{Description: http.StatusText(code)}
+		if body := d.Responses[code]; body != nil {
+			content, err := g.content(body)
+			if err != nil {
+				return nil, errors.Wrapf(err, "response %d", code)
+			}
+			resp.Content = content
+		}
+		op.Responses[strconv.Itoa(code)] = resp
+	}
+	return op, nil
+}
+
+// parameter returns the OpenAPI parameter of a Param.
+func parameter(p Param) *Parameter {
+	s := &Schema{Type: SchemaType{"string"}, Format: p.Format}
+	if p.Type != "" {
+		s.Type = SchemaType{p.Type}
+	}
+	for _, e := range p.Enum {
+		s.Enum = append(s.Enum, e)
+	}
+	in := p.In
+	if in == "" {
+		in = "query"
+	}
+	return &Parameter{Name: p.Name, In: in, Description: p.Description, Required: p.Required, Schema: s}
+}
+
+// content returns the content of a body, by media type.
+func (g *schemaGenerator) content(body interface{}) (map[string]*MediaType, error) {
+	content := make(map[string]*MediaType)
+	if m, ok := body.(Media); ok {
+		for mt, v := range m {
+			content[mt] = &MediaType{}
+			if v == nil {
+				continue
+			}
+			s, err := g.schema(reflect.ValueOf(v))
+			if err != nil {
+				return nil, err
+			}
+			content[mt].Schema = s
+		}
+		return content, nil
+	}
+
+	s, err := g.schema(reflect.ValueOf(body))
+	if err != nil {
+		return nil, err
+	}
+	codecs.RLock()
+	defer codecs.RUnlock()
+	for _, mt := range codecs.types {
+		content[mt] = &MediaType{Schema: s}
+	}
+	return content, nil
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schema returns the schema of a value.
+func (g *schemaGenerator) schema(v reflect.Value) (*Schema, error) {
+	return g.schemaOf(v.Type(), v)
+}
+
+// schemaOf returns the schema of a type. The value, which may be invalid,
+// documents the interfaces it holds.
+func (g *schemaGenerator) schemaOf(t reflect.Type, v reflect.Value) (*Schema, error) {
+	switch t {
+	case timeType:
+		return &Schema{Type: SchemaType{"string"}, Format: "date-time"}, nil
+	case rawMessageType:
+		return &Schema{}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsValid() && !v.IsNil() {
+			return g.schemaOf(v.Elem().Type(), v.Elem())
+		}
+		if t.Kind() == reflect.Ptr {
+			return g.schemaOf(t.Elem(), reflect.Value{})
+		}
+		return &Schema{}, nil
+	case reflect.Bool:
+		return &Schema{Type: SchemaType{"boolean"}}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: SchemaType{"integer"}}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		zero := 0.0
+		return &Schema{Type: SchemaType{"integer"}, Minimum: &zero}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: SchemaType{"number"}}, nil
+	case reflect.String:
+		return &Schema{Type: SchemaType{"string"}}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: SchemaType{"string"}, Format: "byte"}, nil
+		}
+		var ev reflect.Value
+		if v.IsValid() && v.Len() > 0 {
+			ev = v.Index(0)
+		}
+		items, err := g.schemaOf(t.Elem(), ev)
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: SchemaType{"array"}, Items: items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, errors.Errorf("map key of %s is not a string", t)
+		}
+		elem, err := g.schemaOf(t.Elem(), reflect.Value{})
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: SchemaType{"object"}, AdditionalProperties: elem}, nil
+	case reflect.Struct:
+		return g.structSchema(t, v)
+	}
+	return nil, errors.Errorf("unsupported type %s", t)
+}
+
+// structSchema returns the schema of a struct. The named structs are
+// referenced, unless they hold interfaces, whose schema depends on the
+// value.
+func (g *schemaGenerator) structSchema(t reflect.Type, v reflect.Value) (*Schema, error) {
+	named := t.Name() != "" && !holdsInterface(t)
+	if named {
+		if name, ok := g.names[t]; ok {
+			return &Schema{Ref: "#/components/schemas/" + name}, nil
+		}
+	}
+
+	s := &Schema{Type: SchemaType{"object"}, Properties: make(map[string]*Schema)}
+	if named {
+		name := t.Name()
+		if _, taken := g.schemas[name]; taken {
+			name = pkgName(t) + name
+		}
+		g.names[t] = name
+		g.schemas[name] = s
+	}
+	if err := g.fields(s, t, v); err != nil {
+		return nil, err
+	}
+	if named {
+		return &Schema{Ref: "#/components/schemas/" + g.names[t]}, nil
+	}
+	return s, nil
+}
+
+// fields adds the properties of the fields of a struct to its schema, like
+// encoding/json encodes them. The fields of the embedded structs are
+// promoted.
+func (g *schemaGenerator) fields(s *Schema, t reflect.Type, v reflect.Value) error {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		var fv reflect.Value
+		if v.IsValid() {
+			fv = v.Field(i)
+		}
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if i := strings.Index(tag, ","); i >= 0 {
+			name, opts = tag[:i], tag[i+1:]
+		}
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+				fv = reflect.Value{}
+			}
+			if ft.Kind() == reflect.Struct {
+				if err := g.fields(s, ft, fv); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		fs, err := g.schemaOf(f.Type, fv)
+		if err != nil {
+			return errors.Wrap(err, f.Name)
+		}
+		if validateTags(fs, f.Tag.Get("validate")) {
+			s.Required = append(s.Required, name)
+		}
+		omitempty := strings.Contains(","+opts+",", ",omitempty,")
+		switch f.Type.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map:
+			if !omitempty {
+				fs = nullable(fs)
+			}
+		}
+		s.Properties[name] = fs
+	}
+	return nil
+}
+
+// holdsInterface tells whether a struct has interface fields, the embedded
+// structs included.
+func holdsInterface(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Interface && ft != reflect.TypeOf((*error)(nil)).Elem() {
+			return true
+		}
+		if f.Anonymous && ft.Kind() == reflect.Struct && holdsInterface(ft) {
+			return true
+		}
+	}
+	return false
+}
+
+// pkgName returns the name of the package of a type, capitalized.
+func pkgName(t reflect.Type) string {
+	p := t.PkgPath()
+	p = p[strings.LastIndex(p, "/")+1:]
+	if p == "" {
+		return ""
+	}
+	return strings.ToUpper(p[:1]) + p[1:]
+}
+
+// nullable returns the schema accepting null too.
+func nullable(s *Schema) *Schema {
+	switch {
+	case s.Ref != "":
+		return &Schema{AnyOf: []*Schema{s, {Type: SchemaType{"null"}}}}
+	case len(s.Type) > 0:
+		s.Type = append(s.Type, "null")
+	}
+	return s
+}
+
+// validateTags adds the rules of the validate tag of a field to its schema,
+// and tells whether the field is required. The rules after dive apply to
+// the items.
+func validateTags(s *Schema, tag string) bool {
+	if tag == "" {
+		return false
+	}
+	var required bool
+	for _, rule := range strings.Split(tag, ",") {
+		if rule == "dive" {
+			if s.Items == nil {
+				return required
+			}
+			s = s.Items
+			continue
+		}
+		name, param := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			name, param = rule[:i], rule[i+1:]
+		}
+		switch name {
+		case "required":
+			required = true
+		case "uuid", "uuid4":
+			s.Format = "uuid"
+		case "email":
+			s.Format = "email"
+		case "url":
+			s.Format = "uri"
+		case "oneof":
+			for _, e := range strings.Fields(param) {
+				s.Enum = append(s.Enum, e)
+			}
+		case "min", "max", "gte", "lte":
+			n, err := strconv.Atoi(param)
+			if err != nil {
+				continue
+			}
+			limit(s, name == "min" || name == "gte", n)
+		}
+	}
+	return required
+}
+
+// limit sets the lower or upper limit of the size or value of a schema.
+func limit(s *Schema, lower bool, n int) {
+	var kind string
+	if len(s.Type) > 0 {
+		kind = s.Type[0]
+	}
+	switch kind {
+	case "string":
+		if lower {
+			s.MinLength = &n
+		} else {
+			s.MaxLength = &n
+		}
+	case "array":
+		if lower {
+			s.MinItems = &n
+		} else {
+			s.MaxItems = &n
+		}
+	case "integer", "number":
+		f := float64(n)
+		if lower {
+			s.Minimum = &f
+		} else {
+			s.Maximum = &f
+		}
+	}
+}
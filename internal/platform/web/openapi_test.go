@@ -0,0 +1,128 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+)
+
+type testItem struct {
+	ID        *string    `json:"id"`
+	Title     string     `json:"title" validate:"required,min=3"`
+	Tags      []string   `json:"tags" validate:"omitempty,dive,oneof=a b"`
+	Secret    string     `json:"-"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+type testDetailed struct {
+	testItem
+	Score int `json:"score" validate:"max=10"`
+}
+
+func TestOpenAPI(t *testing.T) {
+	noop := func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+		return nil
+	}
+	app := New(log.WithField("test", t.Name()))
+	app.Handle("GET", "/v1/items", noop).Describe(Doc{
+		Summary:   "List the items",
+		Params:    []Param{{Name: "limit", Type: "integer"}},
+		Responses: map[int]interface{}{http.StatusOK: Page{Data: []testItem{}}},
+	})
+	app.Handle("PUT", "/v1/items/:id", noop).Describe(Doc{
+		Params:    []Param{{Name: "id", In: "path", Format: "uuid"}, {Name: "If-Match", In: "header"}},
+		Body:      testItem{},
+		Responses: map[int]interface{}{http.StatusOK: &testDetailed{}},
+	})
+	app.Handle("GET", "/v1/items/:id/file", noop).Describe(Doc{
+		Responses: map[int]interface{}{http.StatusOK: Media{"image/*": nil}},
+	})
+	app.Handle("GET", "/v1/blobs/*key", noop)
+
+	doc, err := app.OpenAPI(Info{Title: "test", Version: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for p := range doc.Paths {
+		paths = append(paths, p)
+	}
+	for _, p := range []string{"/v1/items", "/v1/items/{id}", "/v1/items/{id}/file", "/v1/blobs/{key}"} {
+		if doc.Paths[p] == nil {
+			t.Errorf("path %s missing from %v", p, paths)
+		}
+	}
+
+	put := doc.Paths["/v1/items/{id}"]["put"]
+	if len(put.Parameters) != 2 || put.Parameters[0].Name != "id" || !put.Parameters[0].Required || put.Parameters[0].Schema.Format != "uuid" || put.Parameters[1].In != "header" {
+		t.Errorf("parameters = %+v, want id in path then If-Match", put.Parameters)
+	}
+	if s := put.RequestBody.Content[JSONContentType].Schema; s.Ref != "#/components/schemas/testItem" {
+		t.Errorf("body schema = %+v, want a reference to testItem", s)
+	}
+	if put.Responses["default"].Content[ProblemContentType].Schema.Ref != "#/components/schemas/Problem" {
+		t.Errorf("default response = %+v, want a problem", put.Responses["default"])
+	}
+	if file := doc.Paths["/v1/items/{id}/file"]["get"].Responses["200"]; file.Content["image/*"] == nil || file.Content["image/*"].Schema != nil {
+		t.Errorf("file response = %+v, want image/* without schema", file)
+	}
+
+	item := doc.Components.Schemas["testItem"]
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{name: "required", got: item.Required, want: []string{"title"}},
+		{name: "nullable pointer", got: item.Properties["id"].Type, want: SchemaType{"string", "null"}},
+		{name: "omitempty pointer", got: item.Properties["created_at"].Type, want: SchemaType{"string"}},
+		{name: "time", got: item.Properties["created_at"].Format, want: "date-time"},
+		{name: "min", got: *item.Properties["title"].MinLength, want: 3},
+		{name: "dive", got: item.Properties["tags"].Items.Enum, want: []interface{}{"a", "b"}},
+		{name: "skipped", got: item.Properties["Secret"] == nil && len(item.Properties) == 4, want: true},
+		{name: "embedded", got: len(doc.Components.Schemas["testDetailed"].Properties), want: 5},
+		{name: "max", got: *doc.Components.Schemas["testDetailed"].Properties["score"].Maximum, want: 10.0},
+		{name: "interface", got: doc.Paths["/v1/items"]["get"].Responses["200"].Content[JSONContentType].Schema.Properties["data"].Items.Ref, want: "#/components/schemas/testItem"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !reflect.DeepEqual(tt.got, tt.want) {
+				t.Errorf("got %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+
+	b, err := doc.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v map[string]interface{}
+	if err := json.Unmarshal(b, &v); err != nil || v["openapi"] != OpenAPIVersion {
+		t.Errorf("JSON = %s, %v, want an OpenAPI %s document", b, err, OpenAPIVersion)
+	}
+	y, err := doc.YAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(y), "openapi: 3.1.0\ninfo:\n") {
+		t.Errorf("YAML starts with %q, want the keys in order", strings.SplitN(string(y), "\n", 3))
+	}
+}
+
+func TestOpenAPIPathParam(t *testing.T) {
+	noop := func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+		return nil
+	}
+	app := New(log.WithField("test", t.Name()))
+	app.Handle("GET", "/v1/items", noop).Describe(Doc{Params: []Param{{Name: "id", In: "path"}}})
+	if _, err := app.OpenAPI(Info{}); err == nil {
+		t.Error("OpenAPI() succeeded, want an error for the parameter not in the path")
+	}
+}
@@ -0,0 +1,41 @@
+package web
+
+import (
+	"net/url"
+	"strconv"
+)
+
+// Page is the envelope of paginated responses.
+type Page struct {
+	Data   interface{} `json:"data"`
+	Total  int         `json:"total"`
+	Limit  int         `json:"limit"`
+	Offset int         `json:"offset"`
+}
+
+// ParsePage reads the limit and offset query parameters. The limit defaults
+// to def and can't exceed max.
+func ParsePage(qp url.Values, def, max int) (limit, offset int, err error) {
+	var inv InvalidError
+
+	limit = def
+	if s := qp.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > max {
+			inv = append(inv, Invalid{Fld: "limit", Err: "max", Param: strconv.Itoa(max), Msg: "must be an integer between 1 and " + strconv.Itoa(max)})
+		}
+		limit = n
+	}
+	if s := qp.Get("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			inv = append(inv, Invalid{Fld: "offset", Err: "min", Param: "0", Msg: "must be a positive integer"})
+		}
+		offset = n
+	}
+
+	if len(inv) > 0 {
+		return 0, 0, inv
+	}
+	return limit, offset, nil
+}
@@ -0,0 +1,147 @@
+package web
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ProblemContentType is the media type of RFC 7807 problem documents.
+const ProblemContentType = "application/problem+json"
+
+// ProblemBaseURI prefixes the type URI of the problems defined by the API.
+const ProblemBaseURI = "/problems/"
+
+// Problem is an RFC 7807 problem details document. InvalidParams is an
+// extension member listing the fields which failed validation.
+type Problem struct {
+	Type          string       `json:"type"`
+	Title         string       `json:"title"`
+	Status        int          `json:"status"`
+	Detail        string       `json:"detail,omitempty"`
+	Instance      string       `json:"instance,omitempty"`
+	InvalidParams InvalidError `json:"invalid_params,omitempty"`
+}
+
+// ProblemType describes the type, title and status code sent back for a
+// class of errors.
+type ProblemType struct {
+	Type   string
+	Title  string
+	Status int
+}
+
+// ErrorMapper maps an error to a problem type. It returns false when it does
+// not know about the error.
+type ErrorMapper func(err error) (ProblemType, bool)
+
+// problems holds the error to problem mappings registered by the packages.
+var problems = struct {
+	sync.RWMutex
+	errs    map[error]ProblemType
+	mappers []ErrorMapper
+}{
+	errs: make(map[error]ProblemType),
+}
+
+// RegisterError maps a sentinel error to a problem type. Domain packages
+// register their own errors from an init function.
+func RegisterError(err error, pt ProblemType) {
+	problems.Lock()
+	defer problems.Unlock()
+	problems.errs[err] = pt
+}
+
+// RegisterErrorFunc adds a mapper for errors which can't be compared by value,
+// like typed errors. Mappers are tried in registration order.
+func RegisterErrorFunc(fn ErrorMapper) {
+	problems.Lock()
+	defer problems.Unlock()
+	problems.mappers = append(problems.mappers, fn)
+}
+
+// LookupProblem returns the problem type registered for the error cause. Unknown
+// errors are reported as internal server errors.
+func LookupProblem(err error) ProblemType {
+	problems.RLock()
+	defer problems.RUnlock()
+
+	// Typed errors like InvalidError can't be used as map keys.
+	if reflect.TypeOf(err).Comparable() {
+		if pt, ok := problems.errs[err]; ok {
+			return pt
+		}
+	}
+	for _, fn := range problems.mappers {
+		if pt, ok := fn(err); ok {
+			return pt
+		}
+	}
+
+	return ProblemType{
+		Type:   "about:blank",
+		Title:  http.StatusText(http.StatusInternalServerError),
+		Status: http.StatusInternalServerError,
+	}
+}
+
+// Negotiate returns the offer preferred by the Accept header of the request,
+// or an empty string when none is acceptable. See negotiate.
+func Negotiate(r *http.Request, offers ...string) string {
+	return negotiate(r.Header.Get("Accept"), offers...)
+}
+
+// negotiate returns the offer preferred by the Accept header value. Ties
+// are won by the first offer and an empty header accepts anything. An empty
+// string is returned when no offer is acceptable.
+func negotiate(accept string, offers ...string) string {
+	if accept == "" {
+		return offers[0]
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		q := acceptQuality(accept, offer)
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the quality the Accept header gives to the media
+// type, using the most specific matching range.
+func acceptQuality(accept, mediaType string) float64 {
+	q, specificity := 0.0, -1
+	for _, mr := range strings.Split(accept, ",") {
+		parts := strings.Split(mr, ";")
+		rng := strings.ToLower(strings.TrimSpace(parts[0]))
+
+		s := -1
+		switch {
+		case rng == mediaType:
+			s = 2
+		case rng == "*/*":
+			s = 0
+		case strings.HasSuffix(rng, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(rng, "*")):
+			s = 1
+		}
+		if s <= specificity {
+			continue
+		}
+
+		rq := 1.0
+		for _, p := range parts[1:] {
+			kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+			if len(kv) == 2 && strings.ToLower(kv[0]) == "q" {
+				if f, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					rq = f
+				}
+			}
+		}
+		q, specificity = rq, s
+	}
+	return q
+}
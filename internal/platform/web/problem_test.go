@@ -0,0 +1,86 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		want   string
+	}{
+		{name: "empty accept", accept: "", want: ProblemContentType},
+		{name: "any type", accept: "*/*", want: ProblemContentType},
+		{name: "legacy client", accept: "application/json", want: "application/json"},
+		{name: "problem client", accept: "application/problem+json", want: ProblemContentType},
+		{name: "quality wins", accept: "application/problem+json;q=0.5, application/json", want: "application/json"},
+		{name: "specific range wins", accept: "application/*;q=0.1, application/json;q=0.9", want: "application/json"},
+		{name: "nothing acceptable", accept: "text/html", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := negotiate(tt.accept, ProblemContentType, "application/json"); got != tt.want {
+				t.Errorf("negotiate(%q) = %q, want %q", tt.accept, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupProblem(t *testing.T) {
+	errTeapot := errors.New("teapot")
+	RegisterError(errTeapot, ProblemType{Type: ProblemBaseURI + "teapot", Title: "Teapot", Status: http.StatusTeapot})
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "builtin error", err: ErrNotFound, want: http.StatusNotFound},
+		{name: "registered error", err: errTeapot, want: http.StatusTeapot},
+		{name: "typed error", err: InvalidError{{Fld: "title", Err: "required"}}, want: http.StatusBadRequest},
+		{name: "unknown error", err: errors.New("boom"), want: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := LookupProblem(tt.err).Status; got != tt.want {
+				t.Errorf("LookupProblem(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRespondProblemLegacy(t *testing.T) {
+	type model struct {
+		Publisher string `json:"publisher" validate:"required"`
+	}
+	err := Unmarshal(strings.NewReader(`{}`), &model{})
+
+	tests := []struct {
+		name   string
+		accept string
+		want   string
+	}{
+		{name: "legacy client", accept: "application/json", want: `{"error":"field validation failure","fields":[{"field_name":"Publisher","error":"required"}]}`},
+		{name: "problem client", accept: ProblemContentType, want: `"invalid_params":[{"field_name":"publisher","error":"required","message":"is required"}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), KeyValues, &Values{Accept: tt.accept})
+			w := httptest.NewRecorder()
+			Error(ctx, w, err)
+			if got := w.Body.String(); !strings.Contains(got, tt.want) {
+				t.Errorf("body = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,18 +1,23 @@
 // Current Status Codes:
 //		200 OK           : StatusOK                  : Call is success and returning data.
 //		204 No Content   : StatusNoContent           : Call is success and returns no data.
+//		304 Not Modified : StatusNotModified         : Entity matches the If-None-Match tag.
 //		400 Bad Request  : StatusBadRequest          : Invalid post data (syntax or semantics).
 //		401 Unauthorized : StatusUnauthorized        : Authentication failure.
 //		404 Not Found    : StatusNotFound            : Invalid URL or identifier.
+//		406 Unacceptable : StatusNotAcceptable       : No representation matches the Accept header.
+//		409 Conflict     : StatusConflict            : Entity conflicts with an existing one.
+//		412 Precondition : StatusPreconditionFailed  : Entity doesn't match the If-Match tag.
+//		415 Unsupported  : StatusUnsupportedMediaType : No codec decodes the request body.
+//		428 Precondition : StatusPreconditionRequired : If-Match is required and missing.
 //		500 Internal     : StatusInternalServerError : Application specific beyond scope of user.
+//		503 Unavailable  : StatusServiceUnavailable  : Request timed out or was shed under load.
 
 package web
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 
@@ -21,8 +26,14 @@ import (
 
 // Invalid describes a validation error belonging to a specific field.
 type Invalid struct {
-	Fld string `json:"field_name"`
-	Err string `json:"error"`
+	Fld   string `json:"field_name"`
+	Err   string `json:"error"`
+	Param string `json:"param,omitempty"`
+	Msg   string `json:"message,omitempty"`
+
+	// Name is the Go name of the field, reported by the legacy JSONError.
+	// Fld is reported when it is empty.
+	Name string `json:"-"`
 }
 
 // InvalidError is a custom error type for invalid fields.
@@ -37,7 +48,8 @@ func (err InvalidError) Error() string {
 	return str
 }
 
-// JSONError is the response for errors that occur within the API.
+// JSONError is the legacy response for errors that occur within the API. It
+// is still sent to clients which only accept application/json.
 type JSONError struct {
 	Error  string       `json:"error"`
 	Fields InvalidError `json:"fields,omitempty"`
@@ -58,50 +70,142 @@ var (
 
 	// ErrValidation occurs when there are validation errors.
 	ErrValidation = errors.New("Validation errors occurred")
-)
 
-// Error handles all error responses for the API.
-func Error(cxt context.Context, w http.ResponseWriter, err error) {
-	switch errors.Cause(err) {
-	case ErrNotFound:
-		RespondError(cxt, w, err, http.StatusNotFound)
-		return
+	// ErrMalformedBody occurs when the request body can't be decoded.
+	ErrMalformedBody = errors.New("Request body is malformed")
 
-	case ErrInvalidID:
-		RespondError(cxt, w, err, http.StatusBadRequest)
-		return
+	// ErrNotAcceptable occurs when the resource has no representation
+	// accepted by the Accept header.
+	ErrNotAcceptable = errors.New("No acceptable representation")
 
-	case ErrValidation:
-		RespondError(cxt, w, err, http.StatusBadRequest)
-		return
+	// ErrUnsupportedMediaType occurs when no codec decodes the media type of
+	// the request body.
+	ErrUnsupportedMediaType = errors.New("Unsupported media type")
 
-	case ErrNotAuthorized:
-		RespondError(cxt, w, err, http.StatusUnauthorized)
-		return
-	}
+	// ErrPreconditionFailed occurs when the entity was changed since the
+	// version of the If-Match header.
+	ErrPreconditionFailed = errors.New("Entity was modified")
+
+	// ErrTimeout occurs when the request exceeds the deadline of its route.
+	ErrTimeout = errors.New("Request timed out")
 
-	switch e := errors.Cause(err).(type) {
-	case InvalidError:
-		v := JSONError{
-			Error:  "field validation failure",
-			Fields: e,
+	// ErrPreconditionRequired occurs when a change requires the If-Match
+	// header and the request has none.
+	ErrPreconditionRequired = errors.New("If-Match header is required")
+)
+
+func init() {
+	RegisterError(ErrNotFound, ProblemType{Type: ProblemBaseURI + "not-found", Title: "Entity not found", Status: http.StatusNotFound})
+	RegisterError(ErrInvalidID, ProblemType{Type: ProblemBaseURI + "invalid-id", Title: "Invalid identifier", Status: http.StatusBadRequest})
+	RegisterError(ErrValidation, ProblemType{Type: ProblemBaseURI + "validation", Title: "Validation errors occurred", Status: http.StatusBadRequest})
+	RegisterError(ErrMalformedBody, ProblemType{Type: ProblemBaseURI + "malformed-body", Title: "Request body is malformed", Status: http.StatusBadRequest})
+	RegisterError(ErrNotAcceptable, ProblemType{Type: ProblemBaseURI + "not-acceptable", Title: "No acceptable representation", Status: http.StatusNotAcceptable})
+	RegisterError(ErrUnsupportedMediaType, ProblemType{Type: ProblemBaseURI + "unsupported-media-type", Title: "Unsupported media type", Status: http.StatusUnsupportedMediaType})
+	RegisterError(ErrPreconditionFailed, ProblemType{Type: ProblemBaseURI + "precondition-failed", Title: "Entity was modified", Status: http.StatusPreconditionFailed})
+	RegisterError(ErrPreconditionRequired, ProblemType{Type: ProblemBaseURI + "precondition-required", Title: "If-Match header is required", Status: http.StatusPreconditionRequired})
+	RegisterError(ErrTimeout, ProblemType{Type: ProblemBaseURI + "timeout", Title: "Request timed out", Status: http.StatusServiceUnavailable})
+	RegisterError(ErrNotAuthorized, ProblemType{Type: ProblemBaseURI + "not-authorized", Title: "Not authorized", Status: http.StatusUnauthorized})
+
+	RegisterErrorFunc(func(err error) (ProblemType, bool) {
+		if _, ok := err.(InvalidError); ok {
+			return ProblemType{Type: ProblemBaseURI + "validation", Title: "field validation failure", Status: http.StatusBadRequest}, true
 		}
+		return ProblemType{}, false
+	})
+}
+
+// Error handles all error responses for the API. The status code and problem
+// type are looked up from the registered error mappings, see RegisterError.
+func Error(ctx context.Context, w http.ResponseWriter, err error) {
+	RespondProblem(ctx, w, NewProblem(err))
+}
+
+// NewProblem describes the error as a problem, looked up from the
+// registered error mappings.
+func NewProblem(err error) Problem {
+	cause := errors.Cause(err)
+	pt := LookupProblem(cause)
+
+	p := Problem{
+		Type:   pt.Type,
+		Title:  pt.Title,
+		Status: pt.Status,
+		Detail: cause.Error(),
+	}
+	if inv, ok := cause.(InvalidError); ok {
+		p.Detail = pt.Title
+		p.InvalidParams = inv
+	}
+	return p
+}
+
+// RespondError sends a problem describing the error with the given status
+// code.
+func RespondError(ctx context.Context, w http.ResponseWriter, err error, code int) {
+	RespondProblem(ctx, w, Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(code),
+		Status: code,
+		Detail: err.Error(),
+	})
+}
+
+// RespondProblem sends the problem to the client as application/problem+json,
+// or in the legacy JSONError shape when the client only accepts
+// application/json.
+func RespondProblem(ctx context.Context, w http.ResponseWriter, p Problem) {
+	v := ctx.Value(KeyValues).(*Values)
 
-		Respond(cxt, w, v, http.StatusBadRequest)
+	if negotiate(v.Accept, ProblemContentType, "application/json") == "application/json" {
+		Respond(ctx, w, JSONError{Error: p.Detail, Fields: legacyFields(p.InvalidParams)}, p.Status)
 		return
 	}
 
-	RespondError(cxt, w, err, http.StatusInternalServerError)
+	// Internal errors may carry driver or query details, don't leak them.
+	if p.Status >= http.StatusInternalServerError {
+		p.Detail = ""
+	}
+	p.Instance = v.TraceID
+
+	respond(ctx, w, p, p.Status, ProblemContentType, marshalJSON)
 }
 
-// RespondError sends JSON describing the error
-func RespondError(ctx context.Context, w http.ResponseWriter, err error, code int) {
-	Respond(ctx, w, JSONError{Error: err.Error()}, code)
+// legacyFields returns the field errors as the legacy JSONError reported
+// them, with the Go name of the field and the failed tag only.
+func legacyFields(inv InvalidError) InvalidError {
+	if inv == nil {
+		return nil
+	}
+	fields := make(InvalidError, len(inv))
+	for i, f := range inv {
+		fld := f.Name
+		if fld == "" {
+			fld = f.Fld
+		}
+		fields[i] = Invalid{Fld: fld, Err: f.Err}
+	}
+	return fields
 }
 
-// Respond sends JSON to the client.
+// Respond sends the data to the client, encoded by the codec preferred by
+// the Accept header, compact JSON by default. The pretty query parameter
+// indents the output. When no codec is acceptable, the client gets a 406
+// problem.
 // If code is StatusNoContent, v is expected to be nil.
 func Respond(ctx context.Context, w http.ResponseWriter, data interface{}, code int) {
+	v := ctx.Value(KeyValues).(*Values)
+	w.Header().Add("Vary", "Accept")
+
+	mt, c, ok := negotiateCodec(v.Accept)
+	if !ok {
+		Error(ctx, w, errors.Wrapf(ErrNotAcceptable, "Accept: %s", v.Accept))
+		return
+	}
+	respond(ctx, w, data, code, mt, c.Marshal)
+}
+
+// respond encodes data and sends it with the given content type.
+func respond(ctx context.Context, w http.ResponseWriter, data interface{}, code int, contentType string, marshal func(interface{}, bool) ([]byte, error)) {
 
 	// Set the status code for the request logger middleware.
 	v := ctx.Value(KeyValues).(*Values)
@@ -113,21 +217,24 @@ func Respond(ctx context.Context, w http.ResponseWriter, data interface{}, code
 		return
 	}
 
+	// Encode the data before the status is sent, a failure is an internal
+	// error.
+	body, err := marshal(data, v.Pretty)
+	if err != nil {
+		log.Printf("%s : Respond %v Marshalling %s response\n", v.TraceID, err, contentType)
+		v.StatusCode = http.StatusInternalServerError
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
 	// Set the content type.
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", contentType)
 
 	// Write the status code to the response and context.
 	w.WriteHeader(code)
 
-	// Marshal the data into a JSON string.
-	jsonData, err := json.MarshalIndent(data, "", "  ")
-	if err != nil {
-		log.Printf("%s : Respond %v Marshalling JSON response\n", v.TraceID, err)
-		jsonData = []byte("{}")
-	}
-
 	// Send the result back to the client.
-	if _, err := io.WriteString(w, string(jsonData)); err != nil {
-		log.Printf("%s : Respond %v WriteString JSON response\n", v.TraceID, err)
+	if _, err := w.Write(body); err != nil {
+		log.Printf("%s : Respond %v Write %s response\n", v.TraceID, err, contentType)
 	}
 }
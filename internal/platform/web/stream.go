@@ -0,0 +1,123 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stream writes a response whose body the handler writes as it goes,
+// bypassing Respond. The headers are set by the header function and sent
+// with the first write, so that the errors occurring before get a regular
+// response.
+type Stream struct {
+	http.ResponseWriter
+	v       *Values
+	header  func(http.Header)
+	started bool
+}
+
+// NewStream returns the stream of the response.
+func NewStream(ctx context.Context, w http.ResponseWriter, header func(http.Header)) *Stream {
+	return &Stream{ResponseWriter: w, v: ctx.Value(KeyValues).(*Values), header: header}
+}
+
+// Started tells whether the status was sent, after which the errors can't
+// be reported to the client.
+func (s *Stream) Started() bool {
+	return s.started
+}
+
+// WriteHeader implements the http.ResponseWriter interface.
+func (s *Stream) WriteHeader(code int) {
+	if !s.started {
+		s.started = true
+		s.header(s.Header())
+		s.v.StatusCode = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements the io.Writer interface.
+func (s *Stream) Write(b []byte) (int, error) {
+	if !s.started {
+		s.WriteHeader(http.StatusOK)
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Flush implements the http.Flusher interface.
+func (s *Stream) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Abort ends a started stream abruptly, so that the client doesn't mistake
+// the truncated response for a complete one. The error is logged.
+func (s *Stream) Abort(err error) {
+	s.v.Log.Errorf("%s : Stream aborted : %+v", s.v.TraceID, err)
+	panic(http.ErrAbortHandler)
+}
+
+// EventStreamContentType is the media type of the Server-Sent Events.
+const EventStreamContentType = "text/event-stream"
+
+// EventStream writes Server-Sent Events. Each write is flushed.
+type EventStream struct {
+	*Stream
+}
+
+// NewEventStream returns the event stream of the response.
+func NewEventStream(ctx context.Context, w http.ResponseWriter) *EventStream {
+	return &EventStream{NewStream(ctx, w, func(h http.Header) {
+		h.Set("Content-Type", EventStreamContentType)
+		h.Set("Cache-Control", "no-store")
+
+		// Proxies like nginx would buffer the events.
+		h.Set("X-Accel-Buffering", "no")
+	})}
+}
+
+// Open sends the headers along with the time after which the client
+// reconnects when the stream ends.
+func (es *EventStream) Open(retry time.Duration) error {
+	return es.write("retry: " + strconv.FormatInt(int64(retry/time.Millisecond), 10) + "\n\n")
+}
+
+// Send writes an event. The client resumes after the id when it
+// reconnects.
+func (es *EventStream) Send(id, event string, data []byte) error {
+	var buf bytes.Buffer
+	if id != "" {
+		buf.WriteString("id: " + id + "\n")
+	}
+	if event != "" {
+		buf.WriteString("event: " + event + "\n")
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteString("\n")
+	}
+	buf.WriteString("\n")
+	return es.write(buf.String())
+}
+
+// Comment writes a comment, ignored by the clients, which keeps the
+// connection alive.
+func (es *EventStream) Comment(text string) error {
+	return es.write(": " + strings.Replace(text, "\n", " ", -1) + "\n\n")
+}
+
+// write sends the text and flushes it.
+func (es *EventStream) write(s string) error {
+	if _, err := es.Write([]byte(s)); err != nil {
+		return err
+	}
+	es.Flush()
+	return nil
+}
@@ -0,0 +1,43 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+)
+
+func TestEventStream(t *testing.T) {
+	app := New(log.WithField("test", t.Name()))
+	app.Handle("GET", "/v1/images/events", func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+		es := NewEventStream(ctx, w)
+		if es.Started() {
+			t.Error("stream started before the first write")
+		}
+		es.Open(3 * time.Second)
+		es.Send("1", "image.created", []byte(`{"id":"1"}`))
+		es.Send("", "", []byte("two\nlines"))
+		es.Comment("heartbeat")
+		return nil
+	})
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest("GET", "/v1/images/events", nil))
+
+	if got := w.Header().Get("Content-Type"); got != EventStreamContentType {
+		t.Errorf("Content-Type = %q, want %q", got, EventStreamContentType)
+	}
+	if !w.Flushed {
+		t.Error("events weren't flushed")
+	}
+	want := "retry: 3000\n\n" +
+		"id: 1\nevent: image.created\ndata: {\"id\":\"1\"}\n\n" +
+		"data: two\ndata: lines\n\n" +
+		": heartbeat\n\n"
+	if got := w.Body.String(); got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+}
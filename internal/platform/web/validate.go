@@ -0,0 +1,234 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// validate provides a validator for checking models.
+var validate = newValidator()
+
+// messages holds the human readable message reported for each validation
+// tag. A %s verb is replaced by the tag parameter.
+var messages = map[string]string{
+	"required": "is required",
+	"len":      "must be exactly %s characters long",
+	"min":      "must be at least %s characters long",
+	"max":      "must be at most %s characters long",
+	"eq":       "must be equal to %s",
+	"ne":       "must not be equal to %s",
+	"gt":       "must be greater than %s",
+	"gte":      "must be greater than or equal to %s",
+	"lt":       "must be less than %s",
+	"lte":      "must be less than or equal to %s",
+	"oneof":    "must be one of [%s]",
+	"email":    "must be a valid email address",
+	"url":      "must be a valid URL",
+	"uri":      "must be a valid URI",
+	"uuid":     "must be a valid UUID",
+	"uuid4":    "must be a valid version 4 UUID",
+	"numeric":  "must be a numeric value",
+	"unknown":  "is not an accepted field",
+	"type":     "must be of type %s",
+}
+
+// numericMessages replaces the length messages for numbers and collections.
+var numericMessages = map[string]string{
+	"len": "must be exactly %s",
+	"min": "must be %s or greater",
+	"max": "must be %s or less",
+}
+
+// newValidator configures a validator reporting the json names of the
+// fields.
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.SetTagName("validate")
+	v.RegisterTagNameFunc(func(fld reflect.StructField) string {
+		name := strings.SplitN(fld.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return fld.Name
+		}
+		return name
+	})
+	return v
+}
+
+// RegisterValidation adds a custom validation tag and the message reported
+// when it fails. Domain packages register their rules from an init function.
+func RegisterValidation(tag string, fn validator.Func, message string) {
+	if err := validate.RegisterValidation(tag, fn); err != nil {
+		panic(fmt.Sprintf("web: registering validation %q: %v", tag, err))
+	}
+	messages[tag] = message
+}
+
+// RegisterStructValidation adds a validation run against the whole value of
+// the given types, for rules involving several fields. The tags it reports
+// errors with need a message registered with RegisterMessage.
+func RegisterStructValidation(fn validator.StructLevelFunc, types ...interface{}) {
+	validate.RegisterStructValidation(fn, types...)
+}
+
+// RegisterMessage sets the message reported for a validation tag.
+func RegisterMessage(tag, message string) {
+	messages[tag] = message
+}
+
+// Unmarshal decodes the JSON input to the struct type and checks the
+// fields to verify the value is in a proper state. Unknown fields are ignored.
+func Unmarshal(r io.Reader, v interface{}) error {
+	return unmarshal(r, v, unmarshalJSON, false)
+}
+
+// UnmarshalStrict works like Unmarshal but reports the fields which don't
+// exist in the struct type as invalid.
+func UnmarshalStrict(r io.Reader, v interface{}) error {
+	return unmarshal(r, v, unmarshalJSON, true)
+}
+
+// UnmarshalRequest works like Unmarshal but decodes the request body with
+// the codec of its Content-Type, JSON when it has none. Media types without
+// a codec are rejected with ErrUnsupportedMediaType.
+func UnmarshalRequest(r *http.Request, v interface{}) error {
+	c, err := lookupCodec(r.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	return unmarshal(r.Body, v, c.Unmarshal, false)
+}
+
+// UnmarshalRequestStrict works like UnmarshalRequest but reports the fields
+// which don't exist in the struct type as invalid.
+func UnmarshalRequestStrict(r *http.Request, v interface{}) error {
+	c, err := lookupCodec(r.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	return unmarshal(r.Body, v, c.Unmarshal, true)
+}
+
+// unmarshal decodes the input and validates the value.
+func unmarshal(r io.Reader, v interface{}, decode func(io.Reader, interface{}, bool) error, strict bool) error {
+	if err := decode(r, v, strict); err != nil {
+		return BodyError(r, err)
+	}
+
+	return Validate(v)
+}
+
+// Validate checks the fields of the value against their validate tags and
+// the registered struct validations.
+func Validate(v interface{}) error {
+	err := validate.Struct(v)
+	if err == nil {
+		return nil
+	}
+
+	fve, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return errors.Wrap(err, "Validate")
+	}
+
+	inv := make(InvalidError, 0, len(fve))
+	for _, fe := range fve {
+		inv = append(inv, Invalid{
+			Fld:   fieldPath(fe.Namespace()),
+			Err:   fe.Tag(),
+			Param: fe.Param(),
+			Msg:   message(fe),
+			Name:  fe.StructField(),
+		})
+	}
+	return inv
+}
+
+// decodeError turns the errors of the json decoder into errors the API can
+// report to the client.
+func decodeError(err error) error {
+	switch e := err.(type) {
+	case *json.UnmarshalTypeError:
+		fld := e.Field
+		if fld == "" {
+			fld = "body"
+		}
+		return InvalidError{{Fld: fld, Err: "type", Param: e.Type.String(), Msg: fmt.Sprintf(messages["type"], jsonType(e.Type))}}
+	case *json.SyntaxError:
+		return errors.Wrap(ErrMalformedBody, err.Error())
+	}
+
+	// The decoder doesn't have a typed error for unknown fields.
+	if msg := err.Error(); strings.HasPrefix(msg, "json: unknown field ") {
+		fld := strings.Trim(strings.TrimPrefix(msg, "json: unknown field "), `"`)
+		return InvalidError{{Fld: fld, Err: "unknown", Msg: messages["unknown"]}}
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errors.Wrap(ErrMalformedBody, err.Error())
+	}
+
+	return err
+}
+
+// fieldPath removes the struct name from a validator namespace and uses dots
+// for map keys, so that Image.metadata[width] becomes metadata.width.
+func fieldPath(ns string) string {
+	if i := strings.Index(ns, "."); i >= 0 {
+		ns = ns[i+1:]
+	}
+	ns = strings.Replace(ns, "[", ".", -1)
+	return strings.Replace(ns, "]", "", -1)
+}
+
+// message builds the human readable message of a validation error.
+func message(fe validator.FieldError) string {
+	msg, ok := messages[fe.Tag()]
+	if !ok {
+		return fmt.Sprintf("failed on the %s rule", fe.Tag())
+	}
+
+	switch fe.Kind() {
+	case reflect.String:
+	case reflect.Slice, reflect.Array, reflect.Map:
+		if _, ok := numericMessages[fe.Tag()]; ok {
+			msg = strings.Replace(msg, "characters long", "items", 1)
+		}
+	default:
+		if m, ok := numericMessages[fe.Tag()]; ok {
+			msg = m
+		}
+	}
+
+	if strings.Contains(msg, "%s") {
+		return fmt.Sprintf(msg, fe.Param())
+	}
+	return msg
+}
+
+// jsonType names the JSON type expected for a Go type.
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	}
+	return t.String()
+}
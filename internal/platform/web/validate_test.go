@@ -0,0 +1,106 @@
+package web
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+type validateAddress struct {
+	City string `json:"city" validate:"required"`
+}
+
+type validateModel struct {
+	Name    string            `json:"name" validate:"required,min=3"`
+	Tags    []string          `json:"tags" validate:"max=2"`
+	Count   int               `json:"count" validate:"min=1"`
+	Address validateAddress   `json:"address"`
+	Labels  map[string]string `json:"labels" validate:"dive,min=2"`
+}
+
+func TestUnmarshal(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		strict bool
+		want   InvalidError
+		cause  error
+	}{
+		{
+			name: "valid body",
+			body: `{"name":"abc","count":1,"address":{"city":"Paris"},"extra":true}`,
+		},
+		{
+			name: "messages with parameters",
+			body: `{"name":"ab","tags":["a","b","c"],"count":0,"address":{"city":"Paris"}}`,
+			want: InvalidError{
+				{Fld: "name", Err: "min", Param: "3", Msg: "must be at least 3 characters long", Name: "Name"},
+				{Fld: "tags", Err: "max", Param: "2", Msg: "must be at most 2 items", Name: "Tags"},
+				{Fld: "count", Err: "min", Param: "1", Msg: "must be 1 or greater", Name: "Count"},
+			},
+		},
+		{
+			name: "nested field paths",
+			body: `{"name":"abc","count":1,"labels":{"color":"r"}}`,
+			want: InvalidError{
+				{Fld: "address.city", Err: "required", Msg: "is required", Name: "City"},
+				{Fld: "labels.color", Err: "min", Param: "2", Msg: "must be at least 2 characters long", Name: "Labels[color]"},
+			},
+		},
+		{
+			name:   "strict mode rejects unknown fields",
+			body:   `{"name":"abc","count":1,"address":{"city":"Paris"},"extra":true}`,
+			strict: true,
+			want:   InvalidError{{Fld: "extra", Err: "unknown", Msg: "is not an accepted field"}},
+		},
+		{
+			name: "wrong type",
+			body: `{"name":3}`,
+			want: InvalidError{{Fld: "name", Err: "type", Param: "string", Msg: "must be of type string"}},
+		},
+		{
+			name:  "malformed body",
+			body:  `{"name":`,
+			cause: ErrMalformedBody,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m validateModel
+			var err error
+			if tt.strict {
+				err = UnmarshalStrict(strings.NewReader(tt.body), &m)
+			} else {
+				err = Unmarshal(strings.NewReader(tt.body), &m)
+			}
+
+			if tt.cause != nil {
+				if errors.Cause(err) != tt.cause {
+					t.Fatalf("got error %v, want %v", err, tt.cause)
+				}
+				return
+			}
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("got error %v, want none", err)
+				}
+				return
+			}
+
+			inv, ok := err.(InvalidError)
+			if !ok {
+				t.Fatalf("got error %v, want InvalidError", err)
+			}
+			if len(inv) != len(tt.want) {
+				t.Fatalf("got %+v, want %+v", inv, tt.want)
+			}
+			for i := range inv {
+				if inv[i] != tt.want[i] {
+					t.Errorf("got %+v, want %+v", inv[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -2,45 +2,20 @@ package web
 
 import (
 	"context"
-	"encoding/json"
-	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/apex/log"
 	"github.com/dimfeld/httptreemux"
 	"github.com/pborman/uuid"
-	"gopkg.in/go-playground/validator.v8"
 )
 
 // TraceIDHeader is the header added to outgoing requests which adds the
 // traceID to it.
 const TraceIDHeader = "X-Trace-ID"
 
-// validate provides a validator for checking models.
-var validate = validator.New(&validator.Config{
-	TagName:      "validate",
-	FieldNameTag: "json",
-})
-
-// Unmarshal decodes the input to the struct type and checks the
-// fields to verify the value is in a proper state.
-func Unmarshal(r io.Reader, v interface{}) error {
-	if err := json.NewDecoder(r).Decode(v); err != nil {
-		return err
-	}
-
-	var inv InvalidError
-	if fve := validate.Struct(v); fve != nil {
-		for _, fe := range fve.(validator.ValidationErrors) {
-			inv = append(inv, Invalid{Fld: fe.Field, Err: fe.Tag})
-		}
-		return inv
-	}
-
-	return nil
-}
-
 // Key represents the type of value for the context key.
 type ctxKey int
 
@@ -53,6 +28,15 @@ type Values struct {
 	Now        time.Time
 	StatusCode int
 	Log        *log.Entry
+	Accept     string
+
+	// Pretty indents the responses, set by the pretty query parameter,
+	// which the handlers don't see.
+	Pretty bool
+
+	// Actor is the name of the authenticated caller, empty for anonymous
+	// requests.
+	Actor string
 }
 
 // A Handler is a type that handles an http request within our own little mini
@@ -70,6 +54,27 @@ type App struct {
 	*httptreemux.TreeMux
 	mw  []Middleware
 	Log *log.Entry
+
+	// Compression compresses the responses, nil to send them as is.
+	Compression *Compression
+
+	// MaxBodySize is the maximum size of the request bodies once
+	// decompressed, 0 for no limit. BodyLimits overrides it per route,
+	// keyed by method and path like "POST /v1/images".
+	MaxBodySize int64
+	BodyLimits  map[string]int64
+
+	// Timeout is the deadline of the requests, set on their context, 0 for
+	// none. Timeouts overrides it per route, keyed like BodyLimits.
+	Timeout  time.Duration
+	Timeouts map[string]time.Duration
+
+	// methods lists the methods registered for each path.
+	methods map[string][]string
+
+	// routes lists the routes in registration order, for the OpenAPI
+	// document.
+	routes []*Route
 }
 
 // New creates an App value that handle a set of routes for the application.
@@ -80,6 +85,7 @@ func New(log *log.Entry, mw ...Middleware) *App {
 		TreeMux: httptreemux.New(),
 		Log:     log,
 		mw:      mw,
+		methods: make(map[string][]string),
 	}
 }
 
@@ -100,20 +106,44 @@ func (a *App) Use(mw ...Middleware) {
 }
 
 // Handle is our mechanism for mounting Handlers for a given HTTP verb and path
-// pair, this makes for really easy, convenient routing.
-func (a *App) Handle(verb, path string, handler Handler, mw ...Middleware) {
+// pair, this makes for really easy, convenient routing. The route is returned
+// to be described in the OpenAPI document.
+func (a *App) Handle(verb, path string, handler Handler, mw ...Middleware) *Route {
 
 	// Wrap up the application-wide first, this will call the first function
 	// of each middleware which will return a function of type Handler. Each
 	// Handler will then be wrapped up with the other handlers from the chain.
 	handler = wrapMiddleware(wrapMiddleware(handler, mw), a.mw)
+	a.TreeMux.Handle(verb, path, a.serve(verb, path, handler))
+
+	// The OPTIONS requests of the path, CORS preflights included, are
+	// answered through the same middleware.
+	registered := len(a.methods[path]) > 0
+	a.methods[path] = append(a.methods[path], verb)
+	if !registered && verb != http.MethodOptions {
+		options := wrapMiddleware(wrapMiddleware(a.options(path), mw), a.mw)
+		a.TreeMux.Handle(http.MethodOptions, path, a.serve(http.MethodOptions, path, options))
+	}
+
+	rt := &Route{Method: verb, Path: path}
+	a.routes = append(a.routes, rt)
+	return rt
+}
 
-	// The function to execute for each request.
-	h := func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+// serve returns the function executing the handler of a route for each
+// request.
+func (a *App) serve(verb, path string, handler Handler) httptreemux.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
 
-		// Create the context for the request.
+		// Create the context for the request, which expires with the
+		// deadline of the route.
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
+		if d := a.timeout(verb, path); d > 0 {
+			var stop context.CancelFunc
+			ctx, stop = context.WithTimeout(ctx, d)
+			defer stop()
+		}
 
 		// Set the context with the required values to
 		// process the request.
@@ -121,9 +151,31 @@ func (a *App) Handle(verb, path string, handler Handler, mw ...Middleware) {
 			TraceID: uuid.New(),
 			Now:     time.Now(),
 			Log:     a.Log,
+			Accept:  r.Header.Get("Accept"),
+		}
+
+		// The pretty query parameter is removed once read, so that the
+		// handlers don't take it for one of theirs, like a filter.
+		if qp := r.URL.Query(); qp["pretty"] != nil {
+			v.Pretty, _ = strconv.ParseBool(qp.Get("pretty"))
+			qp.Del("pretty")
+			r = r.Clone(r.Context())
+			r.URL.RawQuery = qp.Encode()
 		}
 		ctx = context.WithValue(ctx, KeyValues, &v)
 
+		// Decompress the request body and enforce the limit of the route.
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = newBody(r, a.bodyLimit(verb, path))
+		}
+
+		// Compress the response, which is completed once the handler
+		// returns. A panicking handler leaves it incomplete.
+		done := func() {}
+		if a.Compression != nil {
+			w, done = a.Compression.newCompressWriter(w, r)
+		}
+
 		// Set the trace id on the outgoing requests before any other header to
 		// ensure that the trace id is ALWAYS added to the request regardless of
 		// any error occuring or not.
@@ -133,11 +185,41 @@ func (a *App) Handle(verb, path string, handler Handler, mw ...Middleware) {
 		if err := handler(ctx, w, r, params); err != nil {
 			a.Log.Errorf("Failed to call handler: %v", err)
 		}
+		done()
+	}
+}
+
+// options returns the handler answering the OPTIONS requests of a path
+// with the methods it allows.
+func (a *App) options(path string) Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+		methods := append([]string(nil), a.methods[path]...)
+		for _, m := range a.methods[path] {
+			if m == http.MethodGet && a.TreeMux.HeadCanUseGet {
+				methods = append(methods, http.MethodHead)
+			}
+		}
+		w.Header().Set("Allow", strings.Join(append(methods, http.MethodOptions), ", "))
+		ctx.Value(KeyValues).(*Values).StatusCode = http.StatusNoContent
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
 
+// bodyLimit returns the maximum size of the request bodies of a route.
+func (a *App) bodyLimit(verb, path string) int64 {
+	if n, ok := a.BodyLimits[verb+" "+path]; ok {
+		return n
 	}
+	return a.MaxBodySize
+}
 
-	// Add this handler for the specified verb and route.
-	a.TreeMux.Handle(verb, path, h)
+// timeout returns the deadline of the requests of a route.
+func (a *App) timeout(verb, path string) time.Duration {
+	if d, ok := a.Timeouts[verb+" "+path]; ok {
+		return d
+	}
+	return a.Timeout
 }
 
 // Group allows a segment of middleware to be shared amongst handlers.
@@ -152,14 +234,14 @@ func (g *Group) Use(mw ...Middleware) {
 }
 
 // Handle proxies the Handle function of the underlying App.
-func (g *Group) Handle(verb, path string, handler Handler, mw ...Middleware) {
+func (g *Group) Handle(verb, path string, handler Handler, mw ...Middleware) *Route {
 
 	// Wrap up the route specific middleware last because rememeber, the
 	// middleware is wrapped backwards.
 	handler = wrapMiddleware(handler, mw)
 
 	// Wrap it with the App wrapper and additionally the group level middleware.
-	g.app.Handle(verb, path, handler, g.mw...)
+	return g.app.Handle(verb, path, handler, g.mw...)
 }
 
 // wrapMiddleware wraps a handler with some middleware.
@@ -0,0 +1,89 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apex/log"
+)
+
+func TestOptions(t *testing.T) {
+	var called int
+	mw := func(next Handler) Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+			called++
+			return next(ctx, w, r, params)
+		}
+	}
+	noop := func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+		return nil
+	}
+
+	app := New(log.WithField("test", t.Name()), mw)
+	app.TreeMux.NotFoundHandler = func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}
+	app.Handle("GET", "/v1/images/:id", noop)
+	app.Group().Handle("PUT", "/v1/images/:id", noop)
+	app.Handle("DELETE", "/v1/images/:id", noop)
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		allow  string
+	}{
+		{name: "registered", path: "/v1/images/1", status: http.StatusNoContent, allow: "GET, PUT, DELETE, HEAD, OPTIONS"},
+		{name: "unknown", path: "/index.html", status: http.StatusTeapot},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called = 0
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, httptest.NewRequest("OPTIONS", tt.path, nil))
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Allow"); got != tt.allow {
+				t.Errorf("Allow = %q, want %q", got, tt.allow)
+			}
+			if tt.allow != "" && called != 1 {
+				t.Errorf("middleware called %d times, want 1", called)
+			}
+		})
+	}
+}
+
+func TestPretty(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		pretty bool
+		want   string
+	}{
+		{name: "pretty", query: "pretty=1&publisher=$eq.etf1", pretty: true, want: "publisher=%24eq.etf1"},
+		{name: "not pretty", query: "pretty=false", want: ""},
+		{name: "absent", query: "publisher=$eq.etf1", want: "publisher=$eq.etf1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pretty bool
+			var query string
+			app := New(log.WithField("test", t.Name()))
+			app.Handle("GET", "/v1/images", func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+				pretty, query = ctx.Value(KeyValues).(*Values).Pretty, r.URL.RawQuery
+				return nil
+			})
+			app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/images?"+tt.query, nil))
+
+			if pretty != tt.pretty || query != tt.want {
+				t.Errorf("pretty = %v, query = %q, want %v, %q", pretty, query, tt.pretty, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,522 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/jdelobel/go-api/internal/image"
+	"github.com/jdelobel/go-api/internal/platform/db"
+	"github.com/pkg/errors"
+)
+
+// Headers of the deliveries.
+const (
+	// SignatureHeader holds the time of a delivery and the HMAC-SHA256 of
+	// the time and the body, like t=1700000000,v1=5257a869...
+	SignatureHeader = "Webhook-Signature"
+
+	// CloudEventsContentType is the media type of the deliveries.
+	CloudEventsContentType = "application/cloudevents+json; charset=utf-8"
+)
+
+// cursorName is the row of webhooks_cursor holding the last event fanned
+// out to the webhooks.
+const cursorName = "events"
+
+// enqueueBatch is the number of events fanned out at once.
+const enqueueBatch = 500
+
+// maxResponse is the number of bytes of a response read before the
+// connection is closed.
+const maxResponse = 64 << 10
+
+// CloudEvent is the body of a delivery, a CloudEvents 1.0 event in the
+// structured mode. The id is the id of the image event, the same for all
+// the webhooks, so that the receivers can tell the retries.
+type CloudEvent struct {
+	SpecVersion     string       `json:"specversion"`
+	ID              string       `json:"id"`
+	Source          string       `json:"source"`
+	Type            string       `json:"type"`
+	Subject         string       `json:"subject"`
+	Time            time.Time    `json:"time"`
+	DataContentType string       `json:"datacontenttype"`
+	Publisher       string       `json:"publisher,omitempty"`
+	Data            *image.Image `json:"data"`
+}
+
+// NewCloudEvent returns the CloudEvent of an image event.
+func NewCloudEvent(source string, e *image.FeedEvent) CloudEvent {
+	ce := CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              strconv.FormatInt(e.ID, 10),
+		Source:          source,
+		Type:            e.Type,
+		Subject:         e.ImageID,
+		Time:            e.CreatedAt,
+		DataContentType: "application/json",
+	}
+	if e.Publisher != nil {
+		ce.Publisher = *e.Publisher
+	}
+	if e.State != nil {
+		ce.Data = &e.State.Image
+	}
+	return ce
+}
+
+// Sign returns the signature of a body sent at the time with the secret:
+// the hex HMAC-SHA256 of the Unix time, a dot and the body. Receivers
+// compute it again and reject the deliveries whose time is too old.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Succeeded tells whether the endpoint acknowledged the event with a 2xx
+// status.
+func (a *Attempt) Succeeded() bool {
+	return a.StatusCode >= 200 && a.StatusCode < 300
+}
+
+// Dispatcher delivers the image events to the webhooks subscribing to
+// them. The events are fanned out into deliveries as they are recorded,
+// from a cursor stored in the database, then POSTed to the webhooks until
+// they answer with a 2xx status. The failed deliveries are retried with
+// an exponential backoff. Several dispatchers can run at once, each
+// delivery is sent by one at a time.
+type Dispatcher struct {
+	DB  *db.DB
+	Log *log.Entry
+
+	// Client sends the deliveries, nil for a client which doesn't follow
+	// the redirects and only connects to public addresses.
+	Client *http.Client
+
+	// AllowedNetworks lists the loopback, private or link-local networks
+	// the default client may connect to anyway, for local receivers.
+	AllowedNetworks []*net.IPNet
+
+	// Source is the source of the CloudEvents.
+	Source string
+
+	// Interval is the maximum time between two checks of the deliveries
+	// due, so that the retries and the events whose notification was lost
+	// are sent.
+	Interval time.Duration
+
+	// Timeout is the time a webhook has to answer.
+	Timeout time.Duration
+
+	// Workers is the number of deliveries sent at once.
+	Workers int
+
+	// MaxAttempts is the number of attempts after which a delivery fails.
+	MaxAttempts int
+
+	// Backoff is the time before the first retry, doubled after each
+	// attempt up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// DisableAfter is the time after which a webhook failing all its
+	// attempts is disabled, 0 to never disable them.
+	DisableAfter time.Duration
+
+	// Retention is the time the completed deliveries are kept, 0 to keep
+	// them forever.
+	Retention time.Duration
+
+	clientOnce    sync.Once
+	defaultClient *http.Client
+}
+
+// job is a delivery claimed by a dispatcher.
+type job struct {
+	delivery int64
+	webhook  string
+	url      string
+	secret   string
+	attempts int
+	event    image.FeedEvent
+}
+
+// Run delivers the events until the context is done. It wakes up when new
+// events are recorded, or after the interval.
+func (d *Dispatcher) Run(ctx context.Context) {
+	notify, err := d.DB.PSQLListen(ctx, image.EventsChannel)
+	if err != nil {
+		d.Log.Errorf("webhooks : listening to notifications, polling every %v : %v", d.Interval, err)
+	}
+
+	poll := time.NewTicker(d.Interval)
+	defer poll.Stop()
+	purge := time.NewTicker(time.Hour)
+	defer purge.Stop()
+	for {
+		if err := d.Tick(ctx); err != nil {
+			d.Log.Errorf("webhooks : %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-notify:
+			if !ok {
+				return
+			}
+		case <-poll.C:
+		case <-purge.C:
+			d.purge(ctx)
+		}
+	}
+}
+
+// Tick fans the new events out, then sends the deliveries due.
+func (d *Dispatcher) Tick(ctx context.Context) error {
+	for {
+		n, err := d.enqueue(ctx)
+		if err != nil {
+			return err
+		}
+		if n < enqueueBatch {
+			break
+		}
+	}
+	for {
+		n, err := d.deliver(ctx)
+		if err != nil {
+			return err
+		}
+		if n < d.batch() {
+			return nil
+		}
+	}
+}
+
+// enqueue creates the deliveries of a batch of the events recorded after
+// the cursor, for the active webhooks subscribing to them, and returns the
+// number of events. The events readable so far are numbered first. The
+// lock on the cursor makes concurrent dispatchers wait for the current one.
+func (d *Dispatcher) enqueue(ctx context.Context) (int, error) {
+	if _, err := image.NumberEvents(ctx, d.DB); err != nil {
+		return 0, errors.Wrap(err, "enqueue")
+	}
+	tx, err := d.DB.PSQLBegin(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "enqueue")
+	}
+	defer tx.Rollback()
+
+	var last int64
+	row := tx.QueryRowxContext(ctx, `SELECT last_event_id FROM webhooks_cursor WHERE name = $1 FOR UPDATE`, cursorName)
+	if err := row.Scan(&last); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, errors.Errorf("enqueue: cursor %s not found", cursorName)
+		}
+		return 0, errors.Wrap(err, "enqueue")
+	}
+
+	var n int
+	var upto sql.NullInt64
+	row = tx.QueryRowxContext(ctx, `SELECT count(*), max(id) FROM
+		(SELECT id FROM image_events WHERE id > $1 ORDER BY id LIMIT $2) e`, last, enqueueBatch)
+	if err := row.Scan(&n, &upto); err != nil {
+		return 0, errors.Wrap(err, "db.image_events.max()")
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO webhook_deliveries(webhook_id, event_id, type, image_id, publisher, state, event_time)
+		SELECT w.id, e.id, e.type, e.image_id, e.publisher, e.state, e.created_at
+		FROM image_events e JOIN webhooks w ON w.active
+			AND (cardinality(w.events) = 0 OR e.type = ANY(w.events))
+			AND (cardinality(w.publishers) = 0 OR e.publisher = ANY(w.publishers))
+		WHERE e.id > $1 AND e.id <= $2
+		ON CONFLICT DO NOTHING`, last, upto.Int64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "db.webhook_deliveries.insert(%d, %d)", last, upto.Int64)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE webhooks_cursor SET last_event_id = $2 WHERE name = $1`, cursorName, upto.Int64); err != nil {
+		return 0, errors.Wrap(err, "enqueue")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Wrap(err, "enqueue")
+	}
+	return n, nil
+}
+
+// deliver sends a batch of the deliveries due and returns its size.
+func (d *Dispatcher) deliver(ctx context.Context) (int, error) {
+	jobs, err := d.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	workers := d.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i := range jobs {
+		j := &jobs[i]
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			a := d.Send(ctx, j.url, j.secret, &j.event)
+			if err := d.record(ctx, j, &a); err != nil {
+				d.Log.Errorf("webhooks : %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	return len(jobs), nil
+}
+
+// claim leases a batch of the deliveries due, of the active webhooks. The
+// deliveries whose dispatcher died before recording the attempt are sent
+// again once their lease ends.
+func (d *Dispatcher) claim(ctx context.Context) ([]job, error) {
+	lease := d.Timeout + time.Minute
+	rows, err := d.DB.PSQLQuerier(ctx, `WITH due AS (
+			SELECT d.id FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+			WHERE d.status = $1 AND d.next_attempt_at <= now() AND w.active
+			ORDER BY d.next_attempt_at, d.id LIMIT $2
+			FOR UPDATE OF d SKIP LOCKED)
+		UPDATE webhook_deliveries d SET next_attempt_at = now() + make_interval(secs => $3)
+		FROM due, webhooks w WHERE d.id = due.id AND w.id = d.webhook_id
+		RETURNING d.id, d.webhook_id, w.url, w.secret, d.attempts,
+			d.event_id, d.type, d.image_id, d.publisher, d.state, d.event_time`,
+		StatusPending, d.batch(), lease.Seconds())
+	if err != nil {
+		return nil, errors.Wrap(err, "db.webhook_deliveries.claim()")
+	}
+	defer rows.Close()
+
+	var jobs []job
+	for rows.Next() {
+		j := job{event: image.FeedEvent{State: new(image.Snapshot)}}
+		e := &j.event
+		if err := rows.Scan(&j.delivery, &j.webhook, &j.url, &j.secret, &j.attempts,
+			&e.ID, &e.Type, &e.ImageID, &e.Publisher, e.State, &e.CreatedAt); err != nil {
+			return nil, errors.Wrap(err, "db.webhook_deliveries.claim()Scan")
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// Send POSTs an event to a webhook and returns the attempt.
+func (d *Dispatcher) Send(ctx context.Context, url, secret string, e *image.FeedEvent) Attempt {
+	a := Attempt{CreatedAt: time.Now()}
+	body, err := json.Marshal(NewCloudEvent(d.Source, e))
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", CloudEventsContentType)
+	req.Header.Set("User-Agent", "go-api-webhooks")
+	req.Header.Set(SignatureHeader, Sign(secret, a.CreatedAt, body))
+
+	resp, err := d.client().Do(req)
+	a.DurationMS = int64(time.Since(a.CreatedAt) / time.Millisecond)
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxResponse))
+	resp.Body.Close()
+
+	a.StatusCode = resp.StatusCode
+	if !a.Succeeded() {
+		a.Error = resp.Status
+	}
+	return a
+}
+
+// record stores an attempt along with its outcome. A failed delivery is
+// retried after the backoff, until it has no attempts left. A webhook
+// failing since longer than DisableAfter is disabled.
+func (d *Dispatcher) record(ctx context.Context, j *job, a *Attempt) error {
+	tx, err := d.DB.PSQLBegin(ctx)
+	if err != nil {
+		return errors.Wrap(err, "record")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `INSERT INTO webhook_attempts(delivery_id, status_code, error, duration_ms, created_at)
+		VALUES($1, NULLIF($2, 0), NULLIF($3, ''), $4, $5)`, j.delivery, a.StatusCode, a.Error, a.DurationMS, a.CreatedAt); err != nil {
+		return errors.Wrapf(err, "db.webhook_attempts.insert(%d)", j.delivery)
+	}
+
+	if a.Succeeded() {
+		if _, err := tx.ExecContext(ctx, `UPDATE webhook_deliveries SET status = $2, attempts = attempts + 1,
+			delivered_at = now() WHERE id = $1`, j.delivery, StatusSucceeded); err != nil {
+			return errors.Wrapf(err, "db.webhook_deliveries.update(%d)", j.delivery)
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE webhooks SET failures = 0, failing_since = NULL WHERE id = $1`, j.webhook); err != nil {
+			return errors.Wrapf(err, "db.webhooks.update(%s)", j.webhook)
+		}
+		return errors.Wrap(tx.Commit(), "record")
+	}
+
+	status := StatusPending
+	if j.attempts+1 >= d.MaxAttempts {
+		status = StatusFailed
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE webhook_deliveries SET status = $2, attempts = attempts + 1,
+		next_attempt_at = now() + make_interval(secs => $3) WHERE id = $1`,
+		j.delivery, status, d.backoff(j.attempts+1).Seconds()); err != nil {
+		return errors.Wrapf(err, "db.webhook_deliveries.update(%d)", j.delivery)
+	}
+
+	// The expressions read the row as it was before the update.
+	var disabled bool
+	row := tx.QueryRowxContext(ctx, `UPDATE webhooks SET failures = failures + 1,
+		failing_since = COALESCE(failing_since, now()),
+		active = active AND NOT ($2 AND COALESCE(failing_since, now()) <= now() - make_interval(secs => $3)),
+		disabled_at = CASE WHEN active AND $2 AND COALESCE(failing_since, now()) <= now() - make_interval(secs => $3)
+			THEN now() ELSE disabled_at END
+		WHERE id = $1 RETURNING COALESCE(NOT active AND disabled_at = now(), false)`,
+		j.webhook, d.DisableAfter > 0, d.DisableAfter.Seconds())
+	if err := row.Scan(&disabled); err != nil {
+		return errors.Wrapf(err, "db.webhooks.update(%s)", j.webhook)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "record")
+	}
+	if disabled {
+		d.Log.Warnf("webhooks : %s disabled, failing for more than %v : %s", j.webhook, d.DisableAfter, a.Error)
+	}
+	return nil
+}
+
+// purge deletes the completed deliveries older than the retention.
+func (d *Dispatcher) purge(ctx context.Context) {
+	if d.Retention <= 0 {
+		return
+	}
+	before := time.Now().Add(-d.Retention)
+	if _, err := d.DB.PSQLExecute(ctx, `DELETE FROM webhook_deliveries WHERE status <> $1 AND created_at < $2`, StatusPending, before); err != nil {
+		d.Log.Errorf("webhooks : %v", errors.Wrap(err, "db.webhook_deliveries.purge()"))
+	}
+}
+
+// backoff returns the time before the retry following the attempt:
+// Backoff doubled after each attempt, up to MaxBackoff.
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	wait := d.Backoff
+	for i := 1; i < attempt && (d.MaxBackoff <= 0 || wait < d.MaxBackoff); i++ {
+		wait *= 2
+	}
+	if d.MaxBackoff > 0 && wait > d.MaxBackoff {
+		wait = d.MaxBackoff
+	}
+	return wait
+}
+
+// batch returns the number of deliveries claimed at once.
+func (d *Dispatcher) batch() int {
+	workers := d.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	return 4 * workers
+}
+
+// client returns the client sending the deliveries.
+func (d *Dispatcher) client() *http.Client {
+	if d.Client != nil {
+		return d.Client
+	}
+	d.clientOnce.Do(func() {
+		d.defaultClient = newClient(d.AllowedNetworks)
+	})
+	return d.defaultClient
+}
+
+// newClient returns a client which doesn't follow the redirects, which
+// would turn the POSTs into GETs. The addresses are checked once resolved,
+// right before connecting, so that a webhook can't reach the internal
+// services through its host name. No proxy is used, which would connect
+// in its place.
+func newClient(allowed []*net.IPNet) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !allowedIP(ip, allowed) {
+				return errors.Errorf("webhook address %s isn't allowed", host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// sharedNetwork is the carrier-grade NAT range, which isn't public either.
+var sharedNetwork = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// allowedIP reports whether the webhooks may be sent to an address: a
+// public unicast one, or one of the allowed networks.
+func allowedIP(ip net.IP, allowed []*net.IPNet) bool {
+	for _, n := range allowed {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedNetwork.Contains(ip)
+}
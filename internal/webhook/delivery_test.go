@@ -0,0 +1,197 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jdelobel/go-api/internal/image"
+)
+
+func TestSign(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	sig := Sign("s3cr3t", at, []byte(`{"id":"1"}`))
+
+	if !strings.HasPrefix(sig, "t=1700000000,v1=") || len(sig) != len("t=1700000000,v1=")+64 {
+		t.Fatalf("signature = %q", sig)
+	}
+	if Sign("s3cr3t", at, []byte(`{"id":"1"}`)) != sig {
+		t.Error("signature isn't deterministic")
+	}
+	if Sign("other", at, []byte(`{"id":"1"}`)) == sig {
+		t.Error("signature doesn't depend on the secret")
+	}
+	if Sign("s3cr3t", at.Add(time.Second), []byte(`{"id":"1"}`)) == sig {
+		t.Error("signature doesn't depend on the time")
+	}
+	if Sign("s3cr3t", at, []byte(`{"id":"2"}`)) == sig {
+		t.Error("signature doesn't depend on the body")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := Dispatcher{Backoff: 30 * time.Second, MaxBackoff: 10 * time.Minute}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 1, want: 30 * time.Second},
+		{attempt: 2, want: time.Minute},
+		{attempt: 3, want: 2 * time.Minute},
+		{attempt: 5, want: 8 * time.Minute},
+		{attempt: 6, want: 10 * time.Minute},
+		{attempt: 60, want: 10 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := d.backoff(tt.attempt); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	etf1, id := "etf1", "47c658e0-68d7-4d79-9f9f-25ece8a1fb03"
+	event := image.FeedEvent{
+		ID:        42,
+		Type:      image.EventUpdated,
+		ImageID:   id,
+		Publisher: &etf1,
+		State:     &image.Snapshot{Image: image.Image{ID: &id, Publisher: &etf1, Version: 3}},
+		CreatedAt: time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		name      string
+		handler   http.HandlerFunc
+		timeout   time.Duration
+		code      int
+		succeeded bool
+		error     string
+	}{
+		{name: "ok", handler: func(w http.ResponseWriter, r *http.Request) {}, code: http.StatusOK, succeeded: true},
+		{name: "accepted", handler: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusAccepted) }, code: http.StatusAccepted, succeeded: true},
+		{name: "server error", handler: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusBadGateway) }, code: http.StatusBadGateway, error: "502 Bad Gateway"},
+		{name: "redirect", handler: func(w http.ResponseWriter, r *http.Request) { http.Redirect(w, r, "/elsewhere", http.StatusFound) }, code: http.StatusFound, error: "302 Found"},
+		{
+			name:    "timeout",
+			handler: func(w http.ResponseWriter, r *http.Request) { time.Sleep(200 * time.Millisecond) },
+			timeout: 20 * time.Millisecond,
+			error:   "deadline exceeded",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The receiver hands the request over once it is done, so that
+			// the timed out deliveries are checked after it returns.
+			type received struct {
+				method, path string
+				header       http.Header
+				body         []byte
+			}
+			calls := make(chan received, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				tt.handler(w, r)
+				calls <- received{method: r.Method, path: r.URL.Path, header: r.Header.Clone(), body: body}
+			}))
+			defer srv.Close()
+
+			d := Dispatcher{Source: "/v1/images", Timeout: tt.timeout, AllowedNetworks: []*net.IPNet{loopback}}
+			a := d.Send(context.Background(), srv.URL+"/hooks", "s3cr3t", &event)
+
+			var got received
+			select {
+			case got = <-calls:
+			case <-time.After(5 * time.Second):
+				t.Fatal("receiver wasn't called")
+			}
+			body := got.body
+			if a.StatusCode != tt.code || a.Succeeded() != tt.succeeded || !strings.Contains(a.Error, tt.error) {
+				t.Fatalf("attempt = %+v, want status %d, succeeded %v, error %q", a, tt.code, tt.succeeded, tt.error)
+			}
+			if got.method != http.MethodPost || got.path != "/hooks" {
+				t.Errorf("request = %s %s, want POST /hooks", got.method, got.path)
+			}
+			if ct := got.header.Get("Content-Type"); ct != CloudEventsContentType {
+				t.Errorf("Content-Type = %q, want %q", ct, CloudEventsContentType)
+			}
+			if sig := got.header.Get(SignatureHeader); sig != Sign("s3cr3t", a.CreatedAt, body) {
+				t.Errorf("%s = %q doesn't sign the body", SignatureHeader, sig)
+			}
+
+			var ce CloudEvent
+			if err := json.Unmarshal(body, &ce); err != nil {
+				t.Fatal(err)
+			}
+			if ce.SpecVersion != "1.0" || ce.ID != "42" || ce.Type != image.EventUpdated || ce.Source != "/v1/images" ||
+				ce.Subject != id || ce.Publisher != "etf1" || !ce.Time.Equal(event.CreatedAt) {
+				t.Errorf("event = %+v", ce)
+			}
+			if ce.Data == nil || *ce.Data.ID != id || ce.Data.Version != 3 {
+				t.Errorf("data = %+v, want the image", ce.Data)
+			}
+		})
+	}
+}
+
+// loopback is the network of the test receivers.
+var loopback = &net.IPNet{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}
+
+func TestSendPrivate(t *testing.T) {
+	var called int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.StoreInt32(&called, 1)
+	}))
+	defer srv.Close()
+
+	d := Dispatcher{Source: "/v1/images"}
+	a := d.Send(context.Background(), srv.URL+"/hooks", "s3cr3t", &image.FeedEvent{ID: 1, Type: image.EventDeleted})
+	if a.Succeeded() || !strings.Contains(a.Error, "isn't allowed") {
+		t.Errorf("attempt = %+v, want a refused address", a)
+	}
+	if atomic.LoadInt32(&called) != 0 {
+		t.Error("receiver was called")
+	}
+}
+
+func TestAllowedIP(t *testing.T) {
+	tests := []struct {
+		ip      string
+		allowed []*net.IPNet
+		want    bool
+	}{
+		{ip: "93.184.216.34", want: true},
+		{ip: "2606:2800:220:1::1", want: true},
+		{ip: "127.0.0.1"},
+		{ip: "::1"},
+		{ip: "::ffff:127.0.0.1"},
+		{ip: "10.1.2.3"},
+		{ip: "172.16.0.1"},
+		{ip: "192.168.1.1"},
+		{ip: "100.64.0.1"},
+		{ip: "169.254.169.254"},
+		{ip: "fe80::1"},
+		{ip: "fd00::1"},
+		{ip: "0.0.0.0"},
+		{ip: "224.0.0.1"},
+		{ip: "127.0.0.1", allowed: []*net.IPNet{loopback}, want: true},
+		{ip: "10.1.2.3", allowed: []*net.IPNet{loopback}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := allowedIP(net.ParseIP(tt.ip), tt.allowed); got != tt.want {
+				t.Errorf("allowedIP(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jdelobel/go-api/internal/image"
+	"github.com/jdelobel/go-api/internal/platform/db"
+	"github.com/jdelobel/go-api/internal/platform/web"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// ErrNotFound occurs when no webhook exists with the requested id.
+var ErrNotFound = errors.New("Webhook not found")
+
+func init() {
+	web.RegisterError(ErrNotFound, web.ProblemType{Type: web.ProblemBaseURI + "webhook-not-found", Title: "Webhook not found", Status: http.StatusNotFound})
+	web.RegisterValidation("webhookurl", isWebhookURL, "must be an absolute http or https URL")
+}
+
+// columns lists the columns of the webhooks table read into a Webhook. The
+// secret is left out, it is only returned when it is set.
+const columns = `id, url, events, publishers, active, failures, failing_since, disabled_at, created_at, updated_at`
+
+// secretSize is the number of random bytes of the generated secrets.
+const secretSize = 32
+
+// Webhook is an endpoint of a partner notified of the image events.
+type Webhook struct {
+	ID         string         `db:"id" json:"id"`
+	URL        string         `db:"url" json:"url"`
+	Events     pq.StringArray `db:"events" json:"events"`
+	Publishers pq.StringArray `db:"publishers" json:"publishers"`
+	Secret     string         `db:"secret" json:"secret,omitempty"`
+
+	// Active is false once the webhook is disabled, after failing for too
+	// long. Failures counts the attempts failed in a row since FailingSince.
+	Active       bool       `db:"active" json:"active"`
+	Failures     int        `db:"failures" json:"failures"`
+	FailingSince *time.Time `db:"failing_since" json:"failing_since"`
+	DisabledAt   *time.Time `db:"disabled_at" json:"disabled_at"`
+
+	CreatedAt *time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt *time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// CreateWebhook contains information about a webhook. No events or
+// publishers subscribe to all of them. A secret is generated when none is
+// set.
+type CreateWebhook struct {
+	URL        string   `json:"url" validate:"required,max=1024,webhookurl"`
+	Events     []string `json:"events" validate:"omitempty,dive,oneof=image.created image.updated image.deleted"`
+	Publishers []string `json:"publishers" validate:"omitempty,dive,required"`
+	Secret     string   `json:"secret" validate:"omitempty,min=16,max=255"`
+
+	// Active enables a webhook again, nil leaves it as is.
+	Active *bool `json:"active"`
+}
+
+// Delivery is an event delivered, or to be delivered, to a webhook.
+type Delivery struct {
+	ID          int64      `db:"id" json:"id"`
+	WebhookID   string     `db:"webhook_id" json:"webhook_id"`
+	EventID     int64      `db:"event_id" json:"event_id"`
+	Type        string     `db:"type" json:"type"`
+	ImageID     string     `db:"image_id" json:"image_id"`
+	Status      string     `db:"status" json:"status"`
+	Attempts    int        `db:"attempts" json:"attempts"`
+	NextAttempt *time.Time `db:"next_attempt_at" json:"next_attempt_at"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+	DeliveredAt *time.Time `db:"delivered_at" json:"delivered_at"`
+	History     []Attempt  `db:"-" json:"history"`
+}
+
+// Attempt is a try to deliver an event. StatusCode is 0 when no response
+// was received, the error tells why.
+type Attempt struct {
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Statuses of the deliveries.
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// isWebhookURL checks the field is an absolute http(s) URL. Its address is
+// checked when the deliveries are sent, as the host may resolve elsewhere
+// by then.
+func isWebhookURL(fl validator.FieldLevel) bool {
+	u, err := url.Parse(fl.Field().String())
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// List returns the webhooks, oldest first.
+func List(ctx context.Context, dbConn *db.DB) ([]Webhook, error) {
+	rows, err := dbConn.PSQLQuerier(ctx, "SELECT "+columns+" FROM webhooks ORDER BY created_at, id")
+	if err != nil {
+		return nil, errors.Wrap(err, "db.webhooks.find()")
+	}
+	defer rows.Close()
+
+	webhooks := make([]Webhook, 0)
+	for rows.Next() {
+		var wh Webhook
+		if err := rows.StructScan(&wh); err != nil {
+			return nil, errors.Wrap(err, "db.webhooks.find()StructScan")
+		}
+		webhooks = append(webhooks, wh)
+	}
+	return webhooks, rows.Err()
+}
+
+// Retrieve gets the specified webhook.
+func Retrieve(ctx context.Context, dbConn *db.DB, id string) (*Webhook, error) {
+	if !image.IsValidUUID(id) {
+		return nil, errors.Wrapf(web.ErrInvalidID, "IsValidUUID: %s", id)
+	}
+	row, err := dbConn.PSQLQueryRawx(ctx, "SELECT "+columns+" FROM webhooks WHERE id=$1", id)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("db.webhooks.find(%s)", db.Query(id)))
+	}
+
+	var wh Webhook
+	err = row.StructScan(&wh)
+	if err == sql.ErrNoRows {
+		return nil, errors.Wrapf(ErrNotFound, "Id: %s", id)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("db.webhooks.find(%s)StructScan", db.Query(id)))
+	}
+	return &wh, nil
+}
+
+// Create inserts a new webhook. It is returned along with its secret.
+func Create(ctx context.Context, dbConn *db.DB, cw *CreateWebhook) (*Webhook, error) {
+	secret := cw.Secret
+	if secret == "" {
+		var err error
+		if secret, err = newSecret(); err != nil {
+			return nil, errors.Wrap(err, "Create")
+		}
+	}
+
+	query := `INSERT INTO webhooks(url, events, publishers, secret) VALUES($1,$2,$3,$4) RETURNING ` + columns
+	row, err := dbConn.PSQLQueryRawx(ctx, query, cw.URL, list(cw.Events), list(cw.Publishers), secret)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("db.webhooks.insert(%s)", db.Query(cw.URL)))
+	}
+
+	var wh Webhook
+	if err := row.StructScan(&wh); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("db.webhooks.insert(%s)StructScan", db.Query(cw.URL)))
+	}
+	wh.Secret = secret
+	return &wh, nil
+}
+
+// Update replaces a webhook. The secret is kept when none is set, and
+// returned otherwise. Enabling a webhook again clears its failures.
+func Update(ctx context.Context, dbConn *db.DB, id string, uw *CreateWebhook) (*Webhook, error) {
+	if !image.IsValidUUID(id) {
+		return nil, errors.Wrapf(web.ErrInvalidID, "IsValidUUID: %s", id)
+	}
+
+	query := `UPDATE webhooks SET url=$2, events=$3, publishers=$4, secret=COALESCE(NULLIF($5, ''), secret),
+		active=COALESCE($6, active),
+		failures=CASE WHEN $6 THEN 0 ELSE failures END,
+		failing_since=CASE WHEN $6 THEN NULL ELSE failing_since END,
+		disabled_at=CASE WHEN $6 THEN NULL WHEN NOT $6 THEN COALESCE(disabled_at, now()) ELSE disabled_at END,
+		updated_at=now()
+		WHERE id=$1 RETURNING ` + columns
+	row, err := dbConn.PSQLQueryRawx(ctx, query, id, uw.URL, list(uw.Events), list(uw.Publishers), uw.Secret, uw.Active)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("db.webhooks.update(%s)", db.Query(id)))
+	}
+
+	var wh Webhook
+	err = row.StructScan(&wh)
+	if err == sql.ErrNoRows {
+		return nil, errors.Wrapf(ErrNotFound, "Id: %s", id)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("db.webhooks.update(%s)StructScan", db.Query(id)))
+	}
+	wh.Secret = uw.Secret
+	return &wh, nil
+}
+
+// Delete removes a webhook along with its deliveries.
+func Delete(ctx context.Context, dbConn *db.DB, id string) error {
+	if !image.IsValidUUID(id) {
+		return errors.Wrapf(web.ErrInvalidID, "IsValidUUID: %s", id)
+	}
+	res, err := dbConn.PSQLExecute(ctx, "DELETE FROM webhooks WHERE id=$1", id)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("db.webhooks.delete(%s)", db.Query(id)))
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "Delete")
+	}
+	if n == 0 {
+		return errors.Wrapf(ErrNotFound, "Id: %s", id)
+	}
+	return nil
+}
+
+// ListDeliveries returns a page of the deliveries of a webhook, latest
+// first, along with their attempts.
+func ListDeliveries(ctx context.Context, dbConn *db.DB, id string, limit, offset int) (*web.Page, error) {
+	if _, err := Retrieve(ctx, dbConn, id); err != nil {
+		return nil, err
+	}
+
+	page := web.Page{Limit: limit, Offset: offset}
+	row, err := dbConn.PSQLQueryRawx(ctx, `SELECT count(*) FROM webhook_deliveries WHERE webhook_id=$1`, id)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("db.webhook_deliveries.count(%s)", db.Query(id)))
+	}
+	if err := row.Scan(&page.Total); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("db.webhook_deliveries.count(%s)", db.Query(id)))
+	}
+
+	rows, err := dbConn.PSQLQuerier(ctx, `SELECT id, webhook_id, event_id, type, image_id, status, attempts,
+		CASE WHEN status = 'pending' THEN next_attempt_at END AS next_attempt_at, created_at, delivered_at
+		FROM webhook_deliveries WHERE webhook_id=$1 ORDER BY id DESC LIMIT $2 OFFSET $3`, id, limit, offset)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("db.webhook_deliveries.find(%s)", db.Query(id)))
+	}
+	defer rows.Close()
+
+	deliveries := make([]Delivery, 0)
+	index := make(map[int64]int)
+	ids := make([]int64, 0)
+	for rows.Next() {
+		d := Delivery{History: make([]Attempt, 0)}
+		if err := rows.StructScan(&d); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("db.webhook_deliveries.find(%s)StructScan", db.Query(id)))
+		}
+		index[d.ID] = len(deliveries)
+		ids = append(ids, d.ID)
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("db.webhook_deliveries.find(%s)", db.Query(id)))
+	}
+
+	attempts, err := dbConn.PSQLQuerier(ctx, `SELECT delivery_id, status_code, error, duration_ms, created_at
+		FROM webhook_attempts WHERE delivery_id = ANY($1) ORDER BY id`, pq.Array(ids))
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("db.webhook_attempts.find(%s)", db.Query(id)))
+	}
+	defer attempts.Close()
+	for attempts.Next() {
+		var deliveryID int64
+		var code sql.NullInt64
+		var msg sql.NullString
+		var a Attempt
+		if err := attempts.Scan(&deliveryID, &code, &msg, &a.DurationMS, &a.CreatedAt); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("db.webhook_attempts.find(%s)Scan", db.Query(id)))
+		}
+		a.StatusCode, a.Error = int(code.Int64), msg.String
+		d := &deliveries[index[deliveryID]]
+		d.History = append(d.History, a)
+	}
+	if err := attempts.Err(); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("db.webhook_attempts.find(%s)", db.Query(id)))
+	}
+
+	page.Data = deliveries
+	return &page, nil
+}
+
+// newSecret returns a random secret.
+func newSecret() (string, error) {
+	b := make([]byte, secretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// list returns the array parameter of a list, empty rather than NULL when
+// it is nil.
+func list(values []string) interface{} {
+	if values == nil {
+		values = []string{}
+	}
+	return pq.Array(values)
+}
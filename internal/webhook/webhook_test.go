@@ -0,0 +1,51 @@
+package webhook
+
+import (
+	"testing"
+
+	"github.com/jdelobel/go-api/internal/platform/web"
+)
+
+func TestCreateWebhookValidation(t *testing.T) {
+	valid := CreateWebhook{
+		URL:        "https://partner.example.com/hooks/images",
+		Events:     []string{"image.created", "image.deleted"},
+		Publishers: []string{"etf1"},
+	}
+
+	tests := []struct {
+		name   string
+		modify func(cw *CreateWebhook)
+		field  string
+	}{
+		{name: "valid webhook", modify: func(cw *CreateWebhook) {}},
+		{name: "all events", modify: func(cw *CreateWebhook) { cw.Events, cw.Publishers = nil, nil }},
+		{name: "no url", modify: func(cw *CreateWebhook) { cw.URL = "" }, field: "url"},
+		{name: "relative url", modify: func(cw *CreateWebhook) { cw.URL = "/hooks" }, field: "url"},
+		{name: "ftp url", modify: func(cw *CreateWebhook) { cw.URL = "ftp://partner.example.com/hooks" }, field: "url"},
+		{name: "unknown event", modify: func(cw *CreateWebhook) { cw.Events = []string{"image.published"} }, field: "events.0"},
+		{name: "empty publisher", modify: func(cw *CreateWebhook) { cw.Publishers = []string{"etf1", ""} }, field: "publishers.1"},
+		{name: "short secret", modify: func(cw *CreateWebhook) { cw.Secret = "s3cr3t" }, field: "secret"},
+		{name: "secret", modify: func(cw *CreateWebhook) { cw.Secret = "0123456789abcdef" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cw := valid
+			tt.modify(&cw)
+
+			err := web.Validate(&cw)
+			if tt.field == "" {
+				if err != nil {
+					t.Fatalf("got error %v, want none", err)
+				}
+				return
+			}
+
+			inv, ok := err.(web.InvalidError)
+			if !ok || len(inv) != 1 || inv[0].Fld != tt.field {
+				t.Fatalf("got error %v, want an invalid %s", err, tt.field)
+			}
+		})
+	}
+}
@@ -0,0 +1,2 @@
+DROP TABLE publisher_schemas;
+DROP INDEX images_metadata_gin;
@@ -0,0 +1,15 @@
+-- Name: images_metadata_gin; Type: INDEX;
+-- Serves the metadata containment filters of GET /v1/images.
+CREATE INDEX images_metadata_gin ON images USING GIN (metadata jsonb_path_ops);
+
+CREATE TABLE publisher_schemas(
+  publisher character varying(255) NOT NULL,
+  schema jsonb NOT NULL,
+  created_at timestamp with time zone DEFAULT now() NOT NULL,
+  updated_at timestamp with time zone
+);
+
+--
+-- Name: publisher_schemas_pkey; Type: CONSTRAINT;
+ALTER TABLE ONLY publisher_schemas
+    ADD CONSTRAINT publisher_schemas_pkey PRIMARY KEY (publisher);
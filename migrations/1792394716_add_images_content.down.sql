@@ -0,0 +1,5 @@
+ALTER TABLE images
+  DROP COLUMN content_type,
+  DROP COLUMN content_size,
+  DROP COLUMN content_sha256,
+  DROP COLUMN storage_key;
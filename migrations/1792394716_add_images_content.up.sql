@@ -0,0 +1,5 @@
+ALTER TABLE images
+  ADD COLUMN content_type character varying(255),
+  ADD COLUMN content_size bigint,
+  ADD COLUMN content_sha256 character(64),
+  ADD COLUMN storage_key character varying(1024);
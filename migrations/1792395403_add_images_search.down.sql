@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS images_search_update ON images;
+DROP INDEX IF EXISTS images_search_gin;
+ALTER TABLE images
+  DROP COLUMN search;
+DROP FUNCTION IF EXISTS images_search_update();
+DROP FUNCTION IF EXISTS images_search_config(text);
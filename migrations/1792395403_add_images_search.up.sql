@@ -0,0 +1,34 @@
+-- images_search_config returns the text search configuration named lang,
+-- english when it doesn't exist.
+CREATE FUNCTION images_search_config(lang text) RETURNS regconfig AS $$
+  SELECT COALESCE((SELECT c.oid::regconfig FROM pg_ts_config c WHERE c.cfgname = lang LIMIT 1), 'english'::regconfig)
+$$ LANGUAGE sql STABLE;
+
+-- images_search_update indexes the title, slug, publisher and the text
+-- metadata of an image, in the language of its language metadata. The
+-- simple configuration keeps the exact words like names next to the stems.
+CREATE FUNCTION images_search_update() RETURNS trigger AS $$
+DECLARE
+  cfg regconfig := images_search_config(NEW.metadata->>'language');
+BEGIN
+  NEW.search :=
+    setweight(to_tsvector(cfg, coalesce(NEW.title, '')), 'A') ||
+    setweight(to_tsvector('simple', coalesce(NEW.title, '')), 'A') ||
+    setweight(to_tsvector('simple', translate(coalesce(NEW.slug, ''), '/-_.@~', '      ')), 'B') ||
+    setweight(to_tsvector('simple', coalesce(NEW.publisher, '')), 'C') ||
+    setweight(to_tsvector(cfg, concat_ws(' ',
+      NEW.metadata->>'caption', NEW.metadata->>'description', NEW.metadata->>'credit',
+      NEW.metadata->>'copyright', NEW.metadata->>'camera', NEW.metadata->>'keywords', NEW.metadata->>'tags')), 'D');
+  RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE images
+  ADD COLUMN search tsvector;
+
+CREATE TRIGGER images_search_update BEFORE INSERT OR UPDATE OF title, slug, publisher, metadata ON images
+  FOR EACH ROW EXECUTE PROCEDURE images_search_update();
+
+UPDATE images SET title = title;
+
+CREATE INDEX images_search_gin ON images USING gin (search);
@@ -0,0 +1,3 @@
+DROP INDEX images_expired_at_idx;
+DROP INDEX images_published_at_idx;
+DROP TABLE images_schedule;
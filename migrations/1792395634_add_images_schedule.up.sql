@@ -0,0 +1,10 @@
+CREATE TABLE images_schedule (
+  name text PRIMARY KEY,
+  last_run timestamptz NOT NULL
+);
+
+-- The boundaries which passed before the scheduler existed aren't replayed.
+INSERT INTO images_schedule(name, last_run) VALUES ('visibility', now());
+
+CREATE INDEX images_published_at_idx ON images (published_at) WHERE deleted_at IS NULL;
+CREATE INDEX images_expired_at_idx ON images (expired_at) WHERE deleted_at IS NULL;
@@ -0,0 +1,9 @@
+CREATE TABLE image_revisions (
+  image_id uuid NOT NULL REFERENCES images (id),
+  revision integer NOT NULL,
+  state jsonb NOT NULL,
+  actor character varying(255),
+  trace_id character varying(64),
+  created_at timestamp with time zone DEFAULT now() NOT NULL,
+  PRIMARY KEY (image_id, revision)
+);
@@ -0,0 +1,5 @@
+DROP TRIGGER images_version_increment ON images;
+DROP FUNCTION images_version_increment();
+
+ALTER TABLE images
+  DROP COLUMN version;
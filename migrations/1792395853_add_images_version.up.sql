@@ -0,0 +1,14 @@
+ALTER TABLE images
+  ADD COLUMN version bigint NOT NULL DEFAULT 1;
+
+-- images_version_increment bumps the version of an image on each update,
+-- so that it can be used as its entity tag.
+CREATE FUNCTION images_version_increment() RETURNS trigger AS $$
+BEGIN
+  NEW.version := OLD.version + 1;
+  RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER images_version_increment BEFORE UPDATE ON images
+  FOR EACH ROW EXECUTE PROCEDURE images_version_increment();
@@ -0,0 +1,3 @@
+DROP TRIGGER images_record_event ON images;
+DROP FUNCTION images_record_event();
+DROP TABLE image_events;
@@ -0,0 +1,39 @@
+CREATE TABLE image_events (
+  id bigserial PRIMARY KEY,
+  type character varying(32) NOT NULL,
+  image_id uuid NOT NULL,
+  publisher character varying(255),
+  state jsonb NOT NULL,
+  created_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+CREATE INDEX image_events_created_at_idx ON image_events (created_at);
+
+-- images_record_event records the creations, updates and deletions of the
+-- images, and notifies the image_events channel. The events are numbered
+-- in commit order, so that the readers resuming after an event never miss
+-- one committed later with a lower number.
+CREATE FUNCTION images_record_event() RETURNS trigger AS $$
+DECLARE
+  event_type text;
+BEGIN
+  IF TG_OP = 'INSERT' THEN
+    event_type := 'image.created';
+  ELSIF NEW.deleted_at IS NOT NULL AND OLD.deleted_at IS NULL THEN
+    event_type := 'image.deleted';
+  ELSIF NEW.deleted_at IS NULL THEN
+    event_type := 'image.updated';
+  ELSE
+    RETURN NULL;
+  END IF;
+
+  PERFORM pg_advisory_xact_lock(hashtext('image_events'));
+  INSERT INTO image_events (type, image_id, publisher, state)
+    VALUES (event_type, NEW.id, NEW.publisher, to_jsonb(NEW) - 'search');
+  PERFORM pg_notify('image_events', '');
+  RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER images_record_event AFTER INSERT OR UPDATE ON images
+  FOR EACH ROW EXECUTE PROCEDURE images_record_event();
@@ -0,0 +1,56 @@
+CREATE TABLE webhooks (
+  id uuid NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+  url character varying(1024) NOT NULL,
+  events text[] NOT NULL DEFAULT '{}',
+  publishers text[] NOT NULL DEFAULT '{}',
+  secret character varying(255) NOT NULL,
+  active boolean NOT NULL DEFAULT true,
+  failures integer NOT NULL DEFAULT 0,
+  failing_since timestamp with time zone,
+  disabled_at timestamp with time zone,
+  created_at timestamp with time zone DEFAULT now() NOT NULL,
+  updated_at timestamp with time zone
+);
+
+-- webhook_deliveries holds an image event to deliver to a webhook, along
+-- with its state, so that the deliveries outlive the retention of the
+-- events.
+CREATE TABLE webhook_deliveries (
+  id bigserial PRIMARY KEY,
+  webhook_id uuid NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+  event_id bigint NOT NULL,
+  type character varying(32) NOT NULL,
+  image_id uuid NOT NULL,
+  publisher character varying(255),
+  state jsonb NOT NULL,
+  event_time timestamp with time zone NOT NULL,
+  status character varying(16) NOT NULL DEFAULT 'pending',
+  attempts integer NOT NULL DEFAULT 0,
+  next_attempt_at timestamp with time zone DEFAULT now() NOT NULL,
+  created_at timestamp with time zone DEFAULT now() NOT NULL,
+  delivered_at timestamp with time zone,
+  UNIQUE (webhook_id, event_id)
+);
+
+CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX webhook_deliveries_created_at_idx ON webhook_deliveries (created_at);
+
+CREATE TABLE webhook_attempts (
+  id bigserial PRIMARY KEY,
+  delivery_id bigint NOT NULL REFERENCES webhook_deliveries (id) ON DELETE CASCADE,
+  status_code integer,
+  error text,
+  duration_ms integer NOT NULL,
+  created_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+CREATE INDEX webhook_attempts_delivery_id_idx ON webhook_attempts (delivery_id);
+
+-- webhooks_cursor holds the last image event fanned out to the webhooks.
+CREATE TABLE webhooks_cursor (
+  name text PRIMARY KEY,
+  last_event_id bigint NOT NULL
+);
+
+-- The events recorded before the webhooks existed aren't delivered.
+INSERT INTO webhooks_cursor(name, last_event_id) SELECT 'events', COALESCE(max(id), 0) FROM image_events;
@@ -0,0 +1,32 @@
+DROP FUNCTION image_events_number();
+
+CREATE OR REPLACE FUNCTION images_record_event() RETURNS trigger AS $$
+DECLARE
+  event_type text;
+BEGIN
+  IF TG_OP = 'INSERT' THEN
+    event_type := 'image.created';
+  ELSIF NEW.deleted_at IS NOT NULL AND OLD.deleted_at IS NULL THEN
+    event_type := 'image.deleted';
+  ELSIF NEW.deleted_at IS NULL THEN
+    event_type := 'image.updated';
+  ELSE
+    RETURN NULL;
+  END IF;
+
+  PERFORM pg_advisory_xact_lock(hashtext('image_events'));
+  INSERT INTO image_events (type, image_id, publisher, state)
+    VALUES (event_type, NEW.id, NEW.publisher, to_jsonb(NEW) - 'search');
+  PERFORM pg_notify('image_events', '');
+  RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TABLE image_events_purged;
+
+UPDATE image_events SET id = nextval('image_events_id_seq') WHERE id IS NULL;
+ALTER TABLE image_events DROP COLUMN xid;
+ALTER TABLE image_events DROP COLUMN pos;
+ALTER TABLE image_events DROP CONSTRAINT image_events_id_key;
+ALTER TABLE image_events ALTER COLUMN id SET NOT NULL, ALTER COLUMN id SET DEFAULT nextval('image_events_id_seq');
+ALTER TABLE image_events ADD PRIMARY KEY (id);
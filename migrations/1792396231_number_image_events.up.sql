@@ -0,0 +1,70 @@
+-- The events were numbered under a lock held by the image writes until
+-- their commit, which serialized them. They are now recorded without an
+-- id, along with the transaction which wrote them, and numbered by the
+-- readers once the transactions older than them are all finished: the ids
+-- increase in the order the events become readable, at the cost of
+-- delaying the events while a long transaction is open.
+ALTER TABLE image_events DROP CONSTRAINT image_events_pkey;
+ALTER TABLE image_events ALTER COLUMN id DROP NOT NULL, ALTER COLUMN id DROP DEFAULT;
+ALTER TABLE image_events ADD CONSTRAINT image_events_id_key UNIQUE (id);
+ALTER TABLE image_events ADD COLUMN pos bigserial PRIMARY KEY;
+ALTER TABLE image_events ADD COLUMN xid xid8 NOT NULL DEFAULT pg_current_xact_id();
+
+CREATE INDEX image_events_pending_idx ON image_events (xid, pos) WHERE id IS NULL;
+
+-- image_events_purged holds the last event purged, below which the
+-- readers can't resume.
+CREATE TABLE image_events_purged (
+  id bigint NOT NULL
+);
+
+INSERT INTO image_events_purged(id) VALUES (0);
+
+CREATE OR REPLACE FUNCTION images_record_event() RETURNS trigger AS $$
+DECLARE
+  event_type text;
+BEGIN
+  IF TG_OP = 'INSERT' THEN
+    event_type := 'image.created';
+  ELSIF NEW.deleted_at IS NOT NULL AND OLD.deleted_at IS NULL THEN
+    event_type := 'image.deleted';
+  ELSIF NEW.deleted_at IS NULL THEN
+    event_type := 'image.updated';
+  ELSE
+    RETURN NULL;
+  END IF;
+
+  INSERT INTO image_events (type, image_id, publisher, state)
+    VALUES (event_type, NEW.id, NEW.publisher, to_jsonb(NEW) - 'search');
+  PERFORM pg_notify('image_events', '');
+  RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+-- image_events_number numbers the events of the transactions older than
+-- the oldest one in progress, none of which can record events anymore, in
+-- the order of their transactions. It returns the number of events, 0 when
+-- another reader is numbering them, and notifies the image_events channel
+-- when there are some.
+CREATE FUNCTION image_events_number() RETURNS bigint AS $$
+DECLARE
+  numbered bigint;
+BEGIN
+  IF NOT pg_try_advisory_xact_lock(hashtext('image_events')) THEN
+    RETURN 0;
+  END IF;
+
+  UPDATE image_events e SET id = n.id
+    FROM (SELECT pos, nextval('image_events_id_seq') AS id
+          FROM (SELECT pos FROM image_events
+                WHERE id IS NULL AND xid < pg_snapshot_xmin(pg_current_snapshot())
+                ORDER BY xid, pos) pending) n
+    WHERE e.pos = n.pos;
+  GET DIAGNOSTICS numbered = ROW_COUNT;
+
+  IF numbered > 0 THEN
+    PERFORM pg_notify('image_events', '');
+  END IF;
+  RETURN numbered;
+END
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,9 @@
+-- images_announced records the visibility boundaries announced by the
+-- scheduler, which looks back over a lag window for the images committed
+-- after it read the boundaries, without announcing them twice.
+CREATE TABLE images_announced (
+  image_id uuid NOT NULL,
+  event character varying(32) NOT NULL,
+  at timestamptz NOT NULL,
+  PRIMARY KEY (image_id, event)
+);